@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The admin API is served on its own listener so it is never reachable
+// through the proxied address space:
+//
+//	GET    /backends              list backends with health and load
+//	POST   /backends              add a backend: {"addr": "host:port", "weight": 1}
+//	DELETE /backends/{addr}       drain and remove a backend
+//	POST   /backends/{addr}/drain stop routing new requests to a backend
+//	POST   /backends/{addr}/undrain resume routing to a drained backend
+//	GET    /indexes               topic and service routing indexes
+func (lb *loadBalancer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", lb.handleBackends)
+	mux.HandleFunc("/backends/", lb.handleBackend)
+	mux.HandleFunc("/indexes", lb.handleIndexes)
+	return mux
+}
+
+// backendStatus is the admin view of one backend.
+type backendStatus struct {
+	Addr     string   `json:"addr"`
+	Weight   int      `json:"weight"`
+	Source   string   `json:"source"`
+	Healthy  bool     `json:"healthy"`
+	Draining bool     `json:"draining"`
+	Removing bool     `json:"removing"`
+	Active   int64    `json:"active"`
+	Requests uint64   `json:"requests"`
+	Topics   []string `json:"topics"`
+	Services []string `json:"services"`
+}
+
+type addBackendRequest struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+}
+
+func (lb *loadBalancer) handleBackends(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		lb.mu.RLock()
+		resp := make([]backendStatus, 0, len(lb.backends))
+		for _, b := range lb.backends {
+			resp = append(resp, backendStatus{
+				Addr:     b.addr,
+				Weight:   b.weight,
+				Source:   b.source,
+				Healthy:  b.healthy,
+				Draining: b.draining,
+				Removing: b.removing,
+				Active:   b.active.Load(),
+				Requests: b.requests.Load(),
+				Topics:   sortedKeys(b.topics),
+				Services: sortedKeys(b.services),
+			})
+		}
+		lb.mu.RUnlock()
+		writeJSON(w, http.StatusOK, resp)
+
+	case http.MethodPost:
+		var req addBackendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+			return
+		}
+		if req.Addr == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "addr is required"})
+			return
+		}
+		if req.Weight == 0 {
+			req.Weight = 1
+		}
+		if req.Weight < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "weight must be positive"})
+			return
+		}
+		if err := lb.addBackend(req.Addr, req.Weight, sourceAdmin); err != nil {
+			writeJSON(w, adminStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"status": "added", "addr": req.Addr})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBackend handles /backends/{addr} and /backends/{addr}/{drain,undrain}.
+func (lb *loadBalancer) handleBackend(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/backends/")
+	addr, action, _ := strings.Cut(rest, "/")
+	if addr == "" {
+		http.Error(w, "missing backend address", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	var status string
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		err, status = lb.removeBackend(addr), "removing"
+	case action == "drain" && r.Method == http.MethodPost:
+		err, status = lb.setDraining(addr, true), "draining"
+	case action == "undrain" && r.Method == http.MethodPost:
+		err, status = lb.setDraining(addr, false), "active"
+	case action == "" || action == "drain" || action == "undrain":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.Error(w, "unknown action", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSON(w, adminStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": status, "addr": addr})
+}
+
+func (lb *loadBalancer) handleIndexes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lb.mu.RLock()
+	resolve := func(index map[string][]int) map[string][]string {
+		out := make(map[string][]string, len(index))
+		for name, idxs := range index {
+			for _, i := range idxs {
+				out[name] = append(out[name], lb.backends[i].addr)
+			}
+		}
+		return out
+	}
+	resp := map[string]map[string][]string{
+		"topics":   resolve(lb.topicIndex),
+		"services": resolve(lb.serviceIndex),
+	}
+	lb.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, errBackendExists), errors.Is(err, errBackendRemoving):
+		return http.StatusConflict
+	case errors.Is(err, errBackendNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
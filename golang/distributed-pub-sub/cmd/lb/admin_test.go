@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveHTTPAddr(t *testing.T) {
+	tests := []struct {
+		http, grpc, via, want string
+	}{
+		{"10.0.0.2:8080", "10.0.0.2:9000", "lb:1", "10.0.0.2:8080"},
+		{":8082", "node2:9002", "node1:8081", "node2:8082"},
+		{":8081", ":9001", "node1:8081", "node1:8081"},
+		{"0.0.0.0:8081", "", "", "localhost:8081"},
+		{"", "node2:9002", "node1:8081", ""},
+	}
+	for _, tt := range tests {
+		if got := resolveHTTPAddr(tt.http, tt.grpc, tt.via); got != tt.want {
+			t.Errorf("resolveHTTPAddr(%q, %q, %q) = %q, want %q", tt.http, tt.grpc, tt.via, got, tt.want)
+		}
+	}
+}
+
+func TestSyncMembers(t *testing.T) {
+	members := `{"members":[{"grpc_address":"node1:9001","http_address":":8081"},{"grpc_address":"node2:9002","http_address":":8082"}]}`
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(members))
+	}))
+	t.Cleanup(seed.Close)
+	seedAddr := strings.TrimPrefix(seed.URL, "http://")
+
+	lb := newLoadBalancer(&roundRobin{}, time.Second)
+	lb.addBackend("static:8080", 1, sourceStatic)
+	client := seed.Client()
+
+	lb.syncMembers(client, []string{seedAddr})
+	if got := backendAddrs(lb); got != "static:8080,node1:8081,node2:8082" {
+		t.Fatalf("after join: %s", got)
+	}
+
+	// node2 leaves; static backends are never removed by membership.
+	members = `{"members":[{"grpc_address":"node1:9001","http_address":":8081"}]}`
+	lb.syncMembers(client, []string{seedAddr})
+	lb.mu.RLock()
+	removing := lb.findLocked("node2:8082").removing
+	lb.mu.RUnlock()
+	if !removing {
+		t.Fatal("node2 should be draining for removal")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if got := backendAddrs(lb); got != "static:8080,node1:8081" {
+		t.Fatalf("after leave: %s", got)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	lb := newLoadBalancer(&roundRobin{}, time.Second)
+	srv := httptest.NewServer(lb.adminHandler())
+	t.Cleanup(srv.Close)
+
+	do := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := do("POST", "/backends", `{"addr":"a:8080","weight":2}`); code != http.StatusCreated {
+		t.Fatalf("add: %d", code)
+	}
+	if code := do("POST", "/backends", `{"addr":"a:8080"}`); code != http.StatusConflict {
+		t.Fatalf("duplicate add: %d", code)
+	}
+	if code := do("POST", "/backends/a:8080/drain", ""); code != http.StatusOK {
+		t.Fatalf("drain: %d", code)
+	}
+	if b := lb.healthyBackend("k"); b != nil {
+		t.Fatalf("drained backend still picked: %s", b.addr)
+	}
+	if code := do("POST", "/backends/a:8080/undrain", ""); code != http.StatusOK {
+		t.Fatalf("undrain: %d", code)
+	}
+
+	resp, err := http.Get(srv.URL + "/backends")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var list []backendStatus
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 1 || list[0].Weight != 2 || list[0].Source != sourceAdmin || list[0].Draining {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	if code := do("DELETE", "/backends/missing:1", ""); code != http.StatusNotFound {
+		t.Fatalf("delete missing: %d", code)
+	}
+	// An open connection keeps the backend draining instead of removed.
+	lb.mu.RLock()
+	a := lb.findLocked("a:8080")
+	lb.mu.RUnlock()
+	a.active.Add(1)
+	if code := do("DELETE", "/backends/a:8080", ""); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	if code := do("POST", "/backends/a:8080/undrain", ""); code != http.StatusConflict {
+		t.Fatalf("undrain while removing: %d", code)
+	}
+	a.active.Add(-1)
+}
+
+func backendAddrs(lb *loadBalancer) string {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	var addrs []string
+	for _, b := range lb.backends {
+		addrs = append(addrs, b.addr)
+	}
+	return strings.Join(addrs, ",")
+}
+
+func TestSyncDNS(t *testing.T) {
+	lb := newLoadBalancer(&roundRobin{}, time.Second)
+	lb.addBackend("static:8080", 1, sourceStatic)
+
+	lb.syncDNS([]string{"10.0.0.1", "node2.svc.:9002"}, "8080")
+	if got := backendAddrs(lb); got != "static:8080,10.0.0.1:8080,node2.svc:8080" {
+		t.Fatalf("after resolve: %s", got)
+	}
+
+	// A failed lookup keeps everything.
+	lb.syncDNS(nil, "8080")
+	if got := backendAddrs(lb); got != "static:8080,10.0.0.1:8080,node2.svc:8080" {
+		t.Fatalf("after failed lookup: %s", got)
+	}
+
+	lb.syncDNS([]string{"node2.svc.:9002"}, "8080")
+	time.Sleep(300 * time.Millisecond)
+	if got := backendAddrs(lb); got != "static:8080,node2.svc:8080" {
+		t.Fatalf("after 10.0.0.1 left the record: %s", got)
+	}
+}
+
+func TestExpireUnhealthy(t *testing.T) {
+	lb := newLoadBalancer(&roundRobin{}, time.Second)
+	lb.addBackend("a:8080", 1, sourceMDNS)
+	lb.addBackend("b:8080", 1, sourceMDNS)
+	lb.addBackend("c:8080", 1, sourceStatic)
+
+	lb.mu.RLock()
+	for _, b := range lb.backends {
+		b.healthy = b.addr == "b:8080"
+		b.unhealthySince = time.Now().Add(-time.Hour)
+	}
+	lb.mu.RUnlock()
+
+	lb.expireUnhealthy(sourceMDNS, time.Minute)
+	time.Sleep(300 * time.Millisecond)
+	if got := backendAddrs(lb); got != "b:8080,c:8080" {
+		t.Fatalf("after expiry: %s", got)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"distributed-pub-sub/pubsub/discovery"
+)
+
+const (
+	// dnsResolveInterval is how often -discover=dns:<name> is re-resolved.
+	dnsResolveInterval = 10 * time.Second
+	// mdnsExpiry is how long an mDNS backend may fail health checks before
+	// it is treated as gone: the TTL of the records nodes announce.
+	mdnsExpiry = 120 * time.Second
+)
+
+// membersResponse is the JSON returned by a node's /members endpoint.
+type membersResponse struct {
+	NodeID  string `json:"node_id"`
+	Members []struct {
+		NodeID      string `json:"node_id"`
+		GRPCAddress string `json:"grpc_address"`
+		HTTPAddress string `json:"http_address"`
+	} `json:"members"`
+}
+
+// syncMembers follows cluster membership through a node: it asks the first
+// reachable node (seeds first, then previously learned members) for its
+// /members list, adds new members and removes cluster-sourced backends that
+// are no longer listed. Nothing changes if no node answers.
+func (lb *loadBalancer) syncMembers(client *http.Client, seeds []string) {
+	candidates := append([]string(nil), seeds...)
+	lb.mu.RLock()
+	for _, b := range lb.backends {
+		if b.source == sourceCluster && !b.removing {
+			candidates = append(candidates, b.addr)
+		}
+	}
+	lb.mu.RUnlock()
+
+	var addrs map[string]bool
+	for _, via := range candidates {
+		var err error
+		if addrs, err = fetchMembers(client, via); err == nil {
+			break
+		}
+		log.Printf("membership from %s: %v", via, err)
+	}
+	if addrs == nil {
+		return
+	}
+
+	lb.reconcile(sourceCluster, addrs)
+}
+
+// reconcile makes the backends added from source match addrs: missing ones
+// are added and those no longer listed are drained and removed.
+func (lb *loadBalancer) reconcile(source string, addrs map[string]bool) {
+	sorted := make([]string, 0, len(addrs))
+	for addr := range addrs {
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+	for _, addr := range sorted {
+		if err := lb.addBackend(addr, 1, source); err != nil && !errors.Is(err, errBackendExists) {
+			log.Printf("failed to add %s backend %s: %v", source, addr, err)
+		}
+	}
+
+	var gone []string
+	lb.mu.RLock()
+	for _, b := range lb.backends {
+		if b.source == source && !b.removing && !addrs[b.addr] {
+			gone = append(gone, b.addr)
+		}
+	}
+	lb.mu.RUnlock()
+	for _, addr := range gone {
+		log.Printf("%s backend %s is gone", source, addr)
+		lb.removeBackend(addr)
+	}
+}
+
+// fetchMembers returns the HTTP addresses of all members known to the node
+// at via.
+func fetchMembers(client *http.Client, via string) (map[string]bool, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/members", via))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var members membersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return nil, fmt.Errorf("decode members: %w", err)
+	}
+
+	addrs := make(map[string]bool, len(members.Members))
+	for _, m := range members.Members {
+		if addr := resolveHTTPAddr(m.HTTPAddress, m.GRPCAddress, via); addr != "" {
+			addrs[addr] = true
+		}
+	}
+	return addrs, nil
+}
+
+// resolveHTTPAddr turns an advertised HTTP address into a dialable one. Nodes
+// usually listen on ":port", so a missing or unspecified host is taken from
+// the member's gRPC address, or failing that from the node that reported it.
+func resolveHTTPAddr(httpAddr, grpcAddr, via string) string {
+	host, port, err := net.SplitHostPort(httpAddr)
+	if err != nil {
+		return ""
+	}
+	for _, fallback := range []string{grpcAddr, via} {
+		if !unspecifiedHost(host) {
+			break
+		}
+		host, _, _ = net.SplitHostPort(fallback)
+	}
+	if unspecifiedHost(host) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func unspecifiedHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// newDiscovery parses a -discover value: "dns:<name>" or "mdns".
+func newDiscovery(spec string) (discovery.Discovery, string, error) {
+	switch {
+	case spec == "mdns":
+		return discovery.NewMDNS(), sourceMDNS, nil
+	case strings.HasPrefix(spec, "dns:") && len(spec) > len("dns:"):
+		return discovery.NewDNS(strings.TrimPrefix(spec, "dns:")), sourceDNS, nil
+	default:
+		return nil, "", fmt.Errorf("invalid -discover %q (want dns:<name> or mdns)", spec)
+	}
+}
+
+// discoveredAddr turns an address reported by discovery into a backend
+// address. Discovery yields gRPC addresses (or bare hosts for DNS A records),
+// so the port is replaced with httpPort.
+func discoveredAddr(addr, httpPort string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.TrimSuffix(host, ".")
+	if unspecifiedHost(host) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, httpPort)
+}
+
+// watchDiscovery keeps a backend for every node d reports. DNS is re-resolved
+// every dnsResolveInterval and diffed against the DNS backends, so nodes
+// dropped from the record are drained and removed. mDNS only announces
+// arrivals; its backends are removed by healthCheck once they have failed
+// checks for longer than mdnsExpiry. The load balancer does not advertise
+// itself.
+func (lb *loadBalancer) watchDiscovery(ctx context.Context, d discovery.Discovery, source, httpPort string) error {
+	if dns, ok := d.(*discovery.DNS); ok {
+		go func() {
+			ticker := time.NewTicker(dnsResolveInterval)
+			defer ticker.Stop()
+			for {
+				lb.syncDNS(dns.Lookup(), httpPort)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		return nil
+	}
+
+	ch, err := d.Start(ctx, "")
+	if err != nil {
+		return fmt.Errorf("start %s discovery: %w", source, err)
+	}
+
+	go func() {
+		for addr := range ch {
+			backendAddr := discoveredAddr(addr, httpPort)
+			if err := lb.addBackend(backendAddr, 1, source); err != nil && !errors.Is(err, errBackendExists) {
+				log.Printf("failed to add discovered backend %s: %v", backendAddr, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// syncDNS reconciles the DNS backends with one lookup's addresses. A failed
+// or empty lookup changes nothing, so a resolver outage does not drain every
+// backend.
+func (lb *loadBalancer) syncDNS(resolved []string, httpPort string) {
+	if len(resolved) == 0 {
+		return
+	}
+	addrs := make(map[string]bool, len(resolved))
+	for _, addr := range resolved {
+		addrs[discoveredAddr(addr, httpPort)] = true
+	}
+	lb.reconcile(sourceDNS, addrs)
+}
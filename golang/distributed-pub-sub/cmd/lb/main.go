@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -18,65 +19,208 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Backend sources record how a backend was added. Only backends learned from
+// cluster membership or discovery are removed automatically when they leave.
+const (
+	sourceStatic  = "static"
+	sourceAdmin   = "admin"
+	sourceCluster = "cluster"
+	sourceDNS     = "dns"
+	sourceMDNS    = "mdns"
+)
+
+var (
+	errBackendExists   = errors.New("backend already exists")
+	errBackendNotFound = errors.New("backend not found")
+	errBackendRemoving = errors.New("backend is being removed")
 )
 
 type backend struct {
 	addr     string
 	url      *url.URL
+	weight   int
+	source   string
 	healthy  bool
+	draining bool // no new requests are routed here
+	removing bool // dropped from the list once drained
 	topics   map[string]bool
 	services map[string]bool
+
+	unhealthySince time.Time // when healthy last turned false
+
+	active   atomic.Int64  // in-flight requests and open WebSocket connections
+	requests atomic.Uint64 // total requests and WebSocket connections routed
+
+	connMu sync.Mutex
+	conns  map[*websocket.Conn]struct{} // client side of proxied WebSockets
 }
 
 type loadBalancer struct {
 	mu       sync.RWMutex
 	backends []*backend
-	counter  atomic.Uint64
+	strategy strategy
+
+	// drainTimeout bounds how long a removed backend's connections may
+	// stay open before they are closed.
+	drainTimeout time.Duration
 
 	// Indexes: topic/service -> list of backend indexes that serve them.
 	topicIndex   map[string][]int
 	serviceIndex map[string][]int
 }
 
-func newLoadBalancer() *loadBalancer {
+func newLoadBalancer(s strategy, drainTimeout time.Duration) *loadBalancer {
 	return &loadBalancer{
+		strategy:     s,
+		drainTimeout: drainTimeout,
 		topicIndex:   make(map[string][]int),
 		serviceIndex: make(map[string][]int),
 	}
 }
 
-func (lb *loadBalancer) addBackend(addr string) {
+func (lb *loadBalancer) addBackend(addr string, weight int, source string) error {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	for _, b := range lb.backends {
-		if b.addr == addr {
-			return
-		}
+	if lb.findLocked(addr) != nil {
+		return errBackendExists
 	}
 
 	u, err := url.Parse(fmt.Sprintf("http://%s", addr))
 	if err != nil {
-		log.Printf("invalid backend address %s: %v", addr, err)
-		return
+		return fmt.Errorf("invalid backend address %s: %w", addr, err)
 	}
 
 	lb.backends = append(lb.backends, &backend{
 		addr:     addr,
 		url:      u,
+		weight:   weight,
+		source:   source,
 		healthy:  true,
 		topics:   make(map[string]bool),
 		services: make(map[string]bool),
+		conns:    make(map[*websocket.Conn]struct{}),
 	})
-	log.Printf("added backend: %s", addr)
+	lb.strategy.update(lb.backends)
+	log.Printf("added %s backend: %s (weight %d)", source, addr, weight)
+	return nil
 }
 
-// healthyBackend returns a healthy backend using round-robin.
-func (lb *loadBalancer) healthyBackend() *backend {
+// findLocked returns the backend with the given address, or nil. Must be
+// called with lb.mu held.
+func (lb *loadBalancer) findLocked(addr string) *backend {
+	for _, b := range lb.backends {
+		if b.addr == addr {
+			return b
+		}
+	}
+	return nil
+}
+
+// setDraining stops (or resumes) routing new requests to addr. Existing
+// connections are left open.
+func (lb *loadBalancer) setDraining(addr string, draining bool) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	b := lb.findLocked(addr)
+	if b == nil {
+		return errBackendNotFound
+	}
+	if b.removing {
+		return errBackendRemoving
+	}
+	if b.draining != draining {
+		b.draining = draining
+		log.Printf("backend %s draining=%v (%d active connections)", addr, draining, b.active.Load())
+	}
+	return nil
+}
+
+// removeBackend stops routing new requests to addr and removes it once its
+// active connections have finished. WebSocket connections still open after
+// drainTimeout are closed so clients reconnect to another backend.
+func (lb *loadBalancer) removeBackend(addr string) error {
+	lb.mu.Lock()
+	b := lb.findLocked(addr)
+	if b == nil {
+		lb.mu.Unlock()
+		return errBackendNotFound
+	}
+	if b.removing {
+		lb.mu.Unlock()
+		return nil
+	}
+	b.draining = true
+	b.removing = true
+	lb.mu.Unlock()
+
+	log.Printf("draining backend %s (%d active connections)", addr, b.active.Load())
+	go lb.drain(b)
+	return nil
+}
+
+func (lb *loadBalancer) drain(b *backend) {
+	deadline := time.Now().Add(lb.drainTimeout)
+	for b.active.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := b.closeConns(); n > 0 {
+		log.Printf("closed %d connections to backend %s after drain timeout", n, b.addr)
+	}
+
+	lb.mu.Lock()
+	for i, cand := range lb.backends {
+		if cand == b {
+			lb.backends = append(lb.backends[:i], lb.backends[i+1:]...)
+			break
+		}
+	}
+	lb.strategy.update(lb.backends)
+	lb.rebuildIndexesLocked()
+	lb.mu.Unlock()
+
+	log.Printf("removed backend: %s", b.addr)
+}
+
+func (b *backend) trackConn(c *websocket.Conn) {
+	b.connMu.Lock()
+	b.conns[c] = struct{}{}
+	b.connMu.Unlock()
+}
+
+func (b *backend) untrackConn(c *websocket.Conn) {
+	b.connMu.Lock()
+	delete(b.conns, c)
+	b.connMu.Unlock()
+}
+
+// closeConns sends a going-away close frame to every proxied WebSocket client
+// and closes the connections. It returns how many were closed.
+func (b *backend) closeConns() int {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "backend draining")
+	for c := range b.conns {
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.Close()
+	}
+	n := len(b.conns)
+	clear(b.conns)
+	return n
+}
+
+// healthyBackend returns a healthy backend for the client key.
+func (lb *loadBalancer) healthyBackend(key string) *backend {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
 
-	return lb.pickHealthy(lb.allIndexes())
+	return lb.pickHealthy(lb.allIndexes(), key)
 }
 
 // healthyBackendForTopic returns a healthy backend that has subscribers for the topic.
@@ -86,11 +230,11 @@ func (lb *loadBalancer) healthyBackendForTopic(topic string) *backend {
 	defer lb.mu.RUnlock()
 
 	if idxs, ok := lb.topicIndex[topic]; ok && len(idxs) > 0 {
-		if b := lb.pickHealthy(idxs); b != nil {
+		if b := lb.pickHealthy(idxs, topic); b != nil {
 			return b
 		}
 	}
-	return lb.pickHealthy(lb.allIndexes())
+	return lb.pickHealthy(lb.allIndexes(), topic)
 }
 
 // healthyBackendForService returns a healthy backend that has the service registered.
@@ -100,28 +244,26 @@ func (lb *loadBalancer) healthyBackendForService(service string) *backend {
 	defer lb.mu.RUnlock()
 
 	if idxs, ok := lb.serviceIndex[service]; ok && len(idxs) > 0 {
-		if b := lb.pickHealthy(idxs); b != nil {
+		if b := lb.pickHealthy(idxs, service); b != nil {
 			return b
 		}
 	}
-	return lb.pickHealthy(lb.allIndexes())
+	return lb.pickHealthy(lb.allIndexes(), service)
 }
 
-// pickHealthy selects a healthy backend from the given index list using round-robin.
-// Must be called with lb.mu held.
-func (lb *loadBalancer) pickHealthy(idxs []int) *backend {
-	if len(idxs) == 0 {
-		return nil
-	}
-	n := len(idxs)
-	start := int(lb.counter.Add(1)) % n
-	for i := range n {
-		b := lb.backends[idxs[(start+i)%n]]
-		if b.healthy {
-			return b
+// pickHealthy selects a backend from the healthy, non-draining members of the
+// given index list using the configured strategy. Must be called with lb.mu held.
+func (lb *loadBalancer) pickHealthy(idxs []int, key string) *backend {
+	cands := make([]*backend, 0, len(idxs))
+	for _, i := range idxs {
+		if b := lb.backends[i]; b.healthy && !b.draining {
+			cands = append(cands, b)
 		}
 	}
-	return nil
+	if len(cands) == 0 {
+		return nil
+	}
+	return lb.strategy.pick(cands, key)
 }
 
 func (lb *loadBalancer) allIndexes() []int {
@@ -154,6 +296,7 @@ func (lb *loadBalancer) healthCheck() {
 		if err != nil || resp.StatusCode != http.StatusOK {
 			b.healthy = false
 			if wasHealthy {
+				b.unhealthySince = time.Now()
 				log.Printf("backend %s is unhealthy", b.addr)
 			}
 		} else {
@@ -173,6 +316,25 @@ func (lb *loadBalancer) healthCheck() {
 	}
 
 	lb.rebuildIndexes()
+	lb.expireUnhealthy(sourceMDNS, mdnsExpiry)
+}
+
+// expireUnhealthy removes backends from source that have been unhealthy for
+// longer than after. It stands in for membership where discovery cannot
+// report departures.
+func (lb *loadBalancer) expireUnhealthy(source string, after time.Duration) {
+	var gone []string
+	lb.mu.RLock()
+	for _, b := range lb.backends {
+		if b.source == source && !b.removing && !b.healthy && time.Since(b.unhealthySince) > after {
+			gone = append(gone, b.addr)
+		}
+	}
+	lb.mu.RUnlock()
+	for _, addr := range gone {
+		log.Printf("%s backend %s unhealthy for over %v, removing", source, addr, after)
+		lb.removeBackend(addr)
+	}
 }
 
 func (lb *loadBalancer) fetchRoutes(client *http.Client, _ int, b *backend) {
@@ -208,6 +370,11 @@ func (lb *loadBalancer) rebuildIndexes() {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
+	lb.rebuildIndexesLocked()
+}
+
+// rebuildIndexesLocked must be called with lb.mu held.
+func (lb *loadBalancer) rebuildIndexesLocked() {
 	topicIdx := make(map[string][]int)
 	serviceIdx := make(map[string][]int)
 
@@ -238,11 +405,14 @@ func (lb *loadBalancer) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	b := lb.pickBackendForPath(r.URL.Path)
+	b := lb.pickBackendForRequest(r)
 	if b == nil {
 		http.Error(w, "no healthy backends", http.StatusServiceUnavailable)
 		return
 	}
+	b.requests.Add(1)
+	b.active.Add(1)
+	defer b.active.Add(-1)
 
 	proxy := httputil.NewSingleHostReverseProxy(b.url)
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
@@ -253,11 +423,14 @@ func (lb *loadBalancer) serveHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (lb *loadBalancer) serveWS(w http.ResponseWriter, r *http.Request) {
-	b := lb.pickBackendForPath(r.URL.Path)
+	b := lb.pickBackendForRequest(r)
 	if b == nil {
 		http.Error(w, "no healthy backends", http.StatusServiceUnavailable)
 		return
 	}
+	b.requests.Add(1)
+	b.active.Add(1)
+	defer b.active.Add(-1)
 
 	// Upgrade client connection
 	clientConn, err := upgrader.Upgrade(w, r, nil)
@@ -266,6 +439,8 @@ func (lb *loadBalancer) serveWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer clientConn.Close()
+	b.trackConn(clientConn)
+	defer b.untrackConn(clientConn)
 
 	// Connect to upstream
 	upstreamURL := fmt.Sprintf("ws://%s%s", b.addr, r.URL.Path)
@@ -310,11 +485,12 @@ func (lb *loadBalancer) serveWS(w http.ResponseWriter, r *http.Request) {
 	<-done
 }
 
-// pickBackendForPath selects a backend based on the URL path.
-// /topics/{topic} routes to a backend with that topic.
-// /svc/{service}/... routes to a backend with that service.
-// Everything else uses global round-robin.
-func (lb *loadBalancer) pickBackendForPath(path string) *backend {
+// pickBackendForRequest selects a backend based on the URL path.
+// /topics/{topic} routes to a backend with that topic, keyed by topic.
+// /svc/{service}/... routes to a backend with that service, keyed by service.
+// Everything else picks from all backends, keyed by client (see clientKey).
+func (lb *loadBalancer) pickBackendForRequest(r *http.Request) *backend {
+	path := r.URL.Path
 	if topic, ok := strings.CutPrefix(path, "/topics/"); ok && topic != "" {
 		return lb.healthyBackendForTopic(topic)
 	}
@@ -326,36 +502,87 @@ func (lb *loadBalancer) pickBackendForPath(path string) *backend {
 		}
 	}
 
-	return lb.healthyBackend()
+	return lb.healthyBackend(clientKey(r))
 }
 
 func main() {
 	addr := flag.String("addr", ":8081", "listen address")
-	nodes := flag.String("nodes", "", "comma-separated node HTTP addresses")
+	nodes := flag.String("nodes", "", "comma-separated node HTTP addresses, each optionally addr=weight")
+	strategyName := flag.String("strategy", "roundrobin", "backend selection: roundrobin, hash, leastconn or weighted")
+	loadFactor := flag.Float64("hash-load-factor", 1.25, "max backend load relative to the average for -strategy=hash")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long a removed backend's connections may stay open")
+	cluster := flag.String("cluster", "", "comma-separated node HTTP addresses to follow cluster membership through")
+	discover := flag.String("discover", "", "discover nodes via dns:<name> or mdns")
+	discoverPort := flag.String("discover-http-port", "8080", "HTTP port of nodes found by -discover")
+	adminAddr := flag.String("admin", "localhost:8090", "admin API listen address (empty = disabled)")
 	flag.Parse()
 
-	lb := newLoadBalancer()
+	s, err := newStrategy(*strategyName, *loadFactor)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	lb := newLoadBalancer(s, *drainTimeout)
 
 	if *nodes != "" {
 		for _, n := range strings.Split(*nodes, ",") {
 			n = strings.TrimSpace(n)
-			if n != "" {
-				lb.addBackend(n)
+			if n == "" {
+				continue
+			}
+			addr, weight, err := parseBackend(n)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if err := lb.addBackend(addr, weight, sourceStatic); err != nil {
+				log.Printf("backend %s: %v", addr, err)
+			}
+		}
+	}
+
+	var seeds []string
+	if *cluster != "" {
+		for _, n := range strings.Split(*cluster, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				seeds = append(seeds, n)
 			}
 		}
 	}
 
-	// Health check + route sync loop.
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+	if *discover != "" {
+		d, source, err := newDiscovery(*discover)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := lb.watchDiscovery(discoveryCtx, d, source, *discoverPort); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer d.Stop()
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newLBCollector(lb))
+
+	// Membership, health check + route sync loop.
 	go func() {
+		client := &http.Client{Timeout: 3 * time.Second}
+		if len(seeds) > 0 {
+			lb.syncMembers(client, seeds)
+		}
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 		for range ticker.C {
+			if len(seeds) > 0 {
+				lb.syncMembers(client, seeds)
+			}
 			lb.healthCheck()
 		}
 	}()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", lb.serveHTTP)
+	mux.Handle("/lb/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 
 	server := &http.Server{
 		Addr:    *addr,
@@ -369,6 +596,20 @@ func main() {
 		}
 	}()
 
+	var admin *http.Server
+	if *adminAddr != "" {
+		admin = &http.Server{
+			Addr:    *adminAddr,
+			Handler: lb.adminHandler(),
+		}
+		go func() {
+			log.Printf("admin API listening on %s", *adminAddr)
+			if err := admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("admin server error: %v", err)
+			}
+		}()
+	}
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigCh
@@ -379,6 +620,9 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("server shutdown error: %v", err)
 	}
+	if admin != nil {
+		admin.Shutdown(ctx)
+	}
 
 	log.Println("shutdown complete")
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lbCollector implements prometheus.Collector by reading per-backend counters
+// on each scrape.
+type lbCollector struct {
+	lb *loadBalancer
+
+	activeDesc   *prometheus.Desc
+	requestsDesc *prometheus.Desc
+	healthyDesc  *prometheus.Desc
+	drainingDesc *prometheus.Desc
+}
+
+func newLBCollector(lb *loadBalancer) *lbCollector {
+	labels := []string{"backend"}
+	return &lbCollector{
+		lb:           lb,
+		activeDesc:   prometheus.NewDesc("lb_backend_active_connections", "Active proxied requests and WebSocket connections per backend", labels, nil),
+		requestsDesc: prometheus.NewDesc("lb_backend_requests_total", "Total requests and WebSocket connections routed to each backend", labels, nil),
+		healthyDesc:  prometheus.NewDesc("lb_backend_healthy", "Whether the backend passed its last health check", labels, nil),
+		drainingDesc: prometheus.NewDesc("lb_backend_draining", "Whether the backend is draining before removal", labels, nil),
+	}
+}
+
+func (c *lbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeDesc
+	ch <- c.requestsDesc
+	ch <- c.healthyDesc
+	ch <- c.drainingDesc
+}
+
+func (c *lbCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lb.mu.RLock()
+	defer c.lb.mu.RUnlock()
+
+	for _, b := range c.lb.backends {
+		ch <- prometheus.MustNewConstMetric(c.activeDesc, prometheus.GaugeValue, float64(b.active.Load()), b.addr)
+		ch <- prometheus.MustNewConstMetric(c.requestsDesc, prometheus.CounterValue, float64(b.requests.Load()), b.addr)
+		ch <- prometheus.MustNewConstMetric(c.healthyDesc, prometheus.GaugeValue, boolFloat(b.healthy), b.addr)
+		ch <- prometheus.MustNewConstMetric(c.drainingDesc, prometheus.GaugeValue, boolFloat(b.draining), b.addr)
+	}
+}
+
+func boolFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
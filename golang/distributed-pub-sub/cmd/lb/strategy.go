@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// strategy chooses a backend for a request.
+type strategy interface {
+	// pick selects one of cands, which are all healthy and not draining.
+	// key identifies the request for affinity-aware strategies.
+	pick(cands []*backend, key string) *backend
+
+	// update is called with the full backend list whenever it changes.
+	update(backends []*backend)
+}
+
+func newStrategy(name string, loadFactor float64) (strategy, error) {
+	switch name {
+	case "roundrobin":
+		return &roundRobin{}, nil
+	case "hash":
+		if loadFactor < 1 {
+			return nil, fmt.Errorf("hash load factor must be >= 1, got %g", loadFactor)
+		}
+		return &consistentHash{loadFactor: loadFactor}, nil
+	case "leastconn":
+		return leastConn{}, nil
+	case "weighted":
+		return &weightedRoundRobin{current: make(map[*backend]int)}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q (want roundrobin, hash, leastconn or weighted)", name)
+	}
+}
+
+// --- Round-robin ---
+
+type roundRobin struct {
+	counter atomic.Uint64
+}
+
+func (s *roundRobin) pick(cands []*backend, _ string) *backend {
+	if len(cands) == 0 {
+		return nil
+	}
+	return cands[int(s.counter.Add(1)%uint64(len(cands)))]
+}
+
+func (s *roundRobin) update([]*backend) {}
+
+// --- Consistent hashing with bounded load ---
+
+// ringReplicas is the number of points each unit of backend weight places on
+// the hash ring.
+const ringReplicas = 100
+
+type ringPoint struct {
+	hash uint64
+	b    *backend
+}
+
+// consistentHash maps a key to the first backend clockwise from the key's
+// position on a ring of virtual nodes, so the same key keeps landing on the
+// same backend while the backend set is stable. A backend already holding
+// more than loadFactor times the average active connections is skipped and
+// the walk continues, bounding hot-spot load.
+type consistentHash struct {
+	loadFactor float64
+
+	mu   sync.RWMutex
+	ring []ringPoint
+}
+
+func (s *consistentHash) update(backends []*backend) {
+	var ring []ringPoint
+	for _, b := range backends {
+		for i := range ringReplicas * b.weight {
+			ring = append(ring, ringPoint{hash: hashKey(b.addr + "#" + strconv.Itoa(i)), b: b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	s.mu.Lock()
+	s.ring = ring
+	s.mu.Unlock()
+}
+
+func (s *consistentHash) pick(cands []*backend, key string) *backend {
+	if len(cands) == 0 {
+		return nil
+	}
+
+	allowed := make(map[*backend]bool, len(cands))
+	var total int64
+	for _, b := range cands {
+		allowed[b] = true
+		total += b.active.Load()
+	}
+	// Counting the incoming connection keeps the bound above zero.
+	limit := int64(math.Ceil(float64(total+1) / float64(len(cands)) * s.loadFactor))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.ring)
+	h := hashKey(key)
+	start := sort.Search(n, func(i int) bool { return s.ring[i].hash >= h })
+	var first *backend
+	for i := range n {
+		b := s.ring[(start+i)%n].b
+		if !allowed[b] {
+			continue
+		}
+		if b.active.Load() < limit {
+			return b
+		}
+		if first == nil {
+			first = b
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return cands[0]
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// --- Least connections ---
+
+// leastConn picks the backend with the fewest active connections relative to
+// its weight.
+type leastConn struct{}
+
+func (leastConn) pick(cands []*backend, _ string) *backend {
+	var best *backend
+	var bestActive int64
+	for _, b := range cands {
+		active := b.active.Load()
+		if best == nil || active*int64(best.weight) < bestActive*int64(b.weight) {
+			best, bestActive = b, active
+		}
+	}
+	return best
+}
+
+func (leastConn) update([]*backend) {}
+
+// --- Weighted round-robin ---
+
+// weightedRoundRobin is the smooth weighted round-robin used by nginx: each
+// pick raises every candidate's current weight by its configured weight and
+// selects the highest, which is then lowered by the total. Backends are
+// chosen in proportion to their weights without long runs of one backend.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*backend]int
+}
+
+func (s *weightedRoundRobin) pick(cands []*backend, _ string) *backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *backend
+	total := 0
+	for _, b := range cands {
+		s.current[b] += b.weight
+		total += b.weight
+		if best == nil || s.current[b] > s.current[best] {
+			best = b
+		}
+	}
+	if best != nil {
+		s.current[best] -= total
+	}
+	return best
+}
+
+func (s *weightedRoundRobin) update(backends []*backend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[*backend]int, len(backends))
+	for _, b := range backends {
+		current[b] = s.current[b]
+	}
+	s.current = current
+}
+
+// --- Request keys ---
+
+// clientKey identifies the client behind r for sticky routing: the
+// X-Client-Key header, else the client_id query parameter, else the remote IP.
+func clientKey(r *http.Request) string {
+	if k := r.Header.Get("X-Client-Key"); k != "" {
+		return k
+	}
+	if k := r.URL.Query().Get("client_id"); k != "" {
+		return k
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseBackend parses a -nodes entry of the form host:port or host:port=weight.
+func parseBackend(s string) (addr string, weight int, err error) {
+	addr, w, ok := strings.Cut(s, "=")
+	if !ok {
+		return addr, 1, nil
+	}
+	weight, err = strconv.Atoi(w)
+	if err != nil || weight < 1 {
+		return "", 0, fmt.Errorf("invalid weight in %q", s)
+	}
+	return addr, weight, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testBackends(weights ...int) []*backend {
+	var bs []*backend
+	for i, w := range weights {
+		bs = append(bs, &backend{addr: fmt.Sprintf("node%d:8080", i), weight: w})
+	}
+	return bs
+}
+
+func TestConsistentHash_Sticky(t *testing.T) {
+	bs := testBackends(1, 1, 1)
+	s := &consistentHash{loadFactor: 1.25}
+	s.update(bs)
+
+	for i := range 50 {
+		key := fmt.Sprintf("client-%d", i)
+		first := s.pick(bs, key)
+		if got := s.pick(bs, key); got != first {
+			t.Fatalf("key %s moved from %s to %s", key, first.addr, got.addr)
+		}
+
+		// Removing another backend must not move the key.
+		var rest []*backend
+		removed := false
+		for _, b := range bs {
+			if b != first && !removed {
+				removed = true
+				continue
+			}
+			rest = append(rest, b)
+		}
+		if got := s.pick(rest, key); got != first {
+			t.Fatalf("key %s moved to %s after removing an unrelated backend", key, got.addr)
+		}
+	}
+}
+
+func TestConsistentHash_BoundedLoad(t *testing.T) {
+	bs := testBackends(1, 1)
+	s := &consistentHash{loadFactor: 1.25}
+	s.update(bs)
+
+	// All connections share one key; the bound forces spill-over.
+	for range 100 {
+		s.pick(bs, "hot").active.Add(1)
+	}
+	for _, b := range bs {
+		if n := b.active.Load(); n > 63 {
+			t.Errorf("%s holds %d of 100 connections, want <= 63", b.addr, n)
+		}
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	bs := testBackends(3, 1)
+	s := &weightedRoundRobin{current: make(map[*backend]int)}
+	s.update(bs)
+
+	counts := make(map[*backend]int)
+	for range 40 {
+		counts[s.pick(bs, "")]++
+	}
+	if counts[bs[0]] != 30 || counts[bs[1]] != 10 {
+		t.Fatalf("got %d/%d picks, want 30/10", counts[bs[0]], counts[bs[1]])
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	bs := testBackends(1, 2)
+	bs[0].active.Store(2)
+	bs[1].active.Store(3)
+
+	// 3 connections at weight 2 is lighter than 2 at weight 1.
+	if got := (leastConn{}).pick(bs, ""); got != bs[1] {
+		t.Fatalf("picked %s, want %s", got.addr, bs[1].addr)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	if addr, w, err := parseBackend("localhost:8081=3"); err != nil || addr != "localhost:8081" || w != 3 {
+		t.Fatalf("got %q %d %v", addr, w, err)
+	}
+	if _, w, _ := parseBackend("localhost:8081"); w != 1 {
+		t.Fatalf("default weight = %d, want 1", w)
+	}
+	if _, _, err := parseBackend("localhost:8081=0"); err == nil {
+		t.Fatal("expected error for zero weight")
+	}
+}
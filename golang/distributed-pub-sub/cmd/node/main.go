@@ -25,6 +25,9 @@ func main() {
 	tlsCert := flag.String("tls-cert", "", "TLS certificate file path")
 	tlsKey := flag.String("tls-key", "", "TLS private key file path")
 	tlsCACert := flag.String("tls-ca", "", "TLS CA certificate file path")
+	compacted := flag.String("compacted", "", "comma-separated topics that keep only the latest value per key")
+	mqttAddr := flag.String("mqtt", "", "MQTT listen address (empty = disabled)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long to drain queued messages on shutdown")
 	flag.Parse()
 
 	opts := pubsub.DefaultOptions()
@@ -42,6 +45,9 @@ func main() {
 	if *seeds != "" {
 		opts.Seeds = strings.Split(*seeds, ",")
 	}
+	if *compacted != "" {
+		opts.CompactedTopics = strings.Split(*compacted, ",")
+	}
 
 	node := pubsub.NewNode(opts)
 
@@ -74,12 +80,29 @@ func main() {
 		}
 	}()
 
+	var broker *pubsub.MQTTBroker
+	if *mqttAddr != "" {
+		broker = pubsub.NewMQTTBroker(node)
+		go func() {
+			if err := broker.ListenAndServe(*mqttAddr); err != nil {
+				log.Fatalf("MQTT server error: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigCh
 	log.Printf("received signal %v, shutting down...", sig)
 
+	// Hand work to the rest of the cluster before tearing anything down.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), *drainTimeout)
+	if err := node.Drain(drainCtx); err != nil {
+		log.Printf("drain incomplete: %v", err)
+	}
+	cancelDrain()
+
 	// Graceful shutdown.
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -87,6 +110,9 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
+	if broker != nil {
+		broker.Close()
+	}
 	if err := node.Stop(); err != nil {
 		log.Printf("node shutdown error: %v", err)
 	}
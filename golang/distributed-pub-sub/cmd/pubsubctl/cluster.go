@@ -0,0 +1,239 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// member mirrors an entry of the gateway's /members response.
+type member struct {
+	NodeID      string `json:"node_id"`
+	GRPCAddress string `json:"grpc_address"`
+	HTTPAddress string `json:"http_address,omitempty"`
+	Self        bool   `json:"self,omitempty"`
+}
+
+type membersResponse struct {
+	NodeID  string   `json:"node_id"`
+	Members []member `json:"members"`
+}
+
+type routesResponse struct {
+	NodeID   string   `json:"node_id"`
+	Topics   []string `json:"topics"`
+	Services []string `json:"services"`
+}
+
+type healthResponse struct {
+	Status string           `json:"status"`
+	NodeID string           `json:"node_id"`
+	Stats  map[string]int64 `json:"stats"`
+}
+
+func (c *ctl) fetchMembers() (*membersResponse, error) {
+	var resp membersResponse
+	if err := c.do(http.MethodGet, c.addr, "/members", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *ctl) members(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("members takes no arguments")
+	}
+	resp, err := c.fetchMembers()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(resp.Members)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tGRPC\tHTTP\t")
+	for _, m := range resp.Members {
+		self := ""
+		if m.Self {
+			self = "(self)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.NodeID, m.GRPCAddress, m.HTTPAddress, self)
+	}
+	return tw.Flush()
+}
+
+// gatewayAddrs returns the HTTP addresses to query: just -addr, or with all
+// every member reachable through it.
+func (c *ctl) gatewayAddrs(all bool) ([]string, error) {
+	if !all {
+		return []string{c.addr}, nil
+	}
+	resp, err := c.fetchMembers()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, m := range resp.Members {
+		if m.Self {
+			addrs = append(addrs, c.addr)
+			continue
+		}
+		if addr := dialableHTTPAddr(m, c.addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// dialableHTTPAddr fills in a member's HTTP host when it is advertised as
+// ":port", using its gRPC host or else the host of the node we asked.
+func dialableHTTPAddr(m member, via string) string {
+	host, port, err := net.SplitHostPort(m.HTTPAddress)
+	if err != nil {
+		return ""
+	}
+	for _, fallback := range []string{m.GRPCAddress, via} {
+		if host != "" && !net.ParseIP(host).IsUnspecified() {
+			break
+		}
+		host, _, _ = net.SplitHostPort(fallback)
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (c *ctl) topics(args []string) error {
+	fs := flag.NewFlagSet("topics", flag.ContinueOnError)
+	all := fs.Bool("all", false, "query every cluster member")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	addrs, err := c.gatewayAddrs(*all)
+	if err != nil {
+		return err
+	}
+
+	var routes []routesResponse
+	for _, addr := range addrs {
+		var r routesResponse
+		if err := c.do(http.MethodGet, addr, "/routes", nil, &r); err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+		sort.Strings(r.Topics)
+		routes = append(routes, r)
+	}
+	if c.json {
+		return c.printJSON(routes)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tTOPIC")
+	for _, r := range routes {
+		for _, t := range r.Topics {
+			fmt.Fprintf(tw, "%s\t%s\n", r.NodeID, t)
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) services(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("services takes no arguments")
+	}
+	// node ID -> service -> servers
+	var svcs map[string]map[string][]string
+	if err := c.do(http.MethodGet, c.addr, "/services", nil, &svcs); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(svcs)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tSERVICE\tSERVERS")
+	for _, node := range sortedKeys(svcs) {
+		for _, svc := range sortedKeys(svcs[node]) {
+			fmt.Fprintf(tw, "%s\t%s\t%v\n", node, svc, svcs[node][svc])
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	all := fs.Bool("all", false, "query every cluster member")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	addrs, err := c.gatewayAddrs(*all)
+	if err != nil {
+		return err
+	}
+
+	var nodes []healthResponse
+	for _, addr := range addrs {
+		var h healthResponse
+		// A draining node reports 503 with its stats.
+		if err := c.do(http.MethodGet, addr, "/health", nil, &h, http.StatusOK, http.StatusServiceUnavailable); err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+		nodes = append(nodes, h)
+	}
+	if c.json {
+		return c.printJSON(nodes)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tSTATUS\tSTAT\tVALUE")
+	for _, h := range nodes {
+		for _, name := range sortedKeys(h.Stats) {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", h.NodeID, h.Status, name, h.Stats[name])
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) drain(args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 0, "how long the node waits for queues to flush (default: server default)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/drain"
+	if *timeout > 0 {
+		path += "?timeout=" + timeout.String()
+		// Leave the node time to answer after its own deadline.
+		if c.client.Timeout > 0 && c.client.Timeout < *timeout+5*time.Second {
+			c.client.Timeout = *timeout + 5*time.Second
+		}
+	} else {
+		c.client.Timeout = 0
+	}
+
+	var resp map[string]string
+	if err := c.do(http.MethodPost, c.addr, path, nil, &resp); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(resp)
+	}
+	fmt.Fprintf(c.out, "node %s drained\n", resp["node_id"])
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"distributed-pub-sub/pubsub"
+)
+
+func newTestCtl(t *testing.T, grpcAddr string) (*ctl, *pubsub.Node, *bytes.Buffer) {
+	t.Helper()
+	opts := pubsub.DefaultOptions()
+	opts.GRPCAddress = grpcAddr
+	opts.EnableMDNS = false
+	opts.MaxRetries = 1
+	opts.RetryBaseDelay = 10 * time.Millisecond
+	n := pubsub.NewNode(opts)
+	if err := n.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	t.Cleanup(func() { n.Stop() })
+
+	srv := httptest.NewServer(pubsub.NewGateway(n).Handler())
+	t.Cleanup(srv.Close)
+
+	out := &bytes.Buffer{}
+	c := &ctl{
+		addr:     strings.TrimPrefix(srv.URL, "http://"),
+		grpcAddr: grpcAddr,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		out:      out,
+	}
+	return c, n, out
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCtl_PublishAndRedrive(t *testing.T) {
+	c, n, out := newTestCtl(t, "localhost:19301")
+
+	var failing atomic.Bool
+	failing.Store(true)
+	var mu sync.Mutex
+	var got []string
+	if _, err := n.Subscribe("jobs", func(msg *pubsub.Message) error {
+		if failing.Load() {
+			return errors.New("handler failing")
+		}
+		mu.Lock()
+		got = append(got, string(msg.Payload))
+		mu.Unlock()
+		return nil
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if err := c.run("publish", []string{"-count", "2", "jobs", "job-{n}"}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if lines := strings.Fields(out.String()); len(lines) != 2 {
+		t.Fatalf("publish printed %q, want two message IDs", out.String())
+	}
+
+	waitFor(t, "dead letters", func() bool {
+		letters, err := c.listDeadLetters("jobs", 10, 0)
+		return err == nil && len(letters) == 2
+	})
+
+	out.Reset()
+	if err := c.run("dlq", []string{"list", "jobs"}); err != nil {
+		t.Fatalf("dlq list: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 3 {
+		t.Fatalf("dlq list output:\n%s", out.String())
+	}
+
+	failing.Store(false)
+	out.Reset()
+	if err := c.run("dlq", []string{"redrive", "jobs"}); err != nil {
+		t.Fatalf("dlq redrive: %v", err)
+	}
+	waitFor(t, "redriven messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 2
+	})
+	if letters, err := c.listDeadLetters("jobs", 10, 0); err != nil || len(letters) != 0 {
+		t.Fatalf("dead letters after redrive: %v, %v", letters, err)
+	}
+
+	out.Reset()
+	if err := c.run("stats", nil); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if !strings.Contains(out.String(), "messages_dlq") {
+		t.Fatalf("stats output missing messages_dlq:\n%s", out.String())
+	}
+}
+
+func TestCtl_TopicsAndDrain(t *testing.T) {
+	c, n, out := newTestCtl(t, "localhost:19302")
+	if _, err := n.Subscribe("orders", func(*pubsub.Message) error { return nil }); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if err := c.run("topics", []string{"-all"}); err != nil {
+		t.Fatalf("topics: %v", err)
+	}
+	if !strings.Contains(out.String(), n.NodeID()) || !strings.Contains(out.String(), "orders") {
+		t.Fatalf("topics output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := c.run("drain", []string{"-timeout", "1s"}); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if !n.Draining() || !strings.Contains(out.String(), "drained") {
+		t.Fatalf("drain output %q, draining=%v", out.String(), n.Draining())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+)
+
+// deadLetter mirrors storage.DeadLetter as the gateway encodes it.
+type deadLetter struct {
+	ID            string
+	OriginalTopic string
+	Source        string
+	Payload       []byte
+	Reason        string
+	Attempts      int32
+	DeadAt        int64
+	MessageID     string
+}
+
+func (c *ctl) dlq(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: dlq list|redrive|purge <topic> [args]")
+	}
+	sub, topic, rest := args[0], args[1], args[2:]
+	switch sub {
+	case "list":
+		return c.dlqList(topic, rest)
+	case "redrive":
+		return c.dlqRedrive(topic, rest)
+	case "purge":
+		return c.dlqPurge(topic, rest)
+	default:
+		return fmt.Errorf("unknown dlq command %q", sub)
+	}
+}
+
+func dlqPath(topic string) string {
+	return "/dlq/" + url.PathEscape(topic)
+}
+
+func (c *ctl) listDeadLetters(topic string, limit, offset int) ([]deadLetter, error) {
+	var letters []deadLetter
+	path := fmt.Sprintf("%s?limit=%d&offset=%d", dlqPath(topic), limit, offset)
+	if err := c.do(http.MethodGet, c.addr, path, nil, &letters); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+func (c *ctl) dlqList(topic string, args []string) error {
+	fs := flag.NewFlagSet("dlq list", flag.ContinueOnError)
+	limit := fs.Int("limit", 50, "maximum entries to list")
+	offset := fs.Int("offset", 0, "entries to skip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	letters, err := c.listDeadLetters(topic, *limit, *offset)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(letters)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tMESSAGE ID\tDEAD AT\tATTEMPTS\tREASON")
+	for _, l := range letters {
+		deadAt := time.Unix(0, l.DeadAt).Format(time.RFC3339)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", l.ID, l.MessageID, deadAt, l.Attempts, l.Reason)
+	}
+	return tw.Flush()
+}
+
+// dlqRedrive republishes the given dead letters, or every dead letter on the
+// topic if none are given. Each redriven entry is removed from the DLQ.
+func (c *ctl) dlqRedrive(topic string, ids []string) error {
+	if len(ids) == 0 {
+		// Collect every ID before redriving, since redriving shifts offsets.
+		const page = 100
+		for offset := 0; ; offset += page {
+			letters, err := c.listDeadLetters(topic, page, offset)
+			if err != nil {
+				return err
+			}
+			for _, l := range letters {
+				ids = append(ids, l.ID)
+			}
+			if len(letters) < page {
+				break
+			}
+		}
+	}
+
+	for _, id := range ids {
+		body := map[string]string{"id": id}
+		if err := c.do(http.MethodPost, c.addr, dlqPath(topic)+"/retry", body, nil); err != nil {
+			return fmt.Errorf("redrive %s: %w", id, err)
+		}
+		fmt.Fprintf(c.out, "redrove %s\n", id)
+	}
+	return nil
+}
+
+func (c *ctl) dlqPurge(topic string, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: dlq purge <topic>")
+	}
+	var resp struct {
+		Count int `json:"count"`
+	}
+	if err := c.do(http.MethodDelete, c.addr, dlqPath(topic), nil, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "purged %d dead letters from %s\n", resp.Count, topic)
+	return nil
+}
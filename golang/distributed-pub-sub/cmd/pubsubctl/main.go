@@ -0,0 +1,158 @@
+// Command pubsubctl operates a pubsub cluster through a node's HTTP gateway
+// and gRPC API: it lists members, topics and services, dumps stats, tails
+// and publishes to topics, inspects and redrives dead letters, and drains a
+// node before shutdown.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const usage = `usage: pubsubctl [flags] <command> [args]
+
+Commands:
+  members                          list the node and its peers
+  topics [-all]                    list subscribed topics
+  services                         list registered services by node
+  stats [-all]                     dump node stats
+  publish [flags] <topic> <data>   publish a message ("-" reads data from stdin)
+  tail <topic>                     print messages on a topic until interrupted
+  dlq list <topic>                 list dead letters for a topic
+  dlq redrive <topic> [id...]      republish dead letters (all if no ids)
+  dlq purge <topic>                delete all dead letters for a topic
+  drain [-timeout d]               stop new subscriptions and flush queued messages
+
+Flags:
+`
+
+// ctl holds the connection settings shared by all commands.
+type ctl struct {
+	addr     string // gateway HTTP address
+	grpcAddr string // gRPC address
+	json     bool   // print raw JSON instead of tables
+	client   *http.Client
+	out      io.Writer
+}
+
+func main() {
+	c := &ctl{out: os.Stdout}
+	flag.StringVar(&c.addr, "addr", "localhost:8080", "node HTTP gateway address")
+	flag.StringVar(&c.grpcAddr, "grpc", "localhost:9000", "node gRPC address (used by tail)")
+	flag.BoolVar(&c.json, "json", false, "print JSON output")
+	timeout := flag.Duration("timeout", 10*time.Second, "HTTP request timeout")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c.client = &http.Client{Timeout: *timeout}
+
+	if err := c.run(flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "pubsubctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (c *ctl) run(cmd string, args []string) error {
+	switch cmd {
+	case "members":
+		return c.members(args)
+	case "topics":
+		return c.topics(args)
+	case "services":
+		return c.services(args)
+	case "stats":
+		return c.stats(args)
+	case "publish":
+		return c.publish(args)
+	case "tail":
+		return c.tail(args)
+	case "dlq":
+		return c.dlq(args)
+	case "drain":
+		return c.drain(args)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// apiError is the error body returned by the gateway.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// do sends a request to the gateway at addr and decodes a JSON response into
+// out (if non-nil). Statuses other than those in ok are returned as errors.
+func (c *ctl) do(method, addr, path string, body, out interface{}, ok ...int) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, "http://"+addr+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: read response: %w", method, path, err)
+	}
+	if !statusOK(resp.StatusCode, ok) {
+		var e apiError
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, path, e.Error)
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: decode response: %w", method, path, err)
+	}
+	return nil
+}
+
+func statusOK(code int, ok []int) bool {
+	if len(ok) == 0 {
+		return code == http.StatusOK
+	}
+	for _, c := range ok {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// printJSON writes v as indented JSON.
+func (c *ctl) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"distributed-pub-sub/pubsub/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// publishRequest mirrors the gateway's /publish body.
+type publishRequest struct {
+	Topic     string `json:"topic"`
+	Payload   string `json:"payload"` // base64-encoded
+	Key       string `json:"key,omitempty"`
+	Tombstone bool   `json:"tombstone,omitempty"`
+}
+
+func (c *ctl) publish(args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	key := fs.String("key", "", "message key (required on compacted topics)")
+	tombstone := fs.Bool("tombstone", false, "delete key from a compacted topic")
+	count := fs.Int("count", 1, "number of messages to publish; {n} in data is replaced by the sequence number")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: publish [flags] <topic> <data>")
+	}
+	topic, data := fs.Arg(0), fs.Arg(1)
+	if data == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+		data = string(b)
+	}
+
+	for i := 0; i < *count; i++ {
+		payload := strings.ReplaceAll(data, "{n}", strconv.Itoa(i))
+		req := publishRequest{
+			Topic:     topic,
+			Payload:   base64.StdEncoding.EncodeToString([]byte(payload)),
+			Key:       *key,
+			Tombstone: *tombstone,
+		}
+		var resp struct {
+			ID string `json:"id"`
+		}
+		if err := c.do(http.MethodPost, c.addr, "/publish", req, &resp); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, resp.ID)
+	}
+	return nil
+}
+
+// tailMessage is the JSON form of a tailed message.
+type tailMessage struct {
+	ID        string `json:"id"`
+	Source    string `json:"source"`
+	Topic     string `json:"topic"`
+	Key       string `json:"key,omitempty"`
+	Payload   string `json:"payload"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// tail subscribes over gRPC, so no gateway connection is held open and the
+// subscription goes away with the stream.
+func (c *ctl) tail(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: tail <topic>")
+	}
+	topic := args[0]
+
+	conn, err := grpc.NewClient(c.grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", c.grpcAddr, err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := pb.NewPubSubServiceClient(conn).SubscribeTopic(ctx, &pb.SubscribeRequest{Topic: topic})
+	if err != nil {
+		return fmt.Errorf("subscribe %s: %w", topic, err)
+	}
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("tail %s: %w", topic, err)
+		}
+		if c.json {
+			c.printJSON(tailMessage{
+				ID:        msg.GetId(),
+				Source:    msg.GetSource(),
+				Topic:     msg.GetTopic(),
+				Key:       msg.GetKey(),
+				Payload:   string(msg.GetPayload()),
+				Timestamp: msg.GetTimestamp(),
+			})
+			continue
+		}
+		ts := time.Unix(0, msg.GetTimestamp()).Format(time.RFC3339Nano)
+		fmt.Fprintf(c.out, "%s %s %s %s\n", ts, msg.GetTopic(), msg.GetSource(), msg.GetPayload())
+	}
+}
@@ -0,0 +1,182 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Leader describes the current holder of an election.
+type Leader struct {
+	Node  string // node ID of the leader
+	Token uint64 // fencing token of the leader's term
+	Value []byte // value passed to Campaign
+}
+
+// Election is a leader election over a single lease name. At most one
+// campaigner holds the lease at a time; it stays leader until it resigns,
+// fails to renew or its node is removed from the cluster.
+type Election struct {
+	m    *Manager
+	name string
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	lease *Lease
+}
+
+// Election returns an election on name whose leadership lease lasts ttl
+// between renewals.
+func (m *Manager) Election(name string, ttl time.Duration) *Election {
+	return &Election{m: m, name: name, ttl: ttl}
+}
+
+// Campaign blocks until this caller becomes leader or ctx is done. value is
+// published to observers as the leader's value. The returned lease's Done
+// channel closes when leadership is lost.
+//
+// A grant only counts once confirm has verified it, so two campaigners
+// granted the lease by different arbiters during a membership change do not
+// both return as leader.
+func (e *Election) Campaign(ctx context.Context, value []byte) (*Lease, error) {
+	e.mu.Lock()
+	if e.lease != nil {
+		select {
+		case <-e.lease.Done():
+			// Leadership was lost; campaigning again is allowed.
+		default:
+			e.mu.Unlock()
+			return nil, errors.New("lease: already leader")
+		}
+	}
+	e.mu.Unlock()
+
+	for {
+		l, err := e.m.acquire(ctx, e.name, e.ttl, value)
+		if err != nil {
+			return nil, err
+		}
+
+		err = e.confirm(ctx, l)
+		if err == nil {
+			e.mu.Lock()
+			e.lease = l
+			e.mu.Unlock()
+			return l, nil
+		}
+
+		log.Printf("[lease %s] grant of %s (token %d) not confirmed: %v", e.m.nodeID, e.name, l.token, err)
+		releaseCtx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		l.Release(releaseCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// confirm checks that l is still the grant of record once it has replicated:
+// this node's replica must show l's holder and token, and the arbiter as
+// currently chosen must renew it. Of two holders granted the same lease by
+// different arbiters, at most one passes once membership has settled.
+func (e *Election) confirm(ctx context.Context, l *Lease) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	wake, unwatch := e.m.watch(e.name)
+	defer unwatch()
+
+	for {
+		rec := e.m.load(e.name)
+		if rec.Token > l.token || (rec.Token == l.token && rec.Holder != l.holder) {
+			return ErrLost
+		}
+		if rec.Token == l.token {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+
+	_, err := e.m.call(ctx, "renew", &leaseRequest{
+		Name:   e.name,
+		Holder: l.holder,
+		Token:  l.token,
+		TTL:    int64(l.ttl),
+	})
+	return err
+}
+
+// Resign gives up leadership if this caller holds it.
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	l := e.lease
+	e.lease = nil
+	e.mu.Unlock()
+
+	if l == nil {
+		return nil
+	}
+	return l.Release(ctx)
+}
+
+// Leader returns the current leader as seen by this node's replica.
+func (e *Election) Leader() (Leader, bool) {
+	rec := e.m.load(e.name)
+	if !e.m.held(rec, time.Now()) {
+		return Leader{}, false
+	}
+	return Leader{Node: rec.Node, Token: rec.Token, Value: rec.Value}, true
+}
+
+// Observe returns a channel that receives the current leader and every
+// subsequent change of leader. A zero Leader means there is none. The
+// channel is closed when ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan Leader {
+	out := make(chan Leader, 1)
+	wake, unwatch := e.m.watch(e.name)
+
+	go func() {
+		defer close(out)
+		defer unwatch()
+
+		var last Leader
+		first := true
+		for {
+			cur, _ := e.Leader()
+			if first || cur.Node != last.Node || cur.Token != last.Token {
+				select {
+				case out <- cur:
+				case <-ctx.Done():
+					return
+				}
+				last, first = cur, false
+			}
+
+			// A leader that stops renewing expires without a kv write, so
+			// also wake at its expiry.
+			wait := retryInterval
+			if rec := e.m.load(e.name); rec.Holder != "" {
+				if d := time.Until(time.Unix(0, rec.ExpiresAt)); d > 0 && d < wait {
+					wait = d
+				}
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}()
+
+	return out
+}
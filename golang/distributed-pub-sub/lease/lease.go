@@ -0,0 +1,520 @@
+// Package lease provides cluster-wide leases, distributed locks and leader
+// election for services running on pubsub nodes.
+//
+// Each lease name is arbitrated by one live node, chosen by rendezvous
+// hashing over the node and its peers, so every node agrees on the arbiter
+// while membership is stable. The arbiter grants, renews and releases leases
+// and records them in the replicated kv.Store, so when it fails the next
+// arbiter continues from the replicated state. Leases held by a node that the
+// health check removes are released immediately by the arbiter.
+//
+// Every grant carries a fencing token that increases with each new holder.
+// Resources protected by a lease should reject operations carrying a token
+// lower than one they have already seen: a holder that stalls past its TTL
+// may still believe it holds the lease. Tokens are monotonic as long as kv
+// replication reaches the next arbiter before it grants the lease again.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"distributed-pub-sub/kv"
+	"distributed-pub-sub/pubsub"
+	"distributed-pub-sub/service"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// keyPrefix prefixes lease records in the kv store.
+	keyPrefix = "_lease/"
+
+	// callTimeout bounds each request to an arbiter.
+	callTimeout = 3 * time.Second
+
+	// retryInterval is the longest Acquire waits between attempts.
+	retryInterval = time.Second
+)
+
+var (
+	// ErrHeld is returned by TryAcquire when another holder has the lease.
+	ErrHeld = errors.New("lease: held by another holder")
+	// ErrLost is returned when renewing or releasing a lease that has
+	// expired or been granted to someone else.
+	ErrLost = errors.New("lease: lost")
+)
+
+// record is the replicated state of one lease name. Records are kept after
+// release so the next grant continues the token sequence.
+type record struct {
+	Holder    string `json:"holder"` // empty when free
+	Node      string `json:"node"`   // node ID of the holder
+	Token     uint64 `json:"token"`
+	ExpiresAt int64  `json:"expires_at"` // unix nanoseconds
+	Value     []byte `json:"value,omitempty"`
+}
+
+// leaseRequest is the JSON payload of arbiter calls.
+type leaseRequest struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	Node   string `json:"node"`
+	Token  uint64 `json:"token,omitempty"`
+	TTL    int64  `json:"ttl,omitempty"` // nanoseconds
+	Value  []byte `json:"value,omitempty"`
+}
+
+// Manager grants and holds leases for one node. Every node that uses leases
+// must run a Manager, since any of them may be chosen as an arbiter.
+type Manager struct {
+	node   *pubsub.Node
+	store  *kv.Store
+	svc    *service.Service
+	nodeID string
+
+	// mu serializes arbiter decisions on this node.
+	mu sync.Mutex
+
+	watchMu sync.Mutex
+	watched map[string]bool
+	wakers  map[string]map[chan struct{}]struct{}
+
+	stopped chan struct{}
+}
+
+// NewManager creates a lease manager on top of node and its kv store. The
+// store must be started separately.
+func NewManager(node *pubsub.Node, store *kv.Store) *Manager {
+	transport := service.NewEmbeddedTransport(node)
+	m := &Manager{
+		node:    node,
+		store:   store,
+		svc:     service.NewService(serviceName(node.NodeID()), transport),
+		nodeID:  node.NodeID(),
+		watched: make(map[string]bool),
+		wakers:  make(map[string]map[chan struct{}]struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	m.svc.Handle("acquire", m.handleAcquire)
+	m.svc.Handle("renew", m.handleRenew)
+	m.svc.Handle("release", m.handleRelease)
+
+	return m
+}
+
+// Start begins serving arbiter requests addressed to this node.
+func (m *Manager) Start() error {
+	m.node.OnPeerRemoved(m.releaseNode)
+	return m.svc.Start()
+}
+
+// Stop stops serving arbiter requests. Leases held through this manager are
+// not released; they expire unless the node is also removed.
+func (m *Manager) Stop() error {
+	close(m.stopped)
+	return m.svc.Stop()
+}
+
+// serviceName is the per-node service through which a node arbitrates.
+func serviceName(nodeID string) string {
+	return "lease." + nodeID
+}
+
+// --- Acquiring ---
+
+// Lease is a held lease. It is renewed in the background until Release is
+// called or renewal fails, at which point Done is closed.
+type Lease struct {
+	m      *Manager
+	name   string
+	holder string
+	token  uint64
+	ttl    time.Duration
+
+	done     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Name returns the lease name.
+func (l *Lease) Name() string { return l.name }
+
+// Token returns the fencing token of this grant.
+func (l *Lease) Token() uint64 { return l.token }
+
+// Done is closed when the lease is lost or released.
+func (l *Lease) Done() <-chan struct{} { return l.done }
+
+// Release stops renewal and frees the lease.
+func (l *Lease) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+	_, err := l.m.call(ctx, "release", &leaseRequest{Name: l.name, Holder: l.holder, Token: l.token})
+	return err
+}
+
+// TryAcquire acquires name for ttl, or returns ErrHeld if it is held.
+func (m *Manager) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	l, _, err := m.tryAcquire(ctx, name, uuid.New().String(), ttl, nil)
+	return l, err
+}
+
+// Acquire blocks until it acquires name for ttl or ctx is done.
+func (m *Manager) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	return m.acquire(ctx, name, ttl, nil)
+}
+
+func (m *Manager) acquire(ctx context.Context, name string, ttl time.Duration, value []byte) (*Lease, error) {
+	wake, unwatch := m.watch(name)
+	defer unwatch()
+
+	holder := uuid.New().String()
+	for {
+		l, rec, err := m.tryAcquire(ctx, name, holder, ttl, value)
+		if err == nil {
+			return l, nil
+		}
+		if !errors.Is(err, ErrHeld) {
+			log.Printf("[lease %s] acquire %s: %v", m.nodeID, name, err)
+		}
+
+		wait := retryInterval
+		if rec != nil {
+			if d := time.Until(time.Unix(0, rec.ExpiresAt)); d > 0 && d < wait {
+				wait = d
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (m *Manager) tryAcquire(ctx context.Context, name, holder string, ttl time.Duration, value []byte) (*Lease, *record, error) {
+	if ttl <= 0 {
+		return nil, nil, fmt.Errorf("lease: ttl must be positive")
+	}
+	rec, err := m.call(ctx, "acquire", &leaseRequest{
+		Name:   name,
+		Holder: holder,
+		Node:   m.nodeID,
+		TTL:    int64(ttl),
+		Value:  value,
+	})
+	if err != nil {
+		return nil, rec, err
+	}
+
+	l := &Lease{
+		m:      m,
+		name:   name,
+		holder: holder,
+		token:  rec.Token,
+		ttl:    ttl,
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go l.keepAlive(time.Unix(0, rec.ExpiresAt))
+	return l, rec, nil
+}
+
+// keepAlive renews the lease every third of its TTL. Transient failures are
+// retried until the lease would have expired.
+func (l *Lease) keepAlive(expires time.Time) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.m.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		rec, err := l.m.call(ctx, "renew", &leaseRequest{
+			Name:   l.name,
+			Holder: l.holder,
+			Token:  l.token,
+			TTL:    int64(l.ttl),
+		})
+		cancel()
+
+		switch {
+		case err == nil:
+			expires = time.Unix(0, rec.ExpiresAt)
+		case errors.Is(err, ErrLost) || time.Now().After(expires):
+			log.Printf("[lease %s] lost %s (token %d): %v", l.m.nodeID, l.name, l.token, err)
+			return
+		default:
+			log.Printf("[lease %s] renew %s: %v", l.m.nodeID, l.name, err)
+		}
+	}
+}
+
+// --- Arbiter calls ---
+
+// arbiter returns the node that arbitrates name: the live node with the
+// highest rendezvous hash for it.
+func (m *Manager) arbiter(name string) string {
+	best, bestHash := m.nodeID, rendezvous(name, m.nodeID)
+	for _, p := range m.node.GetPeers() {
+		if h := rendezvous(name, p.NodeID); h > bestHash {
+			best, bestHash = p.NodeID, h
+		}
+	}
+	return best
+}
+
+func rendezvous(name, nodeID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(nodeID))
+	return h.Sum64()
+}
+
+// call sends a request to the arbiter for req.Name, handling it in-process
+// when this node is the arbiter. The returned record is set whenever the
+// arbiter included one, including with ErrHeld.
+func (m *Manager) call(ctx context.Context, method string, req *leaseRequest) (*record, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal lease request: %w", err)
+	}
+
+	var resp *service.Response
+	if arb := m.arbiter(req.Name); arb == m.nodeID {
+		resp = m.handle(method, &service.Request{Method: method, Payload: payload})
+	} else {
+		resp, err = m.svc.Call(ctx, serviceName(arb), method, payload, callTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rec *record
+	if len(resp.Payload) > 0 {
+		rec = &record{}
+		if err := json.Unmarshal(resp.Payload, rec); err != nil {
+			return nil, fmt.Errorf("decode lease record: %w", err)
+		}
+	}
+	switch resp.Error {
+	case "":
+		return rec, nil
+	case ErrHeld.Error():
+		return rec, ErrHeld
+	case ErrLost.Error():
+		return rec, ErrLost
+	default:
+		return rec, errors.New(resp.Error)
+	}
+}
+
+func (m *Manager) handle(method string, req *service.Request) *service.Response {
+	switch method {
+	case "acquire":
+		return m.handleAcquire(req)
+	case "renew":
+		return m.handleRenew(req)
+	case "release":
+		return m.handleRelease(req)
+	default:
+		return &service.Response{Error: fmt.Sprintf("unknown method: %s", method)}
+	}
+}
+
+// --- Arbiter handlers ---
+
+func (m *Manager) handleAcquire(req *service.Request) *service.Response {
+	var r leaseRequest
+	if err := json.Unmarshal(req.Payload, &r); err != nil {
+		return &service.Response{Error: "invalid request"}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := m.load(r.Name)
+	now := time.Now()
+	if m.held(rec, now) && rec.Holder != r.Holder {
+		return recordResponse(rec, ErrHeld)
+	}
+	if rec.Holder != r.Holder {
+		rec.Token++
+	}
+	rec.Holder = r.Holder
+	rec.Node = r.Node
+	rec.ExpiresAt = now.Add(time.Duration(r.TTL)).UnixNano()
+	rec.Value = r.Value
+	m.save(r.Name, rec)
+	return recordResponse(rec, nil)
+}
+
+func (m *Manager) handleRenew(req *service.Request) *service.Response {
+	var r leaseRequest
+	if err := json.Unmarshal(req.Payload, &r); err != nil {
+		return &service.Response{Error: "invalid request"}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := m.load(r.Name)
+	now := time.Now()
+	if !m.held(rec, now) || rec.Holder != r.Holder || rec.Token != r.Token {
+		return recordResponse(rec, ErrLost)
+	}
+	rec.ExpiresAt = now.Add(time.Duration(r.TTL)).UnixNano()
+	m.save(r.Name, rec)
+	return recordResponse(rec, nil)
+}
+
+func (m *Manager) handleRelease(req *service.Request) *service.Response {
+	var r leaseRequest
+	if err := json.Unmarshal(req.Payload, &r); err != nil {
+		return &service.Response{Error: "invalid request"}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := m.load(r.Name)
+	if rec.Holder == r.Holder && rec.Token == r.Token {
+		m.free(r.Name, rec)
+	}
+	return &service.Response{}
+}
+
+// releaseNode frees every lease this node arbitrates that is held by a node
+// the health check removed.
+func (m *Manager) releaseNode(nodeID string) {
+	select {
+	case <-m.stopped:
+		return
+	default:
+	}
+
+	go func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		for _, key := range m.store.Keys() {
+			name, ok := strings.CutPrefix(key, keyPrefix)
+			if !ok || m.arbiter(name) != m.nodeID {
+				continue
+			}
+			if rec := m.load(name); rec.Holder != "" && rec.Node == nodeID {
+				log.Printf("[lease %s] releasing %s held by removed node %s", m.nodeID, name, nodeID)
+				m.free(name, rec)
+			}
+		}
+	}()
+}
+
+// held reports whether rec is currently granted to a live holder.
+func (m *Manager) held(rec *record, now time.Time) bool {
+	return rec.Holder != "" && now.UnixNano() < rec.ExpiresAt && m.alive(rec.Node)
+}
+
+// alive reports whether nodeID is this node or a connected peer.
+func (m *Manager) alive(nodeID string) bool {
+	if nodeID == m.nodeID {
+		return true
+	}
+	for _, p := range m.node.GetPeers() {
+		if p.NodeID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) free(name string, rec *record) {
+	rec.Holder = ""
+	rec.Node = ""
+	rec.ExpiresAt = 0
+	rec.Value = nil
+	m.save(name, rec)
+}
+
+// load reads the local replica of name's record.
+func (m *Manager) load(name string) *record {
+	rec := &record{}
+	if data, ok := m.store.Get(keyPrefix + name); ok {
+		if err := json.Unmarshal(data, rec); err != nil {
+			log.Printf("[lease %s] corrupt record for %s: %v", m.nodeID, name, err)
+		}
+	}
+	return rec
+}
+
+func (m *Manager) save(name string, rec *record) {
+	data, _ := json.Marshal(rec)
+	m.store.Set(keyPrefix+name, data, 0)
+}
+
+func recordResponse(rec *record, err error) *service.Response {
+	data, _ := json.Marshal(rec)
+	resp := &service.Response{Payload: data}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// --- Watching ---
+
+// watch returns a channel signalled whenever name's record changes on this
+// node, and a function that stops the signalling.
+func (m *Manager) watch(name string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	m.watchMu.Lock()
+	if !m.watched[name] {
+		m.watched[name] = true
+		m.store.Watch(keyPrefix+name, func(string, []byte, bool) { m.wake(name) })
+	}
+	if m.wakers[name] == nil {
+		m.wakers[name] = make(map[chan struct{}]struct{})
+	}
+	m.wakers[name][ch] = struct{}{}
+	m.watchMu.Unlock()
+
+	return ch, func() {
+		m.watchMu.Lock()
+		delete(m.wakers[name], ch)
+		m.watchMu.Unlock()
+	}
+}
+
+func (m *Manager) wake(name string) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	for ch := range m.wakers[name] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
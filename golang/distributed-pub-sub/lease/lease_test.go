@@ -0,0 +1,174 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"distributed-pub-sub/kv"
+	"distributed-pub-sub/pubsub"
+)
+
+type testMember struct {
+	node  *pubsub.Node
+	store *kv.Store
+	m     *Manager
+}
+
+func newTestMember(t *testing.T, addr string, seeds ...string) *testMember {
+	t.Helper()
+	opts := pubsub.DefaultOptions()
+	opts.GRPCAddress = addr
+	opts.EnableMDNS = false
+	opts.HealthCheckInterval = 200 * time.Millisecond
+	opts.MaxHealthFailures = 1
+	opts.Seeds = seeds
+	n := pubsub.NewNode(opts)
+	if err := n.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+
+	s := kv.NewStore(n)
+	if err := s.Start(); err != nil {
+		t.Fatalf("start kv: %v", err)
+	}
+	m := NewManager(n, s)
+	if err := m.Start(); err != nil {
+		t.Fatalf("start lease manager: %v", err)
+	}
+
+	mem := &testMember{node: n, store: s, m: m}
+	t.Cleanup(mem.stop)
+	return mem
+}
+
+func (mem *testMember) stop() {
+	if mem.m == nil {
+		return
+	}
+	mem.m.Stop()
+	mem.store.Stop()
+	mem.node.Stop()
+	mem.m = nil
+}
+
+// newTestCluster starts two joined members and waits for topic sync.
+func newTestCluster(t *testing.T, addr1, addr2 string) (*testMember, *testMember) {
+	t.Helper()
+	a := newTestMember(t, addr1)
+	b := newTestMember(t, addr2, addr1)
+	time.Sleep(300 * time.Millisecond)
+	return a, b
+}
+
+func TestLease_ExclusiveWithFencing(t *testing.T) {
+	a, b := newTestCluster(t, "localhost:19201", "localhost:19202")
+	ctx := context.Background()
+
+	la, err := a.m.TryAcquire(ctx, "jobs", time.Second)
+	if err != nil {
+		t.Fatalf("acquire on a: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := b.m.TryAcquire(ctx, "jobs", time.Second); !errors.Is(err, ErrHeld) {
+		t.Fatalf("acquire on b: got %v, want ErrHeld", err)
+	}
+
+	// Renewal keeps the lease past its TTL.
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := b.m.TryAcquire(ctx, "jobs", time.Second); !errors.Is(err, ErrHeld) {
+		t.Fatalf("lease expired despite renewal: %v", err)
+	}
+
+	if err := la.Release(ctx); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	lb, err := b.m.Acquire(acquireCtx, "jobs", time.Second)
+	if err != nil {
+		t.Fatalf("acquire on b after release: %v", err)
+	}
+	if lb.Token() <= la.Token() {
+		t.Fatalf("token %d not greater than previous %d", lb.Token(), la.Token())
+	}
+	lb.Release(ctx)
+}
+
+func TestLease_ReleasedWhenNodeRemoved(t *testing.T) {
+	a, b := newTestCluster(t, "localhost:19203", "localhost:19204")
+	ctx := context.Background()
+
+	// A long TTL: only node removal can free the lease within the test.
+	if _, err := b.m.TryAcquire(ctx, "singleton", time.Minute); err != nil {
+		t.Fatalf("acquire on b: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	e := a.m.Election("singleton", time.Second)
+	observe, cancelObserve := context.WithCancel(ctx)
+	defer cancelObserve()
+	leaders := e.Observe(observe)
+	if l := <-leaders; l.Node != b.node.NodeID() {
+		t.Fatalf("initial leader = %q, want %q", l.Node, b.node.NodeID())
+	}
+
+	b.stop()
+
+	campaignCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	select {
+	case got := <-leaders:
+		if got.Node != "" {
+			t.Fatalf("expected no leader after removal, got %q", got.Node)
+		}
+	case <-campaignCtx.Done():
+		t.Fatal("lease was not released after node removal")
+	}
+
+	l, err := e.Campaign(campaignCtx, []byte("a"))
+	if err != nil {
+		t.Fatalf("campaign after node removal: %v", err)
+	}
+
+	for {
+		select {
+		case got := <-leaders:
+			if got.Node == a.node.NodeID() && got.Token == l.Token() && string(got.Value) == "a" {
+				e.Resign(ctx)
+				return
+			}
+		case <-campaignCtx.Done():
+			t.Fatal("observer never saw the new leader")
+		}
+	}
+}
+
+func TestElection_ConfirmRejectsConcurrentGrant(t *testing.T) {
+	a := newTestMember(t, "localhost:19205")
+	ctx := context.Background()
+	e := a.m.Election("leader", time.Second)
+
+	l, err := a.m.acquire(ctx, "leader", time.Second, nil)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer l.Release(ctx)
+	if err := e.confirm(ctx, l); err != nil {
+		t.Fatalf("confirm sole grant: %v", err)
+	}
+
+	// Another arbiter granted the same term to someone else.
+	a.m.save("leader", &record{
+		Holder:    "other",
+		Node:      a.node.NodeID(),
+		Token:     l.Token(),
+		ExpiresAt: time.Now().Add(time.Second).UnixNano(),
+	})
+	if err := e.confirm(ctx, l); !errors.Is(err, ErrLost) {
+		t.Fatalf("confirm after concurrent grant: got %v, want ErrLost", err)
+	}
+}
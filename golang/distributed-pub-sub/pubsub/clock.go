@@ -0,0 +1,38 @@
+package pubsub
+
+import "time"
+
+// Clock is the time source for a node's timestamps, tickers and retry
+// backoff. The default is the wall clock; tests substitute a virtual clock
+// (see package pubsub/sim) to control time explicitly.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of time.Ticker used by nodes.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// wallClock is the Clock backed by package time.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (wallClock) NewTicker(d time.Duration) Ticker       { return wallTicker{time.NewTicker(d)} }
+
+type wallTicker struct{ t *time.Ticker }
+
+func (t wallTicker) C() <-chan time.Time { return t.t.C }
+func (t wallTicker) Stop()               { t.t.Stop() }
+
+// clock returns o.Clock, or the wall clock if none is set.
+func (o Options) clock() Clock {
+	if o.Clock == nil {
+		return wallClock{}
+	}
+	return o.Clock
+}
@@ -0,0 +1,62 @@
+package pubsub
+
+import (
+	"fmt"
+	"log"
+)
+
+// deliverCompacted records msg as the latest value for its key and delivers it
+// to local subscribers. Messages older than the retained value for the key
+// are dropped so subscribers never observe a value going backwards.
+func (n *Node) deliverCompacted(msg *Message) {
+	n.compactMu.Lock()
+	defer n.compactMu.Unlock()
+
+	applied, err := n.compactStore.Put(msg.Destination, toStorageMessage(msg))
+	if err != nil {
+		log.Printf("[node %s] compaction put for %s/%s failed: %v", n.opts.NodeID, msg.Destination, msg.Key, err)
+		n.stats.MessagesFailed.Add(1)
+		return
+	}
+	if !applied {
+		return
+	}
+	if msg.Destination == topicSchemas {
+		if err := n.schemas.apply(msg); err != nil {
+			log.Printf("[node %s] schema record %s: %v", n.opts.NodeID, msg.Key, err)
+		}
+	}
+
+	// Deliver under compactMu so a concurrent Subscribe sees this message
+	// either in its snapshot or as a live update, never both or neither.
+	for _, sub := range n.localSubscribers(msg.Destination) {
+		sub.Deliver(msg)
+	}
+}
+
+// replaySnapshot queues the current snapshot of sub's topic ahead of any live
+// messages. Must be called with compactMu held, before sub is registered.
+func (n *Node) replaySnapshot(sub *Subscriber) error {
+	msgs, err := n.snapshot(sub.Topic)
+	if err != nil {
+		return fmt.Errorf("snapshot compacted topic %q: %w", sub.Topic, err)
+	}
+	for _, msg := range msgs {
+		sub.Deliver(msg)
+	}
+	return nil
+}
+
+// snapshot returns the retained messages of a compacted topic, oldest first.
+// Each call returns fresh copies, since subscribers mutate Attempt.
+func (n *Node) snapshot(topic string) ([]*Message, error) {
+	smsgs, err := n.compactStore.Snapshot(topic)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*Message, len(smsgs))
+	for i, smsg := range smsgs {
+		msgs[i] = fromStorageMessage(smsg)
+	}
+	return msgs, nil
+}
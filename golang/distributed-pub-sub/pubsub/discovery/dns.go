@@ -92,7 +92,7 @@ func (d *DNS) Stop() error {
 
 // resolve performs a single DNS lookup and emits any new addresses.
 func (d *DNS) resolve(ctx context.Context, advertiseAddr string, seen map[string]struct{}, ch chan<- string) {
-	addrs := d.lookup()
+	addrs := d.Lookup()
 	for _, addr := range addrs {
 		if addr == advertiseAddr {
 			continue
@@ -108,8 +108,10 @@ func (d *DNS) resolve(ctx context.Context, advertiseAddr string, seen map[string
 	}
 }
 
-// lookup tries SRV first, then falls back to A record resolution.
-func (d *DNS) lookup() []string {
+// Lookup resolves the name once and returns every address it lists. It tries
+// SRV first, then falls back to A record resolution, and returns nil if
+// neither resolves.
+func (d *DNS) Lookup() []string {
 	// Try SRV lookup first.
 	_, srvs, err := net.LookupSRV("", "", d.name)
 	if err == nil && len(srvs) > 0 {
@@ -0,0 +1,251 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"distributed-pub-sub/pubsub/pb"
+)
+
+// ErrDraining is returned by Subscribe once the node has started draining.
+var ErrDraining = errors.New("node is draining")
+
+// drainPollInterval is how often Drain checks for remaining work and hands
+// off stuck forwards.
+const drainPollInterval = 50 * time.Millisecond
+
+// handoffTimeout bounds a single Handoff RPC.
+const handoffTimeout = 2 * time.Second
+
+// drainStallAfter is how long a local subscriber's backlog may go without
+// shrinking during a drain before its unstarted messages are handed off.
+const drainStallAfter = 500 * time.Millisecond
+
+// Drain prepares the node to leave the cluster:
+//
+//  1. New subscriptions are rejected and service registrations redirected
+//     to a peer.
+//  2. Departure is announced: the node withdraws its topics on the topic
+//     sync topic and marks itself leaving on the service sync topic, so
+//     peers stop forwarding new messages and redirecting servers here.
+//  3. It waits for local subscribers to process their queued messages and
+//     for queued forwards to reach peers. Forwards that fail are handed off
+//     to another peer, preferring one that serves the topic, which delivers
+//     them to the original target. A subscriber whose backlog stops
+//     shrinking for drainStallAfter has the messages its handler has not
+//     started on handed to a peer serving the topic, which delivers them to
+//     its own subscribers.
+//
+// Drain returns nil once nothing is pending, or an error if ctx is done
+// first; it can be called again to keep waiting. It does not stop the node.
+func (n *Node) Drain(ctx context.Context) error {
+	if n.draining.CompareAndSwap(false, true) {
+		log.Printf("[node %s] draining", n.opts.NodeID)
+		n.broadcastTopics()
+		n.broadcastServices()
+	}
+
+	ticker := n.opts.clock().NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	stalls := make(map[*Subscriber]stall)
+	for {
+		n.handOffRetries(ctx)
+		n.handOffStalled(ctx, stalls)
+		pending := n.pendingWork()
+		if pending == 0 {
+			log.Printf("[node %s] drained", n.opts.NodeID)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain: %d messages still pending: %w", pending, ctx.Err())
+		case <-ticker.C():
+		}
+	}
+}
+
+// Draining reports whether Drain has been called.
+func (n *Node) Draining() bool {
+	return n.draining.Load()
+}
+
+// pendingWork returns the number of messages queued for local subscribers
+// or for forwarding.
+func (n *Node) pendingWork() int64 {
+	pending := n.outboundPending.Load()
+	n.subMu.RLock()
+	for _, subs := range n.subscribers {
+		for _, sub := range subs {
+			pending += sub.Pending()
+		}
+	}
+	n.subMu.RUnlock()
+	return pending
+}
+
+// stall tracks a subscriber's backlog across drain polls.
+type stall struct {
+	pending int64
+	since   time.Time
+}
+
+// handOffStalled hands off the queued messages of every local subscriber
+// whose backlog has not shrunk for drainStallAfter. Messages no peer accepts
+// are delivered back to the subscriber.
+func (n *Node) handOffStalled(ctx context.Context, stalls map[*Subscriber]stall) {
+	now := n.opts.clock().Now()
+	for _, sub := range n.allSubscribers() {
+		pending := sub.Pending()
+		st, ok := stalls[sub]
+		if !ok || pending < st.pending || pending == 0 {
+			stalls[sub] = stall{pending: pending, since: now}
+			continue
+		}
+		stalls[sub] = stall{pending: pending, since: st.since}
+		if now.Sub(st.since) < drainStallAfter || (len(sub.Topic) > 0 && sub.Topic[0] == '_') {
+			continue
+		}
+
+		var handed int
+		for _, msg := range sub.takeQueued() {
+			if n.handOffDelivery(ctx, msg) != "" {
+				handed++
+			} else {
+				sub.Deliver(msg)
+			}
+		}
+		if handed > 0 {
+			log.Printf("[node %s] handed off %d messages queued for stalled subscriber %s",
+				n.opts.NodeID, handed, sub.ID)
+		}
+		stalls[sub] = stall{pending: sub.Pending(), since: now}
+	}
+}
+
+// allSubscribers returns every local subscriber.
+func (n *Node) allSubscribers() []*Subscriber {
+	n.subMu.RLock()
+	defer n.subMu.RUnlock()
+
+	var subs []*Subscriber
+	for _, topicSubs := range n.subscribers {
+		for _, sub := range topicSubs {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// handOffDelivery asks a peer serving msg's topic to deliver it to its own
+// subscribers, and returns that peer's node ID, or "" if none accepted.
+func (n *Node) handOffDelivery(ctx context.Context, msg *Message) string {
+	n.serviceMu.RLock()
+	n.peerMu.RLock()
+	var serving []*Peer
+	for id, p := range n.peers {
+		if !n.leavingPeers[id] && p.HasTopic(msg.Destination) {
+			serving = append(serving, p)
+		}
+	}
+	n.peerMu.RUnlock()
+	n.serviceMu.RUnlock()
+
+	for _, p := range serving {
+		hctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+		err := p.Handoff(hctx, msg, p.NodeID)
+		cancel()
+		if err == nil {
+			return p.NodeID
+		}
+		log.Printf("[node %s] %v", n.opts.NodeID, err)
+	}
+	return ""
+}
+
+// handOffRetries passes every forward waiting out a retry backoff to
+// another peer. Entries no peer accepts go back to waiting for their retry.
+func (n *Node) handOffRetries(ctx context.Context) {
+	n.retrying.Range(func(key, _ any) bool {
+		e := key.(*outboundEntry)
+		if _, ok := n.retrying.LoadAndDelete(e); !ok {
+			return true // its retry timer fired
+		}
+		if relay := n.handOff(ctx, e); relay != "" {
+			n.outboundPending.Add(-1)
+			log.Printf("[node %s] handed off message %s for peer %s to %s",
+				n.opts.NodeID, e.msg.ID, e.peerID, relay)
+		} else {
+			n.scheduleRetry(e, n.opts.RetryBaseDelay)
+		}
+		return ctx.Err() == nil
+	})
+}
+
+// handOff sends e to a relay peer and returns the relay's node ID, or ""
+// if no peer accepted it. Peers that serve the message's topic are tried
+// first, since they are known to be forwarding it.
+func (n *Node) handOff(ctx context.Context, e *outboundEntry) string {
+	n.serviceMu.RLock()
+	n.peerMu.RLock()
+	var serving, others []*Peer
+	for id, p := range n.peers {
+		if id == e.peerID || n.leavingPeers[id] {
+			continue
+		}
+		if p.HasTopic(e.msg.Destination) {
+			serving = append(serving, p)
+		} else {
+			others = append(others, p)
+		}
+	}
+	n.peerMu.RUnlock()
+	n.serviceMu.RUnlock()
+
+	for _, p := range append(serving, others...) {
+		hctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+		err := p.Handoff(hctx, e.msg, e.peerID)
+		cancel()
+		if err == nil {
+			return p.NodeID
+		}
+		log.Printf("[node %s] %v", n.opts.NodeID, err)
+	}
+	return ""
+}
+
+// Handoff handles a message handed over by a draining peer. A message
+// targeting this node was queued for a subscriber on the draining node and
+// goes to this node's subscribers of the topic; any other is queued for the
+// target peer like any other forward. Either way the relay's own dedup
+// state is bypassed since it has usually seen the message already.
+func (n *Node) Handoff(ctx context.Context, req *pb.HandoffRequest) (*pb.HandoffResponse, error) {
+	msg := fromForwardRequest(req.GetMessage())
+	target := req.GetTargetNodeId()
+
+	if target == n.opts.NodeID {
+		subs := n.localSubscribers(msg.Destination)
+		for _, sub := range subs {
+			sub.Deliver(msg)
+		}
+		return &pb.HandoffResponse{Accepted: len(subs) > 0}, nil
+	}
+
+	n.peerMu.RLock()
+	_, ok := n.peers[target]
+	n.peerMu.RUnlock()
+	if !ok {
+		return &pb.HandoffResponse{Accepted: false}, nil
+	}
+
+	n.outboundPending.Add(1)
+	select {
+	case n.outbound <- &outboundEntry{msg: msg, peerID: target}:
+		return &pb.HandoffResponse{Accepted: true}, nil
+	default:
+		n.outboundPending.Add(-1)
+		return &pb.HandoffResponse{Accepted: false}, nil
+	}
+}
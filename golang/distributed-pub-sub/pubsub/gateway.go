@@ -1,8 +1,10 @@
 package pubsub
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -24,6 +26,9 @@ type Gateway struct {
 	node       *Node
 	upgrader   websocket.Upgrader
 	promRegistry *prometheus.Registry
+
+	pollMu sync.Mutex
+	polls  map[pollKey]*pollSession
 }
 
 // NewGateway creates a Gateway wrapping the given Node.
@@ -39,6 +44,7 @@ func NewGateway(node *Node) *Gateway {
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
 		promRegistry: reg,
+		polls:        make(map[pollKey]*pollSession),
 	}
 }
 
@@ -50,11 +56,18 @@ func (g *Gateway) Handler() http.Handler {
 	mux.HandleFunc("/subscribe", g.handleSubscribe)
 	mux.HandleFunc("/request", g.handleRequest)
 	mux.HandleFunc("/ws", g.handleWS)
+	mux.HandleFunc("/sse", g.handleSSE)
+	mux.HandleFunc("/poll", g.handlePoll)
 	mux.HandleFunc("/dlq/", g.handleDLQ)
 	mux.HandleFunc("/services", g.handleServices)
 	mux.HandleFunc("/topics/", g.handleTopics)
 	mux.HandleFunc("/svc/", g.handleSvc)
 	mux.HandleFunc("/routes", g.handleRoutes)
+	mux.HandleFunc("/members", g.handleMembers)
+	mux.HandleFunc("/drain", g.handleDrain)
+	mux.HandleFunc("/schemas", g.handleSchemas)
+	mux.HandleFunc("/schemas/", g.handleSchemas)
+	mux.HandleFunc("/topic-schemas/", g.handleTopicSchema)
 	mux.Handle("/metrics", promhttp.HandlerFor(g.promRegistry, promhttp.HandlerOpts{}))
 	return mux
 }
@@ -72,15 +85,23 @@ func (g *Gateway) handleHealth(w http.ResponseWriter, r *http.Request) {
 		"node_id": g.node.opts.NodeID,
 		"stats":   stats,
 	}
+	if g.node.Draining() {
+		// Tell health checkers to stop sending new work.
+		resp["status"] = "draining"
+		writeJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
 // --- Publish ---
 
 type publishRequest struct {
-	Topic   string `json:"topic"`
-	Payload string `json:"payload"` // base64-encoded
-	ReplyTo string `json:"reply_to"`
+	Topic     string `json:"topic"`
+	Payload   string `json:"payload"` // base64-encoded
+	ReplyTo   string `json:"reply_to"`
+	Key       string `json:"key"`       // required on compacted topics
+	Tombstone bool   `json:"tombstone"` // deletes key from a compacted topic
 }
 
 type publishResponse struct {
@@ -112,16 +133,27 @@ func (g *Gateway) handlePublish(w http.ResponseWriter, r *http.Request) {
 		Payload:     payload,
 		Timestamp:   time.Now().UnixNano(),
 		ReplyTo:     req.ReplyTo,
+		Key:         req.Key,
+		Tombstone:   req.Tombstone,
 	}
 
 	if err := g.node.Publish(msg); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeJSON(w, publishStatus(err), map[string]string{"error": err.Error()})
 		return
 	}
 
 	writeJSON(w, http.StatusOK, publishResponse{ID: msg.ID})
 }
 
+// publishStatus maps a Publish error to an HTTP status: 422 for payloads
+// rejected by the topic's schema, 500 otherwise.
+func publishStatus(err error) int {
+	if errors.Is(err, ErrInvalidPayload) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
 // --- Subscribe (WebSocket per-topic) ---
 
 func (g *Gateway) handleSubscribe(w http.ResponseWriter, r *http.Request) {
@@ -142,16 +174,8 @@ func (g *Gateway) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	subID, err := g.node.Subscribe(topic, func(msg *Message) error {
-		data := wsMessage{
-			Type:     "message",
-			Topic:    msg.Destination,
-			Payload:  base64.StdEncoding.EncodeToString(msg.Payload),
-			ID:       msg.ID,
-			ReplyTo:  msg.ReplyTo,
-			StreamID: msg.StreamID,
-		}
-		return conn.WriteJSON(data)
+	subID, _, err := g.subscribeFrom(topic, r.URL.Query().Get("last_id"), func(msg *Message) error {
+		return conn.WriteJSON(newWSMessage(msg))
 	})
 	if err != nil {
 		conn.WriteJSON(wsMessage{Type: "error", Message: err.Error()})
@@ -216,14 +240,30 @@ func (g *Gateway) handleRequest(w http.ResponseWriter, r *http.Request) {
 // --- Full bidirectional WebSocket ---
 
 type wsMessage struct {
-	Type     string `json:"type"`
-	Topic    string `json:"topic,omitempty"`
-	Payload  string `json:"payload,omitempty"`
-	ID       string `json:"id,omitempty"`
-	ReplyTo  string `json:"reply_to,omitempty"`
-	StreamID string `json:"stream_id,omitempty"`
-	Timeout  string `json:"timeout,omitempty"`
-	Message  string `json:"message,omitempty"`
+	Type      string `json:"type"`
+	Topic     string `json:"topic,omitempty"`
+	Payload   string `json:"payload,omitempty"`
+	ID        string `json:"id,omitempty"`
+	ReplyTo   string `json:"reply_to,omitempty"`
+	StreamID  string `json:"stream_id,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Tombstone bool   `json:"tombstone,omitempty"`
+}
+
+// newWSMessage converts a delivered message to its wire form.
+func newWSMessage(msg *Message) wsMessage {
+	return wsMessage{
+		Type:      "message",
+		Topic:     msg.Destination,
+		Payload:   base64.StdEncoding.EncodeToString(msg.Payload),
+		ID:        msg.ID,
+		ReplyTo:   msg.ReplyTo,
+		StreamID:  msg.StreamID,
+		Key:       msg.Key,
+		Tombstone: msg.Tombstone,
+	}
 }
 
 func (g *Gateway) handleWS(w http.ResponseWriter, r *http.Request) {
@@ -260,15 +300,9 @@ func (g *Gateway) handleWS(w http.ResponseWriter, r *http.Request) {
 
 		switch cmd.Type {
 		case "subscribe":
-			subID, err := g.node.Subscribe(cmd.Topic, func(msg *Message) error {
-				return writeJSON(wsMessage{
-					Type:     "message",
-					Topic:    msg.Destination,
-					Payload:  base64.StdEncoding.EncodeToString(msg.Payload),
-					ID:       msg.ID,
-					ReplyTo:  msg.ReplyTo,
-					StreamID: msg.StreamID,
-				})
+			// cmd.ID optionally carries the last message ID seen, to resume.
+			subID, _, err := g.subscribeFrom(cmd.Topic, cmd.ID, func(msg *Message) error {
+				return writeJSON(newWSMessage(msg))
 			})
 			if err != nil {
 				writeJSON(wsMessage{Type: "error", Message: err.Error()})
@@ -297,6 +331,8 @@ func (g *Gateway) handleWS(w http.ResponseWriter, r *http.Request) {
 				Destination: cmd.Topic,
 				Payload:     payload,
 				Timestamp:   time.Now().UnixNano(),
+				Key:         cmd.Key,
+				Tombstone:   cmd.Tombstone,
 			}
 			if err := g.node.Publish(msg); err != nil {
 				writeJSON(wsMessage{Type: "error", Message: err.Error()})
@@ -393,6 +429,7 @@ func (g *Gateway) handleWS(w http.ResponseWriter, r *http.Request) {
 					Topic:   msg.Destination,
 					Payload: base64.StdEncoding.EncodeToString(msg.Payload),
 					ID:      msg.ID,
+					Key:     msg.Key,
 				})
 			}
 			data, _ := json.Marshal(items)
@@ -433,7 +470,7 @@ func (g *Gateway) handleDLQ(w http.ResponseWriter, r *http.Request) {
 		g.handleDLQList(w, r, dlq, topic)
 
 	case r.Method == http.MethodPost && isRetry:
-		g.handleDLQRetry(w, r, dlq)
+		g.handleDLQRetry(w, r)
 
 	case r.Method == http.MethodDelete && !isRetry:
 		g.handleDLQPurge(w, dlq, topic)
@@ -454,7 +491,7 @@ func (g *Gateway) handleDLQList(w http.ResponseWriter, r *http.Request, dlq stor
 	writeJSON(w, http.StatusOK, letters)
 }
 
-func (g *Gateway) handleDLQRetry(w http.ResponseWriter, r *http.Request, dlq storage.DLQStore) {
+func (g *Gateway) handleDLQRetry(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ID string `json:"id"`
 	}
@@ -462,27 +499,16 @@ func (g *Gateway) handleDLQRetry(w http.ResponseWriter, r *http.Request, dlq sto
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
-	msg, err := dlq.Retry(req.ID)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
-	}
-	pubMsg := &Message{
-		ID:          msg.ID,
-		Source:      msg.Source,
-		Destination: msg.Destination,
-		Payload:     msg.Payload,
-		Timestamp:   msg.Timestamp,
-		Sequence:    msg.Sequence,
-		ReplyTo:     msg.ReplyTo,
-		StreamID:    msg.StreamID,
-		Attempt:     msg.Attempt,
-	}
-	if err := g.node.Publish(pubMsg); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
+	// Redrive goes only to the subscriber or peer the message failed on,
+	// and the entry stays in the DLQ until that delivery succeeds.
+	switch err := g.node.Redrive(r.Context(), req.ID); {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "retried", "id": req.ID})
+	case errors.Is(err, ErrRedriveTarget):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "retried", "id": msg.ID})
 }
 
 func (g *Gateway) handleDLQPurge(w http.ResponseWriter, dlq storage.DLQStore, topic string) {
@@ -528,6 +554,75 @@ func (g *Gateway) handleRoutes(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// --- Members ---
+
+// member describes one cluster node in the /members response.
+type member struct {
+	NodeID      string `json:"node_id"`
+	GRPCAddress string `json:"grpc_address"`
+	HTTPAddress string `json:"http_address,omitempty"`
+	Self        bool   `json:"self,omitempty"`
+}
+
+// handleMembers handles GET /members — this node and its connected peers.
+// Addresses are as advertised and may lack a host (e.g. ":8080"); clients
+// fill it in from the gRPC address or the node they asked.
+func (g *Gateway) handleMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	members := []member{{
+		NodeID:      g.node.opts.NodeID,
+		GRPCAddress: g.node.opts.GRPCAddress,
+		HTTPAddress: g.node.opts.HTTPAddress,
+		Self:        true,
+	}}
+	for _, p := range g.node.GetPeers() {
+		members = append(members, member{
+			NodeID:      p.NodeID,
+			GRPCAddress: p.Address,
+			HTTPAddress: p.HTTPAddress,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"node_id": g.node.opts.NodeID,
+		"members": members,
+	})
+}
+
+// --- Drain ---
+
+// handleDrain handles POST /drain?timeout= — puts the node into drain mode
+// and waits up to timeout (default 30s) for its queued messages to flush.
+// Responds 504 if work is still pending when the timeout expires; draining
+// can be retried.
+func (g *Gateway) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout := 30 * time.Second
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid timeout"})
+			return
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if err := g.node.Drain(ctx); err != nil {
+		writeJSON(w, http.StatusGatewayTimeout, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "drained", "node_id": g.node.opts.NodeID})
+}
+
 // --- Helpers ---
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
@@ -553,13 +648,15 @@ func queryInt(r *http.Request, key string, defaultVal int) int {
 // topicMessage is the JSON format for /topics/ WebSocket messages.
 // Payloads are raw JSON (not base64).
 type topicMessage struct {
-	Type     string          `json:"type"`
-	Topic    string          `json:"topic,omitempty"`
-	Payload  json.RawMessage `json:"payload,omitempty"`
-	ID       string          `json:"id,omitempty"`
-	ReplyTo  string          `json:"reply_to,omitempty"`
-	StreamID string          `json:"stream_id,omitempty"`
-	Message  string          `json:"message,omitempty"`
+	Type      string          `json:"type"`
+	Topic     string          `json:"topic,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	ReplyTo   string          `json:"reply_to,omitempty"`
+	StreamID  string          `json:"stream_id,omitempty"`
+	Message   string          `json:"message,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	Tombstone bool            `json:"tombstone,omitempty"`
 }
 
 // handleTopics handles /topics/{topic} requests.
@@ -584,7 +681,9 @@ func (g *Gateway) handleTopics(w http.ResponseWriter, r *http.Request) {
 // handleTopicPublish handles POST /topics/{topic} — REST publish.
 func (g *Gateway) handleTopicPublish(w http.ResponseWriter, r *http.Request, topic string) {
 	var req struct {
-		Payload json.RawMessage `json:"payload"`
+		Payload   json.RawMessage `json:"payload"`
+		Key       string          `json:"key"`
+		Tombstone bool            `json:"tombstone"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
@@ -597,17 +696,21 @@ func (g *Gateway) handleTopicPublish(w http.ResponseWriter, r *http.Request, top
 		Destination: topic,
 		Payload:     req.Payload,
 		Timestamp:   time.Now().UnixNano(),
+		Key:         req.Key,
+		Tombstone:   req.Tombstone,
 	}
 
 	if err := g.node.Publish(msg); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeJSON(w, publishStatus(err), map[string]string{"error": err.Error()})
 		return
 	}
 
 	writeJSON(w, http.StatusOK, publishResponse{ID: msg.ID})
 }
 
-// handleTopicWS handles GET /topics/{topic} — WebSocket subscribe with history replay.
+// handleTopicWS handles GET /topics/{topic} — WebSocket subscribe. Compacted
+// topics replay their current snapshot before live messages; ?last_id=
+// resumes from history.
 func (g *Gateway) handleTopicWS(w http.ResponseWriter, r *http.Request, topic string) {
 	conn, err := g.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -622,14 +725,16 @@ func (g *Gateway) handleTopicWS(w http.ResponseWriter, r *http.Request, topic st
 		return conn.WriteJSON(v)
 	}
 
-	subID, err := g.node.Subscribe(topic, func(msg *Message) error {
+	subID, _, err := g.subscribeFrom(topic, r.URL.Query().Get("last_id"), func(msg *Message) error {
 		return wsWrite(topicMessage{
-			Type:     "message",
-			Topic:    msg.Destination,
-			Payload:  json.RawMessage(msg.Payload),
-			ID:       msg.ID,
-			ReplyTo:  msg.ReplyTo,
-			StreamID: msg.StreamID,
+			Type:      "message",
+			Topic:     msg.Destination,
+			Payload:   json.RawMessage(msg.Payload),
+			ID:        msg.ID,
+			ReplyTo:   msg.ReplyTo,
+			StreamID:  msg.StreamID,
+			Key:       msg.Key,
+			Tombstone: msg.Tombstone,
 		})
 	})
 	if err != nil {
@@ -644,13 +749,15 @@ func (g *Gateway) handleTopicWS(w http.ResponseWriter, r *http.Request, topic st
 		if err := conn.ReadJSON(&cmd); err != nil {
 			break
 		}
-		if len(cmd.Payload) > 0 {
+		if len(cmd.Payload) > 0 || cmd.Tombstone {
 			msg := &Message{
 				ID:          uuid.New().String(),
 				Source:      g.node.opts.NodeID,
 				Destination: topic,
 				Payload:     cmd.Payload,
 				Timestamp:   time.Now().UnixNano(),
+				Key:         cmd.Key,
+				Tombstone:   cmd.Tombstone,
 			}
 			if err := g.node.Publish(msg); err != nil {
 				wsWrite(topicMessage{Type: "error", Message: err.Error()})
@@ -0,0 +1,209 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"distributed-pub-sub/pubsub/schema"
+)
+
+// --- Schemas ---
+
+// schemaVersion is the HTTP form of one subject version. JSON Schema
+// documents are inlined; protobuf descriptor sets are base64 strings.
+type schemaVersion struct {
+	Version int             `json:"version"`
+	Type    schema.Type     `json:"type"`
+	Schema  json.RawMessage `json:"schema"`
+	Message string          `json:"message,omitempty"`
+}
+
+type subjectResponse struct {
+	Name          string               `json:"name"`
+	Compatibility schema.Compatibility `json:"compatibility"`
+	Versions      []schemaVersion      `json:"versions"`
+}
+
+func newSubjectResponse(s Subject) subjectResponse {
+	resp := subjectResponse{Name: s.Name, Compatibility: s.Compatibility}
+	for i, v := range s.Versions {
+		def := json.RawMessage(v.Definition)
+		if v.Type != schema.JSON {
+			def, _ = json.Marshal(base64.StdEncoding.EncodeToString(v.Definition))
+		}
+		resp.Versions = append(resp.Versions, schemaVersion{
+			Version: i + 1,
+			Type:    v.Type,
+			Schema:  def,
+			Message: v.Message,
+		})
+	}
+	return resp
+}
+
+// decodeSchema converts a request's schema field into a definition.
+func decodeSchema(typ schema.Type, raw json.RawMessage, message string) (schema.Schema, error) {
+	s := schema.Schema{Type: typ, Message: message}
+	if typ == schema.Protobuf {
+		var b64 string
+		if err := json.Unmarshal(raw, &b64); err != nil {
+			return s, errors.New("protobuf schema must be a base64-encoded FileDescriptorSet")
+		}
+		def, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return s, errors.New("protobuf schema must be a base64-encoded FileDescriptorSet")
+		}
+		s.Definition = def
+		return s, nil
+	}
+	s.Definition = raw
+	return s, nil
+}
+
+// handleSchemas handles /schemas and /schemas/{subject}:
+//
+//	GET    /schemas            list subjects and topic bindings
+//	GET    /schemas/{subject}  show a subject and its versions
+//	POST   /schemas/{subject}  register a new version
+//	PUT    /schemas/{subject}  change the compatibility mode
+//	DELETE /schemas/{subject}  delete an unbound subject
+func (g *Gateway) handleSchemas(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/schemas"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		subjects := []subjectResponse{}
+		for _, s := range g.node.Subjects() {
+			subjects = append(subjects, newSubjectResponse(s))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"subjects": subjects,
+			"bindings": g.node.SchemaBindings(),
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s, ok := g.node.GetSubject(name)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "subject not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, newSubjectResponse(s))
+
+	case http.MethodPost:
+		var req struct {
+			Type    schema.Type     `json:"type"`
+			Schema  json.RawMessage `json:"schema"`
+			Message string          `json:"message"` // protobuf message name
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s, err := decodeSchema(req.Type, req.Schema, req.Message)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		version, err := g.node.RegisterSchema(name, s)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrIncompatibleSchema) {
+				status = http.StatusConflict
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"subject": name, "version": version})
+
+	case http.MethodPut:
+		var req struct {
+			Compatibility string `json:"compatibility"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		mode, err := schema.ParseCompatibility(req.Compatibility)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := g.node.SetSchemaCompatibility(name, mode); err != nil {
+			writeJSON(w, schemaErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"subject": name, "compatibility": string(mode)})
+
+	case http.MethodDelete:
+		if err := g.node.DeleteSubject(name); err != nil {
+			writeJSON(w, schemaErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"subject": name, "status": "deleted"})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTopicSchema handles /topic-schemas/{topic}:
+//
+//	GET    show the subject bound to the topic
+//	PUT    bind the topic to {"subject": ...}
+//	DELETE unbind the topic
+func (g *Gateway) handleTopicSchema(w http.ResponseWriter, r *http.Request) {
+	topic := strings.TrimPrefix(r.URL.Path, "/topic-schemas/")
+	if topic == "" {
+		http.Error(w, "missing topic in path", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subject, ok := g.node.SchemaBindings()[topic]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "topic has no schema"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"topic": topic, "subject": subject})
+
+	case http.MethodPut:
+		var req struct {
+			Subject string `json:"subject"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := g.node.BindSchema(topic, req.Subject); err != nil {
+			writeJSON(w, schemaErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"topic": topic, "subject": req.Subject})
+
+	case http.MethodDelete:
+		if err := g.node.UnbindSchema(topic); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"topic": topic, "status": "unbound"})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func schemaErrorStatus(err error) int {
+	if errors.Is(err, ErrSubjectNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
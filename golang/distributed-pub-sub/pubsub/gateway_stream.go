@@ -0,0 +1,361 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseKeepAlive is how often an idle SSE connection receives a comment line,
+// so proxies don't time it out.
+const sseKeepAlive = 15 * time.Second
+
+// subscribeFrom subscribes to topic and calls deliver for every message after
+// lastID: first the retained history following lastID, then live messages.
+// Messages already replayed from history are not delivered twice. An empty
+// lastID subscribes to live messages only. reset reports that lastID was no
+// longer in history, in which case all retained history is replayed.
+//
+// deliver is never called concurrently. The caller must Unsubscribe subID.
+func (g *Gateway) subscribeFrom(topic, lastID string, deliver func(*Message) error) (subID string, reset bool, err error) {
+	var mu sync.Mutex
+	var seen map[string]bool
+
+	// Hold mu until the replay is done so live messages queue behind it.
+	mu.Lock()
+	defer mu.Unlock()
+
+	subID, err = g.node.Subscribe(topic, func(msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[msg.ID] {
+			return nil
+		}
+		return deliver(msg)
+	})
+	if err != nil || lastID == "" {
+		return subID, false, err
+	}
+
+	history := g.node.History(topic, 0)
+	seen = make(map[string]bool, len(history))
+	start := 0
+	reset = true
+	for i, msg := range history {
+		seen[msg.ID] = true
+		if msg.ID == lastID {
+			start = i + 1
+			reset = false
+		}
+	}
+
+	for _, msg := range history[start:] {
+		if err := deliver(msg); err != nil {
+			g.node.Unsubscribe(subID)
+			return "", reset, err
+		}
+	}
+	return subID, reset, nil
+}
+
+// --- Server-Sent Events ---
+
+// handleSSE handles GET /sse?topic= — a Server-Sent Events stream of topic
+// messages. Each event's id is the message ID, so a reconnecting EventSource
+// resumes via the Last-Event-ID header (or ?last_id= on first connect).
+func (g *Gateway) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "missing topic query parameter", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_id")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var writeMu sync.Mutex
+	writeEvent := func(event, id string, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	subID, reset, err := g.subscribeFrom(topic, lastID, func(msg *Message) error {
+		data, err := json.Marshal(newWSMessage(msg))
+		if err != nil {
+			return err
+		}
+		return writeEvent("message", msg.ID, data)
+	})
+	if err != nil {
+		data, _ := json.Marshal(wsMessage{Type: "error", Message: err.Error()})
+		writeEvent("error", "", data)
+		return
+	}
+	defer g.node.Unsubscribe(subID)
+
+	if reset {
+		// The client's position fell out of history; it received everything
+		// retained but may have missed older messages.
+		writeEvent("reset", "", []byte("{}"))
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			writeMu.Lock()
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+			writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// --- Long polling ---
+
+// pollIdleTTL is how long a poll subscription outlives the poll that parked
+// it. The next poll within it resumes the subscription; a later one falls
+// back to replaying history after its cursor.
+const pollIdleTTL = time.Minute
+
+// pollBufferMax bounds the messages a poll subscription buffers between
+// polls. A session that overflows is dropped and the next poll replays from
+// history instead.
+const pollBufferMax = 10000
+
+// pollKey identifies a parked poll session by topic and the cursor it last
+// returned.
+type pollKey struct {
+	topic  string
+	cursor string
+}
+
+// pollSession is a subscription kept alive between long polls, so messages
+// published while no poll is open, including those forwarded by peers, are
+// still collected and the node keeps advertising interest in the topic.
+type pollSession struct {
+	subID string
+	reset bool
+	ready chan struct{}
+	idle  *time.Timer
+	key   pollKey // guarded by Gateway.pollMu
+
+	mu       sync.Mutex
+	msgs     []*Message
+	overflow bool
+}
+
+func (s *pollSession) deliver(msg *Message) error {
+	s.mu.Lock()
+	if len(s.msgs) < pollBufferMax {
+		s.msgs = append(s.msgs, msg)
+	} else {
+		s.overflow = true
+	}
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take removes and returns up to limit buffered messages.
+func (s *pollSession) take(limit int) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(limit, len(s.msgs))
+	msgs := s.msgs[:n:n]
+	s.msgs = s.msgs[n:]
+	return msgs
+}
+
+// claimPoll takes the session parked at topic and cursor, if any. A session
+// that overflowed its buffer is dropped and nil returned.
+func (g *Gateway) claimPoll(topic, cursor string) *pollSession {
+	key := pollKey{topic, cursor}
+	g.pollMu.Lock()
+	s := g.polls[key]
+	if s != nil {
+		delete(g.polls, key)
+		s.idle.Stop()
+	}
+	g.pollMu.Unlock()
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	overflow := s.overflow
+	s.mu.Unlock()
+	if overflow {
+		g.node.Unsubscribe(s.subID)
+		return nil
+	}
+	return s
+}
+
+// newPoll subscribes a poll session that replays history after cursor.
+func (g *Gateway) newPoll(topic, cursor string) (*pollSession, error) {
+	s := &pollSession{ready: make(chan struct{}, 1)}
+	subID, reset, err := g.subscribeFrom(topic, cursor, s.deliver)
+	if err != nil {
+		return nil, err
+	}
+	s.subID, s.reset = subID, reset
+	return s, nil
+}
+
+// parkPoll keeps s subscribed under the cursor just returned to the client
+// until the next poll claims it or pollIdleTTL passes. A session already
+// parked at the same position is replaced.
+func (g *Gateway) parkPoll(topic, cursor string, s *pollSession) {
+	key := pollKey{topic, cursor}
+	g.pollMu.Lock()
+	old := g.polls[key]
+	g.polls[key] = s
+	s.key = key
+	if s.idle == nil {
+		s.idle = time.AfterFunc(pollIdleTTL, func() { g.expirePoll(s) })
+	} else {
+		s.idle.Reset(pollIdleTTL)
+	}
+	g.pollMu.Unlock()
+
+	if old != nil {
+		old.idle.Stop()
+		g.node.Unsubscribe(old.subID)
+	}
+}
+
+// expirePoll unsubscribes s if it is still parked unclaimed.
+func (g *Gateway) expirePoll(s *pollSession) {
+	g.pollMu.Lock()
+	if g.polls[s.key] != s {
+		g.pollMu.Unlock()
+		return
+	}
+	delete(g.polls, s.key)
+	g.pollMu.Unlock()
+	g.node.Unsubscribe(s.subID)
+}
+
+// pollResponse is the JSON returned by /poll. Cursor is passed back on the
+// next poll; Reset reports that the request cursor had fallen out of history.
+type pollResponse struct {
+	Messages []wsMessage `json:"messages"`
+	Cursor   string      `json:"cursor"`
+	Reset    bool        `json:"reset,omitempty"`
+}
+
+// handlePoll handles GET /poll?topic=&cursor=&timeout=&limit= — returns up to
+// limit messages after cursor, waiting up to timeout (default 30s) for at
+// least one. The subscription outlives the poll for pollIdleTTL, so the next
+// poll with the returned cursor picks up messages that arrived in between,
+// including those beyond limit. Once it has expired, that poll replays them
+// from history instead.
+func (g *Gateway) handlePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	if topic == "" {
+		http.Error(w, "missing topic query parameter", http.StatusBadRequest)
+		return
+	}
+	cursor := q.Get("cursor")
+	limit := queryInt(r, "limit", 100)
+	if limit <= 0 {
+		limit = 100
+	}
+	timeout := 30 * time.Second
+	if s := q.Get("timeout"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			timeout = d
+		}
+	}
+
+	s := g.claimPoll(topic, cursor)
+	if s == nil {
+		// Without a cursor, start from the newest retained message so nothing
+		// published between this poll and the next is missed.
+		if cursor == "" {
+			if h := g.node.History(topic, 1); len(h) > 0 && !g.node.compacted[topic] {
+				cursor = h[0].ID
+			}
+		}
+
+		var err error
+		if s, err = g.newPoll(topic, cursor); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	msgs := s.take(limit)
+wait:
+	for len(msgs) == 0 {
+		select {
+		case <-s.ready:
+			msgs = s.take(limit)
+		case <-timer.C:
+			break wait
+		case <-r.Context().Done():
+			break wait
+		}
+	}
+
+	resp := pollResponse{
+		Messages: make([]wsMessage, 0, len(msgs)),
+		Cursor:   cursor,
+		Reset:    s.reset,
+	}
+	s.reset = false
+	for _, msg := range msgs {
+		resp.Messages = append(resp.Messages, newWSMessage(msg))
+		resp.Cursor = msg.ID
+	}
+	g.parkPoll(topic, resp.Cursor, s)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,274 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGateway_PollCursor(t *testing.T) {
+	n := newTestNode(t, "localhost:19010")
+	srv := httptest.NewServer(NewGateway(n).Handler())
+	t.Cleanup(srv.Close)
+
+	poll := func(cursor string) pollResponse {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/poll?topic=chat&timeout=200ms&cursor=" + cursor)
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		defer resp.Body.Close()
+		var pr pollResponse
+		if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return pr
+	}
+
+	n.Publish(&Message{ID: "m1", Destination: "chat", Payload: []byte("1")})
+
+	// No cursor: start after the newest message and time out empty.
+	pr := poll("")
+	if len(pr.Messages) != 0 || pr.Cursor != "m1" {
+		t.Fatalf("initial poll: %+v", pr)
+	}
+
+	n.Publish(&Message{ID: "m2", Destination: "chat", Payload: []byte("2")})
+	n.Publish(&Message{ID: "m3", Destination: "chat", Payload: []byte("3")})
+
+	pr = poll(pr.Cursor)
+	if len(pr.Messages) != 2 || pr.Messages[0].ID != "m2" || pr.Cursor != "m3" {
+		t.Fatalf("resume poll: %+v", pr)
+	}
+
+	pr = poll("unknown")
+	if !pr.Reset || len(pr.Messages) != 3 {
+		t.Fatalf("expected reset with full history, got %+v", pr)
+	}
+}
+
+// TestGateway_PollKeepsPeerForwarding checks that a message a peer publishes
+// between two polls is forwarded to the polling node and returned by the next
+// poll, since the poll subscription outlives the request.
+func TestGateway_PollKeepsPeerForwarding(t *testing.T) {
+	n1 := newTestNode(t, "localhost:19022")
+	n2 := newTestNode(t, "localhost:19023")
+	srv := httptest.NewServer(NewGateway(n1).Handler())
+	t.Cleanup(srv.Close)
+
+	poll := func(cursor string) pollResponse {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/poll?topic=chat&timeout=100ms&cursor=" + cursor)
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		defer resp.Body.Close()
+		var pr pollResponse
+		if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return pr
+	}
+
+	pr := poll("")
+	if err := n2.joinPeer("localhost:19022"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	// Wait for topic sync to propagate, with no poll open.
+	time.Sleep(500 * time.Millisecond)
+	n2.Publish(&Message{ID: "m1", Destination: "chat", Payload: []byte("1")})
+	time.Sleep(200 * time.Millisecond)
+
+	pr = poll(pr.Cursor)
+	if len(pr.Messages) != 1 || pr.Messages[0].ID != "m1" {
+		t.Fatalf("expected forwarded m1, got %+v", pr)
+	}
+}
+
+func TestGateway_SSEResume(t *testing.T) {
+	n := newTestNode(t, "localhost:19011")
+	srv := httptest.NewServer(NewGateway(n).Handler())
+	t.Cleanup(srv.Close)
+
+	n.Publish(&Message{ID: "m1", Destination: "chat", Payload: []byte("1")})
+	n.Publish(&Message{ID: "m2", Destination: "chat", Payload: []byte("2")})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sse?topic=chat", nil)
+	req.Header.Set("Last-Event-ID", "m1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sse: %v", err)
+	}
+	defer resp.Body.Close()
+
+	ids := make(chan string, 10)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if id, ok := strings.CutPrefix(sc.Text(), "id: "); ok {
+				ids <- id
+			}
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	n.Publish(&Message{ID: "m3", Destination: "chat", Payload: []byte("3")})
+
+	for _, want := range []string{"m2", "m3"} {
+		select {
+		case id := <-ids:
+			if id != want {
+				t.Fatalf("got event %q, want %q", id, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestGateway_Members(t *testing.T) {
+	n1 := newTestNode(t, "localhost:19015")
+	opts := DefaultOptions()
+	opts.GRPCAddress = "localhost:19016"
+	opts.HTTPAddress = "localhost:18016"
+	opts.EnableMDNS = false
+	n2 := NewNode(opts)
+	if err := n2.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { n2.Stop() })
+	if err := n1.joinPeer("localhost:19016"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	srv := httptest.NewServer(NewGateway(n1).Handler())
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/members")
+	if err != nil {
+		t.Fatalf("members: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Members []member `json:"members"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Members) != 2 || !body.Members[0].Self {
+		t.Fatalf("unexpected members: %+v", body.Members)
+	}
+	if m := body.Members[1]; m.NodeID != n2.NodeID() || m.HTTPAddress != "localhost:18016" {
+		t.Fatalf("peer member = %+v, want HTTP address from join", m)
+	}
+}
+
+func TestGateway_Drain(t *testing.T) {
+	n := newTestNode(t, "localhost:19017")
+	srv := httptest.NewServer(NewGateway(n).Handler())
+	t.Cleanup(srv.Close)
+
+	release := make(chan struct{})
+	if _, err := n.Subscribe("jobs", func(msg *Message) error {
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := n.Publish(&Message{Destination: "jobs", Payload: []byte("x")}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	drain := func() int {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/drain?timeout=200ms", "application/json", nil)
+		if err != nil {
+			t.Fatalf("drain: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := drain(); code != http.StatusGatewayTimeout {
+		t.Fatalf("drain with a pending message: status %d, want 504", code)
+	}
+	if _, err := n.Subscribe("other", func(*Message) error { return nil }); err != ErrDraining {
+		t.Fatalf("subscribe while draining: got %v, want ErrDraining", err)
+	}
+	resp, err := http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatalf("health: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("health while draining: status %d, want 503", resp.StatusCode)
+	}
+
+	close(release)
+	if code := drain(); code != http.StatusOK {
+		t.Fatalf("drain after handler finished: status %d, want 200", code)
+	}
+}
+
+func TestGateway_Schemas(t *testing.T) {
+	n := newTestNode(t, "localhost:19021")
+	srv := httptest.NewServer(NewGateway(n).Handler())
+	t.Cleanup(srv.Close)
+
+	do := func(method, path, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := do(http.MethodPost, "/schemas/order", `{"type": "json", "schema": `+testOrderSchema+`}`); code != http.StatusOK {
+		t.Fatalf("register schema: status %d", code)
+	}
+	if code := do(http.MethodPost, "/schemas/order", `{"type": "json", "schema": {"type": "string"}}`); code != http.StatusConflict {
+		t.Fatalf("register incompatible schema: status %d, want 409", code)
+	}
+	if code := do(http.MethodPut, "/topic-schemas/orders", `{"subject": "order"}`); code != http.StatusOK {
+		t.Fatalf("bind topic: status %d", code)
+	}
+
+	if code := do(http.MethodPost, "/topics/orders", `{"payload": {"id": "a"}}`); code != http.StatusOK {
+		t.Fatalf("publish valid payload: status %d", code)
+	}
+	if code := do(http.MethodPost, "/topics/orders", `{"payload": {"qty": 1}}`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("publish invalid payload: status %d, want 422", code)
+	}
+	// "e30=" is base64 for {}.
+	if code := do(http.MethodPost, "/publish", `{"topic": "orders", "payload": "e30="}`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("publish invalid base64 payload: status %d, want 422", code)
+	}
+
+	resp, err := http.Get(srv.URL + "/schemas")
+	if err != nil {
+		t.Fatalf("list schemas: %v", err)
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Subjects []subjectResponse `json:"subjects"`
+		Bindings map[string]string `json:"bindings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode schemas: %v", err)
+	}
+	if len(list.Subjects) != 1 || len(list.Subjects[0].Versions) != 1 || list.Bindings["orders"] != "order" {
+		t.Fatalf("schemas = %+v", list)
+	}
+}
@@ -11,6 +11,12 @@ type Message struct {
 	ReplyTo     string // for request-response pattern
 	StreamID    string // for streaming
 	Attempt     int32  // delivery attempt count
+	Key         string // compaction key (required on compacted topics)
+	Tombstone   bool   // removes Key from a compacted topic
+
+	// redriven receives the outcome of a dead letter's redelivery instead of
+	// the message being dead-lettered again. See Node.Redrive.
+	redriven func(error)
 }
 
 // Handler processes a received message. Return error to trigger retry.
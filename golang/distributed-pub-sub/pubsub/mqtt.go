@@ -0,0 +1,464 @@
+package pubsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MQTTBroker is an MQTT 3.1.1 front-end for a Node. MQTT topic names map
+// one-to-one to pubsub topics and subscription filters use the node's
+// wildcard support, so MQTT clients and gateway/gRPC clients share topics.
+//
+// Supported: QoS 0 and 1 in both directions (inbound QoS 2 is acknowledged
+// but delivered at-least-once), retained messages, will messages and
+// keep-alive. Sessions are always clean: CONNACK never reports a present
+// session and subscriptions end with the connection.
+type MQTTBroker struct {
+	node *Node
+
+	// AckTimeout is how long a QoS 1 delivery waits for PUBACK before the
+	// subscriber's retry loop redelivers it with the DUP flag (default: 10s).
+	AckTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*mqttSession // clientID -> live session
+	listener net.Listener
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+var (
+	errMQTTAckTimeout    = errors.New("mqtt: puback timeout")
+	errMQTTSessionClosed = errors.New("mqtt: session closed")
+)
+
+// NewMQTTBroker creates an MQTTBroker serving the given Node.
+func NewMQTTBroker(node *Node) *MQTTBroker {
+	return &MQTTBroker{
+		node:       node,
+		AckTimeout: 10 * time.Second,
+		sessions:   make(map[string]*mqttSession),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves MQTT clients.
+func (b *MQTTBroker) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return b.Serve(lis)
+}
+
+// Serve accepts MQTT connections on lis until Close is called.
+func (b *MQTTBroker) Serve(lis net.Listener) error {
+	b.mu.Lock()
+	b.listener = lis
+	b.mu.Unlock()
+
+	log.Printf("[node %s] MQTT listening on %s", b.node.opts.NodeID, lis.Addr())
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections, disconnects all clients and waits for
+// their sessions to finish.
+func (b *MQTTBroker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	lis := b.listener
+	sessions := make([]*mqttSession, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	var err error
+	if lis != nil {
+		err = lis.Close()
+	}
+	for _, s := range sessions {
+		s.conn.Close()
+	}
+	b.wg.Wait()
+	return err
+}
+
+// mqttSession is the state of one connected client.
+type mqttSession struct {
+	broker   *MQTTBroker
+	conn     net.Conn
+	r        *bufio.Reader
+	clientID string
+	will     *mqttPublishPacket
+
+	writeMu sync.Mutex
+
+	subs map[string]string // filter -> node subscriber ID
+
+	inflightMu sync.Mutex
+	nextID     uint16
+	inflight   map[uint16]chan struct{} // outbound QoS 1 awaiting PUBACK
+
+	done chan struct{}
+}
+
+func (b *MQTTBroker) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	s := &mqttSession{
+		broker:   b,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		subs:     make(map[string]string),
+		inflight: make(map[uint16]chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// The first packet must be CONNECT [MQTT-3.1.0-1].
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	pkt, err := readPacket(s.r)
+	if err != nil || pkt.Type != mqttConnect {
+		return
+	}
+	connect, err := decodeConnect(pkt.Body)
+	if err != nil {
+		return
+	}
+	if connect.ProtocolName != "MQTT" || connect.ProtocolLevel != 4 {
+		s.write(mqttConnack, 0, []byte{0, mqttConnBadProtocolVersion})
+		return
+	}
+	if connect.ClientID == "" {
+		if !connect.CleanSession {
+			s.write(mqttConnack, 0, []byte{0, mqttConnIdentifierRejected})
+			return
+		}
+		connect.ClientID = uuid.New().String()
+	}
+	s.clientID = connect.ClientID
+	s.will = connect.Will
+
+	if !b.register(s) {
+		return
+	}
+	defer b.unregister(s)
+
+	if err := s.write(mqttConnack, 0, []byte{0, mqttConnAccepted}); err != nil {
+		return
+	}
+
+	// A clean DISCONNECT discards the will; anything else publishes it.
+	graceful := s.readLoop(connect.KeepAlive)
+	close(s.done)
+	for _, subID := range s.subs {
+		b.node.Unsubscribe(subID)
+	}
+	if !graceful && s.will != nil {
+		if err := b.publish(s.will); err != nil {
+			log.Printf("[mqtt:%s] will publish failed: %v", s.clientID, err)
+		}
+	}
+}
+
+// register records s as the live session for its client ID, closing any
+// existing session with the same ID [MQTT-3.1.4-2].
+func (b *MQTTBroker) register(s *mqttSession) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	if old, ok := b.sessions[s.clientID]; ok {
+		old.conn.Close()
+	}
+	b.sessions[s.clientID] = s
+	return true
+}
+
+func (b *MQTTBroker) unregister(s *mqttSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sessions[s.clientID] == s {
+		delete(b.sessions, s.clientID)
+	}
+}
+
+// readLoop processes packets until the connection ends. It returns true if
+// the client sent DISCONNECT.
+func (s *mqttSession) readLoop(keepAlive uint16) bool {
+	for {
+		if keepAlive > 0 {
+			// [MQTT-3.1.2-24]: one and a half keep-alive periods.
+			s.conn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive) * 1500 * time.Millisecond))
+		} else {
+			s.conn.SetReadDeadline(time.Time{})
+		}
+
+		pkt, err := readPacket(s.r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("[mqtt:%s] read: %v", s.clientID, err)
+			}
+			return false
+		}
+
+		switch pkt.Type {
+		case mqttPublish:
+			err = s.handlePublish(pkt)
+		case mqttPuback:
+			s.handlePuback(pkt)
+		case mqttPubrel:
+			// Inbound QoS 2, second half: the message was already published
+			// on PUBLISH, so just complete the handshake.
+			err = s.write(mqttPubcomp, 0, pkt.Body)
+		case mqttSubscribe:
+			err = s.handleSubscribe(pkt)
+		case mqttUnsubscribe:
+			err = s.handleUnsubscribe(pkt)
+		case mqttPingreq:
+			err = s.write(mqttPingresp, 0, nil)
+		case mqttDisconnect:
+			return true
+		default:
+			err = fmt.Errorf("unexpected packet type %d", pkt.Type)
+		}
+		if err != nil {
+			log.Printf("[mqtt:%s] %v", s.clientID, err)
+			return false
+		}
+	}
+}
+
+func (s *mqttSession) write(typ, flags byte, body []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writePacket(s.conn, typ, flags, body)
+}
+
+func (s *mqttSession) handlePublish(pkt *mqttPacket) error {
+	pub, err := decodePublish(pkt.Flags, pkt.Body)
+	if err != nil {
+		return err
+	}
+	if pub.Topic == "" || pub.Topic[0] == '_' || isWildcard(pub.Topic) {
+		return fmt.Errorf("publish to invalid topic %q", pub.Topic)
+	}
+
+	if err := s.broker.publish(pub); err != nil {
+		// MQTT 3.1.1 has no negative PUBACK, so a rejected publish (e.g. rate
+		// limited) is logged and still acknowledged.
+		log.Printf("[mqtt:%s] publish to %s failed: %v", s.clientID, pub.Topic, err)
+	}
+
+	switch pub.QoS {
+	case 1:
+		return s.write(mqttPuback, 0, appendUint16(nil, pub.PacketID))
+	case 2:
+		return s.write(mqttPubrec, 0, appendUint16(nil, pub.PacketID))
+	}
+	return nil
+}
+
+func (s *mqttSession) handlePuback(pkt *mqttPacket) {
+	d := &mqttDecoder{b: pkt.Body}
+	id := d.uint16()
+	if d.err != nil {
+		return
+	}
+	s.inflightMu.Lock()
+	ch, ok := s.inflight[id]
+	delete(s.inflight, id)
+	s.inflightMu.Unlock()
+	if ok {
+		close(ch)
+	}
+}
+
+func (s *mqttSession) handleSubscribe(pkt *mqttPacket) error {
+	sub, err := decodeSubscribe(pkt.Body, true)
+	if err != nil {
+		return err
+	}
+
+	codes := appendUint16(nil, sub.PacketID)
+	for i, filter := range sub.Filters {
+		qos := sub.QoS[i]
+		if qos > 1 {
+			qos = 1 // QoS 2 is downgraded [MQTT-3.8.4-6]
+		}
+		if filter == "" || filter[0] == '_' {
+			codes = append(codes, mqttSubackFailure)
+			continue
+		}
+
+		// A repeated filter replaces the existing subscription [MQTT-3.8.4-3].
+		if old, ok := s.subs[filter]; ok {
+			s.broker.node.Unsubscribe(old)
+			delete(s.subs, filter)
+		}
+		subID, err := s.broker.node.Subscribe(filter, s.deliverFunc(qos, false))
+		if err != nil {
+			codes = append(codes, mqttSubackFailure)
+			continue
+		}
+		s.subs[filter] = subID
+		codes = append(codes, qos)
+		sub.QoS[i] = qos
+	}
+
+	if err := s.write(mqttSuback, 0, codes); err != nil {
+		return err
+	}
+
+	// Retained messages follow the SUBACK [MQTT-3.3.1-6].
+	for i, filter := range sub.Filters {
+		if _, ok := s.subs[filter]; ok {
+			go s.sendRetained(filter, sub.QoS[i])
+		}
+	}
+	return nil
+}
+
+func (s *mqttSession) handleUnsubscribe(pkt *mqttPacket) error {
+	unsub, err := decodeSubscribe(pkt.Body, false)
+	if err != nil {
+		return err
+	}
+	for _, filter := range unsub.Filters {
+		if subID, ok := s.subs[filter]; ok {
+			s.broker.node.Unsubscribe(subID)
+			delete(s.subs, filter)
+		}
+	}
+	return s.write(mqttUnsuback, 0, appendUint16(nil, unsub.PacketID))
+}
+
+// deliverFunc returns the node Handler for a subscription granted at qos.
+// QoS 1 deliveries block until PUBACK; a timeout returns an error so the
+// subscriber's retry loop redelivers with DUP set, and exhausted retries
+// land in the DLQ like any other failed delivery.
+func (s *mqttSession) deliverFunc(qos byte, retain bool) Handler {
+	return func(msg *Message) error {
+		pub := &mqttPublishPacket{
+			Topic:   msg.Destination,
+			QoS:     qos,
+			Retain:  retain,
+			Dup:     qos > 0 && msg.Attempt > 0,
+			Payload: msg.Payload,
+		}
+		if qos == 0 {
+			return s.write(mqttPublish, pub.flags(), pub.encode())
+		}
+
+		ack := make(chan struct{})
+		s.inflightMu.Lock()
+		pub.PacketID = s.allocPacketID()
+		s.inflight[pub.PacketID] = ack
+		s.inflightMu.Unlock()
+
+		if err := s.write(mqttPublish, pub.flags(), pub.encode()); err != nil {
+			s.releasePacketID(pub.PacketID)
+			return err
+		}
+
+		timer := time.NewTimer(s.broker.AckTimeout)
+		defer timer.Stop()
+		select {
+		case <-ack:
+			return nil
+		case <-timer.C:
+			s.releasePacketID(pub.PacketID)
+			return errMQTTAckTimeout
+		case <-s.done:
+			s.releasePacketID(pub.PacketID)
+			return errMQTTSessionClosed
+		}
+	}
+}
+
+// allocPacketID returns an unused non-zero packet ID. Must be called with
+// inflightMu held.
+func (s *mqttSession) allocPacketID() uint16 {
+	for {
+		s.nextID++
+		if s.nextID == 0 {
+			s.nextID = 1
+		}
+		if _, used := s.inflight[s.nextID]; !used {
+			return s.nextID
+		}
+	}
+}
+
+func (s *mqttSession) releasePacketID(id uint16) {
+	s.inflightMu.Lock()
+	delete(s.inflight, id)
+	s.inflightMu.Unlock()
+}
+
+// sendRetained delivers the retained messages matching filter.
+func (s *mqttSession) sendRetained(filter string, qos byte) {
+	retained, err := s.broker.node.snapshot(topicMQTTRetained)
+	if err != nil {
+		log.Printf("[mqtt:%s] retained lookup failed: %v", s.clientID, err)
+		return
+	}
+	deliver := s.deliverFunc(qos, true)
+	for _, r := range retained {
+		if !topicMatches(filter, r.Key) {
+			continue
+		}
+		msg := &Message{ID: r.ID, Destination: r.Key, Payload: r.Payload}
+		if err := deliver(msg); err != nil {
+			return
+		}
+	}
+}
+
+// publish hands an MQTT PUBLISH (or will) to the node. Retained messages are
+// also recorded on the compacted topicMQTTRetained, keyed by topic, so every
+// node's broker can serve them; an empty retained payload clears the topic.
+func (b *MQTTBroker) publish(pub *mqttPublishPacket) error {
+	if pub.Retain {
+		err := b.node.Publish(&Message{
+			Source:      b.node.opts.NodeID,
+			Destination: topicMQTTRetained,
+			Payload:     pub.Payload,
+			Key:         pub.Topic,
+			Tombstone:   len(pub.Payload) == 0,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return b.node.Publish(&Message{
+		Source:      b.node.opts.NodeID,
+		Destination: pub.Topic,
+		Payload:     pub.Payload,
+	})
+}
@@ -0,0 +1,320 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect     byte = 1
+	mqttConnack     byte = 2
+	mqttPublish     byte = 3
+	mqttPuback      byte = 4
+	mqttPubrec      byte = 5
+	mqttPubrel      byte = 6
+	mqttPubcomp     byte = 7
+	mqttSubscribe   byte = 8
+	mqttSuback      byte = 9
+	mqttUnsubscribe byte = 10
+	mqttUnsuback    byte = 11
+	mqttPingreq     byte = 12
+	mqttPingresp    byte = 13
+	mqttDisconnect  byte = 14
+)
+
+// CONNACK return codes.
+const (
+	mqttConnAccepted           byte = 0
+	mqttConnBadProtocolVersion byte = 1
+	mqttConnIdentifierRejected byte = 2
+)
+
+// mqttSubackFailure is the SUBACK return code for a rejected filter.
+const mqttSubackFailure byte = 0x80
+
+// mqttMaxRemaining is the largest remaining length the 4-byte varint encodes.
+const mqttMaxRemaining = 268435455
+
+var errMalformedPacket = errors.New("mqtt: malformed packet")
+
+// mqttPacket is a raw control packet: the fixed header split into type and
+// flags, plus the undecoded variable header and payload.
+type mqttPacket struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+// readPacket reads one control packet from r.
+func readPacket(r *bufio.Reader) (*mqttPacket, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var length, shift int
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMalformedPacket
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length |= int(c&0x7f) << shift
+		if c&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &mqttPacket{Type: b >> 4, Flags: b & 0x0f, Body: body}, nil
+}
+
+// writePacket encodes and writes one control packet.
+func writePacket(w io.Writer, typ, flags byte, body []byte) error {
+	if len(body) > mqttMaxRemaining {
+		return fmt.Errorf("mqtt: packet too large (%d bytes)", len(body))
+	}
+	buf := make([]byte, 0, 5+len(body))
+	buf = append(buf, typ<<4|flags)
+	n := len(body)
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			c |= 0x80
+		}
+		buf = append(buf, c)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// mqttDecoder reads the length-prefixed fields of a packet body.
+type mqttDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *mqttDecoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = errMalformedPacket
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *mqttDecoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = errMalformedPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *mqttDecoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.err = errMalformedPacket
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *mqttDecoder) string() string {
+	return string(d.bytes())
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// --- CONNECT ---
+
+type mqttConnectPacket struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16
+	ClientID      string
+	Will          *mqttPublishPacket // nil if no will
+	Username      string
+	Password      []byte
+}
+
+func decodeConnect(body []byte) (*mqttConnectPacket, error) {
+	d := &mqttDecoder{b: body}
+	c := &mqttConnectPacket{
+		ProtocolName:  d.string(),
+		ProtocolLevel: d.byte(),
+	}
+	flags := d.byte()
+	c.KeepAlive = d.uint16()
+	c.CleanSession = flags&0x02 != 0
+	c.ClientID = d.string()
+	if flags&0x04 != 0 {
+		c.Will = &mqttPublishPacket{
+			Topic:  d.string(),
+			QoS:    (flags >> 3) & 0x03,
+			Retain: flags&0x20 != 0,
+		}
+		c.Will.Payload = append([]byte(nil), d.bytes()...)
+	}
+	if flags&0x80 != 0 {
+		c.Username = d.string()
+	}
+	if flags&0x40 != 0 {
+		c.Password = append([]byte(nil), d.bytes()...)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return c, nil
+}
+
+func (c *mqttConnectPacket) encode() []byte {
+	var flags byte
+	if c.CleanSession {
+		flags |= 0x02
+	}
+	if c.Will != nil {
+		flags |= 0x04 | c.Will.QoS<<3
+		if c.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if c.Username != "" {
+		flags |= 0x80
+	}
+	if c.Password != nil {
+		flags |= 0x40
+	}
+
+	b := appendString(nil, c.ProtocolName)
+	b = append(b, c.ProtocolLevel, flags)
+	b = appendUint16(b, c.KeepAlive)
+	b = appendString(b, c.ClientID)
+	if c.Will != nil {
+		b = appendString(b, c.Will.Topic)
+		b = appendString(b, string(c.Will.Payload))
+	}
+	if c.Username != "" {
+		b = appendString(b, c.Username)
+	}
+	if c.Password != nil {
+		b = appendString(b, string(c.Password))
+	}
+	return b
+}
+
+// --- PUBLISH ---
+
+type mqttPublishPacket struct {
+	Topic    string
+	PacketID uint16 // only for QoS > 0
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	Payload  []byte
+}
+
+func decodePublish(flags byte, body []byte) (*mqttPublishPacket, error) {
+	p := &mqttPublishPacket{
+		QoS:    (flags >> 1) & 0x03,
+		Retain: flags&0x01 != 0,
+		Dup:    flags&0x08 != 0,
+	}
+	if p.QoS > 2 {
+		return nil, errMalformedPacket
+	}
+	d := &mqttDecoder{b: body}
+	p.Topic = d.string()
+	if p.QoS > 0 {
+		p.PacketID = d.uint16()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	p.Payload = d.b
+	return p, nil
+}
+
+func (p *mqttPublishPacket) flags() byte {
+	f := p.QoS << 1
+	if p.Retain {
+		f |= 0x01
+	}
+	if p.Dup {
+		f |= 0x08
+	}
+	return f
+}
+
+func (p *mqttPublishPacket) encode() []byte {
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = appendUint16(b, p.PacketID)
+	}
+	return append(b, p.Payload...)
+}
+
+// --- SUBSCRIBE / UNSUBSCRIBE ---
+
+type mqttSubscribePacket struct {
+	PacketID uint16
+	Filters  []string
+	QoS      []byte // requested QoS per filter (SUBSCRIBE only)
+}
+
+func decodeSubscribe(body []byte, withQoS bool) (*mqttSubscribePacket, error) {
+	d := &mqttDecoder{b: body}
+	s := &mqttSubscribePacket{PacketID: d.uint16()}
+	for d.err == nil && len(d.b) > 0 {
+		s.Filters = append(s.Filters, d.string())
+		if withQoS {
+			s.QoS = append(s.QoS, d.byte())
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(s.Filters) == 0 {
+		return nil, errMalformedPacket // [MQTT-3.8.3-3]
+	}
+	return s, nil
+}
+
+func (s *mqttSubscribePacket) encode() []byte {
+	b := appendUint16(nil, s.PacketID)
+	for i, f := range s.Filters {
+		b = appendString(b, f)
+		if i < len(s.QoS) {
+			b = append(b, s.QoS[i])
+		}
+	}
+	return b
+}
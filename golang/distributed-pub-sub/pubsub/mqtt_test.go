@@ -0,0 +1,201 @@
+package pubsub
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// testMQTTClient is a minimal in-process MQTT 3.1.1 client built on the
+// broker's packet codec.
+type testMQTTClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestMQTTBroker(t *testing.T, n *Node) (*MQTTBroker, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := NewMQTTBroker(n)
+	b.AckTimeout = 200 * time.Millisecond
+	go b.Serve(lis)
+	t.Cleanup(func() { b.Close() })
+	return b, lis.Addr().String()
+}
+
+func dialTestMQTT(t *testing.T, addr string, connect *mqttConnectPacket) *testMQTTClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testMQTTClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolLevel = 4
+	connect.CleanSession = true
+	c.send(mqttConnect, 0, connect.encode())
+	if pkt := c.expect(mqttConnack); pkt.Body[1] != mqttConnAccepted {
+		t.Fatalf("connect refused: %d", pkt.Body[1])
+	}
+	return c
+}
+
+func (c *testMQTTClient) send(typ, flags byte, body []byte) {
+	c.t.Helper()
+	if err := writePacket(c.conn, typ, flags, body); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *testMQTTClient) expect(typ byte) *mqttPacket {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	pkt, err := readPacket(c.r)
+	if err != nil {
+		c.t.Fatalf("read (want type %d): %v", typ, err)
+	}
+	if pkt.Type != typ {
+		c.t.Fatalf("got packet type %d, want %d", pkt.Type, typ)
+	}
+	return pkt
+}
+
+func (c *testMQTTClient) subscribe(filter string, qos byte) {
+	c.t.Helper()
+	sub := &mqttSubscribePacket{PacketID: 1, Filters: []string{filter}, QoS: []byte{qos}}
+	c.send(mqttSubscribe, 0x02, sub.encode())
+	if pkt := c.expect(mqttSuback); pkt.Body[2] != qos {
+		c.t.Fatalf("subscribe %s: granted %d, want %d", filter, pkt.Body[2], qos)
+	}
+}
+
+func (c *testMQTTClient) publish(pub *mqttPublishPacket) {
+	c.t.Helper()
+	c.send(mqttPublish, pub.flags(), pub.encode())
+	if pub.QoS == 1 {
+		c.expect(mqttPuback)
+	}
+}
+
+func (c *testMQTTClient) expectPublish() *mqttPublishPacket {
+	c.t.Helper()
+	pkt := c.expect(mqttPublish)
+	pub, err := decodePublish(pkt.Flags, pkt.Body)
+	if err != nil {
+		c.t.Fatalf("decode publish: %v", err)
+	}
+	return pub
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"+/b", "a/b", true},
+		{"#", "a/b", true},
+		{"#", "_sync.topics", false},
+		{"+", "_mqtt.retained", false},
+		{"a/+/c", "a/x/d", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"a/#/b", "a+/b", "a/b#"} {
+		if validateFilter(bad) == nil {
+			t.Errorf("validateFilter(%q) should fail", bad)
+		}
+	}
+}
+
+func TestMQTT_WildcardQoS0(t *testing.T) {
+	n := newTestNode(t, "localhost:19012")
+	_, addr := newTestMQTTBroker(t, n)
+
+	sub := dialTestMQTT(t, addr, &mqttConnectPacket{ClientID: "sub"})
+	sub.subscribe("sensors/+/temp", 0)
+
+	pub := dialTestMQTT(t, addr, &mqttConnectPacket{ClientID: "pub"})
+	pub.publish(&mqttPublishPacket{Topic: "sensors/kitchen/temp", Payload: []byte("21")})
+
+	got := sub.expectPublish()
+	if got.Topic != "sensors/kitchen/temp" || string(got.Payload) != "21" {
+		t.Fatalf("unexpected publish: %+v", got)
+	}
+
+	// Non-MQTT publishers reach MQTT subscribers too.
+	n.Publish(&Message{Destination: "sensors/hall/temp", Payload: []byte("19")})
+	if got := sub.expectPublish(); got.Topic != "sensors/hall/temp" {
+		t.Fatalf("unexpected publish: %+v", got)
+	}
+}
+
+func TestMQTT_QoS1Redelivery(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GRPCAddress = "localhost:19013"
+	opts.EnableMDNS = false
+	opts.RetryBaseDelay = 10 * time.Millisecond
+	n := NewNode(opts)
+	if err := n.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { n.Stop() })
+	_, addr := newTestMQTTBroker(t, n)
+
+	c := dialTestMQTT(t, addr, &mqttConnectPacket{ClientID: "c1"})
+	c.subscribe("jobs", 1)
+	n.Publish(&Message{Destination: "jobs", Payload: []byte("work")})
+
+	// Ignore the first delivery; the ack timeout triggers a DUP redelivery.
+	first := c.expectPublish()
+	if first.QoS != 1 || first.Dup {
+		t.Fatalf("unexpected first delivery: %+v", first)
+	}
+	second := c.expectPublish()
+	if !second.Dup || string(second.Payload) != "work" {
+		t.Fatalf("expected DUP redelivery, got %+v", second)
+	}
+	c.send(mqttPuback, 0, appendUint16(nil, second.PacketID))
+}
+
+func TestMQTT_RetainedAndWill(t *testing.T) {
+	n := newTestNode(t, "localhost:19014")
+	_, addr := newTestMQTTBroker(t, n)
+
+	pub := dialTestMQTT(t, addr, &mqttConnectPacket{ClientID: "pub"})
+	pub.publish(&mqttPublishPacket{Topic: "status/door", QoS: 1, PacketID: 7, Retain: true, Payload: []byte("open")})
+
+	sub := dialTestMQTT(t, addr, &mqttConnectPacket{ClientID: "sub"})
+	sub.subscribe("status/#", 0)
+	got := sub.expectPublish()
+	if !got.Retain || got.Topic != "status/door" || string(got.Payload) != "open" {
+		t.Fatalf("expected retained message, got %+v", got)
+	}
+
+	// A client that drops without DISCONNECT triggers its will.
+	dev := dialTestMQTT(t, addr, &mqttConnectPacket{
+		ClientID: "dev",
+		Will:     &mqttPublishPacket{Topic: "status/dev", Payload: []byte("offline")},
+	})
+	dev.conn.Close()
+
+	got = sub.expectPublish()
+	if got.Topic != "status/dev" || string(got.Payload) != "offline" {
+		t.Fatalf("expected will message, got %+v", got)
+	}
+}
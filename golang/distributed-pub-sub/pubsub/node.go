@@ -5,11 +5,14 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"distributed-pub-sub/pubsub/discovery"
@@ -18,15 +21,18 @@ import (
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // PeerInfo holds information about a connected peer node.
 type PeerInfo struct {
-	NodeID  string
-	Address string
-	Topics  []string
+	NodeID      string
+	Address     string
+	HTTPAddress string
+	Topics      []string
 }
 
 // outboundEntry is a queued message destined for a specific peer.
@@ -46,6 +52,7 @@ type Node struct {
 
 	// Subscribers: map[topic]map[subscriberID]*Subscriber
 	subscribers map[string]map[string]*Subscriber
+	wildcards   map[string]bool // subscribed topic filters containing '+' or '#'
 	subMu       sync.RWMutex
 
 	// Peers
@@ -53,6 +60,10 @@ type Node struct {
 	removedPeers map[string]string  // nodeID -> address (for rejoin)
 	peerMu       sync.RWMutex
 
+	// Callbacks run after the health check removes a dead peer.
+	peerRemovedHooks []func(nodeID string)
+	hookMu           sync.RWMutex
+
 	// TLS config for gRPC connections (nil = insecure)
 	tlsConfig *tls.Config
 
@@ -60,6 +71,7 @@ type Node struct {
 	queueFactory storage.QueueFactory
 	dlqStore     storage.DLQStore
 	dedupStore   storage.DeduplicationStore
+	compactStore storage.CompactionStore
 	sqliteStore  *storage.SQLiteStorage // non-nil when using SQLite backend
 
 	// Discovery
@@ -73,6 +85,14 @@ type Node struct {
 
 	// Global outbound queue for peer forwarding.
 	outbound chan *outboundEntry
+	// outboundPending counts entries queued or awaiting a retry.
+	outboundPending atomic.Int64
+	// retrying holds entries waiting out a retry backoff. Whoever removes
+	// an entry (its timer or a drain handoff) owns it.
+	retrying sync.Map // *outboundEntry -> struct{}
+
+	// Set by Drain; rejects new subscriptions and registrations.
+	draining atomic.Bool
 
 	// Service registry: tracks which services are registered on this node
 	// and the cluster-wide view from peer broadcasts.
@@ -80,12 +100,22 @@ type Node struct {
 	localServices map[string]map[string]bool
 	// peerServices: node_id -> service_name -> set of server_names
 	peerServices map[string]map[string][]string
+	// leavingPeers: peers that announced they are draining
+	leavingPeers map[string]bool
 	serviceMu    sync.RWMutex
 
 	// Per-topic message history (ring buffer).
 	history   map[string][]*Message
 	historyMu sync.RWMutex
 
+	// Compacted topics retain only the latest message per key. compactMu
+	// orders snapshot replay on Subscribe against live compacted deliveries.
+	compacted map[string]bool
+	compactMu sync.Mutex
+
+	// Cluster-wide schema registry, replicated on topicSchemas.
+	schemas *schemaRegistry
+
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
@@ -136,15 +166,24 @@ func NewNode(opts Options) *Node {
 		opts:          opts,
 		rateLimiter:   NewRateLimiter(opts.RateLimit, opts.RateBurst),
 		subscribers:   make(map[string]map[string]*Subscriber),
+		wildcards:     make(map[string]bool),
 		peers:         make(map[string]*Peer),
 		removedPeers:  make(map[string]string),
 		outbound:      make(chan *outboundEntry, opts.ChannelSize),
 		localServices: make(map[string]map[string]bool),
 		peerServices:  make(map[string]map[string][]string),
+		leavingPeers:  make(map[string]bool),
 		history:       make(map[string][]*Message),
+		compacted:     make(map[string]bool, len(opts.CompactedTopics)),
+		schemas:       newSchemaRegistry(),
 		ctx:           ctx,
 		cancel:        cancel,
 	}
+	n.compacted[topicMQTTRetained] = true
+	n.compacted[topicSchemas] = true
+	for _, t := range opts.CompactedTopics {
+		n.compacted[t] = true
+	}
 
 	// Set up TLS config if cert/key provided.
 	if opts.TLSCert != "" {
@@ -165,11 +204,14 @@ func NewNode(opts Options) *Node {
 		n.queueFactory = sqlStore.NewQueueFactory()
 		n.dlqStore = sqlStore
 		n.dedupStore = sqlStore
+		n.compactStore = sqlStore
 	} else {
 		n.queueFactory = storage.NewMemoryQueueFactory(opts.ChannelSize)
 		n.dlqStore = storage.NewMemoryDLQ()
 		n.dedupStore = storage.NewMemoryDedup()
+		n.compactStore = storage.NewMemoryCompaction()
 	}
+	n.loadSchemas()
 
 	return n
 }
@@ -188,9 +230,13 @@ func (n *Node) NodeID() string {
 // Start begins listening on gRPC, starts discovery (if enabled), and connects
 // to any configured seed peers.
 func (n *Node) Start() error {
-	lis, err := net.Listen("tcp", n.opts.GRPCAddress)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", n.opts.GRPCAddress, err)
+	lis := n.opts.Listener
+	if lis == nil {
+		var err error
+		lis, err = net.Listen("tcp", n.opts.GRPCAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", n.opts.GRPCAddress, err)
+		}
 	}
 
 	var serverOpts []grpc.ServerOption
@@ -281,6 +327,7 @@ func (n *Node) Stop() error {
 			delete(subs, id)
 		}
 		delete(n.subscribers, topic)
+		delete(n.wildcards, topic)
 	}
 	n.subMu.Unlock()
 
@@ -301,14 +348,30 @@ func (n *Node) Stop() error {
 		if n.dlqStore != nil {
 			n.dlqStore.Close()
 		}
+		if n.compactStore != nil {
+			n.compactStore.Close()
+		}
 	}
 
 	return nil
 }
 
-// Publish publishes a message: checks dedup, rate limit, delivers locally,
-// and forwards to peers with matching topics.
+// Publish publishes a message: validates its payload against the topic's
+// schema, checks dedup, rate limit, delivers locally, and forwards to peers
+// with matching topics. Payloads that do not conform are rejected with an
+// error wrapping ErrInvalidPayload.
 func (n *Node) Publish(msg *Message) error {
+	if err := n.validatePayload(msg); err != nil {
+		n.stats.MessagesFailed.Add(1)
+		return err
+	}
+	return n.publish(msg)
+}
+
+// publish is Publish without schema validation, for messages that were
+// validated where they entered the cluster. A peer that has not yet seen a
+// new schema version must not bounce them.
+func (n *Node) publish(msg *Message) error {
 	if msg.ID == "" {
 		msg.ID = uuid.New().String()
 	}
@@ -316,7 +379,10 @@ func (n *Node) Publish(msg *Message) error {
 		msg.Source = n.opts.NodeID
 	}
 	if msg.Timestamp == 0 {
-		msg.Timestamp = time.Now().UnixNano()
+		msg.Timestamp = n.opts.clock().Now().UnixNano()
+	}
+	if msg.Key == "" && n.compacted[msg.Destination] {
+		return fmt.Errorf("topic %q is compacted: message key required", msg.Destination)
 	}
 
 	// Dedup check.
@@ -342,20 +408,46 @@ func (n *Node) Publish(msg *Message) error {
 }
 
 // Subscribe creates a subscriber for the given topic and starts message
-// delivery. Returns the subscriber ID.
+// delivery. Returns the subscriber ID. Subscribers to a compacted topic first
+// receive the current value of every key, then live updates. The topic may
+// be a filter with '+'/'#' wildcards (see topicmatch.go).
 func (n *Node) Subscribe(topic string, handler Handler) (string, error) {
+	if n.draining.Load() {
+		return "", ErrDraining
+	}
+	if isWildcard(topic) {
+		if err := validateFilter(topic); err != nil {
+			return "", err
+		}
+	}
 	subID := uuid.New().String()
 
 	queue := n.queueFactory(topic, subID)
 	sub := NewSubscriber(subID, topic, handler, queue, n.dlqStore, n.opts, &n.stats)
 
+	compacted := n.compacted[topic]
+	if compacted {
+		n.compactMu.Lock()
+		if err := n.replaySnapshot(sub); err != nil {
+			n.compactMu.Unlock()
+			return "", err
+		}
+	}
+
 	n.subMu.Lock()
 	if n.subscribers[topic] == nil {
 		n.subscribers[topic] = make(map[string]*Subscriber)
+		if isWildcard(topic) {
+			n.wildcards[topic] = true
+		}
 	}
 	n.subscribers[topic][subID] = sub
 	n.subMu.Unlock()
 
+	if compacted {
+		n.compactMu.Unlock()
+	}
+
 	sub.Start()
 	n.stats.ActiveSubscribers.Add(1)
 
@@ -378,6 +470,7 @@ func (n *Node) Unsubscribe(subscriberID string) error {
 			delete(subs, subscriberID)
 			if len(subs) == 0 {
 				delete(n.subscribers, topic)
+				delete(n.wildcards, topic)
 				broadcast = true
 			}
 			n.stats.ActiveSubscribers.Add(-1)
@@ -399,6 +492,15 @@ func (n *Node) GetStats() map[string]int64 {
 	return n.stats.Snapshot()
 }
 
+// OnPeerRemoved registers fn to be called with the node ID of every peer the
+// health check removes as dead. fn runs on the health check goroutine and
+// must not block.
+func (n *Node) OnPeerRemoved(fn func(nodeID string)) {
+	n.hookMu.Lock()
+	n.peerRemovedHooks = append(n.peerRemovedHooks, fn)
+	n.hookMu.Unlock()
+}
+
 // GetPeers returns information about all connected peers.
 func (n *Node) GetPeers() []PeerInfo {
 	n.peerMu.RLock()
@@ -407,9 +509,10 @@ func (n *Node) GetPeers() []PeerInfo {
 	result := make([]PeerInfo, 0, len(n.peers))
 	for _, p := range n.peers {
 		result = append(result, PeerInfo{
-			NodeID:  p.NodeID,
-			Address: p.Address,
-			Topics:  p.TopicList(),
+			NodeID:      p.NodeID,
+			Address:     p.Address,
+			HTTPAddress: p.HTTPAddress,
+			Topics:      p.TopicList(),
 		})
 	}
 	return result
@@ -426,19 +529,7 @@ func (n *Node) GetDLQStore() storage.DLQStore {
 
 // Forward handles an incoming forwarded message from a peer.
 func (n *Node) Forward(ctx context.Context, req *pb.ForwardRequest) (*pb.ForwardResponse, error) {
-	msg := &Message{
-		ID:          req.GetId(),
-		Source:      req.GetSource(),
-		Destination: req.GetDestination(),
-		Payload:     req.GetPayload(),
-		Timestamp:   req.GetTimestamp(),
-		Sequence:    req.GetSequence(),
-		ReplyTo:     req.GetReplyTo(),
-		StreamID:    req.GetStreamId(),
-		Attempt:     req.GetAttempt(),
-	}
-
-	err := n.Publish(msg)
+	err := n.publish(fromForwardRequest(req))
 	if err != nil {
 		return &pb.ForwardResponse{Accepted: false}, nil
 	}
@@ -453,7 +544,7 @@ func (n *Node) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinResponse,
 
 	// Don't add ourselves.
 	if nodeID != n.opts.NodeID {
-		if err := n.addPeer(nodeID, address); err != nil {
+		if err := n.addPeer(nodeID, address, req.GetHttpAddress()); err != nil {
 			log.Printf("[node %s] failed to add peer %s: %v", n.opts.NodeID, nodeID, err)
 		}
 	}
@@ -466,18 +557,20 @@ func (n *Node) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinResponse,
 			continue
 		}
 		knownPeers = append(knownPeers, &pb.PeerInfo{
-			NodeId:  p.NodeID,
-			Address: p.Address,
-			Topics:  p.TopicList(),
+			NodeId:      p.NodeID,
+			Address:     p.Address,
+			HttpAddress: p.HTTPAddress,
+			Topics:      p.TopicList(),
 		})
 	}
 	n.peerMu.RUnlock()
 
 	return &pb.JoinResponse{
-		NodeId:  n.opts.NodeID,
-		Address: n.opts.GRPCAddress,
-		Topics:  n.topics(),
-		Peers:   knownPeers,
+		NodeId:      n.opts.NodeID,
+		Address:     n.opts.GRPCAddress,
+		HttpAddress: n.opts.HTTPAddress,
+		Topics:      n.topics(),
+		Peers:       knownPeers,
 	}, nil
 }
 
@@ -514,8 +607,13 @@ func (n *Node) PublishMessage(ctx context.Context, req *pb.PublishRequest) (*pb.
 		Destination: req.GetTopic(),
 		Payload:     req.GetPayload(),
 		ReplyTo:     req.GetReplyTo(),
+		Key:         req.GetKey(),
+		Tombstone:   req.GetTombstone(),
 	}
 	if err := n.Publish(msg); err != nil {
+		if errors.Is(err, ErrInvalidPayload) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		return nil, err
 	}
 	return &pb.PublishResponse{Id: msg.ID}, nil
@@ -534,6 +632,8 @@ func (n *Node) SubscribeTopic(req *pb.SubscribeRequest, stream grpc.ServerStream
 			Timestamp: msg.Timestamp,
 			ReplyTo:   msg.ReplyTo,
 			StreamId:  msg.StreamID,
+			Key:       msg.Key,
+			Tombstone: msg.Tombstone,
 		})
 	})
 	if err != nil {
@@ -553,6 +653,24 @@ func (n *Node) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Regis
 	serviceName := req.GetServiceName()
 	serverName := req.GetServerName()
 
+	// A draining node sends every server elsewhere.
+	if n.draining.Load() {
+		addr, nodeID := n.findUnservedPeer(serviceName)
+		if addr == "" {
+			addr, nodeID = n.anyActivePeer()
+		}
+		if addr == "" {
+			return nil, status.Error(codes.Unavailable, ErrDraining.Error())
+		}
+		log.Printf("[node %s] draining, redirecting %s/%s to %s (%s)",
+			n.opts.NodeID, serviceName, serverName, nodeID, addr)
+		return &pb.RegisterResponse{
+			Accepted:        false,
+			RedirectAddress: addr,
+			RedirectNodeId:  nodeID,
+		}, nil
+	}
+
 	// Check if any peer node lacks this service.
 	if addr, nodeID := n.findUnservedPeer(serviceName); addr != "" {
 		log.Printf("[node %s] redirecting %s/%s to underserved node %s (%s)",
@@ -609,14 +727,21 @@ func (n *Node) unregisterService(serviceName, serverName string) {
 // serviceRegistryPayload is the JSON format for service sync messages.
 type serviceRegistryPayload struct {
 	NodeID   string              `json:"node_id"`
-	Services map[string][]string `json:"services"` // service_name -> [server_names]
+	Services map[string][]string `json:"services"`          // service_name -> [server_names]
+	Leaving  bool                `json:"leaving,omitempty"` // node is draining
 }
 
 // broadcastServices publishes this node's service registry to all peers.
 func (n *Node) broadcastServices() {
+	leaving := n.draining.Load()
 	n.serviceMu.RLock()
 	services := make(map[string][]string, len(n.localServices))
 	for svc, servers := range n.localServices {
+		if leaving {
+			// Servers here are about to lose their node; peers should
+			// not count on them.
+			break
+		}
 		names := make([]string, 0, len(servers))
 		for name := range servers {
 			names = append(names, name)
@@ -628,6 +753,7 @@ func (n *Node) broadcastServices() {
 	payload, err := json.Marshal(serviceRegistryPayload{
 		NodeID:   n.opts.NodeID,
 		Services: services,
+		Leaving:  leaving,
 	})
 	if err != nil {
 		log.Printf("[node %s] failed to marshal service registry: %v", n.opts.NodeID, err)
@@ -655,6 +781,11 @@ func (n *Node) handleServiceSync(msg *Message) error {
 
 	n.serviceMu.Lock()
 	n.peerServices[reg.NodeID] = reg.Services
+	if reg.Leaving {
+		n.leavingPeers[reg.NodeID] = true
+	} else {
+		delete(n.leavingPeers, reg.NodeID)
+	}
 	n.serviceMu.Unlock()
 
 	log.Printf("[node %s] updated service registry for peer %s: %v", n.opts.NodeID, reg.NodeID, reg.Services)
@@ -671,6 +802,9 @@ func (n *Node) findUnservedPeer(serviceName string) (address string, nodeID stri
 	defer n.peerMu.RUnlock()
 
 	for id, p := range n.peers {
+		if n.leavingPeers[id] {
+			continue
+		}
 		peerSvcs, ok := n.peerServices[id]
 		if !ok || len(peerSvcs[serviceName]) == 0 {
 			return p.Address, id
@@ -679,6 +813,23 @@ func (n *Node) findUnservedPeer(serviceName string) (address string, nodeID stri
 	return "", ""
 }
 
+// anyActivePeer returns the gRPC address and node ID of a peer that is not
+// draining. Returns empty strings if there is none.
+func (n *Node) anyActivePeer() (address string, nodeID string) {
+	n.serviceMu.RLock()
+	defer n.serviceMu.RUnlock()
+
+	n.peerMu.RLock()
+	defer n.peerMu.RUnlock()
+
+	for id, p := range n.peers {
+		if !n.leavingPeers[id] {
+			return p.Address, id
+		}
+	}
+	return "", ""
+}
+
 // GetServices returns the cluster-wide service registry snapshot.
 func (n *Node) GetServices() map[string]map[string][]string {
 	n.serviceMu.RLock()
@@ -709,8 +860,9 @@ func (n *Node) GetServices() map[string]map[string][]string {
 // Helper methods
 // ---------------------------------------------------------------------------
 
-// addPeer creates a new peer connection and exchanges topics.
-func (n *Node) addPeer(nodeID, address string) error {
+// addPeer creates a new peer connection and exchanges topics. httpAddress is
+// the peer's advertised HTTP address, if known.
+func (n *Node) addPeer(nodeID, address, httpAddress string) error {
 	n.peerMu.Lock()
 	if _, exists := n.peers[nodeID]; exists {
 		n.peerMu.Unlock()
@@ -718,7 +870,9 @@ func (n *Node) addPeer(nodeID, address string) error {
 	}
 
 	p := NewPeer(nodeID, address)
+	p.HTTPAddress = httpAddress
 	p.tlsConfig = n.tlsConfig
+	p.dialOpts = n.opts.DialOptions
 	n.peers[nodeID] = p
 	n.peerMu.Unlock()
 
@@ -741,14 +895,20 @@ func (n *Node) addPeer(nodeID, address string) error {
 		remoteTopics = append(remoteTopics, topicSync)
 		p.UpdateTopics(remoteTopics)
 	}
+	n.syncSchemas(nodeID)
 
 	return nil
 }
 
 // deliverLocal delivers a message to all local subscribers matching the topic.
 func (n *Node) deliverLocal(msg *Message) {
-	// Record in history (skip internal topics).
 	topic := msg.Destination
+	if n.compacted[topic] {
+		n.deliverCompacted(msg)
+		return
+	}
+
+	// Record in history (skip internal topics).
 	if len(topic) == 0 || topic[0] != '_' {
 		n.historyMu.Lock()
 		h := n.history[topic]
@@ -760,27 +920,129 @@ func (n *Node) deliverLocal(msg *Message) {
 		n.historyMu.Unlock()
 	}
 
+	for _, sub := range n.localSubscribers(topic) {
+		sub.Deliver(msg)
+	}
+}
+
+// ErrRedriveTarget is returned by Redrive when the subscriber or peer a dead
+// letter failed on is no longer there.
+var ErrRedriveTarget = errors.New("dead letter target is gone")
+
+// Redrive delivers dead letter id again, bypassing deduplication, to the
+// local subscriber or peer it failed on, and removes it from the DLQ once
+// that delivery succeeds. A subscriber gets a fresh round of attempts; if
+// they fail the entry stays in the DLQ. Redrive returns when the outcome is
+// known or ctx is done, in which case a subscriber redelivery carries on.
+func (n *Node) Redrive(ctx context.Context, id string) error {
+	dl, err := n.dlqStore.Get(id)
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		ID:          dl.MessageID,
+		Source:      dl.Source,
+		Destination: dl.OriginalTopic,
+		Payload:     dl.Payload,
+		Timestamp:   dl.Timestamp,
+		ReplyTo:     dl.ReplyTo,
+		StreamID:    dl.StreamID,
+		Key:         dl.Key,
+		Tombstone:   dl.Tombstone,
+	}
+
+	switch {
+	case dl.Peer != "":
+		n.peerMu.RLock()
+		p, ok := n.peers[dl.Peer]
+		n.peerMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("%w: peer %s", ErrRedriveTarget, dl.Peer)
+		}
+		if err := p.Forward(ctx, msg); err != nil {
+			return err
+		}
+		n.stats.MessagesForwarded.Add(1)
+		return n.dlqStore.Delete(id)
+
+	case dl.Subscriber != "":
+		sub := n.findSubscriber(dl.Subscriber)
+		if sub == nil {
+			return fmt.Errorf("%w: subscriber %s", ErrRedriveTarget, dl.Subscriber)
+		}
+		result := make(chan error, 1)
+		err := sub.redeliver(ctx, msg, func(err error) {
+			if err == nil {
+				if derr := n.dlqStore.Delete(id); derr != nil {
+					log.Printf("[node %s] DLQ delete of redriven %s failed: %v", n.opts.NodeID, id, derr)
+				}
+			}
+			result <- err
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case err := <-result:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	default:
+		return fmt.Errorf("%w: dead letter %s records no subscriber or peer", ErrRedriveTarget, id)
+	}
+}
+
+// findSubscriber returns the local subscriber with the given ID, or nil.
+func (n *Node) findSubscriber(id string) *Subscriber {
 	n.subMu.RLock()
-	subs, ok := n.subscribers[msg.Destination]
-	if !ok {
-		n.subMu.RUnlock()
-		return
+	defer n.subMu.RUnlock()
+
+	for _, subs := range n.subscribers {
+		if sub, ok := subs[id]; ok {
+			return sub
+		}
 	}
+	return nil
+}
+
+// localSubscribers returns a copy of the subscribers for topic, including
+// those on matching wildcard filters, so delivery can happen without holding
+// subMu.
+func (n *Node) localSubscribers(topic string) []*Subscriber {
+	n.subMu.RLock()
+	defer n.subMu.RUnlock()
 
-	// Copy subscriber references under lock, deliver outside.
+	subs := n.subscribers[topic]
 	targets := make([]*Subscriber, 0, len(subs))
 	for _, sub := range subs {
 		targets = append(targets, sub)
 	}
-	n.subMu.RUnlock()
-
-	for _, sub := range targets {
-		sub.Deliver(msg)
+	for filter := range n.wildcards {
+		if filter != topic && topicMatches(filter, topic) {
+			for _, sub := range n.subscribers[filter] {
+				targets = append(targets, sub)
+			}
+		}
 	}
+	return targets
 }
 
-// History returns the last N messages for the given topic.
+// History returns the last N messages for the given topic. For compacted
+// topics it returns the current value of each key instead.
 func (n *Node) History(topic string, limit int) []*Message {
+	if n.compacted[topic] {
+		h, err := n.snapshot(topic)
+		if err != nil {
+			log.Printf("[node %s] compacted snapshot for %s failed: %v", n.opts.NodeID, topic, err)
+		}
+		if limit > 0 && limit < len(h) {
+			h = h[len(h)-limit:]
+		}
+		return h
+	}
+
 	n.historyMu.RLock()
 	defer n.historyMu.RUnlock()
 
@@ -796,14 +1058,19 @@ func (n *Node) History(topic string, limit int) []*Message {
 // forwardToPeers enqueues a message for delivery to all matching peers.
 // Messages on the internal sync topic are enqueued for all peers.
 func (n *Node) forwardToPeers(_ context.Context, msg *Message) {
-	broadcastAll := msg.Destination == topicSync || msg.Destination == topicServiceSync
+	// Compacted topics go to every peer so each node holds the full snapshot
+	// for subscribers that arrive later.
+	broadcastAll := msg.Destination == topicSync || msg.Destination == topicServiceSync ||
+		n.compacted[msg.Destination] || strings.HasPrefix(msg.Destination, replyTopicPrefix)
 
 	n.peerMu.RLock()
 	for _, p := range n.peers {
 		if broadcastAll || p.HasTopic(msg.Destination) {
+			n.outboundPending.Add(1)
 			select {
 			case n.outbound <- &outboundEntry{msg: msg, peerID: p.NodeID}:
 			default:
+				n.outboundPending.Add(-1)
 				log.Printf("[node %s] outbound queue full, dropping message %s for peer %s",
 					n.opts.NodeID, msg.ID, p.NodeID)
 				n.stats.MessagesFailed.Add(1)
@@ -830,68 +1097,112 @@ func (n *Node) forwardLoop() {
 
 			if !ok {
 				// Peer gone, drop the message.
+				n.outboundPending.Add(-1)
 				continue
 			}
 
 			if err := p.Forward(n.ctx, entry.msg); err != nil {
 				entry.retries++
 				if entry.retries <= n.opts.MaxRetries {
-					// Re-enqueue with backoff in a goroutine to avoid blocking the worker.
 					delay := n.opts.RetryBaseDelay * time.Duration(1<<(entry.retries-1))
 					if delay > n.opts.RetryMaxDelay {
 						delay = n.opts.RetryMaxDelay
 					}
-					go func(e *outboundEntry, d time.Duration) {
-						select {
-						case <-n.ctx.Done():
-						case <-time.After(d):
-							select {
-							case n.outbound <- e:
-							default:
-								log.Printf("[node %s] outbound queue full on retry, dropping message %s for peer %s",
-									n.opts.NodeID, e.msg.ID, e.peerID)
-							}
-						}
-					}(entry, delay)
+					n.scheduleRetry(entry, delay)
 				} else {
-					log.Printf("[node %s] dropping message %s for peer %s after %d retries: %v",
+					log.Printf("[node %s] dead-lettering message %s for peer %s after %d retries: %v",
 						n.opts.NodeID, entry.msg.ID, entry.peerID, entry.retries, err)
 					n.stats.MessagesFailed.Add(1)
+					n.deadLetterForward(entry, err)
+					n.outboundPending.Add(-1)
 				}
 			} else {
 				n.stats.MessagesForwarded.Add(1)
+				n.outboundPending.Add(-1)
 			}
 		}
 	}
 }
 
+// deadLetterForward records a forward to a peer that exhausted its retries,
+// so it can be redriven to that peer.
+func (n *Node) deadLetterForward(e *outboundEntry, reason error) {
+	n.stats.MessagesDLQ.Add(1)
+	dl := &storage.DeadLetter{
+		ID:            uuid.New().String(),
+		OriginalTopic: e.msg.Destination,
+		Source:        e.msg.Source,
+		Payload:       e.msg.Payload,
+		Reason:        reason.Error(),
+		Attempts:      int32(e.retries),
+		DeadAt:        n.opts.clock().Now().UnixNano(),
+		MessageID:     e.msg.ID,
+		Peer:          e.peerID,
+		Key:           e.msg.Key,
+		Tombstone:     e.msg.Tombstone,
+		ReplyTo:       e.msg.ReplyTo,
+		StreamID:      e.msg.StreamID,
+		Timestamp:     e.msg.Timestamp,
+	}
+	if err := n.dlqStore.Add(dl); err != nil {
+		log.Printf("[node %s] DLQ add failed for message %s: %v", n.opts.NodeID, e.msg.ID, err)
+	}
+}
+
+// scheduleRetry re-enqueues e after d, in a goroutine to avoid blocking the
+// forward worker. A draining node may hand e off to another peer first.
+func (n *Node) scheduleRetry(e *outboundEntry, d time.Duration) {
+	n.retrying.Store(e, struct{}{})
+	go func() {
+		select {
+		case <-n.ctx.Done():
+		case <-n.opts.clock().After(d):
+			if _, ok := n.retrying.LoadAndDelete(e); !ok {
+				return // handed off
+			}
+			select {
+			case n.outbound <- e:
+			default:
+				n.outboundPending.Add(-1)
+				log.Printf("[node %s] outbound queue full on retry, dropping message %s for peer %s",
+					n.opts.NodeID, e.msg.ID, e.peerID)
+			}
+		}
+	}()
+}
+
 // dedupCleanupLoop periodically removes expired entries from the dedup store
-// and the in-memory fallback map.
+// and the in-memory fallback map, and purges expired compaction tombstones.
 func (n *Node) dedupCleanupLoop() {
 	defer n.wg.Done()
 	interval := n.opts.DedupTTL / 2
 	if interval < time.Minute {
 		interval = time.Minute
 	}
-	ticker := time.NewTicker(interval)
+	ticker := n.opts.clock().NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-n.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			if err := n.dedupStore.Cleanup(n.opts.DedupTTL); err != nil {
 				log.Printf("[node %s] dedup cleanup error: %v", n.opts.NodeID, err)
 			}
 			// Also prune the in-memory fallback map.
-			cutoff := time.Now().Add(-n.opts.DedupTTL)
+			cutoff := n.opts.clock().Now().Add(-n.opts.DedupTTL)
 			n.memDedup.Range(func(key, value any) bool {
 				if t, ok := value.(time.Time); ok && t.Before(cutoff) {
 					n.memDedup.Delete(key)
 				}
 				return true
 			})
+			if n.opts.TombstoneTTL > 0 {
+				if err := n.compactStore.PurgeTombstones(n.opts.TombstoneTTL); err != nil {
+					log.Printf("[node %s] tombstone purge error: %v", n.opts.NodeID, err)
+				}
+			}
 		}
 	}
 }
@@ -909,7 +1220,7 @@ func (n *Node) isDuplicate(messageID string) bool {
 	}
 
 	// Fallback: use sync.Map based dedup.
-	_, loaded := n.memDedup.LoadOrStore(messageID, time.Now())
+	_, loaded := n.memDedup.LoadOrStore(messageID, n.opts.clock().Now())
 	return loaded
 }
 
@@ -970,6 +1281,10 @@ func (n *Node) startDiscovery() {
 // sync topic so that all peers learn about subscription changes.
 func (n *Node) broadcastTopics() {
 	topics := n.topics()
+	if n.draining.Load() {
+		// Withdraw all topics so peers stop forwarding new messages here.
+		topics = nil
+	}
 
 	// Filter out internal topics (those starting with '_').
 	filtered := make([]string, 0, len(topics))
@@ -1040,14 +1355,24 @@ func (n *Node) removePeer(nodeID string) {
 
 	n.serviceMu.Lock()
 	delete(n.peerServices, nodeID)
+	delete(n.leavingPeers, nodeID)
 	n.serviceMu.Unlock()
+
+	if ok {
+		n.hookMu.RLock()
+		hooks := n.peerRemovedHooks
+		n.hookMu.RUnlock()
+		for _, fn := range hooks {
+			fn(nodeID)
+		}
+	}
 }
 
 // healthCheckLoop periodically pings all peers and removes those that fail
 // MaxHealthFailures consecutive checks.
 func (n *Node) healthCheckLoop() {
 	defer n.wg.Done()
-	ticker := time.NewTicker(n.opts.HealthCheckInterval)
+	ticker := n.opts.clock().NewTicker(n.opts.HealthCheckInterval)
 	defer ticker.Stop()
 
 	failures := make(map[string]int)
@@ -1056,7 +1381,7 @@ func (n *Node) healthCheckLoop() {
 		select {
 		case <-n.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			n.peerMu.RLock()
 			peers := make([]*Peer, 0, len(n.peers))
 			for _, p := range n.peers {
@@ -1091,7 +1416,7 @@ func (n *Node) joinPeer(address string) error {
 	} else {
 		dialCreds = grpc.WithTransportCredentials(insecure.NewCredentials())
 	}
-	conn, err := grpc.NewClient(address, dialCreds)
+	conn, err := grpc.NewClient(address, append([]grpc.DialOption{dialCreds}, n.opts.DialOptions...)...)
 	if err != nil {
 		return fmt.Errorf("dial %s: %w", address, err)
 	}
@@ -1103,9 +1428,10 @@ func (n *Node) joinPeer(address string) error {
 	defer cancel()
 
 	resp, err := client.Join(ctx, &pb.JoinRequest{
-		NodeId:  n.opts.NodeID,
-		Address: n.opts.GRPCAddress,
-		Topics:  n.topics(),
+		NodeId:      n.opts.NodeID,
+		Address:     n.opts.GRPCAddress,
+		HttpAddress: n.opts.HTTPAddress,
+		Topics:      n.topics(),
 	})
 	if err != nil {
 		return fmt.Errorf("join RPC to %s: %w", address, err)
@@ -1113,18 +1439,25 @@ func (n *Node) joinPeer(address string) error {
 
 	// Add the responding node as a peer.
 	if resp.GetNodeId() != n.opts.NodeID {
-		if err := n.addPeer(resp.GetNodeId(), resp.GetAddress()); err != nil {
+		if err := n.addPeer(resp.GetNodeId(), resp.GetAddress(), resp.GetHttpAddress()); err != nil {
 			log.Printf("[node %s] failed to add responding peer %s: %v", n.opts.NodeID, resp.GetNodeId(), err)
 		}
 	}
 
-	// Add any additional peers returned.
+	// Join any additional peers returned, so that they add this node too;
+	// a plain connection would leave the link one-way.
 	for _, pi := range resp.GetPeers() {
 		if pi.GetNodeId() == n.opts.NodeID {
 			continue
 		}
-		if err := n.addPeer(pi.GetNodeId(), pi.GetAddress()); err != nil {
-			log.Printf("[node %s] failed to add peer %s from join response: %v", n.opts.NodeID, pi.GetNodeId(), err)
+		n.peerMu.RLock()
+		_, known := n.peers[pi.GetNodeId()]
+		n.peerMu.RUnlock()
+		if known {
+			continue
+		}
+		if err := n.joinPeer(pi.GetAddress()); err != nil {
+			log.Printf("[node %s] failed to join peer %s from join response: %v", n.opts.NodeID, pi.GetNodeId(), err)
 		}
 	}
 
@@ -1135,14 +1468,14 @@ func (n *Node) joinPeer(address string) error {
 // previously removed by the health check loop.
 func (n *Node) rejoinLoop() {
 	defer n.wg.Done()
-	ticker := time.NewTicker(n.opts.RejoinInterval)
+	ticker := n.opts.clock().NewTicker(n.opts.RejoinInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-n.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			n.peerMu.RLock()
 			candidates := make(map[string]string, len(n.removedPeers))
 			for id, addr := range n.removedPeers {
@@ -1,9 +1,15 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"distributed-pub-sub/pubsub/schema"
+	"distributed-pub-sub/pubsub/storage"
 )
 
 func newTestNode(t *testing.T, grpcAddr string) *Node {
@@ -148,3 +154,316 @@ func TestNode_History(t *testing.T) {
 		t.Fatalf("expected 5 history entries, got %d", len(h))
 	}
 }
+
+func TestNode_CompactedTopicSnapshot(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GRPCAddress = "localhost:19009"
+	opts.CompactedTopics = []string{"config"}
+	n := NewNode(opts)
+	if err := n.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { n.Stop() })
+
+	if err := n.Publish(&Message{Destination: "config", Payload: []byte("x")}); err == nil {
+		t.Fatal("expected error publishing without key to compacted topic")
+	}
+
+	n.Publish(&Message{Destination: "config", Key: "a", Payload: []byte("a1")})
+	n.Publish(&Message{Destination: "config", Key: "b", Payload: []byte("b1")})
+	n.Publish(&Message{Destination: "config", Key: "a", Payload: []byte("a2")})
+	n.Publish(&Message{Destination: "config", Key: "b", Tombstone: true})
+
+	got := make(chan string, 10)
+	n.Subscribe("config", func(msg *Message) error {
+		got <- msg.Key + "=" + string(msg.Payload)
+		return nil
+	})
+	n.Publish(&Message{Destination: "config", Key: "c", Payload: []byte("c1")})
+
+	for _, want := range []string{"a=a2", "c=c1"} {
+		select {
+		case v := <-got:
+			if v != want {
+				t.Fatalf("got %q, want %q", v, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	if h := n.History("config", 0); len(h) != 2 {
+		t.Fatalf("expected 2 retained keys in history, got %d", len(h))
+	}
+}
+
+const testOrderSchema = `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`
+
+func TestNode_SchemaValidation(t *testing.T) {
+	n := newTestNode(t, "localhost:19018")
+
+	if err := n.BindSchema("orders", "order"); !errors.Is(err, ErrSubjectNotFound) {
+		t.Fatalf("bind to unknown subject: got %v, want ErrSubjectNotFound", err)
+	}
+	v, err := n.RegisterSchema("order", schema.Schema{Type: schema.JSON, Definition: []byte(testOrderSchema)})
+	if err != nil || v != 1 {
+		t.Fatalf("register: version %d, err %v", v, err)
+	}
+	if err := n.BindSchema("orders", "order"); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+
+	if err := n.Publish(&Message{Destination: "orders", Payload: []byte(`{"id": "a"}`)}); err != nil {
+		t.Fatalf("publish valid payload: %v", err)
+	}
+	err = n.Publish(&Message{Destination: "orders", Payload: []byte(`{"qty": 1}`)})
+	if !errors.Is(err, ErrInvalidPayload) || !strings.Contains(err.Error(), `missing required property "id"`) {
+		t.Fatalf("publish invalid payload: got %v", err)
+	}
+	if err := n.Publish(&Message{Destination: "other", Payload: []byte("opaque")}); err != nil {
+		t.Fatalf("publish to unbound topic: %v", err)
+	}
+
+	// Requiring a new property breaks the default backward compatibility.
+	stricter := `{"type": "object", "properties": {"id": {"type": "string"}, "qty": {"type": "integer"}}, "required": ["id", "qty"]}`
+	if _, err := n.RegisterSchema("order", schema.Schema{Type: schema.JSON, Definition: []byte(stricter)}); !errors.Is(err, ErrIncompatibleSchema) {
+		t.Fatalf("register incompatible version: got %v, want ErrIncompatibleSchema", err)
+	}
+	if err := n.SetSchemaCompatibility("order", schema.None); err != nil {
+		t.Fatalf("set compatibility: %v", err)
+	}
+	if v, err := n.RegisterSchema("order", schema.Schema{Type: schema.JSON, Definition: []byte(stricter)}); err != nil || v != 2 {
+		t.Fatalf("register with compatibility none: version %d, err %v", v, err)
+	}
+	if err := n.Publish(&Message{Destination: "orders", Payload: []byte(`{"id": "a"}`)}); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("publish against version 2: got %v, want ErrInvalidPayload", err)
+	}
+
+	if err := n.DeleteSubject("order"); err == nil {
+		t.Fatal("deleted a subject still bound to a topic")
+	}
+	if err := n.UnbindSchema("orders"); err != nil {
+		t.Fatalf("unbind: %v", err)
+	}
+	if err := n.Publish(&Message{Destination: "orders", Payload: []byte("opaque")}); err != nil {
+		t.Fatalf("publish after unbind: %v", err)
+	}
+}
+
+func TestNode_SchemaReplicatesToLateJoiner(t *testing.T) {
+	n1 := newTestNode(t, "localhost:19019")
+	if _, err := n1.RegisterSchema("order", schema.Schema{Type: schema.JSON, Definition: []byte(testOrderSchema)}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := n1.BindSchema("orders", "order"); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+
+	n2 := newTestNode(t, "localhost:19020")
+	if err := n2.joinPeer("localhost:19019"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for n2.SchemaBindings()["orders"] != "order" {
+		if time.Now().After(deadline) {
+			t.Fatal("schema binding did not reach the joining node")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := n2.Publish(&Message{Destination: "orders", Payload: []byte(`[]`)}); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("publish invalid payload on joiner: got %v, want ErrInvalidPayload", err)
+	}
+}
+
+func TestNode_RedriveTargetsFailedSubscriber(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GRPCAddress = "localhost:19024"
+	opts.EnableMDNS = false
+	opts.MaxRetries = 1
+	opts.RetryBaseDelay = 10 * time.Millisecond
+	n := NewNode(opts)
+	if err := n.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { n.Stop() })
+
+	var good, bad atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	n.Subscribe("jobs", func(*Message) error {
+		good.Add(1)
+		return nil
+	})
+	n.Subscribe("jobs", func(*Message) error {
+		if failing.Load() {
+			return errors.New("failing")
+		}
+		bad.Add(1)
+		return nil
+	})
+
+	n.Publish(&Message{Destination: "jobs", Payload: []byte("x")})
+	var letters []*storage.DeadLetter
+	for deadline := time.Now().Add(time.Second); len(letters) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("message was not dead-lettered")
+		}
+		time.Sleep(10 * time.Millisecond)
+		letters, _ = n.GetDLQStore().List("jobs", 10, 0)
+	}
+
+	// Still failing: the entry stays in the DLQ.
+	if err := n.Redrive(context.Background(), letters[0].ID); err == nil {
+		t.Fatal("redrive to a failing subscriber succeeded")
+	}
+	if count, _ := n.GetDLQStore().Count("jobs"); count != 1 {
+		t.Fatalf("DLQ count after failed redrive = %d, want 1", count)
+	}
+
+	failing.Store(false)
+	if err := n.Redrive(context.Background(), letters[0].ID); err != nil {
+		t.Fatalf("redrive: %v", err)
+	}
+	if good.Load() != 1 || bad.Load() != 1 {
+		t.Fatalf("deliveries: good %d, bad %d; want 1 each", good.Load(), bad.Load())
+	}
+	if count, _ := n.GetDLQStore().Count("jobs"); count != 0 {
+		t.Fatalf("DLQ count after redrive = %d, want 0", count)
+	}
+}
+
+func TestNode_RedriveToPeer(t *testing.T) {
+	n1 := newTestNode(t, "localhost:19025")
+	n2 := newTestNode(t, "localhost:19026")
+
+	var received atomic.Int32
+	n2.Subscribe("jobs", func(*Message) error {
+		received.Add(1)
+		return nil
+	})
+	if err := n1.joinPeer("localhost:19026"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	// A local subscriber that never saw the message must not get it.
+	var local atomic.Int32
+	n1.Subscribe("jobs", func(*Message) error {
+		local.Add(1)
+		return nil
+	})
+
+	dlq := n1.GetDLQStore()
+	dlq.Add(&storage.DeadLetter{ID: "dl1", OriginalTopic: "jobs", MessageID: "m1", Peer: n2.NodeID()})
+	dlq.Add(&storage.DeadLetter{ID: "dl2", OriginalTopic: "jobs", MessageID: "m2", Peer: "gone"})
+
+	if err := n1.Redrive(context.Background(), "dl1"); err != nil {
+		t.Fatalf("redrive: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if received.Load() != 1 || local.Load() != 0 {
+		t.Fatalf("deliveries: peer %d, local %d; want 1 and 0", received.Load(), local.Load())
+	}
+
+	if err := n1.Redrive(context.Background(), "dl2"); !errors.Is(err, ErrRedriveTarget) {
+		t.Fatalf("redrive to departed peer: got %v, want ErrRedriveTarget", err)
+	}
+	if count, _ := dlq.Count("jobs"); count != 1 {
+		t.Fatalf("DLQ count = %d, want only the undeliverable entry", count)
+	}
+}
+
+func TestNode_RedriveToPeerCompacted(t *testing.T) {
+	n1 := newTestNode(t, "localhost:19029")
+	opts := DefaultOptions()
+	opts.GRPCAddress = "localhost:19030"
+	opts.EnableMDNS = false
+	opts.CompactedTopics = []string{"config"}
+	n2 := NewNode(opts)
+	if err := n2.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { n2.Stop() })
+	if err := n1.joinPeer("localhost:19030"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	now := time.Now().UnixNano()
+	n2.Publish(&Message{Destination: "config", Key: "b", Payload: []byte("b1"), Timestamp: now})
+	n2.Publish(&Message{Destination: "config", Key: "c", Payload: []byte("c2"), Timestamp: now})
+
+	dlq := n1.GetDLQStore()
+	dlq.Add(&storage.DeadLetter{ID: "dl1", OriginalTopic: "config", MessageID: "m1", Peer: n2.NodeID(),
+		Key: "a", Payload: []byte("a1"), Timestamp: now, DeadAt: now + int64(time.Hour)})
+	dlq.Add(&storage.DeadLetter{ID: "dl2", OriginalTopic: "config", MessageID: "m2", Peer: n2.NodeID(),
+		Key: "b", Tombstone: true, Timestamp: now + 1, DeadAt: now + int64(time.Hour)})
+	// Older than the value n2 holds, however late it died.
+	dlq.Add(&storage.DeadLetter{ID: "dl3", OriginalTopic: "config", MessageID: "m3", Peer: n2.NodeID(),
+		Key: "c", Payload: []byte("c1"), Timestamp: now - 1, DeadAt: now + int64(time.Hour)})
+
+	for _, id := range []string{"dl1", "dl2", "dl3"} {
+		if err := n1.Redrive(context.Background(), id); err != nil {
+			t.Fatalf("redrive %s: %v", id, err)
+		}
+	}
+
+	got := map[string]string{}
+	for _, m := range n2.History("config", 0) {
+		got[m.Key] = string(m.Payload)
+	}
+	if len(got) != 2 || got["a"] != "a1" || got["c"] != "c2" {
+		t.Fatalf("compacted values %v, want a=a1 c=c2", got)
+	}
+}
+
+func TestNode_DrainHandsOffStalledSubscriber(t *testing.T) {
+	n1 := newTestNode(t, "localhost:19027")
+	n2 := newTestNode(t, "localhost:19028")
+
+	var remote atomic.Int32
+	n2.Subscribe("jobs", func(*Message) error {
+		remote.Add(1)
+		return nil
+	})
+	release := make(chan struct{})
+	var local atomic.Int32
+	n1.Subscribe("jobs", func(*Message) error {
+		local.Add(1)
+		<-release
+		return nil
+	})
+	if err := n1.joinPeer("localhost:19028"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		n1.Publish(&Message{Destination: "jobs", Payload: []byte("x")})
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- n1.Drain(ctx)
+	}()
+
+	// The two messages queued behind the stuck handler move to n2, which
+	// already had its own copies.
+	deadline := time.Now().Add(3 * time.Second)
+	for remote.Load() < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("n2 received %d messages, want 3 published + 2 handed off", remote.Load())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if local.Load() != 1 {
+		t.Fatalf("stalled subscriber handled %d messages, want 1", local.Load())
+	}
+}
@@ -1,9 +1,11 @@
 package pubsub
 
 import (
+	"net"
 	"time"
 
 	"github.com/google/uuid"
+	"google.golang.org/grpc"
 )
 
 // topicSync is the internal topic used for broadcasting topic list changes
@@ -14,6 +16,22 @@ const topicSync = "_sync.topics"
 // changes between peers, enabling redirect-based server redundancy.
 const topicServiceSync = "_sync.services"
 
+// topicMQTTRetained is the internal compacted topic holding MQTT retained
+// messages, keyed by MQTT topic. It is compacted on every node so brokers
+// anywhere in the cluster can serve retained messages.
+const topicMQTTRetained = "_mqtt.retained"
+
+// topicSchemas is the internal compacted topic holding the schema registry:
+// one record per subject, keyed "subject/<name>", and one per topic bound to
+// a subject, keyed "topic/<topic>". Like topicMQTTRetained it is compacted
+// on every node, and new peers are sent its snapshot when they connect.
+const topicSchemas = "_schemas"
+
+// replyTopicPrefix prefixes the per-request reply topics used by Request.
+// Reply topics are internal and never synced, so replies are forwarded to
+// all peers and dropped by nodes without a waiting requester.
+const replyTopicPrefix = "_reply."
+
 // Options configures a Node
 type Options struct {
 	NodeID         string        // unique node identifier (default: UUID)
@@ -35,6 +53,17 @@ type Options struct {
 	TLSCert             string        // TLS certificate file path (empty = no TLS)
 	TLSKey              string        // TLS private key file path
 	TLSCACert           string        // TLS CA certificate file path (for mutual TLS)
+	CompactedTopics     []string      // topics that retain only the latest message per key
+	TombstoneTTL        time.Duration // how long deleted keys of compacted topics reject older puts (default: 24h)
+
+	// Clock is the time source for timestamps, tickers and retry backoff
+	// (default: wall clock).
+	Clock Clock
+	// Listener, if set, is served instead of listening on GRPCAddress.
+	// GRPCAddress must still be the address peers dial to reach it.
+	Listener net.Listener
+	// DialOptions are appended to the options used to dial peers.
+	DialOptions []grpc.DialOption
 }
 
 // DefaultOptions returns Options populated with sensible defaults.
@@ -56,5 +85,6 @@ func DefaultOptions() Options {
 		HealthCheckInterval: 10 * time.Second,
 		MaxHealthFailures:   3,
 		RejoinInterval:      30 * time.Second,
+		TombstoneTTL:        24 * time.Hour,
 	}
 }
@@ -32,6 +32,8 @@ type ForwardRequest struct {
 	ReplyTo       string                 `protobuf:"bytes,7,opt,name=reply_to,json=replyTo,proto3" json:"reply_to,omitempty"`
 	StreamId      string                 `protobuf:"bytes,8,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
 	Attempt       int32                  `protobuf:"varint,9,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Key           string                 `protobuf:"bytes,10,opt,name=key,proto3" json:"key,omitempty"`              // compaction key (compacted topics only)
+	Tombstone     bool                   `protobuf:"varint,11,opt,name=tombstone,proto3" json:"tombstone,omitempty"` // deletes key from a compacted topic
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -129,6 +131,20 @@ func (x *ForwardRequest) GetAttempt() int32 {
 	return 0
 }
 
+func (x *ForwardRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetTombstone() bool {
+	if x != nil {
+		return x.Tombstone
+	}
+	return false
+}
+
 type ForwardResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Accepted      bool                   `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
@@ -178,6 +194,7 @@ type JoinRequest struct {
 	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
 	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
 	Topics        []string               `protobuf:"bytes,3,rep,name=topics,proto3" json:"topics,omitempty"`
+	HttpAddress   string                 `protobuf:"bytes,4,opt,name=http_address,json=httpAddress,proto3" json:"http_address,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -233,12 +250,20 @@ func (x *JoinRequest) GetTopics() []string {
 	return nil
 }
 
+func (x *JoinRequest) GetHttpAddress() string {
+	if x != nil {
+		return x.HttpAddress
+	}
+	return ""
+}
+
 type JoinResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
 	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
 	Topics        []string               `protobuf:"bytes,3,rep,name=topics,proto3" json:"topics,omitempty"`
 	Peers         []*PeerInfo            `protobuf:"bytes,4,rep,name=peers,proto3" json:"peers,omitempty"`
+	HttpAddress   string                 `protobuf:"bytes,5,opt,name=http_address,json=httpAddress,proto3" json:"http_address,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -301,11 +326,19 @@ func (x *JoinResponse) GetPeers() []*PeerInfo {
 	return nil
 }
 
+func (x *JoinResponse) GetHttpAddress() string {
+	if x != nil {
+		return x.HttpAddress
+	}
+	return ""
+}
+
 type PeerInfo struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
 	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
 	Topics        []string               `protobuf:"bytes,3,rep,name=topics,proto3" json:"topics,omitempty"`
+	HttpAddress   string                 `protobuf:"bytes,4,opt,name=http_address,json=httpAddress,proto3" json:"http_address,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -361,6 +394,13 @@ func (x *PeerInfo) GetTopics() []string {
 	return nil
 }
 
+func (x *PeerInfo) GetHttpAddress() string {
+	if x != nil {
+		return x.HttpAddress
+	}
+	return ""
+}
+
 type ExchangeRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
@@ -550,6 +590,8 @@ type PublishRequest struct {
 	Topic         string                 `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
 	Payload       []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
 	ReplyTo       string                 `protobuf:"bytes,3,opt,name=reply_to,json=replyTo,proto3" json:"reply_to,omitempty"`
+	Key           string                 `protobuf:"bytes,4,opt,name=key,proto3" json:"key,omitempty"`
+	Tombstone     bool                   `protobuf:"varint,5,opt,name=tombstone,proto3" json:"tombstone,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -605,6 +647,20 @@ func (x *PublishRequest) GetReplyTo() string {
 	return ""
 }
 
+func (x *PublishRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetTombstone() bool {
+	if x != nil {
+		return x.Tombstone
+	}
+	return false
+}
+
 type PublishResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
@@ -702,6 +758,8 @@ type SubscribeMessage struct {
 	Timestamp     int64                  `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	ReplyTo       string                 `protobuf:"bytes,6,opt,name=reply_to,json=replyTo,proto3" json:"reply_to,omitempty"`
 	StreamId      string                 `protobuf:"bytes,7,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Key           string                 `protobuf:"bytes,8,opt,name=key,proto3" json:"key,omitempty"`
+	Tombstone     bool                   `protobuf:"varint,9,opt,name=tombstone,proto3" json:"tombstone,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -785,6 +843,20 @@ func (x *SubscribeMessage) GetStreamId() string {
 	return ""
 }
 
+func (x *SubscribeMessage) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *SubscribeMessage) GetTombstone() bool {
+	if x != nil {
+		return x.Tombstone
+	}
+	return false
+}
+
 type RegisterRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ServiceName   string                 `protobuf:"bytes,1,opt,name=service_name,json=serviceName,proto3" json:"service_name,omitempty"`
@@ -993,11 +1065,109 @@ func (x *UnregisterResponse) GetSuccess() bool {
 	return false
 }
 
+// HandoffRequest asks a node to deliver a message to a peer on behalf of a
+// node that is shutting down.
+type HandoffRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       *ForwardRequest        `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	TargetNodeId  string                 `protobuf:"bytes,2,opt,name=target_node_id,json=targetNodeId,proto3" json:"target_node_id,omitempty"` // peer the message was queued for
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandoffRequest) Reset() {
+	*x = HandoffRequest{}
+	mi := &file_pubsub_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandoffRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandoffRequest) ProtoMessage() {}
+
+func (x *HandoffRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandoffRequest.ProtoReflect.Descriptor instead.
+func (*HandoffRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *HandoffRequest) GetMessage() *ForwardRequest {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *HandoffRequest) GetTargetNodeId() string {
+	if x != nil {
+		return x.TargetNodeId
+	}
+	return ""
+}
+
+type HandoffResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      bool                   `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandoffResponse) Reset() {
+	*x = HandoffResponse{}
+	mi := &file_pubsub_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandoffResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandoffResponse) ProtoMessage() {}
+
+func (x *HandoffResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandoffResponse.ProtoReflect.Descriptor instead.
+func (*HandoffResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *HandoffResponse) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
 var File_pubsub_proto protoreflect.FileDescriptor
 
 const file_pubsub_proto_rawDesc = "" +
 	"\n" +
-	"\fpubsub.proto\x12\x02pb\"\x80\x02\n" +
+	"\fpubsub.proto\x12\x02pb\"\xb0\x02\n" +
 	"\x0eForwardRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
 	"\x06source\x18\x02 \x01(\tR\x06source\x12 \n" +
@@ -1007,22 +1177,28 @@ const file_pubsub_proto_rawDesc = "" +
 	"\bsequence\x18\x06 \x01(\x03R\bsequence\x12\x19\n" +
 	"\breply_to\x18\a \x01(\tR\areplyTo\x12\x1b\n" +
 	"\tstream_id\x18\b \x01(\tR\bstreamId\x12\x18\n" +
-	"\aattempt\x18\t \x01(\x05R\aattempt\"-\n" +
+	"\aattempt\x18\t \x01(\x05R\aattempt\x12\x10\n" +
+	"\x03key\x18\n" +
+	" \x01(\tR\x03key\x12\x1c\n" +
+	"\ttombstone\x18\v \x01(\bR\ttombstone\"-\n" +
 	"\x0fForwardResponse\x12\x1a\n" +
-	"\baccepted\x18\x01 \x01(\bR\baccepted\"X\n" +
+	"\baccepted\x18\x01 \x01(\bR\baccepted\"{\n" +
 	"\vJoinRequest\x12\x17\n" +
 	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x18\n" +
 	"\aaddress\x18\x02 \x01(\tR\aaddress\x12\x16\n" +
-	"\x06topics\x18\x03 \x03(\tR\x06topics\"}\n" +
+	"\x06topics\x18\x03 \x03(\tR\x06topics\x12!\n" +
+	"\fhttp_address\x18\x04 \x01(\tR\vhttpAddress\"\xa0\x01\n" +
 	"\fJoinResponse\x12\x17\n" +
 	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x18\n" +
 	"\aaddress\x18\x02 \x01(\tR\aaddress\x12\x16\n" +
 	"\x06topics\x18\x03 \x03(\tR\x06topics\x12\"\n" +
-	"\x05peers\x18\x04 \x03(\v2\f.pb.PeerInfoR\x05peers\"U\n" +
+	"\x05peers\x18\x04 \x03(\v2\f.pb.PeerInfoR\x05peers\x12!\n" +
+	"\fhttp_address\x18\x05 \x01(\tR\vhttpAddress\"x\n" +
 	"\bPeerInfo\x12\x17\n" +
 	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x18\n" +
 	"\aaddress\x18\x02 \x01(\tR\aaddress\x12\x16\n" +
-	"\x06topics\x18\x03 \x03(\tR\x06topics\"B\n" +
+	"\x06topics\x18\x03 \x03(\tR\x06topics\x12!\n" +
+	"\fhttp_address\x18\x04 \x01(\tR\vhttpAddress\"B\n" +
 	"\x0fExchangeRequest\x12\x17\n" +
 	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x16\n" +
 	"\x06topics\x18\x02 \x03(\tR\x06topics\"*\n" +
@@ -1031,15 +1207,17 @@ const file_pubsub_proto_rawDesc = "" +
 	"\x12HealthCheckRequest\"F\n" +
 	"\x13HealthCheckResponse\x12\x16\n" +
 	"\x06status\x18\x01 \x01(\tR\x06status\x12\x17\n" +
-	"\anode_id\x18\x02 \x01(\tR\x06nodeId\"[\n" +
+	"\anode_id\x18\x02 \x01(\tR\x06nodeId\"\x8b\x01\n" +
 	"\x0ePublishRequest\x12\x14\n" +
 	"\x05topic\x18\x01 \x01(\tR\x05topic\x12\x18\n" +
 	"\apayload\x18\x02 \x01(\fR\apayload\x12\x19\n" +
-	"\breply_to\x18\x03 \x01(\tR\areplyTo\"!\n" +
+	"\breply_to\x18\x03 \x01(\tR\areplyTo\x12\x10\n" +
+	"\x03key\x18\x04 \x01(\tR\x03key\x12\x1c\n" +
+	"\ttombstone\x18\x05 \x01(\bR\ttombstone\"!\n" +
 	"\x0fPublishResponse\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\"(\n" +
 	"\x10SubscribeRequest\x12\x14\n" +
-	"\x05topic\x18\x01 \x01(\tR\x05topic\"\xc0\x01\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\"\xf0\x01\n" +
 	"\x10SubscribeMessage\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
 	"\x06source\x18\x02 \x01(\tR\x06source\x12\x14\n" +
@@ -1047,7 +1225,9 @@ const file_pubsub_proto_rawDesc = "" +
 	"\apayload\x18\x04 \x01(\fR\apayload\x12\x1c\n" +
 	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\x12\x19\n" +
 	"\breply_to\x18\x06 \x01(\tR\areplyTo\x12\x1b\n" +
-	"\tstream_id\x18\a \x01(\tR\bstreamId\"U\n" +
+	"\tstream_id\x18\a \x01(\tR\bstreamId\x12\x10\n" +
+	"\x03key\x18\b \x01(\tR\x03key\x12\x1c\n" +
+	"\ttombstone\x18\t \x01(\bR\ttombstone\"U\n" +
 	"\x0fRegisterRequest\x12!\n" +
 	"\fservice_name\x18\x01 \x01(\tR\vserviceName\x12\x1f\n" +
 	"\vserver_name\x18\x02 \x01(\tR\n" +
@@ -1061,7 +1241,12 @@ const file_pubsub_proto_rawDesc = "" +
 	"\vserver_name\x18\x02 \x01(\tR\n" +
 	"serverName\".\n" +
 	"\x12UnregisterResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess2\xd4\x03\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"d\n" +
+	"\x0eHandoffRequest\x12,\n" +
+	"\amessage\x18\x01 \x01(\v2\x12.pb.ForwardRequestR\amessage\x12$\n" +
+	"\x0etarget_node_id\x18\x02 \x01(\tR\ftargetNodeId\"-\n" +
+	"\x0fHandoffResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\bR\baccepted2\x88\x04\n" +
 	"\rPubSubService\x122\n" +
 	"\aForward\x12\x12.pb.ForwardRequest\x1a\x13.pb.ForwardResponse\x12)\n" +
 	"\x04Join\x12\x0f.pb.JoinRequest\x1a\x10.pb.JoinResponse\x125\n" +
@@ -1071,7 +1256,8 @@ const file_pubsub_proto_rawDesc = "" +
 	"\x0eSubscribeTopic\x12\x14.pb.SubscribeRequest\x1a\x14.pb.SubscribeMessage0\x01\x125\n" +
 	"\bRegister\x12\x13.pb.RegisterRequest\x1a\x14.pb.RegisterResponse\x12;\n" +
 	"\n" +
-	"Unregister\x12\x15.pb.UnregisterRequest\x1a\x16.pb.UnregisterResponseB\x1fZ\x1ddistributed-pub-sub/pubsub/pbb\x06proto3"
+	"Unregister\x12\x15.pb.UnregisterRequest\x1a\x16.pb.UnregisterResponse\x122\n" +
+	"\aHandoff\x12\x12.pb.HandoffRequest\x1a\x13.pb.HandoffResponseB\x1fZ\x1ddistributed-pub-sub/pubsub/pbb\x06proto3"
 
 var (
 	file_pubsub_proto_rawDescOnce sync.Once
@@ -1085,7 +1271,7 @@ func file_pubsub_proto_rawDescGZIP() []byte {
 	return file_pubsub_proto_rawDescData
 }
 
-var file_pubsub_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_pubsub_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
 var file_pubsub_proto_goTypes = []any{
 	(*ForwardRequest)(nil),      // 0: pb.ForwardRequest
 	(*ForwardResponse)(nil),     // 1: pb.ForwardResponse
@@ -1104,30 +1290,35 @@ var file_pubsub_proto_goTypes = []any{
 	(*RegisterResponse)(nil),    // 14: pb.RegisterResponse
 	(*UnregisterRequest)(nil),   // 15: pb.UnregisterRequest
 	(*UnregisterResponse)(nil),  // 16: pb.UnregisterResponse
+	(*HandoffRequest)(nil),      // 17: pb.HandoffRequest
+	(*HandoffResponse)(nil),     // 18: pb.HandoffResponse
 }
 var file_pubsub_proto_depIdxs = []int32{
 	4,  // 0: pb.JoinResponse.peers:type_name -> pb.PeerInfo
-	0,  // 1: pb.PubSubService.Forward:input_type -> pb.ForwardRequest
-	2,  // 2: pb.PubSubService.Join:input_type -> pb.JoinRequest
-	5,  // 3: pb.PubSubService.Exchange:input_type -> pb.ExchangeRequest
-	7,  // 4: pb.PubSubService.HealthCheck:input_type -> pb.HealthCheckRequest
-	9,  // 5: pb.PubSubService.PublishMessage:input_type -> pb.PublishRequest
-	11, // 6: pb.PubSubService.SubscribeTopic:input_type -> pb.SubscribeRequest
-	13, // 7: pb.PubSubService.Register:input_type -> pb.RegisterRequest
-	15, // 8: pb.PubSubService.Unregister:input_type -> pb.UnregisterRequest
-	1,  // 9: pb.PubSubService.Forward:output_type -> pb.ForwardResponse
-	3,  // 10: pb.PubSubService.Join:output_type -> pb.JoinResponse
-	6,  // 11: pb.PubSubService.Exchange:output_type -> pb.ExchangeResponse
-	8,  // 12: pb.PubSubService.HealthCheck:output_type -> pb.HealthCheckResponse
-	10, // 13: pb.PubSubService.PublishMessage:output_type -> pb.PublishResponse
-	12, // 14: pb.PubSubService.SubscribeTopic:output_type -> pb.SubscribeMessage
-	14, // 15: pb.PubSubService.Register:output_type -> pb.RegisterResponse
-	16, // 16: pb.PubSubService.Unregister:output_type -> pb.UnregisterResponse
-	9,  // [9:17] is the sub-list for method output_type
-	1,  // [1:9] is the sub-list for method input_type
-	1,  // [1:1] is the sub-list for extension type_name
-	1,  // [1:1] is the sub-list for extension extendee
-	0,  // [0:1] is the sub-list for field type_name
+	0,  // 1: pb.HandoffRequest.message:type_name -> pb.ForwardRequest
+	0,  // 2: pb.PubSubService.Forward:input_type -> pb.ForwardRequest
+	2,  // 3: pb.PubSubService.Join:input_type -> pb.JoinRequest
+	5,  // 4: pb.PubSubService.Exchange:input_type -> pb.ExchangeRequest
+	7,  // 5: pb.PubSubService.HealthCheck:input_type -> pb.HealthCheckRequest
+	9,  // 6: pb.PubSubService.PublishMessage:input_type -> pb.PublishRequest
+	11, // 7: pb.PubSubService.SubscribeTopic:input_type -> pb.SubscribeRequest
+	13, // 8: pb.PubSubService.Register:input_type -> pb.RegisterRequest
+	15, // 9: pb.PubSubService.Unregister:input_type -> pb.UnregisterRequest
+	17, // 10: pb.PubSubService.Handoff:input_type -> pb.HandoffRequest
+	1,  // 11: pb.PubSubService.Forward:output_type -> pb.ForwardResponse
+	3,  // 12: pb.PubSubService.Join:output_type -> pb.JoinResponse
+	6,  // 13: pb.PubSubService.Exchange:output_type -> pb.ExchangeResponse
+	8,  // 14: pb.PubSubService.HealthCheck:output_type -> pb.HealthCheckResponse
+	10, // 15: pb.PubSubService.PublishMessage:output_type -> pb.PublishResponse
+	12, // 16: pb.PubSubService.SubscribeTopic:output_type -> pb.SubscribeMessage
+	14, // 17: pb.PubSubService.Register:output_type -> pb.RegisterResponse
+	16, // 18: pb.PubSubService.Unregister:output_type -> pb.UnregisterResponse
+	18, // 19: pb.PubSubService.Handoff:output_type -> pb.HandoffResponse
+	11, // [11:20] is the sub-list for method output_type
+	2,  // [2:11] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_pubsub_proto_init() }
@@ -1141,7 +1332,7 @@ func file_pubsub_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pubsub_proto_rawDesc), len(file_pubsub_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   17,
+			NumMessages:   19,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -27,6 +27,7 @@ const (
 	PubSubService_SubscribeTopic_FullMethodName = "/pb.PubSubService/SubscribeTopic"
 	PubSubService_Register_FullMethodName       = "/pb.PubSubService/Register"
 	PubSubService_Unregister_FullMethodName     = "/pb.PubSubService/Unregister"
+	PubSubService_Handoff_FullMethodName        = "/pb.PubSubService/Handoff"
 )
 
 // PubSubServiceClient is the client API for PubSubService service.
@@ -41,6 +42,7 @@ type PubSubServiceClient interface {
 	SubscribeTopic(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SubscribeMessage], error)
 	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
 	Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*UnregisterResponse, error)
+	Handoff(ctx context.Context, in *HandoffRequest, opts ...grpc.CallOption) (*HandoffResponse, error)
 }
 
 type pubSubServiceClient struct {
@@ -140,6 +142,16 @@ func (c *pubSubServiceClient) Unregister(ctx context.Context, in *UnregisterRequ
 	return out, nil
 }
 
+func (c *pubSubServiceClient) Handoff(ctx context.Context, in *HandoffRequest, opts ...grpc.CallOption) (*HandoffResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HandoffResponse)
+	err := c.cc.Invoke(ctx, PubSubService_Handoff_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PubSubServiceServer is the server API for PubSubService service.
 // All implementations must embed UnimplementedPubSubServiceServer
 // for forward compatibility.
@@ -152,6 +164,7 @@ type PubSubServiceServer interface {
 	SubscribeTopic(*SubscribeRequest, grpc.ServerStreamingServer[SubscribeMessage]) error
 	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
 	Unregister(context.Context, *UnregisterRequest) (*UnregisterResponse, error)
+	Handoff(context.Context, *HandoffRequest) (*HandoffResponse, error)
 	mustEmbedUnimplementedPubSubServiceServer()
 }
 
@@ -186,6 +199,9 @@ func (UnimplementedPubSubServiceServer) Register(context.Context, *RegisterReque
 func (UnimplementedPubSubServiceServer) Unregister(context.Context, *UnregisterRequest) (*UnregisterResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method Unregister not implemented")
 }
+func (UnimplementedPubSubServiceServer) Handoff(context.Context, *HandoffRequest) (*HandoffResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Handoff not implemented")
+}
 func (UnimplementedPubSubServiceServer) mustEmbedUnimplementedPubSubServiceServer() {}
 func (UnimplementedPubSubServiceServer) testEmbeddedByValue()                       {}
 
@@ -344,6 +360,24 @@ func _PubSubService_Unregister_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PubSubService_Handoff_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandoffRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PubSubServiceServer).Handoff(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PubSubService_Handoff_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PubSubServiceServer).Handoff(ctx, req.(*HandoffRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PubSubService_ServiceDesc is the grpc.ServiceDesc for PubSubService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -379,6 +413,10 @@ var PubSubService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Unregister",
 			Handler:    _PubSubService_Unregister_Handler,
 		},
+		{
+			MethodName: "Handoff",
+			Handler:    _PubSubService_Handoff_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
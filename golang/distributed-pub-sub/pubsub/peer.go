@@ -15,13 +15,16 @@ import (
 
 // Peer represents a remote node in the pub-sub cluster.
 type Peer struct {
-	NodeID    string
-	Address   string
-	Topics    map[string]bool // topics this peer subscribes to
-	conn      *grpc.ClientConn
-	client    pb.PubSubServiceClient
-	tlsConfig *tls.Config
-	mu        sync.RWMutex
+	NodeID      string
+	Address     string
+	HTTPAddress string          // advertised HTTP/WS address (may be empty)
+	Topics      map[string]bool // topics this peer subscribes to
+	filters     []string        // wildcard entries of Topics
+	conn        *grpc.ClientConn
+	client      pb.PubSubServiceClient
+	tlsConfig   *tls.Config
+	dialOpts    []grpc.DialOption // extra options from Options.DialOptions
+	mu          sync.RWMutex
 }
 
 // NewPeer creates a new Peer with the given node ID and address.
@@ -48,7 +51,8 @@ func (p *Peer) Connect(ctx context.Context) error {
 	} else {
 		creds = grpc.WithTransportCredentials(insecure.NewCredentials())
 	}
-	conn, err := grpc.DialContext(ctx, p.Address, creds, grpc.WithBlock())
+	opts := append([]grpc.DialOption{creds, grpc.WithBlock()}, p.dialOpts...)
+	conn, err := grpc.DialContext(ctx, p.Address, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to connect to peer %s at %s: %w", p.NodeID, p.Address, err)
 	}
@@ -83,7 +87,44 @@ func (p *Peer) Forward(ctx context.Context, msg *Message) error {
 		return fmt.Errorf("peer %s is not connected", p.NodeID)
 	}
 
-	req := &pb.ForwardRequest{
+	req := toForwardRequest(msg)
+
+	resp, err := client.Forward(ctx, req)
+	if err != nil {
+		return fmt.Errorf("forward to peer %s failed: %w", p.NodeID, err)
+	}
+	if !resp.Accepted {
+		return fmt.Errorf("forward to peer %s was rejected", p.NodeID)
+	}
+	return nil
+}
+
+// Handoff asks the peer to forward msg to the peer target on this node's
+// behalf.
+func (p *Peer) Handoff(ctx context.Context, msg *Message, target string) error {
+	p.mu.RLock()
+	client := p.client
+	p.mu.RUnlock()
+
+	if client == nil {
+		return fmt.Errorf("peer %s is not connected", p.NodeID)
+	}
+
+	resp, err := client.Handoff(ctx, &pb.HandoffRequest{
+		Message:      toForwardRequest(msg),
+		TargetNodeId: target,
+	})
+	if err != nil {
+		return fmt.Errorf("handoff to peer %s failed: %w", p.NodeID, err)
+	}
+	if !resp.Accepted {
+		return fmt.Errorf("handoff to peer %s was rejected", p.NodeID)
+	}
+	return nil
+}
+
+func toForwardRequest(msg *Message) *pb.ForwardRequest {
+	return &pb.ForwardRequest{
 		Id:          msg.ID,
 		Source:      msg.Source,
 		Destination: msg.Destination,
@@ -93,16 +134,25 @@ func (p *Peer) Forward(ctx context.Context, msg *Message) error {
 		ReplyTo:     msg.ReplyTo,
 		StreamId:    msg.StreamID,
 		Attempt:     msg.Attempt,
+		Key:         msg.Key,
+		Tombstone:   msg.Tombstone,
 	}
+}
 
-	resp, err := client.Forward(ctx, req)
-	if err != nil {
-		return fmt.Errorf("forward to peer %s failed: %w", p.NodeID, err)
+func fromForwardRequest(req *pb.ForwardRequest) *Message {
+	return &Message{
+		ID:          req.GetId(),
+		Source:      req.GetSource(),
+		Destination: req.GetDestination(),
+		Payload:     req.GetPayload(),
+		Timestamp:   req.GetTimestamp(),
+		Sequence:    req.GetSequence(),
+		ReplyTo:     req.GetReplyTo(),
+		StreamID:    req.GetStreamId(),
+		Attempt:     req.GetAttempt(),
+		Key:         req.GetKey(),
+		Tombstone:   req.GetTombstone(),
 	}
-	if !resp.Accepted {
-		return fmt.Errorf("forward to peer %s was rejected", p.NodeID)
-	}
-	return nil
 }
 
 // Exchange sends our topic list to the peer and receives theirs.
@@ -132,8 +182,12 @@ func (p *Peer) UpdateTopics(topics []string) {
 	defer p.mu.Unlock()
 
 	p.Topics = make(map[string]bool, len(topics))
+	p.filters = p.filters[:0]
 	for _, t := range topics {
 		p.Topics[t] = true
+		if isWildcard(t) {
+			p.filters = append(p.filters, t)
+		}
 	}
 }
 
@@ -163,10 +217,19 @@ func (p *Peer) HealthCheck(ctx context.Context) error {
 	return err
 }
 
-// HasTopic returns true if this peer subscribes to the given topic.
+// HasTopic returns true if this peer subscribes to the given topic, directly
+// or through a wildcard filter.
 func (p *Peer) HasTopic(topic string) bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	return p.Topics[topic]
+	if p.Topics[topic] {
+		return true
+	}
+	for _, f := range p.filters {
+		if topicMatches(f, topic) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,348 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"distributed-pub-sub/pubsub/schema"
+)
+
+// ErrInvalidPayload is returned by Publish when a message's payload does not
+// conform to the schema bound to its topic.
+var ErrInvalidPayload = errors.New("payload does not match topic schema")
+
+// ErrIncompatibleSchema is returned by RegisterSchema when a new version
+// breaks the subject's compatibility mode.
+var ErrIncompatibleSchema = errors.New("incompatible schema")
+
+// ErrSubjectNotFound is returned for operations on an unregistered subject.
+var ErrSubjectNotFound = errors.New("schema subject not found")
+
+const (
+	subjectKeyPrefix = "subject/"
+	topicKeyPrefix   = "topic/"
+)
+
+// Subject is a named, versioned schema. Topics bound to a subject accept
+// only payloads that conform to its latest version, and its compatibility
+// mode decides which new versions may be registered.
+type Subject struct {
+	Name          string               `json:"name"`
+	Compatibility schema.Compatibility `json:"compatibility"`
+	Versions      []schema.Schema      `json:"versions"` // version v is at index v-1
+}
+
+// Latest returns the newest version of the subject and its number.
+func (s *Subject) Latest() (schema.Schema, int) {
+	return s.Versions[len(s.Versions)-1], len(s.Versions)
+}
+
+// schemaBinding is the record stored for a topic bound to a subject.
+type schemaBinding struct {
+	Subject string `json:"subject"`
+}
+
+// schemaRegistry is a node's view of the cluster-wide registry, rebuilt
+// from topicSchemas records as they are applied to the compaction store.
+type schemaRegistry struct {
+	mu         sync.RWMutex
+	subjects   map[string]*Subject
+	validators map[string]schema.Validator // latest version, by subject
+	bindings   map[string]string           // topic -> subject
+
+	// writeMu serializes read-modify-write of records on this node.
+	// Concurrent writers on different nodes race; the later write wins.
+	writeMu sync.Mutex
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		subjects:   make(map[string]*Subject),
+		validators: make(map[string]schema.Validator),
+		bindings:   make(map[string]string),
+	}
+}
+
+// apply updates the registry from a topicSchemas record.
+func (r *schemaRegistry) apply(msg *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(msg.Key, subjectKeyPrefix):
+		name := strings.TrimPrefix(msg.Key, subjectKeyPrefix)
+		if msg.Tombstone {
+			delete(r.subjects, name)
+			delete(r.validators, name)
+			return nil
+		}
+		var subj Subject
+		if err := json.Unmarshal(msg.Payload, &subj); err != nil {
+			return fmt.Errorf("decode subject %q: %w", name, err)
+		}
+		if len(subj.Versions) == 0 {
+			return fmt.Errorf("subject %q has no versions", name)
+		}
+		latest, _ := subj.Latest()
+		v, err := schema.Compile(latest)
+		if err != nil {
+			return fmt.Errorf("compile subject %q: %w", name, err)
+		}
+		r.subjects[name] = &subj
+		r.validators[name] = v
+
+	case strings.HasPrefix(msg.Key, topicKeyPrefix):
+		topic := strings.TrimPrefix(msg.Key, topicKeyPrefix)
+		if msg.Tombstone {
+			delete(r.bindings, topic)
+			return nil
+		}
+		var b schemaBinding
+		if err := json.Unmarshal(msg.Payload, &b); err != nil {
+			return fmt.Errorf("decode binding for topic %q: %w", topic, err)
+		}
+		r.bindings[topic] = b.Subject
+
+	default:
+		return fmt.Errorf("unknown schema record key %q", msg.Key)
+	}
+	return nil
+}
+
+// subject returns a copy of the named subject.
+func (r *schemaRegistry) subject(name string) (Subject, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.subjects[name]
+	if !ok {
+		return Subject{}, false
+	}
+	cp := *s
+	cp.Versions = append([]schema.Schema(nil), s.Versions...)
+	return cp, true
+}
+
+// validator returns the validator for topic's bound subject, or nil if the
+// topic is unbound or its subject is unknown on this node.
+func (r *schemaRegistry) validator(topic string) (subject string, version int, v schema.Validator) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	subject, ok := r.bindings[topic]
+	if !ok {
+		return "", 0, nil
+	}
+	s, ok := r.subjects[subject]
+	if !ok {
+		return subject, 0, nil
+	}
+	return subject, len(s.Versions), r.validators[subject]
+}
+
+// loadSchemas rebuilds the registry from the compaction store, so a node
+// with durable storage keeps its schemas across restarts.
+func (n *Node) loadSchemas() {
+	msgs, err := n.snapshot(topicSchemas)
+	if err != nil {
+		log.Printf("[node %s] load schema registry: %v", n.opts.NodeID, err)
+		return
+	}
+	for _, msg := range msgs {
+		if err := n.schemas.apply(msg); err != nil {
+			log.Printf("[node %s] schema record %s: %v", n.opts.NodeID, msg.Key, err)
+		}
+	}
+}
+
+// validatePayload checks msg against the schema bound to its topic.
+// Internal topics and tombstones are never validated.
+func (n *Node) validatePayload(msg *Message) error {
+	if msg.Tombstone || strings.HasPrefix(msg.Destination, "_") {
+		return nil
+	}
+	subject, version, v := n.schemas.validator(msg.Destination)
+	if v == nil {
+		return nil
+	}
+	if err := v.Validate(msg.Payload); err != nil {
+		return fmt.Errorf("%w: topic %q requires subject %q version %d: %v",
+			ErrInvalidPayload, msg.Destination, subject, version, err)
+	}
+	return nil
+}
+
+// publishSchemaRecord publishes a registry record on topicSchemas; a nil
+// value publishes a tombstone. The record is applied to this node's registry
+// before Publish returns.
+func (n *Node) publishSchemaRecord(key string, value any) error {
+	msg := &Message{Destination: topicSchemas, Key: key}
+	if value == nil {
+		msg.Tombstone = true
+	} else {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("encode schema record %s: %w", key, err)
+		}
+		msg.Payload = data
+	}
+	return n.Publish(msg)
+}
+
+// RegisterSchema adds s as the next version of subject, creating the subject
+// with backward compatibility if it does not exist, and returns the version
+// number. Registering the subject's current latest version again is a no-op
+// that returns its number. A version that breaks the subject's compatibility
+// mode is rejected with ErrIncompatibleSchema.
+func (n *Node) RegisterSchema(subject string, s schema.Schema) (int, error) {
+	if subject == "" {
+		return 0, fmt.Errorf("subject name required")
+	}
+	if _, err := schema.Compile(s); err != nil {
+		return 0, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	n.schemas.writeMu.Lock()
+	defer n.schemas.writeMu.Unlock()
+
+	subj, ok := n.schemas.subject(subject)
+	if !ok {
+		subj = Subject{Name: subject, Compatibility: schema.Backward}
+	} else {
+		latest, version := subj.Latest()
+		if latest.Equal(s) {
+			return version, nil
+		}
+		if err := schema.CheckCompatibility(subj.Compatibility, latest, s); err != nil {
+			return 0, fmt.Errorf("%w: subject %q version %d (%s): %v",
+				ErrIncompatibleSchema, subject, version+1, subj.Compatibility, err)
+		}
+	}
+	subj.Versions = append(subj.Versions, s)
+
+	if err := n.publishSchemaRecord(subjectKeyPrefix+subject, subj); err != nil {
+		return 0, err
+	}
+	log.Printf("[node %s] registered schema %s version %d", n.opts.NodeID, subject, len(subj.Versions))
+	return len(subj.Versions), nil
+}
+
+// SetSchemaCompatibility changes the compatibility mode checked when new
+// versions of subject are registered. Existing versions are not rechecked.
+func (n *Node) SetSchemaCompatibility(subject string, mode schema.Compatibility) error {
+	if _, err := schema.ParseCompatibility(string(mode)); err != nil {
+		return err
+	}
+
+	n.schemas.writeMu.Lock()
+	defer n.schemas.writeMu.Unlock()
+
+	subj, ok := n.schemas.subject(subject)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrSubjectNotFound, subject)
+	}
+	if subj.Compatibility == mode {
+		return nil
+	}
+	subj.Compatibility = mode
+	return n.publishSchemaRecord(subjectKeyPrefix+subject, subj)
+}
+
+// DeleteSubject removes subject and all its versions. Subjects still bound
+// to a topic cannot be deleted.
+func (n *Node) DeleteSubject(subject string) error {
+	n.schemas.writeMu.Lock()
+	defer n.schemas.writeMu.Unlock()
+
+	if _, ok := n.schemas.subject(subject); !ok {
+		return fmt.Errorf("%w: %q", ErrSubjectNotFound, subject)
+	}
+	for topic, s := range n.SchemaBindings() {
+		if s == subject {
+			return fmt.Errorf("subject %q is bound to topic %q", subject, topic)
+		}
+	}
+	return n.publishSchemaRecord(subjectKeyPrefix+subject, nil)
+}
+
+// BindSchema binds topic to subject: from then on Publish rejects payloads
+// on topic that do not conform to the subject's latest version.
+func (n *Node) BindSchema(topic, subject string) error {
+	if topic == "" || topic[0] == '_' || isWildcard(topic) {
+		return fmt.Errorf("cannot bind a schema to topic %q", topic)
+	}
+	if _, ok := n.schemas.subject(subject); !ok {
+		return fmt.Errorf("%w: %q", ErrSubjectNotFound, subject)
+	}
+	return n.publishSchemaRecord(topicKeyPrefix+topic, schemaBinding{Subject: subject})
+}
+
+// UnbindSchema removes topic's schema binding, if any.
+func (n *Node) UnbindSchema(topic string) error {
+	n.schemas.mu.RLock()
+	_, ok := n.schemas.bindings[topic]
+	n.schemas.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return n.publishSchemaRecord(topicKeyPrefix+topic, nil)
+}
+
+// Subjects returns every registered subject, sorted by name.
+func (n *Node) Subjects() []Subject {
+	n.schemas.mu.RLock()
+	names := make([]string, 0, len(n.schemas.subjects))
+	for name := range n.schemas.subjects {
+		names = append(names, name)
+	}
+	n.schemas.mu.RUnlock()
+	sort.Strings(names)
+
+	subjects := make([]Subject, 0, len(names))
+	for _, name := range names {
+		if s, ok := n.schemas.subject(name); ok {
+			subjects = append(subjects, s)
+		}
+	}
+	return subjects
+}
+
+// GetSubject returns the named subject.
+func (n *Node) GetSubject(name string) (Subject, bool) {
+	return n.schemas.subject(name)
+}
+
+// SchemaBindings returns the subject bound to each topic.
+func (n *Node) SchemaBindings() map[string]string {
+	n.schemas.mu.RLock()
+	defer n.schemas.mu.RUnlock()
+	out := make(map[string]string, len(n.schemas.bindings))
+	for topic, subject := range n.schemas.bindings {
+		out[topic] = subject
+	}
+	return out
+}
+
+// syncSchemas queues the registry's records for a newly connected peer, so
+// a node that joins after schemas were registered learns them. Records the
+// peer already has are dropped by its dedup check.
+func (n *Node) syncSchemas(peerID string) {
+	msgs, err := n.snapshot(topicSchemas)
+	if err != nil {
+		log.Printf("[node %s] schema snapshot for peer %s: %v", n.opts.NodeID, peerID, err)
+		return
+	}
+	for _, msg := range msgs {
+		n.outboundPending.Add(1)
+		select {
+		case n.outbound <- &outboundEntry{msg: msg, peerID: peerID}:
+		default:
+			n.outboundPending.Add(-1)
+			log.Printf("[node %s] outbound queue full, dropping schema record %s for peer %s",
+				n.opts.NodeID, msg.Key, peerID)
+		}
+	}
+}
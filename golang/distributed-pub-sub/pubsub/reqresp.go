@@ -13,7 +13,7 @@ import (
 // It publishes a message to the given topic with a unique reply topic set,
 // then waits for a single response (or until the timeout/context expires).
 func (n *Node) Request(ctx context.Context, topic string, payload []byte, timeout time.Duration) (*Message, error) {
-	replyTopic := fmt.Sprintf("_reply.%s", uuid.New().String())
+	replyTopic := replyTopicPrefix + uuid.New().String()
 
 	respCh := make(chan *Message, 1)
 	errCh := make(chan error, 1)
@@ -35,7 +35,7 @@ func (n *Node) Request(ctx context.Context, topic string, payload []byte, timeou
 		Source:      n.opts.NodeID,
 		Destination: topic,
 		Payload:     payload,
-		Timestamp:   time.Now().UnixNano(),
+		Timestamp:   n.opts.clock().Now().UnixNano(),
 		ReplyTo:     replyTopic,
 	}
 
@@ -68,7 +68,7 @@ func (n *Node) Reply(original *Message, payload []byte) error {
 		Source:      n.opts.NodeID,
 		Destination: original.ReplyTo,
 		Payload:     payload,
-		Timestamp:   time.Now().UnixNano(),
+		Timestamp:   n.opts.clock().Now().UnixNano(),
 	}
 
 	return n.Publish(resp)
@@ -0,0 +1,670 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema is a compiled JSON Schema. It supports the validation keywords
+// of draft 2020-12 that need no external resolution: type, enum, const,
+// numeric and length bounds, pattern, multipleOf, properties, required,
+// additionalProperties, min/maxProperties, items (single schema),
+// min/maxItems, uniqueItems, allOf, anyOf, oneOf and not. Annotations such
+// as title, description and format are ignored; keywords that need
+// references or conditional evaluation are rejected at compile time.
+type jsonSchema struct {
+	raw   string // canonical JSON, for comparing subschemas
+	never bool   // the schema `false`
+
+	types []string
+	enum  []string // canonical JSON of each allowed value; const is a one-value enum
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	properties                   map[string]*jsonSchema
+	required                     []string
+	additional                   *jsonSchema // nil: any additional property is allowed
+	minProperties, maxProperties *int
+
+	items              *jsonSchema
+	minItems, maxItems *int
+	uniqueItems        bool
+
+	allOf, anyOf, oneOf []*jsonSchema
+	not                 *jsonSchema
+}
+
+// unsupportedKeywords change what validates but are not implemented.
+var unsupportedKeywords = []string{
+	"$ref", "$dynamicRef", "$recursiveRef", "patternProperties", "propertyNames",
+	"dependencies", "dependentRequired", "dependentSchemas", "if", "then", "else",
+	"contains", "prefixItems", "unevaluatedProperties", "unevaluatedItems",
+}
+
+var jsonTypes = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+func compileJSON(def []byte) (*jsonSchema, error) {
+	var doc any
+	if err := json.Unmarshal(def, &doc); err != nil {
+		return nil, fmt.Errorf("parse JSON schema: %w", err)
+	}
+	return parseJSONSchema(doc, "#")
+}
+
+func parseJSONSchema(doc any, loc string) (*jsonSchema, error) {
+	s := &jsonSchema{raw: canonical(doc)}
+	switch v := doc.(type) {
+	case bool:
+		s.never = !v
+		return s, nil
+	case map[string]any:
+		return s, s.parse(v, loc)
+	}
+	return nil, fmt.Errorf("%s: schema must be an object or boolean", loc)
+}
+
+func (s *jsonSchema) parse(m map[string]any, loc string) error {
+	for _, kw := range unsupportedKeywords {
+		if _, ok := m[kw]; ok {
+			return fmt.Errorf("%s: keyword %q is not supported", loc, kw)
+		}
+	}
+
+	if v, ok := m["type"]; ok {
+		switch t := v.(type) {
+		case string:
+			s.types = []string{t}
+		case []any:
+			for _, e := range t {
+				name, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("%s/type: must be a string or array of strings", loc)
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return fmt.Errorf("%s/type: must be a string or array of strings", loc)
+		}
+		for _, t := range s.types {
+			if !slices.Contains(jsonTypes, t) {
+				return fmt.Errorf("%s/type: unknown type %q", loc, t)
+			}
+		}
+	}
+	if v, ok := m["enum"]; ok {
+		vals, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s/enum: must be an array", loc)
+		}
+		for _, e := range vals {
+			s.enum = append(s.enum, canonical(e))
+		}
+	}
+	if v, ok := m["const"]; ok {
+		s.enum = []string{canonical(v)}
+	}
+
+	var err error
+	num := func(kw string, dst **float64) {
+		if v, ok := m[kw]; ok && err == nil {
+			f, isNum := v.(float64)
+			if !isNum {
+				err = fmt.Errorf("%s/%s: must be a number", loc, kw)
+				return
+			}
+			*dst = &f
+		}
+	}
+	count := func(kw string, dst **int) {
+		if v, ok := m[kw]; ok && err == nil {
+			f, isNum := v.(float64)
+			if !isNum || f < 0 || f != math.Trunc(f) {
+				err = fmt.Errorf("%s/%s: must be a non-negative integer", loc, kw)
+				return
+			}
+			n := int(f)
+			*dst = &n
+		}
+	}
+	num("minimum", &s.minimum)
+	num("maximum", &s.maximum)
+	num("exclusiveMinimum", &s.exclusiveMinimum)
+	num("exclusiveMaximum", &s.exclusiveMaximum)
+	num("multipleOf", &s.multipleOf)
+	count("minLength", &s.minLength)
+	count("maxLength", &s.maxLength)
+	count("minProperties", &s.minProperties)
+	count("maxProperties", &s.maxProperties)
+	count("minItems", &s.minItems)
+	count("maxItems", &s.maxItems)
+	if err != nil {
+		return err
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return fmt.Errorf("%s/multipleOf: must be greater than 0", loc)
+	}
+
+	if v, ok := m["pattern"]; ok {
+		p, isStr := v.(string)
+		if !isStr {
+			return fmt.Errorf("%s/pattern: must be a string", loc)
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return fmt.Errorf("%s/pattern: %w", loc, err)
+		}
+	}
+	if v, ok := m["uniqueItems"]; ok {
+		b, isBool := v.(bool)
+		if !isBool {
+			return fmt.Errorf("%s/uniqueItems: must be a boolean", loc)
+		}
+		s.uniqueItems = b
+	}
+
+	if v, ok := m["properties"]; ok {
+		props, isObj := v.(map[string]any)
+		if !isObj {
+			return fmt.Errorf("%s/properties: must be an object", loc)
+		}
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = parseJSONSchema(sub, loc+"/properties/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		names, isArr := v.([]any)
+		if !isArr {
+			return fmt.Errorf("%s/required: must be an array of strings", loc)
+		}
+		for _, e := range names {
+			name, isStr := e.(string)
+			if !isStr {
+				return fmt.Errorf("%s/required: must be an array of strings", loc)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		if s.additional, err = parseJSONSchema(v, loc+"/additionalProperties"); err != nil {
+			return err
+		}
+	}
+	if v, ok := m["items"]; ok {
+		if _, isArr := v.([]any); isArr {
+			return fmt.Errorf("%s/items: tuple form is not supported", loc)
+		}
+		if s.items, err = parseJSONSchema(v, loc+"/items"); err != nil {
+			return err
+		}
+	}
+
+	list := func(kw string) ([]*jsonSchema, error) {
+		v, ok := m[kw]
+		if !ok {
+			return nil, nil
+		}
+		subs, isArr := v.([]any)
+		if !isArr || len(subs) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", loc, kw)
+		}
+		out := make([]*jsonSchema, len(subs))
+		for i, sub := range subs {
+			if out[i], err = parseJSONSchema(sub, fmt.Sprintf("%s/%s/%d", loc, kw, i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	if s.allOf, err = list("allOf"); err != nil {
+		return err
+	}
+	if s.anyOf, err = list("anyOf"); err != nil {
+		return err
+	}
+	if s.oneOf, err = list("oneOf"); err != nil {
+		return err
+	}
+	if v, ok := m["not"]; ok {
+		if s.not, err = parseJSONSchema(v, loc+"/not"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonical returns the JSON encoding of a decoded value. Object keys are
+// sorted by encoding/json, so equal values encode identically.
+func canonical(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (s *jsonSchema) Validate(payload []byte) error {
+	var v any
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	return s.validate(v, "$")
+}
+
+func typeOf(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) && !math.IsInf(x, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func (s *jsonSchema) validate(v any, path string) error {
+	if s.never {
+		return fmt.Errorf("%s: no value is allowed here", path)
+	}
+
+	t := typeOf(v)
+	if len(s.types) > 0 && !s.allowsType(t) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), t)
+	}
+	if s.enum != nil && !slices.Contains(s.enum, canonical(v)) {
+		return fmt.Errorf("%s: value %s is not one of %s", path, canonical(v), strings.Join(s.enum, ", "))
+	}
+
+	switch x := v.(type) {
+	case float64:
+		if err := s.validateNumber(x, path); err != nil {
+			return err
+		}
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.minLength != nil && n < *s.minLength {
+			return fmt.Errorf("%s: string shorter than %d characters", path, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fmt.Errorf("%s: string longer than %d characters", path, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			return fmt.Errorf("%s: string does not match pattern %q", path, s.pattern)
+		}
+	case []any:
+		if err := s.validateArray(x, path); err != nil {
+			return err
+		}
+	case map[string]any:
+		if err := s.validateObject(x, path); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		var first error
+		for _, sub := range s.anyOf {
+			if first = sub.validate(v, path); first == nil {
+				break
+			}
+		}
+		if first != nil {
+			return fmt.Errorf("%s: matches none of anyOf (first: %v)", path, first)
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matches %d of oneOf, want exactly 1", path, matched)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fmt.Errorf("%s: matches a schema it must not", path)
+	}
+	return nil
+}
+
+// allowsType reports whether a value of JSON type t may pass the type
+// keyword; integers are numbers.
+func (s *jsonSchema) allowsType(t string) bool {
+	return slices.Contains(s.types, t) || (t == "integer" && slices.Contains(s.types, "number"))
+}
+
+func (s *jsonSchema) validateNumber(x float64, path string) error {
+	if s.minimum != nil && x < *s.minimum {
+		return fmt.Errorf("%s: %v is less than the minimum %v", path, x, *s.minimum)
+	}
+	if s.maximum != nil && x > *s.maximum {
+		return fmt.Errorf("%s: %v is greater than the maximum %v", path, x, *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && x <= *s.exclusiveMinimum {
+		return fmt.Errorf("%s: %v must be greater than %v", path, x, *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && x >= *s.exclusiveMaximum {
+		return fmt.Errorf("%s: %v must be less than %v", path, x, *s.exclusiveMaximum)
+	}
+	if s.multipleOf != nil {
+		q := x / *s.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			return fmt.Errorf("%s: %v is not a multiple of %v", path, x, *s.multipleOf)
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateArray(x []any, path string) error {
+	if s.minItems != nil && len(x) < *s.minItems {
+		return fmt.Errorf("%s: fewer than %d items", path, *s.minItems)
+	}
+	if s.maxItems != nil && len(x) > *s.maxItems {
+		return fmt.Errorf("%s: more than %d items", path, *s.maxItems)
+	}
+	if s.uniqueItems {
+		seen := make(map[string]bool, len(x))
+		for _, e := range x {
+			c := canonical(e)
+			if seen[c] {
+				return fmt.Errorf("%s: duplicate item %s", path, c)
+			}
+			seen[c] = true
+		}
+	}
+	if s.items != nil {
+		for i, e := range x {
+			if err := s.items.validate(e, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(x map[string]any, path string) error {
+	if s.minProperties != nil && len(x) < *s.minProperties {
+		return fmt.Errorf("%s: fewer than %d properties", path, *s.minProperties)
+	}
+	if s.maxProperties != nil && len(x) > *s.maxProperties {
+		return fmt.Errorf("%s: more than %d properties", path, *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := x[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	// Check properties in a stable order so errors are reproducible.
+	names := make([]string, 0, len(x))
+	for name := range x {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub := s.properties[name]
+		if sub == nil {
+			sub = s.additional
+		}
+		if sub == nil {
+			continue
+		}
+		if sub.never && s.properties[name] == nil {
+			return fmt.Errorf("%s: property %q is not allowed", path, name)
+		}
+		if err := sub.validate(x[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// covers reports a way data valid under w could be rejected by s. Keywords
+// are compared pairwise, so the check is conservative: a new allOf, anyOf,
+// oneOf or not on the reading side is incompatible unless unchanged. A
+// property the reader constrains but the writer neither declares nor rules
+// out is assumed absent from the writer's data, so adding an optional
+// property to an open schema stays compatible.
+func (s *jsonSchema) covers(w compiled) error {
+	ws, ok := w.(*jsonSchema)
+	if !ok {
+		return fmt.Errorf("schema type changed")
+	}
+	return s.coversAt(ws, "$")
+}
+
+func (s *jsonSchema) coversAt(w *jsonSchema, path string) error {
+	if w.never || s.raw == w.raw || s.acceptsAll() {
+		return nil
+	}
+	if s.never {
+		return fmt.Errorf("%s: no value is allowed any more", path)
+	}
+	if (s.allOf != nil || s.anyOf != nil || s.oneOf != nil || s.not != nil) &&
+		(canonicalList(s.allOf) != canonicalList(w.allOf) || canonicalList(s.anyOf) != canonicalList(w.anyOf) ||
+			canonicalList(s.oneOf) != canonicalList(w.oneOf) || canonicalOne(s.not) != canonicalOne(w.not)) {
+		return fmt.Errorf("%s: allOf/anyOf/oneOf/not changed", path)
+	}
+
+	if len(s.types) > 0 {
+		if len(w.types) == 0 {
+			return fmt.Errorf("%s: type restricted to %s", path, strings.Join(s.types, " or "))
+		}
+		for _, t := range w.types {
+			if !s.allowsType(t) {
+				return fmt.Errorf("%s: type %s is no longer allowed", path, t)
+			}
+		}
+	}
+	if s.enum != nil {
+		if w.enum == nil {
+			return fmt.Errorf("%s: values restricted to an enum", path)
+		}
+		for _, v := range w.enum {
+			if !slices.Contains(s.enum, v) {
+				return fmt.Errorf("%s: enum value %s was removed", path, v)
+			}
+		}
+	}
+
+	if w.mayBe("number", "integer") {
+		if err := s.coversNumber(w, path); err != nil {
+			return err
+		}
+	}
+	if w.mayBe("string") {
+		if err := coversCount(s.minLength, s.maxLength, w.minLength, w.maxLength, path, "length"); err != nil {
+			return err
+		}
+		if s.pattern != nil && (w.pattern == nil || w.pattern.String() != s.pattern.String()) {
+			return fmt.Errorf("%s: pattern changed to %q", path, s.pattern)
+		}
+	}
+	if w.mayBe("array") {
+		if err := coversCount(s.minItems, s.maxItems, w.minItems, w.maxItems, path, "item count"); err != nil {
+			return err
+		}
+		if s.uniqueItems && !w.uniqueItems {
+			return fmt.Errorf("%s: items must now be unique", path)
+		}
+		if s.items != nil {
+			if w.items == nil {
+				return fmt.Errorf("%s: items are now constrained", path)
+			}
+			if err := s.items.coversAt(w.items, path+"[]"); err != nil {
+				return err
+			}
+		}
+	}
+	if w.mayBe("object") {
+		if err := s.coversObject(w, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptsAll reports whether s is the empty or true schema.
+func (s *jsonSchema) acceptsAll() bool {
+	return s.raw == "true" || s.raw == "{}"
+}
+
+// mayBe reports whether s admits values of any of the given types.
+func (s *jsonSchema) mayBe(types ...string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if slices.Contains(s.types, t) || (t == "integer" && slices.Contains(s.types, "number")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *jsonSchema) coversNumber(w *jsonSchema, path string) error {
+	// w's effective lower bound must be at least s's.
+	if s.minimum != nil || s.exclusiveMinimum != nil {
+		ok := false
+		switch {
+		case s.minimum != nil && w.minimum != nil && *w.minimum >= *s.minimum,
+			s.minimum != nil && w.exclusiveMinimum != nil && *w.exclusiveMinimum >= *s.minimum,
+			s.exclusiveMinimum != nil && w.minimum != nil && *w.minimum > *s.exclusiveMinimum,
+			s.exclusiveMinimum != nil && w.exclusiveMinimum != nil && *w.exclusiveMinimum >= *s.exclusiveMinimum:
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("%s: lower bound raised", path)
+		}
+	}
+	if s.maximum != nil || s.exclusiveMaximum != nil {
+		ok := false
+		switch {
+		case s.maximum != nil && w.maximum != nil && *w.maximum <= *s.maximum,
+			s.maximum != nil && w.exclusiveMaximum != nil && *w.exclusiveMaximum <= *s.maximum,
+			s.exclusiveMaximum != nil && w.maximum != nil && *w.maximum < *s.exclusiveMaximum,
+			s.exclusiveMaximum != nil && w.exclusiveMaximum != nil && *w.exclusiveMaximum <= *s.exclusiveMaximum:
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("%s: upper bound lowered", path)
+		}
+	}
+	if s.multipleOf != nil {
+		if w.multipleOf == nil {
+			return fmt.Errorf("%s: values must now be a multiple of %v", path, *s.multipleOf)
+		}
+		q := *w.multipleOf / *s.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			return fmt.Errorf("%s: multipleOf changed from %v to %v", path, *w.multipleOf, *s.multipleOf)
+		}
+	}
+	return nil
+}
+
+// coversCount checks that the writer's [wMin, wMax] range lies within the
+// reader's [sMin, sMax].
+func coversCount(sMin, sMax, wMin, wMax *int, path, what string) error {
+	if sMin != nil && (wMin == nil || *wMin < *sMin) {
+		return fmt.Errorf("%s: minimum %s raised to %d", path, what, *sMin)
+	}
+	if sMax != nil && (wMax == nil || *wMax > *sMax) {
+		return fmt.Errorf("%s: maximum %s lowered to %d", path, what, *sMax)
+	}
+	return nil
+}
+
+func (s *jsonSchema) coversObject(w *jsonSchema, path string) error {
+	if err := coversCount(s.minProperties, s.maxProperties, w.minProperties, w.maxProperties, path, "property count"); err != nil {
+		return err
+	}
+	for _, name := range s.required {
+		if !slices.Contains(w.required, name) {
+			return fmt.Errorf("%s: property %q is now required", path, name)
+		}
+	}
+
+	names := make([]string, 0, len(s.properties))
+	for name := range s.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sp := s.properties[name]
+		wp := w.properties[name]
+		if wp == nil {
+			wp = w.additional // nil: assumed absent, see covers
+		}
+		if wp == nil {
+			continue
+		}
+		if err := sp.coversAt(wp, path+"."+name); err != nil {
+			return err
+		}
+	}
+
+	if s.additional != nil && !s.additional.acceptsAll() {
+		for name, wp := range w.properties {
+			if s.properties[name] != nil {
+				continue
+			}
+			if err := s.additional.coversAt(wp, path+"."+name); err != nil {
+				if s.additional.never {
+					return fmt.Errorf("%s: property %q is no longer allowed", path, name)
+				}
+				return err
+			}
+		}
+		if w.additional == nil {
+			if s.additional.never {
+				return fmt.Errorf("%s: additional properties are no longer allowed", path)
+			}
+			return fmt.Errorf("%s: additional properties are now constrained", path)
+		}
+		if err := s.additional.coversAt(w.additional, path+".*"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func canonicalList(l []*jsonSchema) string {
+	raws := make([]string, len(l))
+	for i, s := range l {
+		raws[i] = s.raw
+	}
+	return strings.Join(raws, ",")
+}
+
+func canonicalOne(s *jsonSchema) string {
+	if s == nil {
+		return ""
+	}
+	return s.raw
+}
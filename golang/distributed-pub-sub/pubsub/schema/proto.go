@@ -0,0 +1,154 @@
+package schema
+
+import (
+	"fmt"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoSchema validates binary protobuf payloads against one message type
+// of a FileDescriptorSet. Payloads must decode cleanly, set every proto2
+// required field and carry no fields the message does not declare, which
+// also catches fields encoded with the wrong wire type.
+type protoSchema struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func compileProto(def []byte, message string) (*protoSchema, error) {
+	if message == "" {
+		return nil, fmt.Errorf("protobuf schema needs a message name")
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(def, &set); err != nil {
+		return nil, fmt.Errorf("parse file descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("build file descriptors: %w", err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %q: %w", message, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message", message)
+	}
+	return &protoSchema{desc: md}, nil
+}
+
+func (s *protoSchema) Validate(payload []byte) error {
+	m := dynamicpb.NewMessage(s.desc)
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return fmt.Errorf("payload is not a valid %s: %w", s.desc.FullName(), err)
+	}
+	return checkUnknown(m, string(s.desc.Name()))
+}
+
+// checkUnknown fails if m or any message nested in it holds unknown fields.
+func checkUnknown(m protoreflect.Message, path string) error {
+	if len(m.GetUnknown()) > 0 {
+		return fmt.Errorf("%s: undeclared or mistyped fields", path)
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fpath := path + "." + string(fd.Name())
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				return true
+			}
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				err = checkUnknown(mv.Message(), fmt.Sprintf("%s[%v]", fpath, k))
+				return err == nil
+			})
+		case fd.IsList():
+			if fd.Message() == nil {
+				return true
+			}
+			l := v.List()
+			for i := 0; i < l.Len() && err == nil; i++ {
+				err = checkUnknown(l.Get(i).Message(), fmt.Sprintf("%s[%d]", fpath, i))
+			}
+		case fd.Message() != nil:
+			err = checkUnknown(v.Message(), fpath)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// covers compares fields by number. Fields the writer has and the reader
+// lacks are fine, since protobuf readers skip unknown fields; fields the
+// reader has must keep their cardinality and a wire-compatible type, and
+// may only be required if the writer requires them too.
+func (s *protoSchema) covers(w compiled) error {
+	ws, ok := w.(*protoSchema)
+	if !ok {
+		return fmt.Errorf("schema type changed")
+	}
+	return protoCovers(s.desc, ws.desc, string(s.desc.Name()), make(map[[2]protoreflect.FullName]bool))
+}
+
+func protoCovers(r, w protoreflect.MessageDescriptor, path string, seen map[[2]protoreflect.FullName]bool) error {
+	pair := [2]protoreflect.FullName{r.FullName(), w.FullName()}
+	if seen[pair] {
+		return nil // recursive message; already being compared
+	}
+	seen[pair] = true
+
+	fields := r.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		rf := fields.Get(i)
+		fpath := path + "." + string(rf.Name())
+		wf := w.Fields().ByNumber(rf.Number())
+		if wf == nil {
+			if rf.Cardinality() == protoreflect.Required {
+				return fmt.Errorf("%s: required field %d is new", fpath, rf.Number())
+			}
+			continue
+		}
+		if rf.Cardinality() == protoreflect.Required && wf.Cardinality() != protoreflect.Required {
+			return fmt.Errorf("%s: field %d is now required", fpath, rf.Number())
+		}
+		if rf.IsList() != wf.IsList() || rf.IsMap() != wf.IsMap() {
+			return fmt.Errorf("%s: field %d changed cardinality", fpath, rf.Number())
+		}
+		if !wireCompatible(rf.Kind(), wf.Kind()) {
+			return fmt.Errorf("%s: field %d changed type from %s to %s", fpath, rf.Number(), wf.Kind(), rf.Kind())
+		}
+		if rf.Message() != nil {
+			if err := protoCovers(rf.Message(), wf.Message(), fpath, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wireGroups lists kinds whose encodings a reader of one can decode as the
+// other, following the protobuf language guide's rules for updating types.
+var wireGroups = [][]protoreflect.Kind{
+	{protoreflect.Int32Kind, protoreflect.Uint32Kind, protoreflect.Int64Kind,
+		protoreflect.Uint64Kind, protoreflect.BoolKind, protoreflect.EnumKind},
+	{protoreflect.Sint32Kind, protoreflect.Sint64Kind},
+	{protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind},
+	{protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind},
+}
+
+func wireCompatible(a, b protoreflect.Kind) bool {
+	if a == b {
+		return true
+	}
+	for _, g := range wireGroups {
+		if slices.Contains(g, a) && slices.Contains(g, b) {
+			return true
+		}
+	}
+	return false
+}
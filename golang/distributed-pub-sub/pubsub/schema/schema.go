@@ -0,0 +1,117 @@
+// Package schema validates message payloads against JSON Schema documents
+// and protobuf message descriptors, and decides whether a new version of a
+// schema may replace the previous one under a compatibility mode.
+package schema
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Type identifies the schema language of a definition.
+type Type string
+
+const (
+	JSON     Type = "json"     // JSON Schema document; payloads are JSON
+	Protobuf Type = "protobuf" // FileDescriptorSet; payloads are binary protobuf
+)
+
+// Compatibility governs how a subject's schema may evolve between versions.
+type Compatibility string
+
+const (
+	None     Compatibility = "none"     // any change is allowed
+	Backward Compatibility = "backward" // the new version accepts data written with the previous one
+	Forward  Compatibility = "forward"  // the previous version accepts data written with the new one
+	Full     Compatibility = "full"     // both backward and forward
+)
+
+// ParseCompatibility parses a compatibility mode name.
+func ParseCompatibility(s string) (Compatibility, error) {
+	switch c := Compatibility(s); c {
+	case None, Backward, Forward, Full:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compatibility mode %q (want none, backward, forward or full)", s)
+}
+
+// Schema is one version of a schema definition.
+type Schema struct {
+	Type Type `json:"type"`
+	// Definition is a JSON Schema document for JSON, or a serialized
+	// google.protobuf.FileDescriptorSet for Protobuf.
+	Definition []byte `json:"definition"`
+	// Message is the fully qualified name of the protobuf message payloads
+	// must encode. It is unused for JSON.
+	Message string `json:"message,omitempty"`
+}
+
+// Equal reports whether s and o define the same schema.
+func (s Schema) Equal(o Schema) bool {
+	return s.Type == o.Type && s.Message == o.Message && bytes.Equal(s.Definition, o.Definition)
+}
+
+// Validator checks payloads against a compiled schema.
+type Validator interface {
+	// Validate returns an error describing the first way payload does not
+	// conform, or nil.
+	Validate(payload []byte) error
+}
+
+// compiled is a Validator that can also compare itself to another version
+// of the same schema.
+type compiled interface {
+	Validator
+	// covers returns an error if some payload valid under w could be
+	// rejected by this schema. It may be conservative: constructs it cannot
+	// compare are reported as incompatible.
+	covers(w compiled) error
+}
+
+// Compile parses s and returns a validator for it.
+func Compile(s Schema) (Validator, error) {
+	return compile(s)
+}
+
+func compile(s Schema) (compiled, error) {
+	switch s.Type {
+	case JSON:
+		return compileJSON(s.Definition)
+	case Protobuf:
+		return compileProto(s.Definition, s.Message)
+	}
+	return nil, fmt.Errorf("unknown schema type %q (want json or protobuf)", s.Type)
+}
+
+// CheckCompatibility returns an error explaining why next may not follow
+// prev under mode, or nil if it may.
+func CheckCompatibility(mode Compatibility, prev, next Schema) error {
+	if mode == None {
+		return nil
+	}
+	if prev.Type != next.Type {
+		return fmt.Errorf("schema type changed from %s to %s", prev.Type, next.Type)
+	}
+	old, err := compile(prev)
+	if err != nil {
+		return fmt.Errorf("previous version: %w", err)
+	}
+	cur, err := compile(next)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case Backward, Full:
+		if err := cur.covers(old); err != nil {
+			return fmt.Errorf("not backward compatible: %w", err)
+		}
+	}
+	switch mode {
+	case Forward, Full:
+		if err := old.covers(cur); err != nil {
+			return fmt.Errorf("not forward compatible: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"properties": {
+		"id":     {"type": "string", "minLength": 1},
+		"qty":    {"type": "integer", "minimum": 1},
+		"status": {"enum": ["new", "paid"]},
+		"tags":   {"type": "array", "items": {"type": "string"}, "uniqueItems": true}
+	},
+	"required": ["id", "qty"],
+	"additionalProperties": false
+}`
+
+func TestJSON_Validate(t *testing.T) {
+	v, err := Compile(Schema{Type: JSON, Definition: []byte(orderSchema)})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	tests := []struct {
+		payload string
+		wantErr string // substring; empty means valid
+	}{
+		{`{"id": "a", "qty": 2}`, ""},
+		{`{"id": "a", "qty": 2, "status": "paid", "tags": ["x", "y"]}`, ""},
+		{`{"id": "a"}`, `missing required property "qty"`},
+		{`{"id": "a", "qty": 1.5}`, "$.qty: expected integer, got number"},
+		{`{"id": "a", "qty": 0}`, "less than the minimum"},
+		{`{"id": "", "qty": 1}`, "shorter than 1"},
+		{`{"id": "a", "qty": 1, "status": "lost"}`, "not one of"},
+		{`{"id": "a", "qty": 1, "tags": ["x", "x"]}`, "duplicate item"},
+		{`{"id": "a", "qty": 1, "tags": [1]}`, "$.tags[0]: expected string"},
+		{`{"id": "a", "qty": 1, "extra": true}`, `property "extra" is not allowed`},
+		{`[1, 2]`, "expected object, got array"},
+		{`not json`, "not valid JSON"},
+	}
+	for _, tt := range tests {
+		err := v.Validate([]byte(tt.payload))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%s) = %v, want nil", tt.payload, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%s) = %v, want error containing %q", tt.payload, err, tt.wantErr)
+		}
+	}
+}
+
+func TestJSON_CompileRejectsUnsupported(t *testing.T) {
+	for _, def := range []string{
+		`{"$ref": "#/$defs/x"}`,
+		`{"type": "widget"}`,
+		`{"items": [{"type": "string"}]}`,
+		`{"pattern": "("}`,
+		`[]`,
+	} {
+		if _, err := Compile(Schema{Type: JSON, Definition: []byte(def)}); err == nil {
+			t.Errorf("Compile(%s) succeeded, want error", def)
+		}
+	}
+}
+
+func TestJSON_Compatibility(t *testing.T) {
+	base := `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`
+
+	tests := []struct {
+		name string
+		next string
+		mode Compatibility
+		ok   bool
+	}{
+		{"add optional property", `{"type": "object", "properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id"]}`, Full, true},
+		{"add required property", `{"type": "object", "properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id", "note"]}`, Backward, false},
+		{"add required property", `{"type": "object", "properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id", "note"]}`, Forward, true},
+		{"drop required", `{"type": "object", "properties": {"id": {"type": "string"}}}`, Backward, true},
+		{"drop required", `{"type": "object", "properties": {"id": {"type": "string"}}}`, Forward, false},
+		{"widen type", `{"type": "object", "properties": {"id": {"type": ["string", "integer"]}}, "required": ["id"]}`, Backward, true},
+		{"widen type", `{"type": "object", "properties": {"id": {"type": ["string", "integer"]}}, "required": ["id"]}`, Full, false},
+		{"close object", `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"], "additionalProperties": false}`, Backward, false},
+		{"anything goes", `{"type": "array"}`, None, true},
+	}
+	for _, tt := range tests {
+		prev := Schema{Type: JSON, Definition: []byte(base)}
+		next := Schema{Type: JSON, Definition: []byte(tt.next)}
+		err := CheckCompatibility(tt.mode, prev, next)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s under %s: err = %v, want ok=%v", tt.name, tt.mode, err, tt.ok)
+		}
+	}
+}
+
+// orderDescriptor builds a FileDescriptorSet for
+//
+//	message Order { string id = 1; int32 qty = 2; <extra> }
+func orderDescriptor(t *testing.T, extra ...*descriptorpb.FieldDescriptorProto) []byte {
+	t.Helper()
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	fields := []*descriptorpb.FieldDescriptorProto{
+		field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("qty", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:        proto.String("order.proto"),
+		Package:     proto.String("shop"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Order"), Field: append(fields, extra...)}},
+	}}}
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal descriptor set: %v", err)
+	}
+	return b
+}
+
+func TestProtobuf_ValidateAndCompatibility(t *testing.T) {
+	s := Schema{Type: Protobuf, Definition: orderDescriptor(t), Message: "shop.Order"}
+	v, err := Compile(s)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	// id = "a" (field 1, bytes), qty = 3 (field 2, varint)
+	valid := []byte{0x0a, 0x01, 'a', 0x10, 0x03}
+	if err := v.Validate(valid); err != nil {
+		t.Fatalf("Validate(valid) = %v", err)
+	}
+	// qty encoded as a length-delimited field: wrong wire type.
+	if err := v.Validate([]byte{0x12, 0x01, 'x'}); err == nil {
+		t.Fatal("Validate accepted a mistyped field")
+	}
+	if err := v.Validate([]byte(`{"id":"a"}`)); err == nil {
+		t.Fatal("Validate accepted JSON")
+	}
+	if _, err := Compile(Schema{Type: Protobuf, Definition: s.Definition, Message: "shop.Missing"}); err == nil {
+		t.Fatal("Compile accepted an unknown message")
+	}
+
+	note := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String("note"),
+		Number: proto.Int32(3),
+		Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	added := Schema{Type: Protobuf, Definition: orderDescriptor(t, note), Message: "shop.Order"}
+	if err := CheckCompatibility(Full, s, added); err != nil {
+		t.Fatalf("adding an optional field: %v", err)
+	}
+
+	retyped := proto.Clone(note).(*descriptorpb.FieldDescriptorProto)
+	retyped.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+	changed := Schema{Type: Protobuf, Definition: orderDescriptor(t, retyped), Message: "shop.Order"}
+	err = CheckCompatibility(Backward, added, changed)
+	if err == nil || !strings.Contains(err.Error(), "changed type") {
+		t.Fatalf("changing a field's type: err = %v, want changed type", err)
+	}
+}
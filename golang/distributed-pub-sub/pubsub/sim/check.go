@@ -0,0 +1,127 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxReported caps how many message IDs an invariant violation lists.
+const maxReported = 5
+
+type subscription struct {
+	node      int
+	topic     string
+	since     int // index into published of the first expected message
+	alive     bool
+	delivered map[string]int // message ID -> delivery count
+}
+
+type published struct {
+	topic string
+	id    string
+}
+
+// recorder tracks what was published and what each subscription received.
+type recorder struct {
+	mu        sync.Mutex
+	published []published
+	subs      []*subscription
+}
+
+func newRecorder() *recorder {
+	return &recorder{}
+}
+
+func (r *recorder) subscribe(node int, topic string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs = append(r.subs, &subscription{
+		node:      node,
+		topic:     topic,
+		since:     len(r.published),
+		alive:     true,
+		delivered: make(map[string]int),
+	})
+	return len(r.subs) - 1
+}
+
+func (r *recorder) deliver(sub int, id string) {
+	r.mu.Lock()
+	r.subs[sub].delivered[id]++
+	r.mu.Unlock()
+}
+
+func (r *recorder) publish(topic, id string) {
+	r.mu.Lock()
+	r.published = append(r.published, published{topic: topic, id: id})
+	r.mu.Unlock()
+}
+
+func (r *recorder) crash(node int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.subs {
+		if s.node == node {
+			s.alive = false
+		}
+	}
+}
+
+func (r *recorder) checkNoLoss() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var problems []string
+	for _, s := range r.subs {
+		if !s.alive {
+			continue
+		}
+		var missing []string
+		for _, p := range r.published[s.since:] {
+			if p.topic == s.topic && s.delivered[p.id] == 0 {
+				missing = append(missing, p.id)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s on %s missed %d messages %s",
+				s.topic, host(s.node), len(missing), truncate(missing)))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("message loss: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (r *recorder) checkNoDuplicates() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var problems []string
+	for _, s := range r.subs {
+		var dups []string
+		for id, n := range s.delivered {
+			if n > 1 {
+				dups = append(dups, id)
+			}
+		}
+		if len(dups) > 0 {
+			sort.Strings(dups)
+			problems = append(problems, fmt.Sprintf("%s on %s received %d messages more than once %s",
+				s.topic, host(s.node), len(dups), truncate(dups)))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("duplicate delivery: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func truncate(ids []string) string {
+	if len(ids) > maxReported {
+		return fmt.Sprintf("%v...", ids[:maxReported])
+	}
+	return fmt.Sprintf("%v", ids)
+}
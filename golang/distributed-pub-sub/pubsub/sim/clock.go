@@ -0,0 +1,152 @@
+package sim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"distributed-pub-sub/pubsub"
+)
+
+// settleTime is the real time given to node goroutines after each timer
+// fires, so that work triggered by one instant completes before the clock
+// moves on.
+const settleTime = time.Millisecond
+
+// Clock is a virtual pubsub.Clock. Time only moves when Advance is called;
+// timers and tickers fire in deadline order as it passes them.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers timerHeap
+	seq    uint64
+}
+
+// NewClock returns a virtual clock starting at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the virtual time once d has passed.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.schedule(&timer{when: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// NewTicker returns a ticker that fires every d of virtual time. Like
+// time.Ticker it drops ticks for slow receivers.
+func (c *Clock) NewTicker(d time.Duration) pubsub.Ticker {
+	if d <= 0 {
+		panic("sim: non-positive interval for NewTicker")
+	}
+	t := &timer{period: d, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	t.when = c.now.Add(d)
+	c.schedule(t)
+	c.mu.Unlock()
+	return &ticker{c: c, t: t}
+}
+
+// Advance moves virtual time forward by d, firing every timer that falls due
+// on the way. Each firing is followed by a short real-time pause so the
+// goroutines it wakes can run.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			break
+		}
+		t := heap.Pop(&c.timers).(*timer)
+		c.now = t.when
+		select {
+		case t.ch <- t.when:
+		default:
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			c.schedule(t)
+		}
+		c.mu.Unlock()
+		time.Sleep(settleTime)
+	}
+	time.Sleep(settleTime)
+}
+
+// schedule adds t to the timer heap. c.mu must be held.
+func (c *Clock) schedule(t *timer) {
+	c.seq++
+	t.seq = c.seq
+	heap.Push(&c.timers, t)
+}
+
+type timer struct {
+	when   time.Time
+	seq    uint64 // orders timers due at the same instant
+	period time.Duration
+	ch     chan time.Time
+	index  int
+}
+
+type ticker struct {
+	c *Clock
+	t *timer
+}
+
+func (t *ticker) C() <-chan time.Time { return t.t.ch }
+
+func (t *ticker) Stop() {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	if t.t.index >= 0 && t.t.index < len(t.c.timers) && t.c.timers[t.t.index] == t.t {
+		heap.Remove(&t.c.timers, t.t.index)
+	}
+	t.t.period = 0
+}
+
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
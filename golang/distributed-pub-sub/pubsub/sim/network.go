@@ -0,0 +1,213 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1 << 20
+
+// addrPrefix makes node addresses dialable without DNS by both
+// grpc.DialContext and grpc.NewClient.
+const addrPrefix = "passthrough:///"
+
+// Network is an in-memory transport between simulated nodes. Connections
+// are bufconn pipes; faults are injected per RPC by a client interceptor so
+// they apply to every call a node makes: partitions and down nodes fail the
+// call with Unavailable, latency delays it on the virtual clock, and loss
+// drops either the request or the response. Dropping a response after the
+// callee handled it makes the caller retry a delivered message, which is
+// what exercises deduplication.
+type Network struct {
+	clock *Clock
+
+	mu        sync.Mutex
+	listeners map[string]*bufconn.Listener // by host name; absent while down
+	groups    map[string]int               // partition group by host; nil = fully connected
+	cut       map[[2]string]bool           // severed links, both directions
+	latency   time.Duration
+	loss      float64
+	rng       *rand.Rand
+	dropped   int
+}
+
+// NewNetwork returns a fully connected network whose loss decisions are
+// drawn from a PRNG seeded with seed.
+func NewNetwork(clock *Clock, seed int64) *Network {
+	return &Network{
+		clock:     clock,
+		listeners: make(map[string]*bufconn.Listener),
+		cut:       make(map[[2]string]bool),
+		rng:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Addr returns the gRPC address for the named host.
+func Addr(host string) string {
+	return addrPrefix + host
+}
+
+// Listen brings host up and returns the listener its node should serve on.
+func (nw *Network) Listen(host string) net.Listener {
+	lis := bufconn.Listen(bufSize)
+	nw.mu.Lock()
+	nw.listeners[host] = lis
+	nw.mu.Unlock()
+	return lis
+}
+
+// Down takes host off the network. New dials to it fail; calls to it fail
+// with Unavailable.
+func (nw *Network) Down(host string) {
+	nw.mu.Lock()
+	delete(nw.listeners, host)
+	nw.mu.Unlock()
+}
+
+// Partition splits the network into the given groups of hosts. Hosts in
+// different groups cannot reach each other; hosts not listed are isolated.
+func (nw *Network) Partition(groups ...[]string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.groups = make(map[string]int)
+	for i, g := range groups {
+		for _, host := range g {
+			nw.groups[host] = i
+		}
+	}
+}
+
+// Cut severs the link between hosts a and b; both can still reach others.
+func (nw *Network) Cut(a, b string) {
+	nw.mu.Lock()
+	nw.cut[[2]string{a, b}] = true
+	nw.cut[[2]string{b, a}] = true
+	nw.mu.Unlock()
+}
+
+// Heal removes any partition and restores cut links.
+func (nw *Network) Heal() {
+	nw.mu.Lock()
+	nw.groups = nil
+	nw.cut = make(map[[2]string]bool)
+	nw.mu.Unlock()
+}
+
+// SetLatency sets the one-way virtual delay applied to every call.
+func (nw *Network) SetLatency(d time.Duration) {
+	nw.mu.Lock()
+	nw.latency = d
+	nw.mu.Unlock()
+}
+
+// SetLoss sets the probability in [0, 1] that a call's request, and
+// separately its response, is dropped.
+func (nw *Network) SetLoss(p float64) {
+	nw.mu.Lock()
+	nw.loss = p
+	nw.mu.Unlock()
+}
+
+// Dropped returns how many requests and responses loss has dropped.
+func (nw *Network) Dropped() int {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return nw.dropped
+}
+
+// DialOptions returns the options a node on host uses to reach its peers.
+func (nw *Network) DialOptions(host string) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(nw.dialer),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithUnaryInterceptor(nw.interceptor(host)),
+	}
+}
+
+// errHostDown is returned when dialing a host that is down. It reports
+// itself as permanent so blocking dials fail instead of retrying forever.
+type errHostDown string
+
+func (e errHostDown) Error() string   { return fmt.Sprintf("sim: host %s is down", string(e)) }
+func (e errHostDown) Temporary() bool { return false }
+
+func (nw *Network) dialer(ctx context.Context, host string) (net.Conn, error) {
+	nw.mu.Lock()
+	lis := nw.listeners[host]
+	nw.mu.Unlock()
+	if lis == nil {
+		return nil, errHostDown(host)
+	}
+	return lis.DialContext(ctx)
+}
+
+func (nw *Network) interceptor(src string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		dst := strings.TrimPrefix(cc.Target(), addrPrefix)
+
+		latency, err := nw.send(src, dst)
+		if err != nil {
+			return err
+		}
+		if err := nw.wait(ctx, latency); err != nil {
+			return err
+		}
+		if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+			return err
+		}
+
+		latency, err = nw.send(dst, src)
+		if err != nil {
+			return err
+		}
+		return nw.wait(ctx, latency)
+	}
+}
+
+// send decides the fate of one message from src to dst, returning the
+// latency to apply or an Unavailable error if it does not arrive.
+func (nw *Network) send(src, dst string) (time.Duration, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	if nw.listeners[src] == nil || nw.listeners[dst] == nil {
+		return 0, status.Errorf(codes.Unavailable, "sim: %s -> %s: host down", src, dst)
+	}
+	if nw.groups != nil {
+		gs, okSrc := nw.groups[src]
+		gd, okDst := nw.groups[dst]
+		if !okSrc || !okDst || gs != gd {
+			return 0, status.Errorf(codes.Unavailable, "sim: %s -> %s: partitioned", src, dst)
+		}
+	}
+	if nw.cut[[2]string{src, dst}] {
+		return 0, status.Errorf(codes.Unavailable, "sim: %s -> %s: link cut", src, dst)
+	}
+	if nw.loss > 0 && nw.rng.Float64() < nw.loss {
+		nw.dropped++
+		return 0, status.Errorf(codes.Unavailable, "sim: %s -> %s: dropped", src, dst)
+	}
+	return nw.latency, nil
+}
+
+func (nw *Network) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-nw.clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
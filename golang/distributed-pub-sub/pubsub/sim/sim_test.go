@@ -0,0 +1,209 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"distributed-pub-sub/pubsub"
+	"distributed-pub-sub/pubsub/pb"
+)
+
+func TestClock_FiresInDeadlineOrder(t *testing.T) {
+	c := NewClock(time.Unix(0, 0))
+	late := c.After(2 * time.Second)
+	early := c.After(time.Second)
+	tick := c.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-early:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+	<-tick.C()
+
+	c.Advance(time.Millisecond)
+	if got := <-early; !got.Equal(time.Unix(1, 0)) {
+		t.Fatalf("early fired at %v, want %v", got, time.Unix(1, 0))
+	}
+	select {
+	case <-late:
+		t.Fatal("late timer fired early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	<-late
+	if got := c.Now(); !got.Equal(time.Unix(2, 0)) {
+		t.Fatalf("Now() = %v, want %v", got, time.Unix(2, 0))
+	}
+}
+
+func publishN(t *testing.T, c *Cluster, node, n int, topic string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := c.Publish(node, topic, []byte(fmt.Sprintf("m%d", i))); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+}
+
+func checkInvariants(t *testing.T, c *Cluster) {
+	t.Helper()
+	if err := c.CheckNoLoss(); err != nil {
+		t.Error(err)
+	}
+	if err := c.CheckNoDuplicates(); err != nil {
+		t.Error(err)
+	}
+}
+
+// tolerant keeps peers through the faults these tests inject, so that
+// forwarding retries rather than dropping messages for a removed peer.
+func tolerant(_ int, opts *pubsub.Options) {
+	opts.MaxHealthFailures = 20
+}
+
+func TestSim_LossAndLatency(t *testing.T) {
+	c := New(t, Config{Nodes: 3, Seed: 1, Options: tolerant})
+	c.Subscribe(1, "orders")
+	c.Subscribe(2, "orders")
+	c.Advance(time.Second)
+
+	c.SetLoss(0.2)
+	c.SetLatency(20 * time.Millisecond)
+	publishN(t, c, 0, 50, "orders")
+	c.Advance(30 * time.Second)
+
+	if c.Net.Dropped() == 0 {
+		t.Fatal("expected the network to drop some calls")
+	}
+	checkInvariants(t, c)
+}
+
+func TestSim_PartitionHeals(t *testing.T) {
+	c := New(t, Config{Nodes: 3, Seed: 2, Options: tolerant})
+	c.Subscribe(2, "orders")
+	c.Advance(time.Second)
+
+	c.Partition([]int{0}, []int{1, 2})
+	publishN(t, c, 0, 20, "orders")
+	c.Advance(3 * time.Second)
+	if err := c.CheckNoLoss(); err == nil {
+		t.Fatal("messages crossed the partition")
+	}
+
+	c.Heal()
+	c.Advance(20 * time.Second)
+	checkInvariants(t, c)
+}
+
+func TestSim_CheckerDetectsLoss(t *testing.T) {
+	c := New(t, Config{Nodes: 2, Seed: 3, Options: func(_ int, opts *pubsub.Options) {
+		opts.MaxRetries = 2
+		opts.MaxHealthFailures = 100
+	}})
+	c.Subscribe(1, "orders")
+	c.Advance(time.Second)
+
+	// The partition outlasts the forwarding retries.
+	c.Partition([]int{0}, []int{1})
+	publishN(t, c, 0, 5, "orders")
+	c.Advance(10 * time.Second)
+	c.Heal()
+	c.Advance(10 * time.Second)
+
+	if err := c.CheckNoLoss(); err == nil {
+		t.Fatal("expected CheckNoLoss to report dropped messages")
+	}
+}
+
+func TestSim_CrashRestartDurable(t *testing.T) {
+	c := New(t, Config{Nodes: 3, Seed: 4, Durable: true})
+	c.Subscribe(1, "orders")
+	c.Subscribe(2, "orders")
+	c.Advance(time.Second)
+
+	publishN(t, c, 0, 10, "orders")
+	c.Advance(time.Second)
+	c.rec.mu.Lock()
+	delivered := &pubsub.Message{ID: c.rec.published[0].id, Destination: "orders"}
+	c.rec.mu.Unlock()
+
+	c.Crash(2)
+	publishN(t, c, 0, 10, "orders")
+	c.Advance(5 * time.Second)
+
+	c.Restart(2)
+	c.Subscribe(2, "orders")
+	c.Advance(2 * time.Second)
+	publishN(t, c, 0, 10, "orders")
+	c.Advance(5 * time.Second)
+	checkInvariants(t, c)
+
+	// Dedup state survived the crash: a message node 2 saw before it went
+	// down is not delivered again.
+	if err := c.Node(2).Publish(delivered); err != nil {
+		t.Fatalf("republish: %v", err)
+	}
+	c.Advance(time.Second)
+	c.rec.mu.Lock()
+	n := c.rec.subs[len(c.rec.subs)-1].delivered[delivered.ID]
+	c.rec.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("message delivered %d times after restart, want 0", n)
+	}
+}
+
+func TestSim_DrainHandsOffStuckForwards(t *testing.T) {
+	c := New(t, Config{Nodes: 3, Seed: 5, Options: tolerant})
+	c.Subscribe(0, "orders")
+	c.Subscribe(2, "orders")
+	c.Advance(time.Second)
+
+	// Node 0 cannot reach node 2 directly, so its forwards sit in retry
+	// until the drain hands them to node 1.
+	c.Cut(0, 2)
+	publishN(t, c, 0, 10, "orders")
+	c.Advance(time.Second)
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		drained <- c.Node(0).Drain(ctx)
+	}()
+	var err error
+	c.WaitFor(10*time.Second, func() bool {
+		select {
+		case err = <-drained:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	c.Advance(time.Second)
+	checkInvariants(t, c)
+
+	// Peers saw the departure: node 0's topics are withdrawn and service
+	// registrations on it are redirected.
+	for _, p := range c.Node(1).GetPeers() {
+		if p.NodeID == host(0) && slices.Contains(p.Topics, "orders") {
+			t.Fatal("node 1 still forwards orders to the drained node")
+		}
+	}
+	resp, err := c.Node(0).Register(context.Background(), &pb.RegisterRequest{ServiceName: "billing", ServerName: "b1"})
+	if err != nil {
+		t.Fatalf("register on draining node: %v", err)
+	}
+	if resp.Accepted || resp.RedirectNodeId == "" {
+		t.Fatalf("register on draining node = %+v, want a redirect", resp)
+	}
+}
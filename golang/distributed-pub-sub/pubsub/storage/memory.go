@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -150,7 +151,7 @@ func (d *MemoryDLQ) List(topic string, limit, offset int) ([]*DeadLetter, error)
 	return result, nil
 }
 
-func (d *MemoryDLQ) Retry(id string) (*Message, error) {
+func (d *MemoryDLQ) Get(id string) (*DeadLetter, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -158,6 +159,23 @@ func (d *MemoryDLQ) Retry(id string) (*Message, error) {
 	if !ok {
 		return nil, fmt.Errorf("dead letter %q not found", id)
 	}
+	cp := *dl
+	return &cp, nil
+}
+
+func (d *MemoryDLQ) Delete(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deleteLocked(id)
+	return nil
+}
+
+func (d *MemoryDLQ) deleteLocked(id string) {
+	dl, ok := d.byID[id]
+	if !ok {
+		return
+	}
 
 	// remove from byTopic slice
 	topic := dl.OriginalTopic
@@ -169,14 +187,29 @@ func (d *MemoryDLQ) Retry(id string) (*Message, error) {
 		}
 	}
 	delete(d.byID, id)
+}
+
+func (d *MemoryDLQ) Retry(id string) (*Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl, ok := d.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("dead letter %q not found", id)
+	}
+	d.deleteLocked(id)
 
 	return &Message{
 		ID:          dl.MessageID,
 		Source:      dl.Source,
 		Destination: dl.OriginalTopic,
 		Payload:     dl.Payload,
-		Timestamp:   dl.DeadAt,
+		Timestamp:   dl.Timestamp,
+		ReplyTo:     dl.ReplyTo,
+		StreamID:    dl.StreamID,
 		Attempt:     dl.Attempts,
+		Key:         dl.Key,
+		Tombstone:   dl.Tombstone,
 	}, nil
 }
 
@@ -250,3 +283,108 @@ func (d *MemoryDedup) Close() error {
 	})
 	return nil
 }
+
+// ---------------------------------------------------------------------------
+// MemoryCompaction - map-based latest-value-per-key store
+// ---------------------------------------------------------------------------
+
+// MemoryCompaction implements CompactionStore using in-memory maps.
+type MemoryCompaction struct {
+	mu         sync.Mutex
+	topics     map[string]map[string]*Message  // topic -> key -> latest message
+	tombstones map[string]map[string]tombstone // topic -> key -> deletion
+}
+
+// tombstone records a deleted key of a compacted topic.
+type tombstone struct {
+	timestamp int64     // of the tombstone message
+	deletedAt time.Time // when it was applied here
+}
+
+// NewMemoryCompaction creates a new in-memory compaction store.
+func NewMemoryCompaction() *MemoryCompaction {
+	return &MemoryCompaction{
+		topics:     make(map[string]map[string]*Message),
+		tombstones: make(map[string]map[string]tombstone),
+	}
+}
+
+func (c *MemoryCompaction) Put(topic string, msg *Message) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.topics[topic]
+	if cur, ok := keys[msg.Key]; ok && msg.Timestamp < cur.Timestamp {
+		return false, nil // stale
+	}
+	dead := c.tombstones[topic]
+	if ts, ok := dead[msg.Key]; ok && msg.Timestamp < ts.timestamp {
+		return false, nil // deleted later
+	}
+
+	if msg.Tombstone {
+		delete(keys, msg.Key)
+		if len(keys) == 0 {
+			delete(c.topics, topic)
+		}
+		if dead == nil {
+			dead = make(map[string]tombstone)
+			c.tombstones[topic] = dead
+		}
+		dead[msg.Key] = tombstone{timestamp: msg.Timestamp, deletedAt: time.Now()}
+		return true, nil
+	}
+
+	delete(dead, msg.Key)
+	if len(dead) == 0 {
+		delete(c.tombstones, topic)
+	}
+
+	if keys == nil {
+		keys = make(map[string]*Message)
+		c.topics[topic] = keys
+	}
+	keys[msg.Key] = msg
+	return true, nil
+}
+
+func (c *MemoryCompaction) Snapshot(topic string) ([]*Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.topics[topic]
+	result := make([]*Message, 0, len(keys))
+	for _, msg := range keys {
+		result = append(result, msg)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp < result[j].Timestamp
+	})
+	return result, nil
+}
+
+func (c *MemoryCompaction) PurgeTombstones(olderThan time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	for topic, dead := range c.tombstones {
+		for key, ts := range dead {
+			if ts.deletedAt.Before(cutoff) {
+				delete(dead, key)
+			}
+		}
+		if len(dead) == 0 {
+			delete(c.tombstones, topic)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCompaction) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics = nil
+	c.tombstones = nil
+	return nil
+}
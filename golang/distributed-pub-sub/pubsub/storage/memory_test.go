@@ -134,3 +134,81 @@ func TestMemoryDedup_MarkSeenAndCleanup(t *testing.T) {
 		t.Fatal("MarkSeen after cleanup should return false")
 	}
 }
+
+// --- MemoryCompaction ---
+
+func TestMemoryCompaction_PutSnapshotTombstone(t *testing.T) {
+	c := NewMemoryCompaction()
+
+	c.Put("cfg", &Message{ID: "1", Key: "a", Payload: []byte("v1"), Timestamp: 1})
+	c.Put("cfg", &Message{ID: "2", Key: "b", Payload: []byte("v1"), Timestamp: 2})
+	c.Put("cfg", &Message{ID: "3", Key: "a", Payload: []byte("v2"), Timestamp: 3})
+
+	applied, _ := c.Put("cfg", &Message{ID: "4", Key: "a", Payload: []byte("old"), Timestamp: 0})
+	if applied {
+		t.Fatal("stale put should not be applied")
+	}
+
+	snap, err := c.Snapshot("cfg")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if len(snap) != 2 || snap[0].Key != "b" || snap[1].Key != "a" || string(snap[1].Payload) != "v2" {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	c.Put("cfg", &Message{ID: "5", Key: "b", Tombstone: true, Timestamp: 4})
+	snap, _ = c.Snapshot("cfg")
+	if len(snap) != 1 || snap[0].Key != "a" {
+		t.Fatalf("expected only key a after tombstone, got %+v", snap)
+	}
+}
+
+// checkTombstoneBlocksStalePut verifies a deleted key stays deleted when an
+// older put arrives late, until its tombstone is purged.
+func checkTombstoneBlocksStalePut(t *testing.T, c CompactionStore) {
+	t.Helper()
+
+	c.Put("cfg", &Message{ID: "1", Key: "a", Payload: []byte("v1"), Timestamp: 1})
+	c.Put("cfg", &Message{ID: "2", Key: "a", Tombstone: true, Timestamp: 5})
+
+	applied, err := c.Put("cfg", &Message{ID: "3", Key: "a", Payload: []byte("late"), Timestamp: 3})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if applied {
+		t.Fatal("put older than the tombstone should not be applied")
+	}
+	if snap, _ := c.Snapshot("cfg"); len(snap) != 0 {
+		t.Fatalf("deleted key came back: %+v", snap)
+	}
+
+	// Tombstones within the grace period survive a purge.
+	if err := c.PurgeTombstones(time.Hour); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if applied, _ := c.Put("cfg", &Message{ID: "4", Key: "a", Timestamp: 4}); applied {
+		t.Fatal("tombstone purged inside its grace period")
+	}
+
+	applied, _ = c.Put("cfg", &Message{ID: "5", Key: "a", Payload: []byte("v2"), Timestamp: 6})
+	if !applied {
+		t.Fatal("put newer than the tombstone should be applied")
+	}
+	snap, _ := c.Snapshot("cfg")
+	if len(snap) != 1 || string(snap[0].Payload) != "v2" {
+		t.Fatalf("expected v2 after re-put, got %+v", snap)
+	}
+
+	c.Put("cfg", &Message{ID: "6", Key: "a", Tombstone: true, Timestamp: 7})
+	if err := c.PurgeTombstones(-time.Second); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if applied, _ := c.Put("cfg", &Message{ID: "7", Key: "a", Timestamp: 2}); !applied {
+		t.Fatal("purged tombstone still rejects puts")
+	}
+}
+
+func TestMemoryCompaction_TombstoneBlocksStalePut(t *testing.T) {
+	checkTombstoneBlocksStalePut(t, NewMemoryCompaction())
+}
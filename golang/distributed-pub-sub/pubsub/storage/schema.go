@@ -13,6 +13,8 @@ const CreateQueueTable = `CREATE TABLE IF NOT EXISTS queue_messages (
     reply_to TEXT,
     stream_id TEXT,
     attempt INTEGER DEFAULT 0,
+    msg_key TEXT DEFAULT '',
+    tombstone INTEGER DEFAULT 0,
     created_at INTEGER DEFAULT (strftime('%s','now'))
 );
 CREATE INDEX IF NOT EXISTS idx_queue_topic_sub ON queue_messages(topic, subscriber);`
@@ -27,6 +29,13 @@ const CreateDLQTable = `CREATE TABLE IF NOT EXISTS dlq_messages (
     attempts INTEGER DEFAULT 0,
     dead_at INTEGER,
     message_id TEXT NOT NULL,
+    subscriber TEXT DEFAULT '',
+    peer TEXT DEFAULT '',
+    msg_key TEXT DEFAULT '',
+    tombstone INTEGER DEFAULT 0,
+    reply_to TEXT DEFAULT '',
+    stream_id TEXT DEFAULT '',
+    timestamp INTEGER DEFAULT 0,
     created_at INTEGER DEFAULT (strftime('%s','now'))
 );
 CREATE INDEX IF NOT EXISTS idx_dlq_topic ON dlq_messages(original_topic);
@@ -38,3 +47,38 @@ const CreateSeenTable = `CREATE TABLE IF NOT EXISTS seen_messages (
     seen_at INTEGER DEFAULT (strftime('%s','now'))
 );
 CREATE INDEX IF NOT EXISTS idx_seen_at ON seen_messages(seen_at);`
+
+// CreateCompactedTable defines the DDL for the latest-value-per-key table
+// backing compacted topics.
+const CreateCompactedTable = `CREATE TABLE IF NOT EXISTS compacted_messages (
+    topic TEXT NOT NULL,
+    msg_key TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    source TEXT,
+    payload BLOB,
+    timestamp INTEGER,
+    sequence INTEGER,
+    tombstone INTEGER DEFAULT 0,
+    deleted_at INTEGER DEFAULT 0,
+    PRIMARY KEY (topic, msg_key)
+);
+CREATE INDEX IF NOT EXISTS idx_compacted_topic_ts ON compacted_messages(topic, timestamp);`
+
+// queueMigrations upgrades queue tables created before compacted topics.
+// "duplicate column" errors are expected on up-to-date databases.
+var queueMigrations = []string{
+	`ALTER TABLE queue_messages ADD COLUMN msg_key TEXT DEFAULT ''`,
+	`ALTER TABLE queue_messages ADD COLUMN tombstone INTEGER DEFAULT 0`,
+}
+
+// dlqMigrations upgrades dead-letter tables created before entries recorded
+// where delivery failed and enough of the message to redrive it.
+var dlqMigrations = []string{
+	`ALTER TABLE dlq_messages ADD COLUMN subscriber TEXT DEFAULT ''`,
+	`ALTER TABLE dlq_messages ADD COLUMN peer TEXT DEFAULT ''`,
+	`ALTER TABLE dlq_messages ADD COLUMN msg_key TEXT DEFAULT ''`,
+	`ALTER TABLE dlq_messages ADD COLUMN tombstone INTEGER DEFAULT 0`,
+	`ALTER TABLE dlq_messages ADD COLUMN reply_to TEXT DEFAULT ''`,
+	`ALTER TABLE dlq_messages ADD COLUMN stream_id TEXT DEFAULT ''`,
+	`ALTER TABLE dlq_messages ADD COLUMN timestamp INTEGER DEFAULT 0`,
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,7 +18,7 @@ import (
 // QueueFactory, DLQStore, and DeduplicationStore.
 type SQLiteStorage struct {
 	db *sql.DB
-	mu sync.Mutex // serialise DDL / schema operations
+	mu sync.Mutex // serialise DDL / schema and read-modify-write operations
 
 	// prepared statements for DLQ
 	dlqAdd   *sql.Stmt
@@ -31,6 +32,13 @@ type SQLiteStorage struct {
 	dedupMark    *sql.Stmt
 	dedupCheck   *sql.Stmt
 	dedupCleanup *sql.Stmt
+
+	// prepared statements for compacted topics
+	compactGet       *sql.Stmt
+	compactUpsert    *sql.Stmt
+	compactTombstone *sql.Stmt
+	compactSnapshot  *sql.Stmt
+	compactPurge     *sql.Stmt
 }
 
 // OpenSQLite opens (or creates) a SQLite database at path and initialises the
@@ -47,12 +55,19 @@ func OpenSQLite(path string) (*SQLiteStorage, error) {
 	db.SetMaxOpenConns(1)
 
 	// Create tables.
-	for _, ddl := range []string{CreateQueueTable, CreateDLQTable, CreateSeenTable} {
+	for _, ddl := range []string{CreateQueueTable, CreateDLQTable, CreateSeenTable, CreateCompactedTable} {
 		if _, err := db.Exec(ddl); err != nil {
 			db.Close()
 			return nil, fmt.Errorf("sqlite schema: %w", err)
 		}
 	}
+	migrations := append(queueMigrations, dlqMigrations...)
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("sqlite migrate: %w", err)
+		}
+	}
 
 	s := &SQLiteStorage{db: db}
 	if err := s.prepareStatements(); err != nil {
@@ -67,19 +82,22 @@ func (s *SQLiteStorage) prepareStatements() error {
 
 	// DLQ statements
 	s.dlqAdd, err = s.db.Prepare(`INSERT INTO dlq_messages
-		(original_topic, source, payload, reason, attempts, dead_at, message_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+		(original_topic, source, payload, reason, attempts, dead_at, message_id, subscriber, peer,
+		 msg_key, tombstone, reply_to, stream_id, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return fmt.Errorf("prepare dlqAdd: %w", err)
 	}
 
-	s.dlqList, err = s.db.Prepare(`SELECT id, original_topic, source, payload, reason, attempts, dead_at, message_id
+	s.dlqList, err = s.db.Prepare(`SELECT id, original_topic, source, payload, reason, attempts, dead_at, message_id, subscriber, peer,
+		msg_key, tombstone, reply_to, stream_id, timestamp
 		FROM dlq_messages WHERE original_topic = ? ORDER BY id LIMIT ? OFFSET ?`)
 	if err != nil {
 		return fmt.Errorf("prepare dlqList: %w", err)
 	}
 
-	s.dlqGet, err = s.db.Prepare(`SELECT id, original_topic, source, payload, reason, attempts, dead_at, message_id
+	s.dlqGet, err = s.db.Prepare(`SELECT id, original_topic, source, payload, reason, attempts, dead_at, message_id, subscriber, peer,
+		msg_key, tombstone, reply_to, stream_id, timestamp
 		FROM dlq_messages WHERE id = ?`)
 	if err != nil {
 		return fmt.Errorf("prepare dlqGet: %w", err)
@@ -116,6 +134,37 @@ func (s *SQLiteStorage) prepareStatements() error {
 		return fmt.Errorf("prepare dedupCleanup: %w", err)
 	}
 
+	// Compaction statements
+	s.compactGet, err = s.db.Prepare(`SELECT timestamp FROM compacted_messages WHERE topic = ? AND msg_key = ?`)
+	if err != nil {
+		return fmt.Errorf("prepare compactGet: %w", err)
+	}
+
+	s.compactUpsert, err = s.db.Prepare(`INSERT OR REPLACE INTO compacted_messages
+		(topic, msg_key, message_id, source, payload, timestamp, sequence)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("prepare compactUpsert: %w", err)
+	}
+
+	s.compactTombstone, err = s.db.Prepare(`INSERT OR REPLACE INTO compacted_messages
+		(topic, msg_key, message_id, source, payload, timestamp, sequence, tombstone, deleted_at)
+		VALUES (?, ?, ?, ?, NULL, ?, ?, 1, strftime('%s','now'))`)
+	if err != nil {
+		return fmt.Errorf("prepare compactTombstone: %w", err)
+	}
+
+	s.compactSnapshot, err = s.db.Prepare(`SELECT msg_key, message_id, source, payload, timestamp, sequence
+		FROM compacted_messages WHERE topic = ? AND tombstone = 0 ORDER BY timestamp ASC`)
+	if err != nil {
+		return fmt.Errorf("prepare compactSnapshot: %w", err)
+	}
+
+	s.compactPurge, err = s.db.Prepare(`DELETE FROM compacted_messages WHERE tombstone = 1 AND deleted_at < ?`)
+	if err != nil {
+		return fmt.Errorf("prepare compactPurge: %w", err)
+	}
+
 	return nil
 }
 
@@ -124,6 +173,8 @@ func (s *SQLiteStorage) Close() error {
 	stmts := []*sql.Stmt{
 		s.dlqAdd, s.dlqList, s.dlqGet, s.dlqDel, s.dlqPurge, s.dlqCount,
 		s.dedupCheck, s.dedupMark, s.dedupCleanup,
+		s.compactGet, s.compactUpsert, s.compactTombstone, s.compactSnapshot,
+		s.compactPurge,
 	}
 	for _, st := range stmts {
 		if st != nil {
@@ -179,14 +230,14 @@ func (q *SQLiteQueue) prepare() error {
 		var err error
 
 		q.enqueue, err = q.db.Prepare(`INSERT INTO queue_messages
-			(topic, subscriber, message_id, source, payload, timestamp, sequence, reply_to, stream_id, attempt)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+			(topic, subscriber, message_id, source, payload, timestamp, sequence, reply_to, stream_id, attempt, msg_key, tombstone)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 		if err != nil {
 			firstErr = fmt.Errorf("prepare enqueue: %w", err)
 			return
 		}
 
-		q.dequeue, err = q.db.Prepare(`SELECT id, message_id, source, payload, timestamp, sequence, reply_to, stream_id, attempt
+		q.dequeue, err = q.db.Prepare(`SELECT id, message_id, source, payload, timestamp, sequence, reply_to, stream_id, attempt, msg_key, tombstone
 			FROM queue_messages WHERE topic = ? AND subscriber = ? ORDER BY id ASC LIMIT 1`)
 		if err != nil {
 			firstErr = fmt.Errorf("prepare dequeue: %w", err)
@@ -216,6 +267,7 @@ func (q *SQLiteQueue) Enqueue(msg *Message) error {
 		q.topic, q.subscriber, msg.ID, msg.Source,
 		msg.Payload, msg.Timestamp, msg.Sequence,
 		msg.ReplyTo, msg.StreamID, msg.Attempt,
+		msg.Key, msg.Tombstone,
 	)
 	return err
 }
@@ -233,6 +285,7 @@ func (q *SQLiteQueue) Dequeue() (*Message, error) {
 		&rowID, &msg.ID, &msg.Source, &msg.Payload,
 		&msg.Timestamp, &msg.Sequence, &msg.ReplyTo,
 		&msg.StreamID, &msg.Attempt,
+		&msg.Key, &msg.Tombstone,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -275,6 +328,8 @@ func (s *SQLiteStorage) Add(msg *DeadLetter) error {
 	_, err := s.dlqAdd.Exec(
 		msg.OriginalTopic, msg.Source, msg.Payload,
 		msg.Reason, msg.Attempts, msg.DeadAt, msg.MessageID,
+		msg.Subscriber, msg.Peer,
+		msg.Key, msg.Tombstone, msg.ReplyTo, msg.StreamID, msg.Timestamp,
 	)
 	if err != nil {
 		return fmt.Errorf("dlq add: %w", err)
@@ -295,6 +350,8 @@ func (s *SQLiteStorage) List(topic string, limit, offset int) ([]*DeadLetter, er
 		if err := rows.Scan(
 			&dl.ID, &dl.OriginalTopic, &dl.Source, &dl.Payload,
 			&dl.Reason, &dl.Attempts, &dl.DeadAt, &dl.MessageID,
+			&dl.Subscriber, &dl.Peer,
+			&dl.Key, &dl.Tombstone, &dl.ReplyTo, &dl.StreamID, &dl.Timestamp,
 		); err != nil {
 			return nil, fmt.Errorf("dlq list scan: %w", err)
 		}
@@ -303,24 +360,37 @@ func (s *SQLiteStorage) List(topic string, limit, offset int) ([]*DeadLetter, er
 	return results, rows.Err()
 }
 
-func (s *SQLiteStorage) Retry(id string) (*Message, error) {
-	row := s.dlqGet.QueryRow(id)
-
+func (s *SQLiteStorage) Get(id string) (*DeadLetter, error) {
 	dl := &DeadLetter{}
-	var rowID int64
-	err := row.Scan(
-		&rowID, &dl.OriginalTopic, &dl.Source, &dl.Payload,
+	err := s.dlqGet.QueryRow(id).Scan(
+		&dl.ID, &dl.OriginalTopic, &dl.Source, &dl.Payload,
 		&dl.Reason, &dl.Attempts, &dl.DeadAt, &dl.MessageID,
+		&dl.Subscriber, &dl.Peer,
+		&dl.Key, &dl.Tombstone, &dl.ReplyTo, &dl.StreamID, &dl.Timestamp,
 	)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("dead letter %q not found", id)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("dlq retry scan: %w", err)
+		return nil, fmt.Errorf("dlq get scan: %w", err)
 	}
+	return dl, nil
+}
 
-	if _, err := s.dlqDel.Exec(rowID); err != nil {
-		return nil, fmt.Errorf("dlq retry delete: %w", err)
+func (s *SQLiteStorage) Delete(id string) error {
+	if _, err := s.dlqDel.Exec(id); err != nil {
+		return fmt.Errorf("dlq delete: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Retry(id string) (*Message, error) {
+	dl, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Delete(id); err != nil {
+		return nil, err
 	}
 
 	return &Message{
@@ -328,8 +398,12 @@ func (s *SQLiteStorage) Retry(id string) (*Message, error) {
 		Source:      dl.Source,
 		Destination: dl.OriginalTopic,
 		Payload:     dl.Payload,
-		Timestamp:   dl.DeadAt,
+		Timestamp:   dl.Timestamp,
+		ReplyTo:     dl.ReplyTo,
+		StreamID:    dl.StreamID,
 		Attempt:     dl.Attempts,
+		Key:         dl.Key,
+		Tombstone:   dl.Tombstone,
 	}, nil
 }
 
@@ -379,3 +453,73 @@ func (s *SQLiteStorage) Cleanup(olderThan time.Duration) error {
 	}
 	return nil
 }
+
+// ---------------------------------------------------------------------------
+// CompactionStore implementation
+// ---------------------------------------------------------------------------
+
+// Put stores msg as the latest value for its key, or replaces it with a
+// tombstone row. Writes older than the stored value or tombstone are ignored.
+func (s *SQLiteStorage) Put(topic string, msg *Message) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ts int64
+	err := s.compactGet.QueryRow(topic, msg.Key).Scan(&ts)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return false, fmt.Errorf("compact get: %w", err)
+	case msg.Timestamp < ts:
+		return false, nil // stale
+	}
+
+	if msg.Tombstone {
+		if _, err := s.compactTombstone.Exec(
+			topic, msg.Key, msg.ID, msg.Source, msg.Timestamp, msg.Sequence,
+		); err != nil {
+			return false, fmt.Errorf("compact tombstone: %w", err)
+		}
+		return true, nil
+	}
+
+	_, err = s.compactUpsert.Exec(
+		topic, msg.Key, msg.ID, msg.Source,
+		msg.Payload, msg.Timestamp, msg.Sequence,
+	)
+	if err != nil {
+		return false, fmt.Errorf("compact put: %w", err)
+	}
+	return true, nil
+}
+
+// PurgeTombstones deletes tombstone rows recorded more than olderThan ago.
+func (s *SQLiteStorage) PurgeTombstones(olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan).Unix()
+	if _, err := s.compactPurge.Exec(cutoff); err != nil {
+		return fmt.Errorf("compact purge: %w", err)
+	}
+	return nil
+}
+
+// Snapshot returns the current value of every key on the topic, oldest first.
+func (s *SQLiteStorage) Snapshot(topic string) ([]*Message, error) {
+	rows, err := s.compactSnapshot.Query(topic)
+	if err != nil {
+		return nil, fmt.Errorf("compact snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*Message
+	for rows.Next() {
+		msg := &Message{Destination: topic}
+		if err := rows.Scan(
+			&msg.Key, &msg.ID, &msg.Source, &msg.Payload,
+			&msg.Timestamp, &msg.Sequence,
+		); err != nil {
+			return nil, fmt.Errorf("compact snapshot scan: %w", err)
+		}
+		results = append(results, msg)
+	}
+	return results, rows.Err()
+}
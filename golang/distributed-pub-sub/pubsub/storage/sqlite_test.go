@@ -65,6 +65,10 @@ func TestSQLiteDLQ_AddListRetryPurge(t *testing.T) {
 		MessageID:     "msg1",
 		Payload:       []byte("hello"),
 		Reason:        "failed",
+		Key:           "k",
+		Tombstone:     true,
+		ReplyTo:       "replies",
+		Timestamp:     42,
 	}
 	if err := s.Add(dl); err != nil {
 		t.Fatalf("add: %v", err)
@@ -91,6 +95,9 @@ func TestSQLiteDLQ_AddListRetryPurge(t *testing.T) {
 	if msg.ID != "msg1" {
 		t.Fatalf("retry returned wrong message ID: %s", msg.ID)
 	}
+	if msg.Key != "k" || !msg.Tombstone || msg.ReplyTo != "replies" || msg.Timestamp != 42 {
+		t.Fatalf("retry lost message fields: %+v", msg)
+	}
 
 	count, _ = s.Count("test")
 	if count != 0 {
@@ -130,3 +137,53 @@ func TestSQLiteDedup_MarkSeenAndCleanup(t *testing.T) {
 		t.Fatal("MarkSeen after cleanup should return false")
 	}
 }
+
+func TestSQLiteCompaction_PutSnapshotTombstone(t *testing.T) {
+	s := openTestSQLite(t)
+
+	s.Put("cfg", &Message{ID: "1", Key: "a", Payload: []byte("v1"), Timestamp: 1})
+	s.Put("cfg", &Message{ID: "2", Key: "b", Payload: []byte("v1"), Timestamp: 2})
+	s.Put("cfg", &Message{ID: "3", Key: "a", Payload: []byte("v2"), Timestamp: 3})
+
+	applied, err := s.Put("cfg", &Message{ID: "4", Key: "a", Payload: []byte("old"), Timestamp: 0})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if applied {
+		t.Fatal("stale put should not be applied")
+	}
+
+	snap, err := s.Snapshot("cfg")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if len(snap) != 2 || snap[0].Key != "b" || snap[1].Key != "a" || string(snap[1].Payload) != "v2" {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	s.Put("cfg", &Message{ID: "5", Key: "b", Tombstone: true, Timestamp: 4})
+	snap, _ = s.Snapshot("cfg")
+	if len(snap) != 1 || snap[0].Key != "a" {
+		t.Fatalf("expected only key a after tombstone, got %+v", snap)
+	}
+}
+
+func TestSQLiteQueue_KeyRoundTrip(t *testing.T) {
+	s := openTestSQLite(t)
+	q := s.NewQueueFactory()("cfg", "sub1")
+
+	if err := q.Enqueue(&Message{ID: "m1", Destination: "cfg", Key: "k", Tombstone: true}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	msg, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	if msg.Key != "k" || !msg.Tombstone {
+		t.Fatalf("key/tombstone not preserved: %+v", msg)
+	}
+}
+
+func TestSQLiteCompaction_TombstoneBlocksStalePut(t *testing.T) {
+	checkTombstoneBlocksStalePut(t, openTestSQLite(t))
+}
@@ -14,6 +14,8 @@ type QueueStore interface {
 type DLQStore interface {
 	Add(msg *DeadLetter) error
 	List(topic string, limit, offset int) ([]*DeadLetter, error)
+	Get(id string) (*DeadLetter, error)
+	Delete(id string) error
 	Retry(id string) (*Message, error) // removes from DLQ and returns as Message
 	Purge(topic string) (int, error)   // removes all for topic, returns count
 	Count(topic string) (int, error)
@@ -27,6 +29,21 @@ type DeduplicationStore interface {
 	Close() error
 }
 
+// CompactionStore retains the newest message per key for compacted topics.
+type CompactionStore interface {
+	// Put records msg as the latest value for msg.Key, or removes the key if
+	// msg is a tombstone. A removed key keeps a tombstone with its timestamp.
+	// Messages older than the stored value or tombstone are ignored and
+	// reported as not applied.
+	Put(topic string, msg *Message) (applied bool, err error)
+	Snapshot(topic string) ([]*Message, error) // current values, oldest first
+	// PurgeTombstones forgets tombstones recorded more than olderThan ago.
+	// Puts older than a forgotten tombstone are applied again, so olderThan
+	// must outlast any redelivery or replay.
+	PurgeTombstones(olderThan time.Duration) error
+	Close() error
+}
+
 // QueueFactory creates QueueStore instances per topic+subscriber.
 type QueueFactory func(topic, subscriberID string) QueueStore
 
@@ -41,6 +58,8 @@ type Message struct {
 	ReplyTo     string
 	StreamID    string
 	Attempt     int32
+	Key         string
+	Tombstone   bool
 }
 
 // DeadLetter represents a message that failed delivery.
@@ -53,4 +72,13 @@ type DeadLetter struct {
 	Attempts      int32
 	DeadAt        int64
 	MessageID     string // original message ID
+	Subscriber    string // local subscriber the delivery failed on, if any
+	Peer          string // peer node the forward failed to, if any
+
+	// Kept so a redriven message matches the original.
+	Key       string
+	Tombstone bool
+	ReplyTo   string
+	StreamID  string
+	Timestamp int64
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
 
 	"github.com/google/uuid"
 )
@@ -69,7 +68,7 @@ func (s *Stream) Send(payload []byte) error {
 		Source:      s.node.opts.NodeID,
 		Destination: s.Topic,
 		Payload:     payload,
-		Timestamp:   time.Now().UnixNano(),
+		Timestamp:   s.node.opts.clock().Now().UnixNano(),
 		StreamID:    s.ID,
 	}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"distributed-pub-sub/pubsub/storage"
@@ -24,6 +25,10 @@ type Subscriber struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 	wg      sync.WaitGroup
+
+	// queued counts accepted messages not yet processed, whether in the
+	// channel, the overflow queue or the handler.
+	queued atomic.Int64
 }
 
 // NewSubscriber creates a new Subscriber. The dlq parameter may be nil to disable
@@ -59,6 +64,12 @@ func (s *Subscriber) Stop() {
 // Deliver attempts to send a message to the subscriber's channel.
 // If the channel is full, the message overflows to the persistent queue.
 func (s *Subscriber) Deliver(msg *Message) {
+	// The subscriber records delivery attempts on its message, so it takes
+	// its own copy rather than sharing one with other subscribers and the
+	// forwarding queue.
+	cp := *msg
+	msg = &cp
+	s.queued.Add(1)
 	select {
 	case s.ch <- msg:
 	default:
@@ -66,14 +77,70 @@ func (s *Subscriber) Deliver(msg *Message) {
 		smsg := toStorageMessage(msg)
 		if err := s.queue.Enqueue(smsg); err != nil {
 			log.Printf("[subscriber:%s] overflow enqueue failed: %v", s.ID, err)
+			s.queued.Add(-1)
 		}
 	}
 }
 
+// redeliver queues msg for a fresh round of delivery attempts and calls done
+// with the outcome instead of dead-lettering it again. It waits for room in
+// the channel rather than overflowing, since the overflow queue does not keep
+// done.
+func (s *Subscriber) redeliver(ctx context.Context, msg *Message, done func(error)) error {
+	cp := *msg
+	cp.Attempt = 0
+	cp.redriven = done
+	s.queued.Add(1)
+	select {
+	case s.ch <- &cp:
+		return nil
+	case <-ctx.Done():
+		s.queued.Add(-1)
+		return ctx.Err()
+	case <-s.ctx.Done():
+		s.queued.Add(-1)
+		return s.ctx.Err()
+	}
+}
+
+// takeQueued removes and returns the messages accepted by Deliver that the
+// handler has not started on, so a draining node can hand them to a peer.
+func (s *Subscriber) takeQueued() []*Message {
+	var msgs []*Message
+	for {
+		select {
+		case msg := <-s.ch:
+			msgs = append(msgs, msg)
+			continue
+		default:
+		}
+		break
+	}
+	for {
+		smsg, err := s.queue.Dequeue()
+		if err != nil {
+			log.Printf("[subscriber:%s] overflow dequeue failed: %v", s.ID, err)
+			break
+		}
+		if smsg == nil {
+			break
+		}
+		msgs = append(msgs, fromStorageMessage(smsg))
+	}
+	s.queued.Add(-int64(len(msgs)))
+	return msgs
+}
+
+// Pending returns the number of messages accepted by Deliver that have not
+// yet been handled or dead-lettered.
+func (s *Subscriber) Pending() int64 {
+	return s.queued.Load()
+}
+
 func (s *Subscriber) deliverLoop() {
 	defer s.wg.Done()
 
-	drainTicker := time.NewTicker(100 * time.Millisecond)
+	drainTicker := s.opts.clock().NewTicker(100 * time.Millisecond)
 	defer drainTicker.Stop()
 
 	for {
@@ -82,7 +149,8 @@ func (s *Subscriber) deliverLoop() {
 			return
 		case msg := <-s.ch:
 			s.processMessage(msg)
-		case <-drainTicker.C:
+			s.queued.Add(-1)
+		case <-drainTicker.C():
 			s.drainOverflow()
 		}
 	}
@@ -107,6 +175,7 @@ func (s *Subscriber) drainOverflow() {
 
 		msg := fromStorageMessage(smsg)
 		s.processMessage(msg)
+		s.queued.Add(-1)
 
 		// Check context between messages.
 		select {
@@ -131,6 +200,9 @@ func (s *Subscriber) processMessage(msg *Message) {
 		lastErr = s.handler(msg)
 		if lastErr == nil {
 			s.stats.MessagesDelivered.Add(1)
+			if msg.redriven != nil {
+				msg.redriven(nil)
+			}
 			return
 		}
 
@@ -149,12 +221,19 @@ func (s *Subscriber) processMessage(msg *Message) {
 
 		select {
 		case <-s.ctx.Done():
+			if msg.redriven != nil {
+				msg.redriven(s.ctx.Err())
+			}
 			return
-		case <-time.After(delay):
+		case <-s.opts.clock().After(delay):
 		}
 	}
 
-	// All retries exhausted, send to DLQ.
+	// All retries exhausted. A redriven message is still in the DLQ.
+	if msg.redriven != nil {
+		msg.redriven(lastErr)
+		return
+	}
 	s.sendToDLQ(msg, lastErr)
 }
 
@@ -175,8 +254,14 @@ func (s *Subscriber) sendToDLQ(msg *Message, reason error) {
 		Payload:       msg.Payload,
 		Reason:        reason.Error(),
 		Attempts:      int32(s.opts.MaxRetries),
-		DeadAt:        time.Now().UnixNano(),
+		DeadAt:        s.opts.clock().Now().UnixNano(),
 		MessageID:     msg.ID,
+		Subscriber:    s.ID,
+		Key:           msg.Key,
+		Tombstone:     msg.Tombstone,
+		ReplyTo:       msg.ReplyTo,
+		StreamID:      msg.StreamID,
+		Timestamp:     msg.Timestamp,
 	}
 
 	if err := s.dlq.Add(dl); err != nil {
@@ -196,6 +281,8 @@ func toStorageMessage(msg *Message) *storage.Message {
 		ReplyTo:     msg.ReplyTo,
 		StreamID:    msg.StreamID,
 		Attempt:     msg.Attempt,
+		Key:         msg.Key,
+		Tombstone:   msg.Tombstone,
 	}
 }
 
@@ -211,5 +298,7 @@ func fromStorageMessage(msg *storage.Message) *Message {
 		ReplyTo:     msg.ReplyTo,
 		StreamID:    msg.StreamID,
 		Attempt:     msg.Attempt,
+		Key:         msg.Key,
+		Tombstone:   msg.Tombstone,
 	}
 }
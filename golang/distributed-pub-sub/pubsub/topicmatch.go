@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topic filters follow MQTT rules: levels are separated by '/', '+' matches
+// exactly one level and '#' (only as the last level) matches any number of
+// remaining levels, including none. Subscribing to a filter receives every
+// topic it matches, locally and from peers.
+
+// isWildcard reports whether topic is a filter containing '+' or '#'.
+func isWildcard(topic string) bool {
+	return strings.ContainsAny(topic, "+#")
+}
+
+// validateFilter checks that wildcards occupy whole levels and that '#' is
+// the final level.
+func validateFilter(filter string) error {
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return fmt.Errorf("invalid topic filter %q: '#' must be the last level", filter)
+		case level != "+" && level != "#" && strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("invalid topic filter %q: wildcards must occupy a whole level", filter)
+		}
+	}
+	return nil
+}
+
+// topicMatches reports whether topic matches filter. Filters starting with a
+// wildcard never match internal topics ('_' prefix), just as MQTT keeps '$'
+// topics out of wildcard subscriptions.
+func topicMatches(filter, topic string) bool {
+	if filter == topic {
+		return true
+	}
+	if !isWildcard(filter) {
+		return false
+	}
+	if len(topic) > 0 && topic[0] == '_' && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
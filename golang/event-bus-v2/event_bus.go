@@ -7,7 +7,9 @@ import (
 )
 
 var (
-	ErrClosed = errors.New("event bus closed")
+	ErrClosed           = errors.New("event bus closed")
+	ErrInvalidTopic     = errors.New("invalid topic")
+	ErrSubscriberExists = errors.New("subscriber already exists")
 )
 
 type EventBus[T any] struct {
@@ -2,13 +2,59 @@ package eventbusv2
 
 import (
 	"context"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DeliveryPolicy decides what the bus does when a subscriber's channel is
+// full.
+type DeliveryPolicy int
+
+const (
+	// DeliverDrop drops the event.
+	DeliverDrop DeliveryPolicy = iota
+	// DeliverBlock waits up to the subscription's Timeout for room, then
+	// drops the event. Delivery to every other subscriber waits too.
+	DeliverBlock
+	// DeliverBuffer queues the event without bound and never drops it.
+	DeliverBuffer
+)
+
+type SubscribeOptions struct {
+	Policy DeliveryPolicy
+	// Timeout is how long DeliverBlock waits. Default 1 second.
+	Timeout time.Duration
+	// Size is the channel's buffer. Default 16.
+	Size int
+}
+
+// Receipt reports who a message published with PublishSync was handed to.
+type Receipt struct {
+	Delivered []string
+	Dropped   []string
+}
+
+type subscriber[T any] struct {
+	ch      chan T
+	policy  DeliveryPolicy
+	timeout time.Duration
+	dropped atomic.Uint64
+
+	// DeliverBuffer only: queue is fed by the bus and drained into ch by
+	// pump.
+	mu    sync.Mutex
+	queue []T
+	ready chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
 type MessageBus[T any] struct {
 	incoming    chan Message[T]
 	topics      map[string][]string
-	subscribers map[string]chan T
+	subscribers map[string]*subscriber[T]
 	ctx         context.Context
 	cancel      context.CancelFunc
 	rw          sync.RWMutex
@@ -19,7 +65,7 @@ func NewMessageBus[T any]() *MessageBus[T] {
 
 	ev := &MessageBus[T]{
 		incoming:    make(chan Message[T], 16),
-		subscribers: make(map[string]chan T, 8),
+		subscribers: make(map[string]*subscriber[T], 8),
 		topics:      make(map[string][]string, 8),
 		ctx:         ctx,
 		cancel:      cancel,
@@ -38,29 +84,125 @@ func (ev *MessageBus[T]) run() {
 		case event := <-ev.incoming:
 			ev.rw.RLock()
 
-			if event.dest != "" {
-				if ch, ok := ev.subscribers[event.dest]; ok {
-					select {
-					case ch <- event.payload:
-					default:
-					}
+			receipt := Receipt{}
+			for _, name := range ev.recipients(event) {
+				if ev.deliver(ev.subscribers[name], event.payload) {
+					receipt.Delivered = append(receipt.Delivered, name)
+				} else {
+					receipt.Dropped = append(receipt.Dropped, name)
 				}
 			}
 
-			if event.topic != "" {
-				if subs, ok := ev.topics[event.topic]; ok {
-					for _, name := range subs {
-						if ch, ok := ev.subscribers[name]; ok {
-							select {
-							case ch <- event.payload:
-							default:
-							}
-						}
-					}
-				}
+			ev.rw.RUnlock()
+
+			if event.receipt != nil {
+				event.receipt <- receipt
 			}
+		}
+	}
+}
 
-			ev.rw.RUnlock()
+// recipients returns the subscribers a message goes to, each once.
+func (ev *MessageBus[T]) recipients(event Message[T]) []string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	add := func(name string) {
+		if _, ok := ev.subscribers[name]; ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	if event.dest != "" {
+		add(event.dest)
+	}
+
+	if event.topic != "" {
+		for pattern, subs := range ev.topics {
+			if !matchTopic(pattern, event.topic) {
+				continue
+			}
+
+			for _, name := range subs {
+				add(name)
+			}
+		}
+	}
+
+	return names
+}
+
+func (ev *MessageBus[T]) deliver(sub *subscriber[T], payload T) bool {
+	switch sub.policy {
+	case DeliverBuffer:
+		sub.mu.Lock()
+		sub.queue = append(sub.queue, payload)
+		sub.mu.Unlock()
+
+		select {
+		case sub.ready <- struct{}{}:
+		default:
+		}
+
+		return true
+
+	case DeliverBlock:
+		timer := time.NewTimer(sub.timeout)
+		defer timer.Stop()
+
+		select {
+		case sub.ch <- payload:
+			return true
+		case <-timer.C:
+		case <-ev.ctx.Done():
+		}
+
+	default:
+		select {
+		case sub.ch <- payload:
+			return true
+		default:
+		}
+	}
+
+	sub.dropped.Add(1)
+	return false
+}
+
+// pump moves a DeliverBuffer subscriber's queue into its channel.
+func (ev *MessageBus[T]) pump(sub *subscriber[T]) {
+	defer close(sub.done)
+
+	for {
+		sub.mu.Lock()
+		if len(sub.queue) == 0 {
+			sub.mu.Unlock()
+
+			select {
+			case <-sub.ready:
+				continue
+			case <-sub.stop:
+				return
+			case <-ev.ctx.Done():
+				return
+			}
+		}
+
+		payload := sub.queue[0]
+		sub.mu.Unlock()
+
+		select {
+		case sub.ch <- payload:
+			sub.mu.Lock()
+			var zero T
+			sub.queue[0] = zero
+			sub.queue = sub.queue[1:]
+			sub.mu.Unlock()
+		case <-sub.stop:
+			return
+		case <-ev.ctx.Done():
+			return
 		}
 	}
 }
@@ -79,7 +221,38 @@ func (ev *MessageBus[T]) Stop() error {
 	}
 }
 
+// Subscribe is SubscribeWith using the default options.
+//
+// Subscribing a name that is already subscribed returns ErrSubscriberExists.
+// Earlier versions instead handed out a new channel for the name and added
+// topic to the ones it already had, leaving the old channel open and unfed;
+// callers relying on that must Unsubscribe first, or use a topic pattern
+// covering every topic they want.
 func (ev *MessageBus[T]) Subscribe(name string, topic string) (chan T, error) {
+	return ev.SubscribeWith(name, topic, SubscribeOptions{})
+}
+
+// SubscribeWith subscribes name to direct messages and, unless topic is
+// empty, to a topic. Topics are dot separated; in topic a "*" segment
+// matches any one segment and a final ">" matches one or more. A name
+// already subscribed gets ErrSubscriberExists; Unsubscribe it first.
+func (ev *MessageBus[T]) SubscribeWith(
+	name string,
+	topic string,
+	opts SubscribeOptions,
+) (chan T, error) {
+	if !validTopic(topic) {
+		return nil, ErrInvalidTopic
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+
+	if opts.Size <= 0 {
+		opts.Size = 16
+	}
+
 	ev.rw.Lock()
 	defer ev.rw.Unlock()
 
@@ -89,20 +262,40 @@ func (ev *MessageBus[T]) Subscribe(name string, topic string) (chan T, error) {
 	default:
 	}
 
-	ch := make(chan T, 16)
-	ev.subscribers[name] = ch
+	if _, ok := ev.subscribers[name]; ok {
+		return nil, ErrSubscriberExists
+	}
+
+	sub := &subscriber[T]{
+		ch:      make(chan T, opts.Size),
+		policy:  opts.Policy,
+		timeout: opts.Timeout,
+	}
+
+	if sub.policy == DeliverBuffer {
+		sub.ready = make(chan struct{}, 1)
+		sub.stop = make(chan struct{})
+		sub.done = make(chan struct{})
+		go ev.pump(sub)
+	}
+
+	ev.subscribers[name] = sub
 	if topic != "" {
 		ev.topics[topic] = append(ev.topics[topic], name)
 	}
-	return ch, nil
+	return sub.ch, nil
 }
 
 func (ev *MessageBus[T]) Unsubscribe(name string, ch chan T) {
 	ev.rw.Lock()
 	defer ev.rw.Unlock()
 
-	if _, ok := ev.subscribers[name]; ok {
+	if sub, ok := ev.subscribers[name]; ok {
 		delete(ev.subscribers, name)
+		if sub.stop != nil {
+			close(sub.stop)
+			<-sub.done
+		}
 		close(ch)
 	}
 
@@ -121,6 +314,17 @@ func (ev *MessageBus[T]) Unsubscribe(name string, ch chan T) {
 	}
 }
 
+// Dropped returns how many events a subscriber has had dropped.
+func (ev *MessageBus[T]) Dropped(name string) uint64 {
+	ev.rw.RLock()
+	defer ev.rw.RUnlock()
+
+	if sub, ok := ev.subscribers[name]; ok {
+		return sub.dropped.Load()
+	}
+	return 0
+}
+
 func (ev *MessageBus[T]) Publish(msg Message[T]) error {
 	select {
 	case <-ev.ctx.Done():
@@ -130,10 +334,34 @@ func (ev *MessageBus[T]) Publish(msg Message[T]) error {
 	}
 }
 
+// PublishSync publishes msg and waits until it has been handed to, or
+// dropped for, every subscriber it goes to.
+func (ev *MessageBus[T]) PublishSync(ctx context.Context, msg Message[T]) (Receipt, error) {
+	msg.receipt = make(chan Receipt, 1)
+
+	select {
+	case <-ev.ctx.Done():
+		return Receipt{}, ErrClosed
+	case <-ctx.Done():
+		return Receipt{}, ctx.Err()
+	case ev.incoming <- msg:
+	}
+
+	select {
+	case <-ev.ctx.Done():
+		return Receipt{}, ErrClosed
+	case <-ctx.Done():
+		return Receipt{}, ctx.Err()
+	case receipt := <-msg.receipt:
+		return receipt, nil
+	}
+}
+
 type Message[T any] struct {
 	dest    string
 	topic   string
 	payload T
+	receipt chan Receipt
 }
 
 func NewDirectMessage[T any](dest string, payload T) Message[T] {
@@ -149,3 +377,34 @@ func NewTopicMessage[T any](topic string, payload T) Message[T] {
 		payload: payload,
 	}
 }
+
+func validTopic(topic string) bool {
+	segments := strings.Split(topic, ".")
+	for i, s := range segments {
+		if s == ">" && i != len(segments)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTopic(pattern string, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+
+	ps := strings.Split(pattern, ".")
+	ts := strings.Split(topic, ".")
+
+	for i, p := range ps {
+		if p == ">" {
+			return len(ts) > i
+		}
+
+		if i >= len(ts) || (p != "*" && p != ts[i]) {
+			return false
+		}
+	}
+
+	return len(ps) == len(ts)
+}
@@ -1,3 +1,195 @@
 package eventbusv2_test
 
-import ()
+import (
+	"context"
+	eventbusv2 "event-bus-v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMessageBusWildcards(t *testing.T) {
+	bus := eventbusv2.NewMessageBus[string]()
+	defer bus.Stop()
+
+	subs := map[string]string{
+		"exact": "orders.eu.created",
+		"star":  "orders.*.created",
+		"tail":  "orders.>",
+		"other": "users.>",
+	}
+
+	chans := map[string]chan string{}
+	for name, topic := range subs {
+		ch, err := bus.Subscribe(name, topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chans[name] = ch
+	}
+
+	_, err := bus.Subscribe("bad", "orders.>.created")
+	if err != eventbusv2.ErrInvalidTopic {
+		t.Fatalf("expected ErrInvalidTopic, got %v", err)
+	}
+
+	cases := []struct {
+		topic string
+		want  []string
+	}{
+		{"orders.eu.created", []string{"exact", "star", "tail"}},
+		{"orders.us.created", []string{"star", "tail"}},
+		{"orders.us.deleted", []string{"tail"}},
+		{"orders", nil},
+		{"users.1", []string{"other"}},
+	}
+
+	for _, c := range cases {
+		receipt, err := bus.PublishSync(context.Background(), eventbusv2.NewTopicMessage(c.topic, c.topic))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := receipt.Delivered
+		slices.Sort(got)
+		if !slices.Equal(got, c.want) {
+			t.Fatalf("%s: delivered to %v, want %v", c.topic, got, c.want)
+		}
+
+		for _, name := range c.want {
+			if m := <-chans[name]; m != c.topic {
+				t.Fatalf("%s: %s got %s", c.topic, name, m)
+			}
+		}
+	}
+}
+
+func TestMessageBusPolicies(t *testing.T) {
+	bus := eventbusv2.NewMessageBus[int]()
+	defer bus.Stop()
+
+	drop, _ := bus.SubscribeWith("drop", "n", eventbusv2.SubscribeOptions{
+		Policy: eventbusv2.DeliverDrop,
+		Size:   1,
+	})
+	block, _ := bus.SubscribeWith("block", "n", eventbusv2.SubscribeOptions{
+		Policy:  eventbusv2.DeliverBlock,
+		Timeout: 10 * time.Millisecond,
+		Size:    1,
+	})
+	buffer, _ := bus.SubscribeWith("buffer", "n", eventbusv2.SubscribeOptions{
+		Policy: eventbusv2.DeliverBuffer,
+		Size:   1,
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := bus.PublishSync(context.Background(), eventbusv2.NewTopicMessage("n", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := bus.Dropped("drop"); n != 4 {
+		t.Fatalf("drop: dropped %d, want 4", n)
+	}
+
+	if n := bus.Dropped("block"); n != 4 {
+		t.Fatalf("block: dropped %d, want 4", n)
+	}
+
+	if n := bus.Dropped("buffer"); n != 0 {
+		t.Fatalf("buffer: dropped %d, want 0", n)
+	}
+
+	if m := <-drop; m != 0 {
+		t.Fatalf("drop: got %d, want 0", m)
+	}
+
+	if m := <-block; m != 0 {
+		t.Fatalf("block: got %d, want 0", m)
+	}
+
+	for i := 0; i < 5; i++ {
+		if m := <-buffer; m != i {
+			t.Fatalf("buffer: got %d, want %d", m, i)
+		}
+	}
+
+	// A blocked subscriber gets the event once it makes room in time.
+	go func() {
+		time.Sleep(2 * time.Millisecond)
+		<-block
+	}()
+	block <- -1
+
+	receipt, err := bus.PublishSync(context.Background(), eventbusv2.NewDirectMessage("block", 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(receipt.Delivered, []string{"block"}) {
+		t.Fatalf("block: receipt %+v", receipt)
+	}
+
+	bus.Unsubscribe("buffer", buffer)
+	if _, ok := <-buffer; ok {
+		t.Fatal("buffer: channel open after unsubscribe")
+	}
+}
+
+func TestMessageBusPublishSyncClosed(t *testing.T) {
+	bus := eventbusv2.NewMessageBus[int]()
+	bus.Stop()
+
+	_, err := bus.PublishSync(context.Background(), eventbusv2.NewDirectMessage("a", 1))
+	if err != eventbusv2.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMessageBusDuplicateSubscriber(t *testing.T) {
+	bus := eventbusv2.NewMessageBus[int]()
+	defer bus.Stop()
+
+	ch, err := bus.SubscribeWith("a", "x", eventbusv2.SubscribeOptions{Policy: eventbusv2.DeliverBuffer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = bus.Subscribe("a", "y")
+	if err != eventbusv2.ErrSubscriberExists {
+		t.Fatalf("expected ErrSubscriberExists, got %v", err)
+	}
+
+	// The rejected subscription must not have bound "a" to y.
+	receipt, err := bus.PublishSync(context.Background(), eventbusv2.NewTopicMessage("y", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.Delivered) != 0 {
+		t.Fatalf("y: receipt %+v", receipt)
+	}
+
+	_, err = bus.PublishSync(context.Background(), eventbusv2.NewTopicMessage("x", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := <-ch; m != 2 {
+		t.Fatalf("got %d, want 2", m)
+	}
+
+	bus.Unsubscribe("a", ch)
+
+	ch, err = bus.Subscribe("a", "y")
+	if err != nil {
+		t.Fatalf("resubscribe after unsubscribe: %v", err)
+	}
+
+	receipt, err = bus.PublishSync(context.Background(), eventbusv2.NewTopicMessage("x", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.Delivered) != 0 {
+		t.Fatalf("x after unsubscribe: receipt %+v", receipt)
+	}
+}
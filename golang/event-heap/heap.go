@@ -45,21 +45,20 @@ func (h *EventHeap[K]) Pop() *Event[K] {
 	return evt
 }
 
+// Decrement moves time on by seconds. It pops the due events off the end
+// and then walks every remaining one, so it is O(n) per call; EventWheel
+// avoids the walk.
 func (h *EventHeap[K]) Decrement(seconds int64) []*Event[K] {
 	var ready []*Event[K]
 
-	if len(*h) == 0 {
-		return ready
+	for len(*h) > 0 && (*h)[len(*h)-1].delay <= seconds {
+		evt := h.Pop()
+		evt.delay -= seconds
+		ready = append(ready, evt)
 	}
 
-	for i := range *h {
-		if (*h)[i].delay <= seconds {
-			evt := h.Pop()
-			evt.delay -= seconds
-			ready = append(ready, evt)
-		} else {
-			(*h)[i].delay -= seconds
-		}
+	for _, evt := range *h {
+		evt.delay -= seconds
 	}
 
 	return ready
@@ -0,0 +1,196 @@
+package eventtimer
+
+import (
+	"cmp"
+	"slices"
+)
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+)
+
+// Timers counts down events by the second. EventHeap keeps them sorted and
+// walks every one on Decrement; EventWheel only touches the slots it
+// passes.
+type Timers[K any] interface {
+	Len() int
+	// Min returns the smallest remaining delay, or -1 if there are no
+	// events.
+	Min() int64
+	Push(evt Event[K])
+	// Decrement moves time on by seconds and returns the events that came
+	// due, soonest first, with delay set to how overdue they are (<= 0).
+	Decrement(seconds int64) []*Event[K]
+}
+
+var (
+	_ Timers[struct{}] = (*EventHeap[struct{}])(nil)
+	_ Timers[struct{}] = (*EventWheel[struct{}])(nil)
+)
+
+// EventWheel is a hierarchical timing wheel. Level 0 has a slot per second
+// and each level above a slot per full turn of the one below, so four
+// levels of 64 slots span 2^24 seconds; later events wait in an overflow
+// list. Push is O(1) and Decrement costs the slots it passes plus the
+// events it moves, not the number of events held.
+type EventWheel[K any] struct {
+	now int64 // seconds elapsed
+
+	// Events hold their absolute deadline in delay until they come due.
+	levels   [wheelLevels][wheelSlots][]*Event[K]
+	counts   [wheelLevels]int
+	overflow []*Event[K]
+	due      []*Event[K]
+	n        int
+}
+
+func NewEventWheel[K any]() *EventWheel[K] {
+	return &EventWheel[K]{}
+}
+
+func (w *EventWheel[K]) Len() int {
+	return w.n
+}
+
+func (w *EventWheel[K]) Push(evt Event[K]) {
+	evt.delay += w.now
+	w.place(&evt)
+	w.n++
+}
+
+// place puts e in the due list, a slot or the overflow list, according to
+// its deadline.
+func (w *EventWheel[K]) place(e *Event[K]) {
+	if e.delay <= w.now {
+		w.due = append(w.due, e)
+		return
+	}
+
+	for level := 0; level < wheelLevels; level++ {
+		shift := wheelBits * (level + 1)
+		if e.delay>>shift != w.now>>shift {
+			continue
+		}
+
+		slot := (e.delay >> (wheelBits * level)) & wheelMask
+		w.levels[level][slot] = append(w.levels[level][slot], e)
+		w.counts[level]++
+		return
+	}
+
+	w.overflow = append(w.overflow, e)
+}
+
+// cascade moves the events of a slot down to where they now belong.
+func (w *EventWheel[K]) cascade(level int, events []*Event[K]) {
+	if level >= 0 {
+		w.counts[level] -= len(events)
+	}
+
+	for _, e := range events {
+		w.place(e)
+	}
+}
+
+// step moves on a second, first cascading every level whose turn it starts.
+func (w *EventWheel[K]) step() {
+	w.now++
+
+	if w.now&(1<<(wheelBits*wheelLevels)-1) == 0 {
+		events := w.overflow
+		w.overflow = nil
+		w.cascade(-1, events)
+	}
+
+	for level := wheelLevels - 1; level >= 0; level-- {
+		if w.now&(1<<(wheelBits*level)-1) != 0 {
+			continue
+		}
+
+		slot := (w.now >> (wheelBits * level)) & wheelMask
+		events := w.levels[level][slot]
+		w.levels[level][slot] = nil
+		w.cascade(level, events)
+	}
+}
+
+// Decrement moves time on by seconds, jumping over stretches where nothing
+// is in the lower levels.
+func (w *EventWheel[K]) Decrement(seconds int64) []*Event[K] {
+	target := w.now + seconds
+
+	for w.now < target {
+		empty := 0
+		for empty < wheelLevels && w.counts[empty] == 0 {
+			empty++
+		}
+
+		if empty == wheelLevels && len(w.overflow) == 0 {
+			w.now = target
+			break
+		}
+
+		// Until the next turn of the lowest non-empty level, no slot
+		// below it has anything to expire.
+		if empty > 0 {
+			last := w.now | (1<<(wheelBits*empty) - 1)
+			if last > w.now {
+				w.now = min(last, target)
+				continue
+			}
+		}
+
+		w.step()
+	}
+
+	ready := w.due
+	w.due = nil
+	w.n -= len(ready)
+
+	for _, e := range ready {
+		e.delay -= w.now
+	}
+
+	slices.SortStableFunc(ready, func(a, b *Event[K]) int {
+		return cmp.Compare(a.delay, b.delay)
+	})
+
+	return ready
+}
+
+func (w *EventWheel[K]) Min() int64 {
+	if w.n == 0 {
+		return -1
+	}
+
+	if len(w.due) > 0 {
+		return minDeadline(w.due) - w.now
+	}
+
+	for level := 0; level < wheelLevels; level++ {
+		if w.counts[level] == 0 {
+			continue
+		}
+
+		digit := (w.now >> (wheelBits * level)) & wheelMask
+		for slot := digit + 1; slot < wheelSlots; slot++ {
+			if len(w.levels[level][slot]) > 0 {
+				return minDeadline(w.levels[level][slot]) - w.now
+			}
+		}
+	}
+
+	return minDeadline(w.overflow) - w.now
+}
+
+func minDeadline[K any](events []*Event[K]) int64 {
+	m := events[0].delay
+	for _, e := range events[1:] {
+		m = min(m, e.delay)
+	}
+
+	return m
+}
@@ -0,0 +1,83 @@
+package eventtimer
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// TestTimersAgree drives the heap and the wheel through the same pushes and
+// decrements and checks they hand out the same events.
+func TestTimersAgree(t *testing.T) {
+	heap := &EventHeap[int]{}
+	wheel := NewEventWheel[int]()
+
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		if rng.Intn(10) < 7 {
+			// Spread delays over every level of the wheel and past it.
+			delay := rng.Int63n(int64(1) << rng.Intn(26))
+
+			heap.Push(Event[int]{key: i, delay: delay})
+			wheel.Push(Event[int]{key: i, delay: delay})
+		} else {
+			seconds := rng.Int63n(int64(1) << rng.Intn(20))
+
+			h, w := heap.Decrement(seconds), wheel.Decrement(seconds)
+			if len(h) != len(w) {
+				t.Fatalf("decrement %d: heap %d events, wheel %d", seconds, len(h), len(w))
+			}
+
+			for j := range h {
+				if h[j].delay != w[j].delay {
+					t.Fatalf("decrement %d: event %d heap delay %d, wheel %d", seconds, j, h[j].delay, w[j].delay)
+				}
+			}
+		}
+
+		if heap.Len() != wheel.Len() {
+			t.Fatalf("len: heap %d, wheel %d", heap.Len(), wheel.Len())
+		}
+		if heap.Min() != wheel.Min() {
+			t.Fatalf("min: heap %d, wheel %d", heap.Min(), wheel.Min())
+		}
+	}
+}
+
+var backends = []struct {
+	name string
+	new  func() Timers[int]
+}{
+	{"heap", func() Timers[int] { return &EventHeap[int]{} }},
+	{"wheel", func() Timers[int] { return NewEventWheel[int]() }},
+}
+
+// BenchmarkDecrement counts down n events spread over an hour, a second at
+// a time.
+func BenchmarkDecrement(b *testing.B) {
+	for _, n := range []int{1e3, 1e5} {
+		rng := rand.New(rand.NewSource(1))
+		delays := make([]int64, n)
+		for i := range delays {
+			delays[i] = rng.Int63n(3600)
+		}
+
+		for _, backend := range backends {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					timers := backend.new()
+					for key, delay := range delays {
+						timers.Push(Event[int]{key: key, delay: delay})
+					}
+					b.StartTimer()
+
+					for s := 0; s <= 3600; s++ {
+						timers.Decrement(1)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyDecay is the weight of a new sample in a server's latency EWMA.
+const latencyDecay = 0.2
+
+// Healthy reports whether the server passes its health checks and is not
+// ejected. A server never checked counts as healthy.
+func (s *Server) Healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().Before(s.ejectedUntil) {
+		return false
+	}
+
+	return s.IsHealthy || s.LastHealthCheck.IsZero()
+}
+
+// ActiveConnections returns how many requests the server is handling.
+func (s *Server) ActiveConnections() int64 {
+	return s.active.Load()
+}
+
+// Latency returns the moving average of the server's response times.
+func (s *Server) Latency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Duration(s.latency)
+}
+
+// begin counts a request to the server and returns the func to call with
+// its outcome once the response headers or an error arrive. The request
+// stays counted until end is called.
+func (s *Server) begin() func(ctx context.Context, err error) {
+	s.active.Add(1)
+	start := time.Now()
+
+	return func(ctx context.Context, err error) {
+		// A request canceled by the client or a winning hedge says nothing
+		// about the server; one that ran out its deadline does.
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			return
+		}
+
+		s.observe(time.Since(start), err)
+	}
+}
+
+// end stops counting a request begin counted.
+func (s *Server) end() {
+	s.active.Add(-1)
+}
+
+// observe records a proxied request, ejecting the server after too many
+// errors in a row.
+func (s *Server) observe(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.errors = 0
+
+		if s.latency == 0 {
+			s.latency = float64(latency)
+		} else {
+			s.latency += (float64(latency) - s.latency) * latencyDecay
+		}
+		return
+	}
+
+	s.errors++
+
+	if s.pool == nil || s.pool.MaxFails <= 0 || s.errors < s.pool.MaxFails {
+		return
+	}
+
+	s.errors = 0
+	s.ejectedUntil = time.Now().Add(s.pool.EjectFor)
+	log.Println("load balancer - ejected", s.Name, "for", s.pool.EjectFor)
+}
+
+func (s *Server) baseURL() string {
+	if s.URL != "" {
+		return s.URL
+	}
+
+	return fmt.Sprintf("%s://%s", s.Protocol, net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+}
+
+func (s *Server) address() (string, error) {
+	if s.Host != "" {
+		return net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), nil
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+type HealthCheckConfig struct {
+	// Path is fetched with GET from each server and passes on a 2xx or 3xx
+	// answer. Left empty, the check only opens a TCP connection.
+	Path string
+	// Default 10 seconds.
+	Interval time.Duration
+	// Default 2 seconds.
+	Timeout time.Duration
+	// Checks in a row that mark a server healthy or unhealthy, after the
+	// first decides it outright. Defaults 2 and 3.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// HealthChecker probes every server in a pool in the background and keeps
+// their IsHealthy and LastHealthCheck up to date.
+type HealthChecker struct {
+	pool   *ServerPool
+	config HealthCheckConfig
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewHealthChecker(pool *ServerPool, config HealthCheckConfig) *HealthChecker {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * time.Second
+	}
+
+	if config.HealthyThreshold <= 0 {
+		config.HealthyThreshold = 2
+	}
+
+	if config.UnhealthyThreshold <= 0 {
+		config.UnhealthyThreshold = 3
+	}
+
+	// The context is made here rather than in Start so checks can run
+	// before Start. Once stopped, a checker is not started again.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &HealthChecker{
+		pool:   pool,
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Start checks every server now and then every Interval.
+func (hc *HealthChecker) Start() {
+	hc.wg.Add(1)
+	go func() {
+		defer hc.wg.Done()
+
+		ticker := time.NewTicker(hc.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			hc.checkAll()
+
+			select {
+			case <-hc.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (hc *HealthChecker) Stop() {
+	hc.cancel()
+	hc.wg.Wait()
+}
+
+func (hc *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+
+	for _, s := range hc.pool.GetAllServers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hc.record(s, hc.probe(s))
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (hc *HealthChecker) probe(s *Server) error {
+	ctx, cancel := context.WithTimeout(hc.ctx, hc.config.Timeout)
+	defer cancel()
+
+	if hc.config.Path == "" {
+		addr, err := s.address()
+		if err != nil {
+			return err
+		}
+
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	target, err := url.JoinPath(s.baseURL(), hc.config.Path)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("health check returned %s", res.Status)
+	}
+
+	return nil
+}
+
+func (hc *HealthChecker) record(s *Server, err error) {
+	// A check cut short by Stop says nothing about the server.
+	if hc.ctx.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := s.LastHealthCheck.IsZero()
+	s.LastHealthCheck = time.Now()
+
+	if err != nil {
+		s.passes = 0
+		s.fails++
+	} else {
+		s.fails = 0
+		s.passes++
+	}
+
+	healthy := s.IsHealthy
+	switch {
+	case first:
+		healthy = err == nil
+	case s.fails >= hc.config.UnhealthyThreshold:
+		healthy = false
+	case s.passes >= hc.config.HealthyThreshold:
+		healthy = true
+	}
+
+	if healthy != s.IsHealthy || first {
+		if healthy {
+			log.Println("load balancer - server", s.Name, "healthy")
+		} else {
+			log.Println("load balancer - server", s.Name, "unhealthy:", err)
+		}
+	}
+
+	s.IsHealthy = healthy
+}
@@ -0,0 +1,110 @@
+package loadbalancer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthThresholds(t *testing.T) {
+	var status atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer backend.Close()
+
+	pool := NewServerPool()
+	s := &Server{Name: "a", URL: backend.URL}
+	pool.AddServer(s)
+
+	hc := NewHealthChecker(pool, HealthCheckConfig{
+		Path:               "/health",
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	})
+	defer hc.Stop()
+
+	steps := []struct {
+		status  int
+		healthy bool
+	}{
+		// The first check decides outright.
+		{http.StatusOK, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusOK, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusOK, false},
+		{http.StatusOK, true},
+		{http.StatusMovedPermanently, true},
+	}
+
+	for i, step := range steps {
+		status.Store(int32(step.status))
+		hc.checkAll()
+
+		if s.Healthy() != step.healthy {
+			t.Fatalf("check %d (%d): healthy = %v, want %v", i, step.status, s.Healthy(), step.healthy)
+		}
+	}
+}
+
+func TestHealthCheckTCP(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+
+	pool := NewServerPool()
+	s := &Server{Name: "a", URL: backend.URL}
+	pool.AddServer(s)
+
+	hc := NewHealthChecker(pool, HealthCheckConfig{UnhealthyThreshold: 1})
+	defer hc.Stop()
+
+	// Without a Path only the connection counts, not the 404.
+	hc.checkAll()
+	if !s.Healthy() {
+		t.Fatal("reachable server marked unhealthy")
+	}
+
+	backend.Close()
+	hc.checkAll()
+	if s.Healthy() {
+		t.Fatal("closed server marked healthy")
+	}
+}
+
+func TestPassiveEjection(t *testing.T) {
+	pool := NewServerPool()
+	pool.MaxFails = 2
+	pool.EjectFor = 50 * time.Millisecond
+
+	s := &Server{Name: "a", URL: "http://127.0.0.1:1"}
+	pool.AddServer(s)
+
+	failed := errors.New("refused")
+
+	// A success in between starts the count again.
+	s.observe(time.Millisecond, failed)
+	s.observe(time.Millisecond, nil)
+	s.observe(time.Millisecond, failed)
+	if !s.Healthy() {
+		t.Fatal("ejected without MaxFails errors in a row")
+	}
+
+	s.observe(time.Millisecond, failed)
+	if s.Healthy() {
+		t.Fatal("not ejected after MaxFails errors in a row")
+	}
+
+	if _, err := pool.candidates(); err != ErrNoHealthyServers {
+		t.Fatalf("candidates: %v, want ErrNoHealthyServers", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !s.Healthy() {
+		t.Fatal("still ejected after EjectFor")
+	}
+}
@@ -1,20 +1,33 @@
 package loadbalancer
 
 import (
+	"context"
 	"errors"
-	"io"
+	"fmt"
 	"log"
-	"maps"
 	"net/http"
-	"net/url"
+	"net/http/httputil"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrNoServers        = errors.New("no servers found")
+	ErrNoHealthyServers = errors.New("no healthy servers")
+)
+
 type ServerPool struct {
 	Servers []*Server
+	// MaxFails proxy errors in a row eject a server for EjectFor. Zero
+	// turns passive ejection off. Defaults 5 and 30 seconds.
+	MaxFails int
+	EjectFor time.Duration
+
+	mu sync.RWMutex
 }
 type Server struct {
 	Name            string
@@ -24,21 +37,70 @@ type Server struct {
 	URL             string
 	IsHealthy       bool
 	LastHealthCheck time.Time
+	// Weight is the server's share of requests under WeightedRoundRobin.
+	// Default 1.
+	Weight int
+
+	pool *ServerPool
+	mu   sync.Mutex
+	// consecutive health check results and proxy errors
+	passes       int
+	fails        int
+	errors       int
+	ejectedUntil time.Time
+	active       atomic.Int64
+	latency      float64 // EWMA, nanoseconds
 }
 
 func NewServerPool() *ServerPool {
 	return &ServerPool{
-		Servers: make([]*Server, 0),
+		Servers:  make([]*Server, 0),
+		MaxFails: 5,
+		EjectFor: 30 * time.Second,
 	}
 }
 
 func (p *ServerPool) AddServer(server *Server) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	server.pool = p
 	p.Servers = append(p.Servers, server)
 	return nil
 }
 
 func (p *ServerPool) GetAllServers() []*Server {
-	return p.Servers
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return slices.Clone(p.Servers)
+}
+
+// HealthyServers returns the servers that are healthy and not ejected.
+func (p *ServerPool) HealthyServers() []*Server {
+	servers := p.GetAllServers()
+
+	healthy := make([]*Server, 0, len(servers))
+	for _, s := range servers {
+		if s.Healthy() {
+			healthy = append(healthy, s)
+		}
+	}
+
+	return healthy
+}
+
+// candidates returns the servers a strategy may pick from.
+func (p *ServerPool) candidates() ([]*Server, error) {
+	servers := p.HealthyServers()
+	if len(servers) == 0 {
+		if len(p.GetAllServers()) == 0 {
+			return nil, ErrNoServers
+		}
+		return nil, ErrNoHealthyServers
+	}
+
+	return servers, nil
 }
 
 type RoundRobin struct {
@@ -55,9 +117,9 @@ func NewRoundRobin(pool *ServerPool) *RoundRobin {
 }
 
 func (rr *RoundRobin) GetNextServer() (*Server, error) {
-	servers := rr.pool.GetAllServers()
-	if len(servers) == 0 {
-		return nil, errors.New("no servers found")
+	servers, err := rr.pool.candidates()
+	if err != nil {
+		return nil, err
 	}
 
 	rr.mu.Lock()
@@ -74,65 +136,139 @@ type Strategy interface {
 	GetNextServer() (*Server, error)
 }
 
+// Route holds the proxy settings for requests whose path starts with
+// Prefix.
+type Route struct {
+	Prefix string
+	// Timeout bounds a request, retries included. Zero means none. Upgraded
+	// connections are not bound by it.
+	Timeout time.Duration
+	// Retries is how many more servers an idempotent request without a
+	// body is tried on when one fails.
+	Retries int
+	// HedgeAfter, when set, also sends such a request to another server if
+	// the first has not answered after this long, and takes whichever
+	// answers first. The hedge counts as a retry.
+	HedgeAfter time.Duration
+}
+
 type LoadBalancer struct {
-	strategy Strategy
+	// Name identifies the load balancer in Via headers.
+	Name string
+	// DefaultRoute applies to requests no added route matches. Default a
+	// 30 second timeout and 2 retries.
+	DefaultRoute Route
+
+	strategy  Strategy
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+	routes    []Route
+	mu        sync.RWMutex
 }
 
 func NewLoadBalancer(strategy Strategy) *LoadBalancer {
-	return &LoadBalancer{
-		strategy: strategy,
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 64
+	transport.ForceAttemptHTTP2 = true
+
+	lb := &LoadBalancer{
+		Name: "load-balancer",
+		DefaultRoute: Route{
+			Timeout: 30 * time.Second,
+			Retries: 2,
+		},
+		strategy:  strategy,
+		transport: transport,
 	}
+
+	lb.proxy = &httputil.ReverseProxy{
+		Rewrite:        lb.rewrite,
+		Transport:      &proxyTransport{lb: lb},
+		ModifyResponse: lb.modifyResponse,
+		ErrorHandler:   lb.proxyError,
+	}
+
+	return lb
 }
 
-func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	server, err := lb.strategy.GetNextServer()
-	if err != nil {
-		log.Println(err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+// AddRoute adds a route; the one with the longest matching prefix wins.
+func (lb *LoadBalancer) AddRoute(route Route) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.routes = append(lb.routes, route)
+	slices.SortStableFunc(lb.routes, func(a, b Route) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+}
+
+func (lb *LoadBalancer) route(path string) Route {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	for _, route := range lb.routes {
+		if strings.HasPrefix(path, route.Prefix) {
+			return route
+		}
 	}
 
-	targetUrl, err := url.Parse(server.URL)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Invalid backend URL", http.StatusInternalServerError)
-		return
+	return lb.DefaultRoute
+}
+
+type routeKey struct{}
+
+func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := lb.route(r.URL.Path)
+	ctx := context.WithValue(r.Context(), routeKey{}, route)
+
+	if route.Timeout > 0 && !isUpgrade(r.Header) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+		defer cancel()
 	}
 
-	targetPath := strings.TrimRight(targetUrl.String(), "/") + r.URL.Path
-	if r.URL.RawQuery == "" {
-		targetPath += "?" + r.URL.RawQuery
+	lb.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// rewrite prepares the outgoing request; the server it goes to is picked
+// per attempt by proxyTransport.
+func (lb *LoadBalancer) rewrite(pr *httputil.ProxyRequest) {
+	// ReverseProxy drops the inbound X-Forwarded-For before Rewrite; keep
+	// the chain so SetXForwarded appends the client to it.
+	if xff := pr.In.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		pr.Out.Header["X-Forwarded-For"] = xff
 	}
+	pr.SetXForwarded()
+	pr.Out.Header.Add("Via", via(pr.In.ProtoMajor, pr.In.ProtoMinor, lb.Name))
 
-	req, err := http.NewRequest(r.Method, targetPath, r.Body)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Failed to forward request", http.StatusInternalServerError)
-		return
+	if pr.Out.Header.Get("X-Request-ID") == "" {
+		pr.Out.Header.Set("X-Request-ID", strconv.FormatInt(time.Now().UnixMilli(), 10))
 	}
+}
 
-	maps.Copy(req.Header, r.Header)
+func (lb *LoadBalancer) modifyResponse(res *http.Response) error {
+	res.Header.Add("Via", via(res.ProtoMajor, res.ProtoMinor, lb.Name))
+	return nil
+}
 
-	req.Header.Set("X-Forwarded-For", r.RemoteAddr)
-	req.Header.Set("X-Request-ID", strconv.FormatInt(time.Now().UnixMilli(), 10))
+func (lb *LoadBalancer) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Println("load balancer -", r.Method, r.URL.Path, err)
 
-	client := &http.Client{
-		Timeout: time.Second * 30,
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, ErrNoServers), errors.Is(err, ErrNoHealthyServers):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
 	}
 
-	res, err := client.Do(req)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Failed to forward request", http.StatusInternalServerError)
-	}
-	defer res.Body.Close()
+	http.Error(w, http.StatusText(status), status)
+}
 
-	for k, values := range res.Header {
-		for _, value := range values {
-			w.Header().Add(k, value)
-		}
+func via(major, minor int, name string) string {
+	if major >= 2 {
+		return fmt.Sprintf("%d %s", major, name)
 	}
 
-	w.WriteHeader(res.StatusCode)
-	io.Copy(w, res.Body)
+	return fmt.Sprintf("%d.%d %s", major, minor, name)
 }
@@ -0,0 +1,288 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNoUntried = errors.New("no servers left to try")
+
+// proxyTransport sends the requests of a LoadBalancer's ReverseProxy to the
+// servers its strategy picks, retrying and hedging them as their route
+// allows.
+type proxyTransport struct {
+	lb *LoadBalancer
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route, _ := req.Context().Value(routeKey{}).(Route)
+	tried := map[*Server]bool{}
+
+	if !replayable(req) {
+		s, err := t.pick(tried)
+		if err != nil {
+			return nil, err
+		}
+		return t.send(req, s)
+	}
+
+	if route.HedgeAfter > 0 && route.Retries > 0 && !isUpgrade(req.Header) {
+		return t.hedged(req, route)
+	}
+
+	var lastErr error
+
+	for attempt := 0; attempt <= route.Retries; attempt++ {
+		s, err := t.pick(tried)
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		tried[s] = true
+
+		res, err := t.send(req, s)
+		if err == nil {
+			return res, nil
+		}
+
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+type attempt struct {
+	i   int
+	res *http.Response
+	err error
+}
+
+// hedged sends req to one server, then to another whenever the last has
+// not answered within HedgeAfter or has failed, until one answers or the
+// route's retries run out.
+func (t *proxyTransport) hedged(req *http.Request, route Route) (*http.Response, error) {
+	results := make(chan attempt, route.Retries+1)
+	cancels := []context.CancelFunc{}
+	tried := map[*Server]bool{}
+
+	launch := func() error {
+		s, err := t.pick(tried)
+		if err != nil {
+			return err
+		}
+		tried[s] = true
+
+		ctx, cancel := context.WithCancel(req.Context())
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+
+		go func() {
+			res, err := t.send(req.WithContext(ctx), s)
+			results <- attempt{i: i, res: res, err: err}
+		}()
+
+		return nil
+	}
+
+	lastErr := launch()
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	pending := 1
+
+	timer := time.NewTimer(route.HedgeAfter)
+	defer timer.Stop()
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) <= route.Retries && launch() == nil {
+				pending++
+				timer.Reset(route.HedgeAfter)
+			}
+
+		case a := <-results:
+			pending--
+
+			if a.err == nil {
+				for i, cancel := range cancels {
+					if i != a.i {
+						cancel()
+					}
+				}
+
+				go discard(results, pending)
+
+				a.res.Body = &cancelBody{ReadCloser: a.res.Body, cancel: cancels[a.i]}
+				return a.res, nil
+			}
+
+			cancels[a.i]()
+			lastErr = a.err
+
+			if req.Context().Err() == nil && len(cancels) <= route.Retries && launch() == nil {
+				pending++
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// discard closes the responses of attempts that lost a hedge.
+func discard(results <-chan attempt, n int) {
+	for range n {
+		a := <-results
+		if a.res != nil {
+			a.res.Body.Close()
+		}
+	}
+}
+
+// cancelBody releases a hedged attempt's context once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// pick returns a server the request has not been tried on.
+func (t *proxyTransport) pick(tried map[*Server]bool) (*Server, error) {
+	for range 2 * (len(tried) + 1) {
+		s, err := t.lb.strategy.GetNextServer()
+		if err != nil {
+			return nil, err
+		}
+
+		if !tried[s] {
+			return s, nil
+		}
+	}
+
+	return nil, errNoUntried
+}
+
+// send makes one attempt of req at s.
+func (t *proxyTransport) send(req *http.Request, s *Server) (*http.Response, error) {
+	target, err := url.Parse(s.baseURL())
+	if err != nil {
+		return nil, err
+	}
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = target.Scheme
+	out.URL.Host = target.Host
+	out.URL.Path, out.URL.RawPath = joinURLPath(target, req.URL)
+	out.Host = ""
+
+	observe := s.begin()
+	res, err := t.lb.transport.RoundTrip(out)
+	observe(out.Context(), err)
+
+	if err != nil {
+		s.end()
+		return nil, err
+	}
+
+	res.Body = endOnClose(res.Body, s.end)
+
+	return res, nil
+}
+
+// endBody calls end once the response body is closed, so a server counts a
+// request as active until its body has been read or abandoned.
+type endBody struct {
+	io.ReadCloser
+	once sync.Once
+	end  func()
+}
+
+func (b *endBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.end)
+	return err
+}
+
+// endConn is endBody for the connection of a 101 Switching Protocols
+// response, which ReverseProxy needs to write to as well.
+type endConn struct {
+	io.ReadWriteCloser
+	once sync.Once
+	end  func()
+}
+
+func (c *endConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.end)
+	return err
+}
+
+func endOnClose(body io.ReadCloser, end func()) io.ReadCloser {
+	if conn, ok := body.(io.ReadWriteCloser); ok {
+		return &endConn{ReadWriteCloser: conn, end: end}
+	}
+
+	return &endBody{ReadCloser: body, end: end}
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.Path == "" || a.Path == "/" {
+		return b.Path, b.RawPath
+	}
+
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	joined := strings.TrimSuffix(apath, "/") + "/" + strings.TrimPrefix(bpath, "/")
+	path, err := url.PathUnescape(joined)
+	if err != nil {
+		return b.Path, b.RawPath
+	}
+
+	if path == joined {
+		return path, ""
+	}
+	return path, joined
+}
+
+// replayable reports whether req may be sent again after a failed attempt:
+// its method is idempotent and it has no body to resend.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+func isUpgrade(h http.Header) bool {
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
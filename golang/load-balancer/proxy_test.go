@@ -0,0 +1,360 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBackend starts a server for handler and returns it as a pool
+// member.
+func newTestBackend(t *testing.T, name string, handler http.HandlerFunc) *Server {
+	t.Helper()
+
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+
+	return &Server{Name: name, URL: backend.URL}
+}
+
+// newTestProxy puts servers behind a round robin load balancer, in order,
+// and returns its URL.
+func newTestProxy(t *testing.T, route Route, servers ...*Server) (*LoadBalancer, string) {
+	t.Helper()
+
+	pool := NewServerPool()
+	for _, s := range servers {
+		pool.AddServer(s)
+	}
+
+	lb := NewLoadBalancer(NewRoundRobin(pool))
+	lb.DefaultRoute = route
+
+	front := httptest.NewServer(lb)
+	t.Cleanup(front.Close)
+
+	return lb, front.URL
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestActiveUntilBodyClosed(t *testing.T) {
+	release := make(chan struct{})
+
+	s := newTestBackend(t, "a", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("head "))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("tail"))
+	})
+	_, url := newTestProxy(t, Route{Timeout: 5 * time.Second}, s)
+
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	// Runs before the backend is closed, which waits for the handler.
+	t.Cleanup(unblock)
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// Headers have arrived but the body is still streaming.
+	if n := s.ActiveConnections(); n != 1 {
+		t.Fatalf("active = %d while the body streams, want 1", n)
+	}
+
+	unblock()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "head tail" {
+		t.Fatalf("body %q", body)
+	}
+
+	waitFor(t, func() bool { return s.ActiveConnections() == 0 })
+}
+
+func TestHedgeLoserNotCountedAsFailure(t *testing.T) {
+	slow := newTestBackend(t, "slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	fast := newTestBackend(t, "fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+
+	lb, url := newTestProxy(t, Route{Timeout: 5 * time.Second, Retries: 1, HedgeAfter: 20 * time.Millisecond}, slow, fast)
+	lb.strategy.(*RoundRobin).pool.MaxFails = 1
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "fast" {
+		t.Fatalf("body %q, want the hedge's", body)
+	}
+
+	// The canceled first attempt must not eject slow.
+	waitFor(t, func() bool { return slow.ActiveConnections() == 0 })
+	if !slow.Healthy() {
+		t.Fatal("hedge loser ejected")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	cases := []struct {
+		method string
+		status int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusBadGateway},
+	}
+
+	for _, c := range cases {
+		t.Run(c.method, func(t *testing.T) {
+			// Nothing listens on down's port any more.
+			dead := httptest.NewServer(http.NotFoundHandler())
+			dead.Close()
+			down := &Server{Name: "down", URL: dead.URL}
+
+			up := newTestBackend(t, "up", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("up"))
+			})
+
+			_, url := newTestProxy(t, Route{Timeout: 5 * time.Second, Retries: 1}, down, up)
+
+			req, _ := http.NewRequest(c.method, url, nil)
+			if c.method == http.MethodPost {
+				req.Body = io.NopCloser(strings.NewReader("order"))
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != c.status {
+				t.Fatalf("status %d, want %d", res.StatusCode, c.status)
+			}
+		})
+	}
+}
+
+func TestHedgeWins(t *testing.T) {
+	canceled := make(chan struct{})
+
+	slow := newTestBackend(t, "slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("slow"))
+		}
+	})
+	fast := newTestBackend(t, "fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+
+	_, url := newTestProxy(t, Route{Timeout: 10 * time.Second, Retries: 1, HedgeAfter: 20 * time.Millisecond}, slow, fast)
+
+	start := time.Now()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "fast" || time.Since(start) > time.Second {
+		t.Fatalf("body %q after %v, want the hedge's", body, time.Since(start))
+	}
+
+	// The losing attempt is torn down rather than left to finish.
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("losing attempt not canceled")
+	}
+
+	waitFor(t, func() bool {
+		return slow.ActiveConnections() == 0 && fast.ActiveConnections() == 0
+	})
+}
+
+func TestErrorStatus(t *testing.T) {
+	t.Run("route timeout", func(t *testing.T) {
+		s := newTestBackend(t, "a", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+
+		lb, url := newTestProxy(t, Route{Timeout: 5 * time.Second}, s)
+		lb.AddRoute(Route{Prefix: "/slow", Timeout: 50 * time.Millisecond})
+
+		res, err := http.Get(url + "/slow/report")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("status %d, want 504", res.StatusCode)
+		}
+	})
+
+	t.Run("no servers", func(t *testing.T) {
+		_, url := newTestProxy(t, Route{Timeout: 5 * time.Second})
+
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("status %d, want 503", res.StatusCode)
+		}
+	})
+}
+
+func TestProxyHeaders(t *testing.T) {
+	var got http.Header
+
+	s := newTestBackend(t, "a", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Backend", "1")
+	})
+	_, url := newTestProxy(t, Route{Timeout: 5 * time.Second}, s)
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	req.Header.Set("X-Client", "1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("Via", "1.1 edge")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	for _, h := range []string{"X-Client-Hop", "Proxy-Authorization"} {
+		if v := got.Get(h); v != "" {
+			t.Errorf("backend got hop-by-hop %s: %q", h, v)
+		}
+	}
+	if got.Get("X-Client") != "1" {
+		t.Error("backend lost X-Client")
+	}
+
+	if xff := got.Get("X-Forwarded-For"); xff != "203.0.113.7, 127.0.0.1" {
+		t.Errorf("X-Forwarded-For %q", xff)
+	}
+	if via := got.Values("Via"); strings.Join(via, ", ") != "1.1 edge, 1.1 load-balancer" {
+		t.Errorf("request Via %q", via)
+	}
+
+	for _, h := range []string{"X-Backend-Hop", "Keep-Alive"} {
+		if v := res.Header.Get(h); v != "" {
+			t.Errorf("client got hop-by-hop %s: %q", h, v)
+		}
+	}
+	if res.Header.Get("X-Backend") != "1" {
+		t.Error("client lost X-Backend")
+	}
+	if via := res.Header.Get("Via"); via != "1.1 load-balancer" {
+		t.Errorf("response Via %q", via)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	s := newTestBackend(t, "ws", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+
+		// Echo until the client hangs up.
+		io.Copy(conn, rw)
+	})
+	_, url := newTestProxy(t, Route{Timeout: 50 * time.Millisecond}, s)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", res.StatusCode)
+	}
+
+	// The route timeout does not apply to the upgraded connection.
+	time.Sleep(100 * time.Millisecond)
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("echoed %q", buf)
+	}
+
+	if n := s.ActiveConnections(); n != 1 {
+		t.Fatalf("active = %d during the upgrade, want 1", n)
+	}
+
+	conn.Close()
+	waitFor(t, func() bool { return s.ActiveConnections() == 0 })
+}
@@ -0,0 +1,118 @@
+package loadbalancer
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// LeastConnections picks the healthy server handling the fewest requests.
+type LeastConnections struct {
+	pool *ServerPool
+}
+
+func NewLeastConnections(pool *ServerPool) *LeastConnections {
+	return &LeastConnections{
+		pool: pool,
+	}
+}
+
+func (lc *LeastConnections) GetNextServer() (*Server, error) {
+	servers, err := lc.pool.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := servers[0]
+	for _, s := range servers[1:] {
+		if s.ActiveConnections() < selected.ActiveConnections() {
+			selected = s
+		}
+	}
+
+	return selected, nil
+}
+
+// WeightedRoundRobin spreads requests over the healthy servers in
+// proportion to their Weight, interleaving them rather than sending a
+// server its whole share in a row.
+type WeightedRoundRobin struct {
+	pool    *ServerPool
+	mu      sync.Mutex
+	current map[*Server]int
+}
+
+func NewWeightedRoundRobin(pool *ServerPool) *WeightedRoundRobin {
+	return &WeightedRoundRobin{
+		pool:    pool,
+		current: map[*Server]int{},
+	}
+}
+
+func (wrr *WeightedRoundRobin) GetNextServer() (*Server, error) {
+	servers, err := wrr.pool.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	// Smooth weighted round robin: every server gains its weight, the one
+	// furthest ahead is picked and falls back by the total.
+	var selected *Server
+	total := 0
+
+	for _, s := range servers {
+		weight := max(s.Weight, 1)
+		total += weight
+		wrr.current[s] += weight
+
+		if selected == nil || wrr.current[s] > wrr.current[selected] {
+			selected = s
+		}
+	}
+
+	wrr.current[selected] -= total
+
+	return selected, nil
+}
+
+// PowerOfTwo picks two healthy servers at random and takes the one with
+// the lower latency average, weighed by the requests it is handling.
+type PowerOfTwo struct {
+	pool *ServerPool
+}
+
+func NewPowerOfTwo(pool *ServerPool) *PowerOfTwo {
+	return &PowerOfTwo{
+		pool: pool,
+	}
+}
+
+func (p2 *PowerOfTwo) GetNextServer() (*Server, error) {
+	servers, err := p2.pool.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(servers) == 1 {
+		return servers[0], nil
+	}
+
+	i := rand.IntN(len(servers))
+	j := rand.IntN(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := servers[i], servers[j]
+	if cost(b) < cost(a) {
+		return b, nil
+	}
+
+	return a, nil
+}
+
+func cost(s *Server) float64 {
+	return float64(s.Latency()) * float64(s.ActiveConnections()+1)
+}
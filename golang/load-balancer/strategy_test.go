@@ -0,0 +1,134 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPool(names ...string) (*ServerPool, map[string]*Server) {
+	pool := NewServerPool()
+	servers := map[string]*Server{}
+
+	for _, name := range names {
+		s := &Server{Name: name, URL: "http://" + name}
+		pool.AddServer(s)
+		servers[name] = s
+	}
+
+	return pool, servers
+}
+
+func TestNoServers(t *testing.T) {
+	pool, _ := newTestPool()
+
+	strategies := []Strategy{
+		NewRoundRobin(pool),
+		NewLeastConnections(pool),
+		NewWeightedRoundRobin(pool),
+		NewPowerOfTwo(pool),
+	}
+
+	for _, strategy := range strategies {
+		if _, err := strategy.GetNextServer(); err != ErrNoServers {
+			t.Errorf("%T: %v, want ErrNoServers", strategy, err)
+		}
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	pool, servers := newTestPool("a", "b", "c")
+	lc := NewLeastConnections(pool)
+
+	servers["a"].active.Store(3)
+	servers["b"].active.Store(1)
+	servers["c"].active.Store(2)
+
+	s, err := lc.GetNextServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "b" {
+		t.Fatalf("picked %s, want b", s.Name)
+	}
+
+	// An unhealthy server is passed over however idle it is.
+	servers["b"].LastHealthCheck = time.Now()
+	servers["b"].IsHealthy = false
+
+	s, err = lc.GetNextServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "c" {
+		t.Fatalf("picked %s, want c", s.Name)
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	pool, servers := newTestPool("a", "b", "c")
+	servers["a"].Weight = 5
+	servers["b"].Weight = 2
+	// c keeps the default weight of 1.
+
+	wrr := NewWeightedRoundRobin(pool)
+
+	counts := map[string]int{}
+	run, longest := 0, 0
+	last := ""
+
+	for i := 0; i < 80; i++ {
+		s, err := wrr.GetNextServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[s.Name]++
+
+		if s.Name == last {
+			run++
+		} else {
+			run = 1
+		}
+		last = s.Name
+		longest = max(longest, run)
+	}
+
+	want := map[string]int{"a": 50, "b": 20, "c": 10}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("%s picked %d times, want %d", name, counts[name], n)
+		}
+	}
+
+	// Smooth weighting interleaves a's share with the others.
+	if longest > 3 {
+		t.Errorf("a server was picked %d times in a row", longest)
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	pool, servers := newTestPool("fast", "busy", "slow")
+	servers["fast"].latency = float64(10 * time.Millisecond)
+	servers["busy"].latency = float64(10 * time.Millisecond)
+	servers["busy"].active.Store(4)
+	servers["slow"].latency = float64(100 * time.Millisecond)
+
+	p2 := NewPowerOfTwo(pool)
+
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		s, err := p2.GetNextServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[s.Name]++
+	}
+
+	// slow costs the most, so it loses every pair it is drawn in; busy
+	// wins only against slow.
+	if counts["slow"] != 0 {
+		t.Errorf("slow picked %d times", counts["slow"])
+	}
+	if counts["fast"] <= counts["busy"] {
+		t.Errorf("fast picked %d times, busy %d", counts["fast"], counts["busy"])
+	}
+}
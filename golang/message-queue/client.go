@@ -0,0 +1,528 @@
+package messagequeue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client talks to a Server. It reconnects with backoff when the connection
+// drops and re-subscribes every open subscription, which resumes each
+// channel after the last message acked.
+type Client struct {
+	addr string
+	opts ClientOpts
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	nextID  atomic.Uint64
+	nextSub atomic.Uint64
+
+	mu        sync.Mutex
+	conn      net.Conn
+	enc       *json.Encoder
+	connected chan struct{} // closed while conn is usable
+	pending   map[uint64]chan *frame
+	subs      map[string]*Subscription
+
+	wmu sync.Mutex
+}
+
+type ClientOpts struct {
+	// DialTimeout bounds each connection attempt.
+	DialTimeout time.Duration
+	// MaxBackoff caps the delay between reconnection attempts.
+	MaxBackoff time.Duration
+}
+
+type SubscribeOpts struct {
+	// Start is where the channel starts reading if it does not exist yet.
+	Start Position
+}
+
+// Subscription is a consumer on a remote channel.
+type Subscription struct {
+	c       *Client
+	id      string
+	topic   string
+	channel string
+	handler func(id string, payload []byte) error
+
+	msgs   chan *frame
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// subscribed is set once the server accepted the subscription, so
+	// reconnects re-subscribe it; guarded by c.mu.
+	subscribed bool
+}
+
+// Dial connects to the server at addr. The first connection must succeed;
+// later disconnects are retried in the background until Close.
+func Dial(addr string, opts ...*ClientOpts) (*Client, error) {
+	o := ClientOpts{
+		DialTimeout: 5 * time.Second,
+		MaxBackoff:  5 * time.Second,
+	}
+	if len(opts) > 0 && opts[0] != nil {
+		if opts[0].DialTimeout > 0 {
+			o.DialTimeout = opts[0].DialTimeout
+		}
+		if opts[0].MaxBackoff > 0 {
+			o.MaxBackoff = opts[0].MaxBackoff
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &Client{
+		addr:      addr,
+		opts:      o,
+		ctx:       ctx,
+		cancel:    cancel,
+		connected: make(chan struct{}),
+		pending:   map[uint64]chan *frame{},
+		subs:      map[string]*Subscription{},
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, o.DialTimeout)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go c.run(conn)
+
+	return c, nil
+}
+
+// Close disconnects and stops every subscription. Messages being handled
+// are not acked and will be delivered again.
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+
+	return nil
+}
+
+// run owns the connection: it reads frames until the connection fails,
+// then redials with exponential backoff.
+func (c *Client) run(conn net.Conn) {
+	defer c.wg.Done()
+
+	for attempt := 0; ; {
+		if conn != nil {
+			attempt = 0
+			c.readLoop(conn)
+		}
+
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		backoff := time.Duration(float64(100*time.Millisecond) * math.Pow(2, float64(attempt)))
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+		attempt++
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		var err error
+		conn, err = net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+		if err != nil {
+			log.Println("reconnect", c.addr, err)
+			conn = nil
+		}
+	}
+}
+
+func (c *Client) readLoop(conn net.Conn) {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	c.conn = conn
+	c.enc = json.NewEncoder(conn)
+	close(c.connected)
+	subs := make([]*Subscription, 0, len(c.subs))
+	for _, s := range c.subs {
+		if s.subscribed {
+			subs = append(subs, s)
+		}
+	}
+	c.mu.Unlock()
+
+	// Resubscribe in the background: the responses arrive on this loop.
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for _, s := range subs {
+			if err := c.resubscribe(s); err != nil {
+				log.Println("resubscribe", s.topic, s.channel, err)
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxFrameSize)
+
+	for scanner.Scan() {
+		var f frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			log.Println("invalid frame from", c.addr, err)
+			break
+		}
+
+		if f.Op == opMessage {
+			c.mu.Lock()
+			s, ok := c.subs[f.Sub]
+			c.mu.Unlock()
+
+			if ok {
+				select {
+				case s.msgs <- &f:
+				case <-s.ctx.Done():
+				}
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[f.ID]
+		delete(c.pending, f.ID)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- &f
+		}
+	}
+
+	conn.Close()
+
+	c.mu.Lock()
+	c.conn = nil
+	c.enc = nil
+	c.connected = make(chan struct{})
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
+
+// do sends req and waits for its response, waiting for a connection first
+// if the client is reconnecting.
+func (c *Client) do(ctx context.Context, req *frame) (*frame, error) {
+	for {
+		c.mu.Lock()
+		enc, connected := c.enc, c.connected
+		c.mu.Unlock()
+
+		if enc != nil {
+			break
+		}
+
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, ErrStopped
+		}
+	}
+
+	req.ID = c.nextID.Add(1)
+	ch := make(chan *frame, 1)
+
+	c.mu.Lock()
+	enc := c.enc
+	if enc == nil {
+		c.mu.Unlock()
+		return nil, ErrDisconnected
+	}
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := enc.Encode(req)
+	c.wmu.Unlock()
+
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+		return nil, ErrDisconnected
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// sendAck sends an ack or nack without waiting, since neither gets a
+// response. One lost to a disconnect means the message is delivered again.
+func (c *Client) sendAck(op, sub, msgID string) {
+	c.mu.Lock()
+	enc := c.enc
+	c.mu.Unlock()
+
+	if enc == nil {
+		return
+	}
+
+	c.wmu.Lock()
+	enc.Encode(&frame{Op: op, Sub: sub, MsgID: msgID})
+	c.wmu.Unlock()
+}
+
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte) (string, error) {
+	resp, err := c.do(ctx, &frame{Op: opPublish, Topic: topic, Payload: payload})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.MsgID, nil
+}
+
+// PublishBatch publishes payloads to topic in one request and one write.
+func (c *Client) PublishBatch(ctx context.Context, topic string, payloads [][]byte) ([]string, error) {
+	resp, err := c.do(ctx, &frame{Op: opPublishBatch, Topic: topic, Payloads: payloads})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.MsgIDs, nil
+}
+
+// PublishTo publishes payload through a routing exchange and returns its
+// ID in each topic it reached.
+func (c *Client) PublishTo(
+	ctx context.Context,
+	exchange string,
+	routingKey string,
+	payload []byte,
+	headers map[string]string,
+) (map[string]string, error) {
+	resp, err := c.do(ctx, &frame{
+		Op:       opPublishTo,
+		Exchange: exchange,
+		Key:      routingKey,
+		Payload:  payload,
+		Headers:  headers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Routed, nil
+}
+
+func (c *Client) DeclareExchange(ctx context.Context, exchange string, kind ExchangeType) error {
+	_, err := c.do(ctx, &frame{Op: opDeclareExchange, Exchange: exchange, Kind: kind})
+	return err
+}
+
+func (c *Client) DeleteExchange(ctx context.Context, exchange string) error {
+	_, err := c.do(ctx, &frame{Op: opDeleteExchange, Exchange: exchange})
+	return err
+}
+
+func (c *Client) Bind(ctx context.Context, b Binding) error {
+	_, err := c.do(ctx, &frame{Op: opBind, Binding: &b})
+	return err
+}
+
+func (c *Client) Unbind(ctx context.Context, b Binding) error {
+	_, err := c.do(ctx, &frame{Op: opUnbind, Binding: &b})
+	return err
+}
+
+func (c *Client) CreateTopic(ctx context.Context, topic string) error {
+	_, err := c.do(ctx, &frame{Op: opCreateTopic, Topic: topic})
+	return err
+}
+
+func (c *Client) ClearTopic(ctx context.Context, topic string) error {
+	_, err := c.do(ctx, &frame{Op: opClearTopic, Topic: topic})
+	return err
+}
+
+func (c *Client) DeleteTopic(ctx context.Context, topic string) error {
+	_, err := c.do(ctx, &frame{Op: opDeleteTopic, Topic: topic})
+	return err
+}
+
+func (c *Client) Topics(ctx context.Context) ([]string, error) {
+	resp, err := c.do(ctx, &frame{Op: opTopics})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Topics, nil
+}
+
+func (c *Client) Metrics(ctx context.Context, topic string) (*Metrics, error) {
+	resp, err := c.do(ctx, &frame{Op: opMetrics, Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Metrics, nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, &frame{Op: opPing})
+	return err
+}
+
+// Subscribe consumes channel on topic, calling handler for each message.
+// A message is acked when handler returns nil and nacked otherwise, which
+// has the server redeliver it later or dead-letter it. Subscriptions on the
+// same topic and channel, from any client, share its messages.
+func (c *Client) Subscribe(
+	ctx context.Context,
+	topic string,
+	channel string,
+	handler func(id string, payload []byte) error,
+	opts ...*SubscribeOpts,
+) (*Subscription, error) {
+	start := Position{}
+	if len(opts) > 0 && opts[0] != nil {
+		start = opts[0].Start
+	}
+
+	sctx, cancel := context.WithCancel(c.ctx)
+
+	s := &Subscription{
+		c:       c,
+		id:      strconv.FormatUint(c.nextSub.Add(1), 10),
+		topic:   topic,
+		channel: channel,
+		handler: handler,
+		msgs:    make(chan *frame, 1),
+		ctx:     sctx,
+		cancel:  cancel,
+	}
+
+	c.mu.Lock()
+	c.subs[s.id] = s
+	c.mu.Unlock()
+
+	_, err := c.do(ctx, &frame{Op: opSubscribe, Sub: s.id, Topic: topic, Channel: channel, Start: &start})
+	if err != nil {
+		c.mu.Lock()
+		delete(c.subs, s.id)
+		c.mu.Unlock()
+		cancel()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	s.subscribed = true
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Seek moves a channel of topic to pos for all of its subscribers.
+func (c *Client) Seek(ctx context.Context, topic string, channel string, pos Position) error {
+	_, err := c.do(ctx, &frame{Op: opSeek, Topic: topic, Channel: channel, Start: &pos})
+	return err
+}
+
+func (c *Client) resubscribe(s *Subscription) error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, c.opts.DialTimeout)
+	defer cancel()
+
+	_, err := c.do(ctx, &frame{Op: opSubscribe, Sub: s.id, Topic: s.topic, Channel: s.channel})
+	return err
+}
+
+func (s *Subscription) run() {
+	defer s.c.wg.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case f := <-s.msgs:
+			s.handle(f)
+		}
+	}
+}
+
+func (s *Subscription) handle(f *frame) {
+	err := s.handler(f.MsgID, f.Payload)
+	if err != nil {
+		log.Println("handler failed:", s.topic, f.MsgID, err)
+		s.c.sendAck(opNack, s.id, f.MsgID)
+		return
+	}
+
+	s.c.sendAck(opAck, s.id, f.MsgID)
+}
+
+// Stop ends the subscription. The channel keeps its position, so a later
+// Subscribe to the same topic and channel resumes where this one stopped,
+// and a message it had not acked yet is delivered again.
+func (s *Subscription) Stop() error {
+	s.cancel()
+
+	s.c.mu.Lock()
+	delete(s.c.subs, s.id)
+	s.c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.c.opts.DialTimeout)
+	defer cancel()
+
+	_, err := s.c.do(ctx, &frame{Op: opUnsubscribe, Sub: s.id})
+	if errors.Is(err, ErrStopped) {
+		return nil
+	}
+
+	return err
+}
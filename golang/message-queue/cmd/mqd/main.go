@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"log"
+	messagequeue "message-queue"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":7070", "TCP listen address")
+	maxRetries := flag.Int("max-retries", 3, "deliveries before a message is dead-lettered")
+	ackTimeout := flag.Duration("ack-timeout", 30*time.Second, "time to ack a message before it is redelivered")
+	dir := flag.String("dir", "./_msq_", "data directory")
+	storage := flag.String("storage", "sqlite", "topic storage: sqlite, segment or memory")
+	maxAge := flag.Duration("retention-age", 0, "delete messages older than this, read or not")
+	maxBytes := flag.Int64("retention-bytes", 0, "keep at most this many payload bytes per topic")
+	flag.Parse()
+
+	stores := map[string]messagequeue.OpenStore{
+		"sqlite":  messagequeue.OpenSQLiteStore,
+		"segment": messagequeue.OpenSegmentStore,
+		"memory":  messagequeue.OpenMemoryStore,
+	}
+
+	open, ok := stores[*storage]
+	if !ok {
+		log.Fatalf("unknown storage %q", *storage)
+	}
+
+	ex, err := messagequeue.NewExchange(&messagequeue.ExchangeOpts{
+		MaxRetries: *maxRetries,
+		AckTimeout: *ackTimeout,
+		Dir:        *dir,
+		Storage:    open,
+		Retention: messagequeue.Retention{
+			MaxAge:   *maxAge,
+			MaxBytes: *maxBytes,
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ex.Run()
+
+	srv := messagequeue.NewServer(ex)
+
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt)
+		<-ch
+
+		log.Println("shutting down...")
+		srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(*addr); err != nil {
+		log.Fatal(err)
+	}
+
+	ex.Stop()
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,29 +22,77 @@ type Exchange struct {
 	rw         sync.RWMutex
 	running    atomic.Bool
 	maxRetries int
+	ackTimeout time.Duration
+	dir        string
+	storage    OpenStore
+	retention  Retention
+	routes     routes
 }
 
 type ExchangeOpts struct {
+	// Dir holds topic data and the exchange's metadata. Defaults to
+	// ./_msq_.
+	Dir string
+	// Storage opens the store for each persistent topic. Defaults to
+	// OpenSQLiteStore; #temp topics always use OpenMemoryStore.
+	Storage OpenStore
+	// MaxRetries is how many times a message is delivered on a channel
+	// before it is moved to the topic's dead-letter topic.
 	MaxRetries int
+	// AckTimeout is how long a delivered message may go unacked before it
+	// is delivered again.
+	AckTimeout time.Duration
+	// Retention applies to every topic.
+	Retention Retention
+}
+
+type ConsumerOpts struct {
+	// Start is where the channel starts reading if it does not exist yet.
+	// Defaults to the latest message; use Seek to move an existing one.
+	Start Position
+}
+
+// DeadLetterTopic names the topic that receives messages from topic which
+// ran out of retries on some channel.
+func DeadLetterTopic(topic string) string {
+	return topic + "#dlq"
 }
 
 func NewExchange(opts ...*ExchangeOpts) (*Exchange, error) {
 	log.Println("creating exchange...")
 
-	if _, err := os.Stat("./_msq_"); os.IsNotExist(err) {
-		err := os.MkdirAll("./_msq_", 0777)
+	if len(opts) == 0 {
+		opts = append(opts, &ExchangeOpts{})
+	}
+
+	dir := opts[0].Dir
+	if dir == "" {
+		dir = "./_msq_"
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err := os.MkdirAll(dir, 0777)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if len(opts) == 0 {
-		opts = append(opts, &ExchangeOpts{
-			MaxRetries: 3,
-		})
+	storage := opts[0].Storage
+	if storage == nil {
+		storage = OpenSQLiteStore
+	}
+
+	maxRetries := opts[0].MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
 	}
 
-	metadataDB, err := sql.Open("sqlite3", "./_msq_/metadata.db")
+	ackTimeout := opts[0].AckTimeout
+	if ackTimeout <= 0 {
+		ackTimeout = 30 * time.Second
+	}
+
+	metadataDB, err := sql.Open("sqlite3", filepath.Join(dir, "metadata.db"))
 	if err != nil {
 		panic(err)
 	}
@@ -61,7 +111,17 @@ func NewExchange(opts ...*ExchangeOpts) (*Exchange, error) {
 	ex := &Exchange{
 		metadata:   metadataDB,
 		topics:     map[string]*topic{},
-		maxRetries: opts[0].MaxRetries,
+		maxRetries: maxRetries,
+		ackTimeout: ackTimeout,
+		dir:        dir,
+		storage:    storage,
+		retention:  opts[0].Retention,
+	}
+
+	err = ex.loadRoutes()
+	if err != nil {
+		metadataDB.Close()
+		return nil, err
 	}
 
 	// DEPRECATED:
@@ -106,8 +166,17 @@ func (ex *Exchange) CreateTopic(topic string) error {
 		return ErrStopped
 	}
 
+	if _, ok := ex.topics[topic]; ok {
+		return nil
+	}
+
 	if strings.HasSuffix(topic, "#temp") {
-		t, err := newTopic(topic)
+		store, err := OpenMemoryStore(ex.dir, topic)
+		if err != nil {
+			return err
+		}
+
+		t, err := newTopic(topic, store, ex.retention)
 		if err != nil {
 			return err
 		}
@@ -128,8 +197,14 @@ func (ex *Exchange) CreateTopic(topic string) error {
 		// return err
 	}
 
-	t, err := newTopic(topic)
+	store, err := ex.storage(ex.dir, topic)
+	if err != nil {
+		return err
+	}
+
+	t, err := newTopic(topic, store, ex.retention)
 	if err != nil {
+		store.Close()
 		return err
 	}
 
@@ -156,7 +231,48 @@ func (ex *Exchange) DeleteTopic(topic string) error {
 		return ErrStopped
 	}
 
-	return nil
+	ex.rw.Lock()
+	t, ok := ex.topics[topic]
+	delete(ex.topics, topic)
+	ex.rw.Unlock()
+
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	t.Stop()
+
+	_, err := ex.metadata.Exec(`DELETE FROM topics WHERE name = ?`, topic)
+	if err != nil {
+		return err
+	}
+
+	return t.store.Drop()
+}
+
+// Topics returns the names of all open topics.
+func (ex *Exchange) Topics() []string {
+	ex.rw.RLock()
+	defer ex.rw.RUnlock()
+
+	names := make([]string, 0, len(ex.topics))
+	for name := range ex.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Seek moves a channel of topic to pos, so its consumers continue from
+// there. Messages in flight on the channel are not redelivered.
+func (ex *Exchange) Seek(topic string, channel string, pos Position) error {
+	t, err := ex.GetTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	return t.seek(channel, pos)
 }
 
 func (ex *Exchange) DeleteConsumer(topic string, channel string) error {
@@ -185,11 +301,17 @@ func (ex *Exchange) NewConsumer(
 	topic string,
 	channel string,
 	handler func(id string, payload []byte) error,
+	opts ...*ConsumerOpts,
 ) (*Consumer, error) {
 	if !ex.running.Load() {
 		return nil, ErrStopped
 	}
 
+	start := Position{}
+	if len(opts) > 0 && opts[0] != nil {
+		start = opts[0].Start
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := &Consumer{
@@ -218,39 +340,88 @@ func (ex *Exchange) NewConsumer(
 		log.Panicln("topic not found:", topic)
 	}
 
+	err := t.open(channel, start)
+	if err != nil {
+		return nil, err
+	}
+
 	go func() {
 		for {
-			id, msg, err := t.readNext(channel)
+			d, err := ex.next(c.ctx, t, channel)
+			if err == context.Canceled || err == ErrStopped {
+				break
+			}
 			if err != nil {
 				log.Println(err)
 				break
 			}
 
-			for i := 0; i < ex.maxRetries; i++ {
-				err = c.handler(id, msg)
-				if err != nil {
-					if i == ex.maxRetries-1 {
-						log.Println("max retries reached:", topic, err)
-						break
-					}
-
-					dur := math.Exp(float64(i))
-					time.Sleep(time.Duration(dur) * time.Second)
-					continue
-				}
+			err = c.handler(d.ID, d.Payload)
+			if err != nil {
+				log.Println("handler failed:", topic, d.ID, err)
+				err = ex.nack(t, channel, d)
+			} else {
+				err = t.ack(channel, d.ID)
 			}
 
-			err = t.markRead(channel, id)
-			if err != nil {
-				log.Panic(err)
+			if err == ErrStopped {
 				break
 			}
+			if err != nil {
+				log.Println(err)
+			}
 		}
 	}()
 
 	return c, nil
 }
 
+// next claims the channel's next message, first dead-lettering any that
+// were already delivered MaxRetries times.
+func (ex *Exchange) next(ctx context.Context, t *topic, channel string) (*Delivery, error) {
+	for {
+		d, err := t.claim(ctx, channel, ex.ackTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if d.Attempt <= ex.maxRetries {
+			return d, nil
+		}
+
+		// On failure the message stays in flight and is retried once its
+		// ack deadline passes again.
+		err = ex.deadLetter(t, channel, d)
+		if err != nil {
+			log.Println("dead letter:", t.name, d.ID, err)
+		}
+	}
+}
+
+// nack returns a failed message to the channel with exponential backoff,
+// or dead-letters it on its last attempt.
+func (ex *Exchange) nack(t *topic, channel string, d *Delivery) error {
+	if d.Attempt >= ex.maxRetries {
+		log.Println("max retries reached:", t.name, d.ID)
+		return ex.deadLetter(t, channel, d)
+	}
+
+	dur := math.Exp(float64(d.Attempt - 1))
+
+	return t.nack(channel, d.ID, time.Duration(dur)*time.Second)
+}
+
+func (ex *Exchange) deadLetter(t *topic, channel string, d *Delivery) error {
+	p := &Publisher{ex: ex}
+
+	_, err := p.Publish(DeadLetterTopic(t.name), d.Payload)
+	if err != nil {
+		return err
+	}
+
+	return t.ack(channel, d.ID)
+}
+
 func (ex *Exchange) GetTopic(topic string) (*topic, error) {
 	if !ex.running.Load() {
 		return nil, ErrStopped
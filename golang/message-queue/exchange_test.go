@@ -0,0 +1,69 @@
+package messagequeue
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestExchange(t *testing.T, opts ExchangeOpts) *Exchange {
+	t.Helper()
+
+	opts.Dir = t.TempDir()
+	if opts.Storage == nil {
+		opts.Storage = OpenMemoryStore
+	}
+
+	ex, err := NewExchange(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Run()
+	t.Cleanup(ex.Stop)
+
+	return ex
+}
+
+func TestExchange_NackRetriesThenDeadLetters(t *testing.T) {
+	ex := newTestExchange(t, ExchangeOpts{MaxRetries: 2})
+
+	dead := make(chan string, 1)
+	dlq, err := ex.NewConsumer(DeadLetterTopic("jobs"), "dlq", func(id string, payload []byte) error {
+		dead <- string(payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dlq.Stop() })
+
+	var attempts atomic.Int32
+	c, err := ex.NewConsumer("jobs", "workers", func(id string, payload []byte) error {
+		attempts.Add(1)
+		return errors.New("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Stop() })
+
+	p, _ := ex.NewPublisher()
+	_, err = p.Publish("jobs", []byte("job-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-dead:
+		if got != "job-1" {
+			t.Fatalf("dead-lettered %q, want job-1", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message not dead-lettered after %d attempts", attempts.Load())
+	}
+
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+}
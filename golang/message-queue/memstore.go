@@ -0,0 +1,170 @@
+package messagequeue
+
+import "sort"
+
+// memStore keeps a topic in memory only. It backs #temp topics.
+type memStore struct {
+	messages []Message
+	channels map[string]*ChannelState
+}
+
+// OpenMemoryStore keeps a topic in memory; dir is unused and the data is
+// lost when the exchange stops.
+func OpenMemoryStore(dir string, topic string) (Store, error) {
+	return &memStore{
+		channels: map[string]*ChannelState{},
+	}, nil
+}
+
+// search returns the index of the first message with an ID greater than id.
+func (m *memStore) search(id string) int {
+	return sort.Search(len(m.messages), func(i int) bool {
+		return m.messages[i].ID > id
+	})
+}
+
+func (m *memStore) Append(msgs []Message) error {
+	for _, msg := range msgs {
+		m.messages = append(m.messages, Message{
+			ID:      msg.ID,
+			Payload: append([]byte(nil), msg.Payload...),
+		})
+	}
+
+	return nil
+}
+
+func (m *memStore) After(id string) (*Message, error) {
+	i := m.search(id)
+	if i == len(m.messages) {
+		return nil, nil
+	}
+
+	msg := m.messages[i]
+	return &msg, nil
+}
+
+func (m *memStore) Get(id string) (*Message, error) {
+	i := m.search(id) - 1
+	if i < 0 || m.messages[i].ID != id {
+		return nil, nil
+	}
+
+	msg := m.messages[i]
+	return &msg, nil
+}
+
+func (m *memStore) Last() (string, error) {
+	if len(m.messages) == 0 {
+		return "", nil
+	}
+
+	return m.messages[len(m.messages)-1].ID, nil
+}
+
+func (m *memStore) CountAfter(id string) (int64, error) {
+	return int64(len(m.messages) - m.search(id)), nil
+}
+
+func (m *memStore) Prune(through string) error {
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if msg.ID > through || m.inFlight(msg.ID) {
+			kept = append(kept, msg)
+		}
+	}
+	clear(m.messages[len(kept):])
+	m.messages = kept
+
+	return nil
+}
+
+func (m *memStore) Retain(before string, maxBytes int64) error {
+	drop := 0
+	if before != "" {
+		drop = sort.Search(len(m.messages), func(i int) bool {
+			return m.messages[i].ID >= before
+		})
+	}
+
+	if maxBytes > 0 {
+		var total int64
+		for i := len(m.messages) - 1; i >= drop; i-- {
+			total += int64(len(m.messages[i].Payload))
+			if total > maxBytes {
+				drop = i + 1
+				break
+			}
+		}
+	}
+
+	clear(m.messages[:drop])
+	m.messages = m.messages[drop:]
+
+	return nil
+}
+
+func (m *memStore) inFlight(id string) bool {
+	for _, st := range m.channels {
+		if _, ok := st.InFlight[id]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *memStore) Channels() (map[string]*ChannelState, error) {
+	channels := map[string]*ChannelState{}
+	for name, st := range m.channels {
+		cp := &ChannelState{
+			LastRead: st.LastRead,
+			InFlight: map[string]InFlight{},
+		}
+		for id, f := range st.InFlight {
+			cp.InFlight[id] = f
+		}
+		channels[name] = cp
+	}
+
+	return channels, nil
+}
+
+func (m *memStore) channel(name string) *ChannelState {
+	st, ok := m.channels[name]
+	if !ok {
+		st = &ChannelState{InFlight: map[string]InFlight{}}
+		m.channels[name] = st
+	}
+
+	return st
+}
+
+func (m *memStore) SetCursor(channel string, id string) error {
+	m.channel(channel).LastRead = id
+	return nil
+}
+
+func (m *memStore) PutInFlight(channel string, f InFlight) error {
+	m.channel(channel).InFlight[f.ID] = f
+	return nil
+}
+
+func (m *memStore) DeleteInFlight(channel string, id string) error {
+	delete(m.channel(channel).InFlight, id)
+	return nil
+}
+
+func (m *memStore) Clear() error {
+	m.messages = nil
+	m.channels = map[string]*ChannelState{}
+	return nil
+}
+
+func (m *memStore) Close() error {
+	return nil
+}
+
+func (m *memStore) Drop() error {
+	return m.Clear()
+}
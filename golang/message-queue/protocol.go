@@ -0,0 +1,65 @@
+package messagequeue
+
+import "errors"
+
+// The network protocol is newline-delimited JSON over TCP. Clients send
+// requests tagged with an ID of their choosing and the server answers each
+// with a response carrying the same ID. Messages for a subscription are
+// pushed as "message" frames tagged with the subscription ID; the client
+// acks or nacks each one, and the server sends the next message on that
+// subscription only after that.
+//
+// Subscriptions on the same topic and channel share its messages, each
+// going to one subscriber. A nacked message is delivered again after a
+// backoff and moved to the topic's dead-letter topic once it has been
+// delivered MaxRetries times. A message not acked before a disconnect or
+// the exchange's AckTimeout is delivered again, possibly elsewhere.
+
+const (
+	opPublish      = "publish"       // topic, payload -> msg_id
+	opPublishBatch = "publish_batch" // topic, payloads -> msg_ids
+	opSubscribe    = "subscribe"     // sub, topic, channel, start
+	opSeek         = "seek"          // topic, channel, start
+	opUnsubscribe  = "unsubscribe"   // sub
+	opAck          = "ack"           // sub, msg_id
+	opNack         = "nack"          // sub, msg_id
+	opCreateTopic  = "create_topic"
+	opClearTopic   = "clear_topic"
+	opDeleteTopic  = "delete_topic"
+	opTopics       = "topics"  // -> topics
+	opMetrics      = "metrics" // topic -> metrics
+	opPing         = "ping"
+
+	opDeclareExchange = "declare_exchange" // exchange, kind
+	opDeleteExchange  = "delete_exchange"  // exchange
+	opBind            = "bind"             // binding
+	opUnbind          = "unbind"           // binding
+	opPublishTo       = "publish_to"       // exchange, key, headers, payload -> routed
+
+	opMessage = "message" // server push: sub, msg_id, payload
+)
+
+var ErrDisconnected = errors.New("disconnected")
+
+type frame struct {
+	Op       string    `json:"op,omitempty"`
+	ID       uint64    `json:"id,omitempty"`
+	Sub      string    `json:"sub,omitempty"`
+	Topic    string    `json:"topic,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	Start    *Position `json:"start,omitempty"`
+	MsgID    string    `json:"msg_id,omitempty"`
+	Payload  []byte    `json:"payload,omitempty"`
+	Payloads [][]byte  `json:"payloads,omitempty"`
+	MsgIDs   []string  `json:"msg_ids,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Topics   []string  `json:"topics,omitempty"`
+	Metrics  *Metrics  `json:"metrics,omitempty"`
+
+	Exchange string            `json:"exchange,omitempty"`
+	Kind     ExchangeType      `json:"kind,omitempty"`
+	Key      string            `json:"key,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Binding  *Binding          `json:"binding,omitempty"`
+	Routed   map[string]string `json:"routed,omitempty"` // topic -> msg_id
+}
@@ -0,0 +1,410 @@
+package messagequeue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrExchangeNotFound = errors.New("exchange not found")
+	ErrExchangeExists   = errors.New("exchange exists with a different type")
+	ErrUnroutable       = errors.New("no binding matched")
+)
+
+// ExchangeType decides which bindings of a routing exchange a message
+// follows, as in AMQP.
+type ExchangeType string
+
+const (
+	// DirectExchange routes to bindings whose key equals the routing key.
+	DirectExchange ExchangeType = "direct"
+	// FanoutExchange routes to every binding and ignores the routing key.
+	FanoutExchange ExchangeType = "fanout"
+	// TopicExchange matches the routing key against binding patterns of
+	// dot-separated words, where * stands for one word and # for zero or
+	// more.
+	TopicExchange ExchangeType = "topic"
+	// HeadersExchange matches message headers against binding headers,
+	// requiring all of them, or any one if the binding sets MatchAny.
+	HeadersExchange ExchangeType = "headers"
+)
+
+func (k ExchangeType) valid() bool {
+	switch k {
+	case DirectExchange, FanoutExchange, TopicExchange, HeadersExchange:
+		return true
+	}
+
+	return false
+}
+
+// Binding routes messages from a routing exchange to a topic.
+type Binding struct {
+	Exchange string            `json:"exchange"`
+	Topic    string            `json:"topic"`
+	Key      string            `json:"key,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	MatchAny bool              `json:"match_any,omitempty"`
+}
+
+func (b Binding) headersJSON() string {
+	if len(b.Headers) == 0 {
+		return ""
+	}
+
+	// Map keys marshal sorted, so equal headers give equal strings.
+	h, _ := json.Marshal(b.Headers)
+	return string(h)
+}
+
+func (b Binding) same(o Binding) bool {
+	return b.Exchange == o.Exchange &&
+		b.Topic == o.Topic &&
+		b.Key == o.Key &&
+		b.MatchAny == o.MatchAny &&
+		b.headersJSON() == o.headersJSON()
+}
+
+func (b Binding) matches(kind ExchangeType, key string, headers map[string]string) bool {
+	switch kind {
+	case DirectExchange:
+		return b.Key == key
+	case FanoutExchange:
+		return true
+	case TopicExchange:
+		return matchTopic(strings.Split(b.Key, "."), strings.Split(key, "."))
+	case HeadersExchange:
+		for k, v := range b.Headers {
+			got, ok := headers[k]
+			if ok && got == v {
+				if b.MatchAny {
+					return true
+				}
+			} else if !b.MatchAny {
+				return false
+			}
+		}
+
+		return !b.MatchAny || len(b.Headers) == 0
+	}
+
+	return false
+}
+
+// matchTopic matches routing key words against pattern words.
+func matchTopic(pattern []string, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	}
+
+	return len(words) > 0 && pattern[0] == words[0] && matchTopic(pattern[1:], words[1:])
+}
+
+type router struct {
+	kind     ExchangeType
+	bindings []Binding
+}
+
+// routes holds the routing exchanges, mirrored in metadata.db.
+type routes struct {
+	mu      sync.RWMutex
+	routers map[string]*router
+}
+
+func (ex *Exchange) loadRoutes() error {
+	ex.routes.routers = map[string]*router{}
+
+	_, err := ex.metadata.Exec(`
+		create table if not exists exchanges (
+		name text not null,
+		kind text not null,
+		primary key (name)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.metadata.Exec(`
+		create table if not exists bindings (
+		exchange text not null,
+		topic text not null,
+		routing_key text not null,
+		headers text not null,
+		match_any int not null,
+		primary key (exchange, topic, routing_key, headers, match_any)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := ex.metadata.Query(`select name, kind from exchanges`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		name := ""
+		kind := ""
+
+		err = rows.Scan(&name, &kind)
+		if err != nil {
+			return err
+		}
+
+		ex.routes.routers[name] = &router{kind: ExchangeType(kind)}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = ex.metadata.Query(`select exchange, topic, routing_key, headers, match_any from bindings`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b := Binding{}
+		headers := ""
+
+		err = rows.Scan(&b.Exchange, &b.Topic, &b.Key, &headers, &b.MatchAny)
+		if err != nil {
+			return err
+		}
+
+		if headers != "" {
+			err = json.Unmarshal([]byte(headers), &b.Headers)
+			if err != nil {
+				return fmt.Errorf("binding %s -> %s: %w", b.Exchange, b.Topic, err)
+			}
+		}
+
+		r, ok := ex.routes.routers[b.Exchange]
+		if !ok {
+			continue
+		}
+
+		r.bindings = append(r.bindings, b)
+	}
+
+	return rows.Err()
+}
+
+// DeclareExchange creates a routing exchange. Declaring one that already
+// exists with the same type does nothing.
+func (ex *Exchange) DeclareExchange(name string, kind ExchangeType) error {
+	if !ex.running.Load() {
+		return ErrStopped
+	}
+
+	if name == "" {
+		return errors.New("exchange name is empty")
+	}
+
+	if !kind.valid() {
+		return fmt.Errorf("unknown exchange type %q", kind)
+	}
+
+	ex.routes.mu.Lock()
+	defer ex.routes.mu.Unlock()
+
+	if r, ok := ex.routes.routers[name]; ok {
+		if r.kind != kind {
+			return ErrExchangeExists
+		}
+
+		return nil
+	}
+
+	_, err := ex.metadata.Exec(`insert into exchanges (name, kind) values (?, ?)`, name, string(kind))
+	if err != nil {
+		return err
+	}
+
+	ex.routes.routers[name] = &router{kind: kind}
+
+	return nil
+}
+
+// DeleteExchange removes a routing exchange and its bindings. The bound
+// topics are left alone.
+func (ex *Exchange) DeleteExchange(name string) error {
+	if !ex.running.Load() {
+		return ErrStopped
+	}
+
+	ex.routes.mu.Lock()
+	defer ex.routes.mu.Unlock()
+
+	if _, ok := ex.routes.routers[name]; !ok {
+		return ErrExchangeNotFound
+	}
+
+	tx, err := ex.metadata.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`delete from bindings where exchange = ?`, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`delete from exchanges where name = ?`, name)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	delete(ex.routes.routers, name)
+
+	return nil
+}
+
+// Exchanges returns the routing exchanges by name.
+func (ex *Exchange) Exchanges() map[string]ExchangeType {
+	ex.routes.mu.RLock()
+	defer ex.routes.mu.RUnlock()
+
+	kinds := map[string]ExchangeType{}
+	for name, r := range ex.routes.routers {
+		kinds[name] = r.kind
+	}
+
+	return kinds
+}
+
+// Bind routes messages from b.Exchange to b.Topic. Binding the same way
+// twice does nothing.
+func (ex *Exchange) Bind(b Binding) error {
+	if !ex.running.Load() {
+		return ErrStopped
+	}
+
+	if b.Topic == "" {
+		return errors.New("binding topic is empty")
+	}
+
+	ex.routes.mu.Lock()
+	defer ex.routes.mu.Unlock()
+
+	r, ok := ex.routes.routers[b.Exchange]
+	if !ok {
+		return ErrExchangeNotFound
+	}
+
+	for _, o := range r.bindings {
+		if o.same(b) {
+			return nil
+		}
+	}
+
+	_, err := ex.metadata.Exec(
+		`insert into bindings (exchange, topic, routing_key, headers, match_any)
+		values (?, ?, ?, ?, ?)`,
+		b.Exchange, b.Topic, b.Key, b.headersJSON(), b.MatchAny,
+	)
+	if err != nil {
+		return err
+	}
+
+	r.bindings = append(r.bindings, b)
+
+	return nil
+}
+
+// Unbind removes a binding made by Bind with the same fields.
+func (ex *Exchange) Unbind(b Binding) error {
+	if !ex.running.Load() {
+		return ErrStopped
+	}
+
+	ex.routes.mu.Lock()
+	defer ex.routes.mu.Unlock()
+
+	r, ok := ex.routes.routers[b.Exchange]
+	if !ok {
+		return ErrExchangeNotFound
+	}
+
+	_, err := ex.metadata.Exec(
+		`delete from bindings
+		where exchange = ? and topic = ? and routing_key = ? and headers = ? and match_any = ?`,
+		b.Exchange, b.Topic, b.Key, b.headersJSON(), b.MatchAny,
+	)
+	if err != nil {
+		return err
+	}
+
+	kept := r.bindings[:0]
+	for _, o := range r.bindings {
+		if !o.same(b) {
+			kept = append(kept, o)
+		}
+	}
+	r.bindings = kept
+
+	return nil
+}
+
+// Bindings returns the bindings of a routing exchange.
+func (ex *Exchange) Bindings(exchange string) ([]Binding, error) {
+	ex.routes.mu.RLock()
+	defer ex.routes.mu.RUnlock()
+
+	r, ok := ex.routes.routers[exchange]
+	if !ok {
+		return nil, ErrExchangeNotFound
+	}
+
+	return append([]Binding(nil), r.bindings...), nil
+}
+
+// route returns the topics a message published to exchange reaches, each
+// once, in name order.
+func (ex *Exchange) route(exchange string, key string, headers map[string]string) ([]string, error) {
+	ex.routes.mu.RLock()
+	defer ex.routes.mu.RUnlock()
+
+	r, ok := ex.routes.routers[exchange]
+	if !ok {
+		return nil, ErrExchangeNotFound
+	}
+
+	seen := map[string]bool{}
+	topics := []string{}
+
+	for _, b := range r.bindings {
+		if seen[b.Topic] || !b.matches(r.kind, key, headers) {
+			continue
+		}
+
+		seen[b.Topic] = true
+		topics = append(topics, b.Topic)
+	}
+	sort.Strings(topics)
+
+	return topics, nil
+}
@@ -0,0 +1,70 @@
+package messagequeue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.deleted", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.created", false},
+		{"*.created", "orders.created", true},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.created", "orders.created", false},
+		{"orders.#", "orders", true},
+		{"orders.#", "orders.created", true},
+		{"orders.#", "orders.eu.created", true},
+		{"orders.#", "users.created", false},
+		{"#.created", "created", true},
+		{"#.created", "orders.eu.created", true},
+		{"#.created", "orders.eu.deleted", false},
+		{"orders.#.created", "orders.created", true},
+		{"orders.#.created", "orders.eu.west.created", true},
+		{"#", "anything.at.all", true},
+		{"#.*", "orders", true},
+		{"*.#.*", "orders", false},
+	}
+
+	for _, c := range cases {
+		got := matchTopic(strings.Split(c.pattern, "."), strings.Split(c.key, "."))
+		if got != c.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", c.pattern, c.key, got, c.want)
+		}
+	}
+}
+
+func TestBindingMatchesHeaders(t *testing.T) {
+	headers := map[string]string{"region": "eu", "kind": "order"}
+
+	cases := []struct {
+		name     string
+		bind     map[string]string
+		matchAny bool
+		want     bool
+	}{
+		{"all match", map[string]string{"region": "eu", "kind": "order"}, false, true},
+		{"all one differs", map[string]string{"region": "us", "kind": "order"}, false, false},
+		{"all one missing", map[string]string{"region": "eu", "tier": "gold"}, false, false},
+		{"all empty", nil, false, true},
+		{"any one matches", map[string]string{"region": "us", "kind": "order"}, true, true},
+		{"any none match", map[string]string{"region": "us", "kind": "refund"}, true, false},
+		{"any missing", map[string]string{"tier": "gold"}, true, false},
+		{"any empty", nil, true, true},
+	}
+
+	for _, c := range cases {
+		b := Binding{Headers: c.bind, MatchAny: c.matchAny}
+		got := b.matches(HeadersExchange, "", headers)
+		if got != c.want {
+			t.Errorf("%s: matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package messagequeue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// segmentSize is the size at which a new segment file is started.
+const segmentSize = 64 << 20
+
+// maxRecordSize bounds a record body so a corrupt length is not trusted.
+const maxRecordSize = 256 << 20
+
+// A segment record is a header of the body's CRC-32 and length, both
+// big-endian uint32, followed by the body: a uint16 ID length, the ID and
+// the payload.
+const recordHeader = 8
+
+var errCorrupt = errors.New("corrupt record")
+
+type segment struct {
+	num  int
+	f    *os.File
+	size int64
+	last string
+}
+
+type segmentEntry struct {
+	id  string
+	seg *segment
+	off int64
+}
+
+// segmentStore keeps a topic in append-only segment files under
+// dir/<topic>/, with channel state in a JSON-lines log beside them.
+type segmentStore struct {
+	dir      string
+	segments []*segment
+	index    []segmentEntry
+	channels map[string]*ChannelState
+	state    *os.File
+}
+
+type stateRecord struct {
+	Op       string `json:"op"` // cursor, put or del
+	Channel  string `json:"channel"`
+	ID       string `json:"id"`
+	Attempts int    `json:"attempts,omitempty"`
+	Deadline int64  `json:"deadline,omitempty"`
+}
+
+// OpenSegmentStore keeps a topic in append-only segment files under
+// dir/<topic>/. A torn record at the end of a segment, left by a crash
+// mid-write, is truncated away on open.
+func OpenSegmentStore(dir string, topic string) (Store, error) {
+	s := &segmentStore{
+		dir:      filepath.Join(dir, topic),
+		channels: map[string]*ChannelState{},
+	}
+
+	err := os.MkdirAll(s.dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		num := 0
+		_, err = fmt.Sscanf(filepath.Base(name), "%d.seg", &num)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("segment %s: %w", name, err)
+		}
+
+		err = s.openSegment(num)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	if len(s.segments) == 0 {
+		err = s.openSegment(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.loadState()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *segmentStore) segmentPath(num int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.seg", num))
+}
+
+// openSegment opens a segment file, indexes its records and truncates
+// anything after the last valid one.
+func (s *segmentStore) openSegment(num int) error {
+	f, err := os.OpenFile(s.segmentPath(num), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	seg := &segment{num: num, f: f}
+	r := bufio.NewReader(f)
+
+	for {
+		id, _, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Torn write: drop the partial record.
+			err = f.Truncate(seg.size)
+			if err != nil {
+				f.Close()
+				return err
+			}
+			break
+		}
+
+		s.index = append(s.index, segmentEntry{id: id, seg: seg, off: seg.size})
+		seg.size += n
+		seg.last = id
+	}
+
+	_, err = f.Seek(seg.size, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.segments = append(s.segments, seg)
+
+	return nil
+}
+
+func readRecord(r io.Reader) (id string, payload []byte, n int64, err error) {
+	var header [recordHeader]byte
+
+	_, err = io.ReadFull(r, header[:])
+	if err == io.ErrUnexpectedEOF {
+		return "", nil, 0, errCorrupt
+	}
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	sum := binary.BigEndian.Uint32(header[0:4])
+	size := binary.BigEndian.Uint32(header[4:8])
+	if size < 2 || size > maxRecordSize {
+		return "", nil, 0, errCorrupt
+	}
+
+	body := make([]byte, size)
+
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return "", nil, 0, errCorrupt
+	}
+
+	if crc32.ChecksumIEEE(body) != sum {
+		return "", nil, 0, errCorrupt
+	}
+
+	idLen := int(binary.BigEndian.Uint16(body[0:2]))
+	if 2+idLen > len(body) {
+		return "", nil, 0, errCorrupt
+	}
+
+	return string(body[2 : 2+idLen]), body[2+idLen:], recordHeader + int64(size), nil
+}
+
+func appendRecord(buf []byte, m Message) []byte {
+	body := make([]byte, 2, 2+len(m.ID)+len(m.Payload))
+	binary.BigEndian.PutUint16(body, uint16(len(m.ID)))
+	body = append(body, m.ID...)
+	body = append(body, m.Payload...)
+
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(body))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+
+	return append(buf, body...)
+}
+
+func (s *segmentStore) active() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *segmentStore) Append(msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if s.active().size >= segmentSize {
+		err := s.openSegment(s.active().num + 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	seg := s.active()
+	buf := []byte{}
+	entries := make([]segmentEntry, 0, len(msgs))
+
+	for _, m := range msgs {
+		if 2+len(m.ID)+len(m.Payload) > maxRecordSize {
+			return fmt.Errorf("message %s is larger than %d bytes", m.ID, maxRecordSize)
+		}
+
+		entries = append(entries, segmentEntry{id: m.ID, seg: seg, off: seg.size + int64(len(buf))})
+		buf = appendRecord(buf, m)
+	}
+
+	_, err := seg.f.Write(buf)
+	if err != nil {
+		// Leave the file as it was so the next append starts cleanly.
+		seg.f.Truncate(seg.size)
+		seg.f.Seek(seg.size, io.SeekStart)
+		return err
+	}
+
+	err = seg.f.Sync()
+	if err != nil {
+		return err
+	}
+
+	seg.size += int64(len(buf))
+	seg.last = msgs[len(msgs)-1].ID
+	s.index = append(s.index, entries...)
+
+	return nil
+}
+
+func (s *segmentStore) read(e segmentEntry) (*Message, error) {
+	r := io.NewSectionReader(e.seg.f, e.off, e.seg.size-e.off)
+
+	id, payload, _, err := readRecord(r)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d at %d: %w", e.seg.num, e.off, err)
+	}
+
+	return &Message{ID: id, Payload: payload}, nil
+}
+
+// search returns the index of the first entry with an ID greater than id.
+func (s *segmentStore) search(id string) int {
+	return sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].id > id
+	})
+}
+
+func (s *segmentStore) After(id string) (*Message, error) {
+	i := s.search(id)
+	if i == len(s.index) {
+		return nil, nil
+	}
+
+	return s.read(s.index[i])
+}
+
+func (s *segmentStore) Get(id string) (*Message, error) {
+	i := s.search(id) - 1
+	if i < 0 || s.index[i].id != id {
+		return nil, nil
+	}
+
+	return s.read(s.index[i])
+}
+
+func (s *segmentStore) Last() (string, error) {
+	if len(s.index) == 0 {
+		return "", nil
+	}
+
+	return s.index[len(s.index)-1].id, nil
+}
+
+func (s *segmentStore) CountAfter(id string) (int64, error) {
+	return int64(len(s.index) - s.search(id)), nil
+}
+
+// Prune removes whole segments from the front of the log, so it keeps
+// whatever shares a segment with a message that must stay. It also
+// rewrites the channel state log without superseded records.
+func (s *segmentStore) Prune(through string) error {
+	oldest := ""
+	for _, st := range s.channels {
+		for id := range st.InFlight {
+			if oldest == "" || id < oldest {
+				oldest = id
+			}
+		}
+	}
+
+	removed := 0
+	for _, seg := range s.segments[:len(s.segments)-1] {
+		if seg.last > through || (oldest != "" && oldest <= seg.last) {
+			break
+		}
+		removed++
+	}
+
+	err := s.dropSegments(removed)
+	if err != nil {
+		return err
+	}
+
+	return s.compactState()
+}
+
+// Retain removes whole segments from the front of the log, never the one
+// being written, and counts record headers towards maxBytes.
+func (s *segmentStore) Retain(before string, maxBytes int64) error {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+
+	removed := 0
+	for _, seg := range s.segments[:len(s.segments)-1] {
+		old := before != "" && seg.last < before
+		over := maxBytes > 0 && total > maxBytes
+		if !old && !over {
+			break
+		}
+
+		total -= seg.size
+		removed++
+	}
+
+	return s.dropSegments(removed)
+}
+
+// dropSegments deletes the first n segments.
+func (s *segmentStore) dropSegments(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	for _, seg := range s.segments[:n] {
+		seg.f.Close()
+
+		err := os.Remove(s.segmentPath(seg.num))
+		if err != nil {
+			return err
+		}
+	}
+
+	kept := s.segments[n:]
+	i := sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].seg.num >= kept[0].num
+	})
+
+	s.segments = append([]*segment(nil), kept...)
+	s.index = append([]segmentEntry(nil), s.index[i:]...)
+
+	return nil
+}
+
+func (s *segmentStore) statePath() string {
+	return filepath.Join(s.dir, "channels.log")
+}
+
+func (s *segmentStore) loadState() error {
+	f, err := os.OpenFile(s.statePath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	s.state = f
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rec := stateRecord{}
+
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			// A torn last line; everything before it applies.
+			break
+		}
+
+		s.apply(rec)
+	}
+
+	return scanner.Err()
+}
+
+func (s *segmentStore) channel(name string) *ChannelState {
+	st, ok := s.channels[name]
+	if !ok {
+		st = &ChannelState{InFlight: map[string]InFlight{}}
+		s.channels[name] = st
+	}
+
+	return st
+}
+
+func (s *segmentStore) apply(rec stateRecord) {
+	st := s.channel(rec.Channel)
+
+	switch rec.Op {
+	case "cursor":
+		st.LastRead = rec.ID
+	case "put":
+		st.InFlight[rec.ID] = InFlight{ID: rec.ID, Attempts: rec.Attempts, Deadline: rec.Deadline}
+	case "del":
+		delete(st.InFlight, rec.ID)
+	}
+}
+
+// writeState appends rec to the state log. It is not synced: after a crash
+// a channel may see recent messages again, as with any unacked delivery.
+func (s *segmentStore) writeState(rec stateRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.state.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+
+	s.apply(rec)
+
+	return nil
+}
+
+// compactState rewrites the state log with one record per cursor and
+// in-flight message.
+func (s *segmentStore) compactState() error {
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+
+	for name, st := range s.channels {
+		err := enc.Encode(stateRecord{Op: "cursor", Channel: name, ID: st.LastRead})
+		if err != nil {
+			return err
+		}
+
+		for _, f := range st.InFlight {
+			err = enc.Encode(stateRecord{Op: "put", Channel: name, ID: f.ID, Attempts: f.Attempts, Deadline: f.Deadline})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	tmp := s.statePath() + ".tmp"
+
+	err := os.WriteFile(tmp, []byte(buf.String()), 0666)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, s.statePath())
+	if err != nil {
+		return err
+	}
+
+	s.state.Close()
+
+	s.state, err = os.OpenFile(s.statePath(), os.O_WRONLY|os.O_APPEND, 0666)
+	return err
+}
+
+func (s *segmentStore) Channels() (map[string]*ChannelState, error) {
+	channels := map[string]*ChannelState{}
+	for name, st := range s.channels {
+		cp := &ChannelState{
+			LastRead: st.LastRead,
+			InFlight: map[string]InFlight{},
+		}
+		for id, f := range st.InFlight {
+			cp.InFlight[id] = f
+		}
+		channels[name] = cp
+	}
+
+	return channels, nil
+}
+
+func (s *segmentStore) SetCursor(channel string, id string) error {
+	return s.writeState(stateRecord{Op: "cursor", Channel: channel, ID: id})
+}
+
+func (s *segmentStore) PutInFlight(channel string, f InFlight) error {
+	return s.writeState(stateRecord{Op: "put", Channel: channel, ID: f.ID, Attempts: f.Attempts, Deadline: f.Deadline})
+}
+
+func (s *segmentStore) DeleteInFlight(channel string, id string) error {
+	return s.writeState(stateRecord{Op: "del", Channel: channel, ID: id})
+}
+
+func (s *segmentStore) Clear() error {
+	next := s.active().num + 1
+
+	for _, seg := range s.segments {
+		seg.f.Close()
+
+		err := os.Remove(s.segmentPath(seg.num))
+		if err != nil {
+			return err
+		}
+	}
+
+	s.segments = nil
+	s.index = nil
+	s.channels = map[string]*ChannelState{}
+
+	err := s.openSegment(next)
+	if err != nil {
+		return err
+	}
+
+	return s.compactState()
+}
+
+func (s *segmentStore) Close() error {
+	var err error
+
+	for _, seg := range s.segments {
+		if cerr := seg.f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	if s.state != nil {
+		if cerr := s.state.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+func (s *segmentStore) Drop() error {
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,502 @@
+package messagequeue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxFrameSize bounds a single protocol frame, payload included.
+const maxFrameSize = 16 << 20
+
+// Server exposes an Exchange over TCP so separate processes can publish,
+// consume and manage topics. See protocol.go for the wire format.
+type Server struct {
+	ex *Exchange
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*serverConn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func NewServer(ex *Exchange) *Server {
+	return &Server{
+		ex:    ex,
+		conns: map[*serverConn]struct{}{},
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrStopped
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	log.Println("serving exchange on", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+
+			return err
+		}
+
+		c := newServerConn(s, conn)
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Addr returns the listener's address, or nil before Serve is called.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes every open one. Messages
+// delivered but not yet acked stay unread and are delivered again when
+// their channel is next consumed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+type serverConn struct {
+	srv  *Server
+	conn net.Conn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mu   sync.Mutex
+	subs map[string]*serverSub
+	wg   sync.WaitGroup
+}
+
+type serverSub struct {
+	topic   string
+	channel string
+	acks    chan *frame // ack and nack frames
+	cancel  context.CancelFunc
+}
+
+func newServerConn(s *Server, conn net.Conn) *serverConn {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &serverConn{
+		srv:    s,
+		conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+		enc:    json.NewEncoder(conn),
+		subs:   map[string]*serverSub{},
+	}
+}
+
+func (c *serverConn) serve() {
+	defer func() {
+		c.cancel()
+		c.conn.Close()
+		c.wg.Wait()
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), maxFrameSize)
+
+	for scanner.Scan() {
+		var req frame
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.send(&frame{Error: "invalid frame: " + err.Error()})
+			return
+		}
+
+		resp := c.handle(&req)
+		if resp == nil {
+			continue
+		}
+
+		resp.ID = req.ID
+		if err := c.send(resp); err != nil {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Println("connection", c.conn.RemoteAddr(), err)
+	}
+}
+
+func (c *serverConn) send(f *frame) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.enc.Encode(f)
+}
+
+func errFrame(err error) *frame {
+	return &frame{Error: err.Error()}
+}
+
+// handle runs a request and returns its response, or nil for requests that
+// get none (acks and nacks).
+func (c *serverConn) handle(req *frame) *frame {
+	ex := c.srv.ex
+
+	switch req.Op {
+	case opPing:
+		return &frame{}
+
+	case opPublish:
+		p, err := ex.NewPublisher(req.Topic)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		id, err := p.Publish(req.Topic, req.Payload)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{MsgID: id}
+
+	case opPublishBatch:
+		p, err := ex.NewPublisher(req.Topic)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		ids, err := p.PublishBatch(req.Topic, req.Payloads)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{MsgIDs: ids}
+
+	case opSubscribe:
+		if req.Sub == "" || req.Topic == "" || req.Channel == "" {
+			return errFrame(errors.New("subscribe needs sub, topic and channel"))
+		}
+
+		start := Position{}
+		if req.Start != nil {
+			start = *req.Start
+		}
+
+		if err := c.subscribe(req.Sub, req.Topic, req.Channel, start); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opUnsubscribe:
+		c.mu.Lock()
+		sub, ok := c.subs[req.Sub]
+		delete(c.subs, req.Sub)
+		c.mu.Unlock()
+
+		if ok {
+			sub.cancel()
+		}
+
+		return &frame{}
+
+	case opAck, opNack:
+		c.mu.Lock()
+		sub, ok := c.subs[req.Sub]
+		c.mu.Unlock()
+
+		if !ok {
+			return nil
+		}
+
+		// consume waits for one reply at a time, so a reply still queued is
+		// for a delivery it gave up on and this one replaces it.
+		for {
+			select {
+			case sub.acks <- req:
+				return nil
+			default:
+			}
+
+			select {
+			case stale := <-sub.acks:
+				log.Println("dropping stale", stale.Op, sub.topic, sub.channel, stale.MsgID)
+			default:
+			}
+		}
+
+	case opCreateTopic:
+		if err := ex.CreateTopic(req.Topic); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opClearTopic:
+		t, err := ex.GetTopic(req.Topic)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		if err := t.Clear(); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opDeleteTopic:
+		if err := ex.DeleteTopic(req.Topic); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opTopics:
+		return &frame{Topics: ex.Topics()}
+
+	case opMetrics:
+		t, err := ex.GetTopic(req.Topic)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		m, err := t.Metrics()
+		if err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{Metrics: m}
+
+	case opSeek:
+		start := Position{}
+		if req.Start != nil {
+			start = *req.Start
+		}
+
+		if err := ex.Seek(req.Topic, req.Channel, start); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opDeclareExchange:
+		if err := ex.DeclareExchange(req.Exchange, req.Kind); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opDeleteExchange:
+		if err := ex.DeleteExchange(req.Exchange); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opBind, opUnbind:
+		if req.Binding == nil {
+			return errFrame(errors.New("missing binding"))
+		}
+
+		bind := ex.Bind
+		if req.Op == opUnbind {
+			bind = ex.Unbind
+		}
+
+		if err := bind(*req.Binding); err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{}
+
+	case opPublishTo:
+		p, err := ex.NewPublisher()
+		if err != nil {
+			return errFrame(err)
+		}
+
+		ids, err := p.PublishTo(req.Exchange, req.Key, req.Payload, req.Headers)
+		if err != nil {
+			return errFrame(err)
+		}
+
+		return &frame{Routed: ids}
+	}
+
+	return errFrame(fmt.Errorf("unknown op %q", req.Op))
+}
+
+func (c *serverConn) subscribe(id, topicName, channel string, start Position) error {
+	ex := c.srv.ex
+
+	t, err := ex.GetTopic(topicName)
+	if errors.Is(err, ErrTopicNotFound) {
+		if err = ex.CreateTopic(topicName); err == nil {
+			t, err = ex.GetTopic(topicName)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	err = t.open(channel, start)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub := &serverSub{
+		topic:   topicName,
+		channel: channel,
+		acks:    make(chan *frame, 1),
+		cancel:  cancel,
+	}
+
+	c.mu.Lock()
+	if _, ok := c.subs[id]; ok {
+		c.mu.Unlock()
+		cancel()
+		return fmt.Errorf("subscription %q already exists", id)
+	}
+	c.subs[id] = sub
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	go func() {
+		defer c.wg.Done()
+		c.consume(ctx, id, t, sub)
+	}()
+
+	return nil
+}
+
+// consume pushes messages claimed from the channel to the client one at a
+// time. Other subscriptions on the same channel, on this connection or
+// another, claim different messages.
+func (c *serverConn) consume(ctx context.Context, id string, t *topic, sub *serverSub) {
+	ex := c.srv.ex
+
+	for {
+		d, err := ex.next(ctx, t, sub.channel)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("consume", sub.topic, sub.channel, err)
+			}
+			return
+		}
+
+		err = c.send(&frame{Op: opMessage, Sub: id, MsgID: d.ID, Payload: d.Payload})
+		if err != nil {
+			t.nack(sub.channel, d.ID, 0)
+			return
+		}
+
+		var reply *frame
+		expired := time.NewTimer(ex.ackTimeout)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				expired.Stop()
+				// Hand the message to another consumer right away
+				// rather than after its ack deadline.
+				t.nack(sub.channel, d.ID, 0)
+				return
+			case <-expired.C:
+				// The message's ack deadline has passed, so the channel
+				// delivers it again, here or to another subscription.
+				log.Println("ack timeout:", sub.topic, sub.channel, d.ID)
+				reply = nil
+				break wait
+			case reply = <-sub.acks:
+				if reply.MsgID == d.ID {
+					expired.Stop()
+					break wait
+				}
+			}
+		}
+
+		if reply == nil {
+			continue
+		}
+
+		if reply.Op == opNack {
+			err = ex.nack(t, sub.channel, d)
+		} else {
+			err = t.ack(sub.channel, d.ID)
+		}
+
+		if err != nil {
+			log.Println("ack", sub.topic, sub.channel, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package messagequeue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, ex *Exchange) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(ex)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return l.Addr().String()
+}
+
+func dialTest(t *testing.T, addr string) *Client {
+	t.Helper()
+
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func TestServer_RoundTrip(t *testing.T) {
+	ex := newTestExchange(t, ExchangeOpts{})
+	c := dialTest(t, newTestServer(t, ex))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	got := make(chan string, 1)
+	_, err := c.Subscribe(ctx, "orders", "billing", func(id string, payload []byte) error {
+		got <- id + ":" + string(payload)
+		return nil
+	}, &SubscribeOpts{Start: FromEarliest()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := c.Publish(ctx, "orders", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg != id+":hello" {
+			t.Fatalf("received %q, want %q", msg, id+":hello")
+		}
+	case <-ctx.Done():
+		t.Fatal("message not delivered")
+	}
+
+	// The ack travels back asynchronously.
+	for {
+		m, err := c.Metrics(ctx, "orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.TotalInFlight == 0 && m.Channels["billing"].Lag == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("message still in flight: %+v", m)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_ConsumersShareChannel(t *testing.T) {
+	ex := newTestExchange(t, ExchangeOpts{})
+	addr := newTestServer(t, ex)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const total = 20
+
+	var mu sync.Mutex
+	seen := map[string]int{}
+	per := [2]int{}
+	done := make(chan struct{})
+
+	for i := range per {
+		c := dialTest(t, addr)
+		_, err := c.Subscribe(ctx, "jobs", "workers", func(id string, payload []byte) error {
+			// Hold each message briefly so the other consumer gets a turn.
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			seen[string(payload)]++
+			per[i]++
+			if len(seen) == total {
+				close(done)
+			}
+			return nil
+		}, &SubscribeOpts{Start: FromEarliest()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pub := dialTest(t, addr)
+	for i := 0; i < total; i++ {
+		_, err := pub.Publish(ctx, "jobs", []byte(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		t.Fatalf("received %d of %d messages", len(seen), total)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for payload, n := range seen {
+		if n != 1 {
+			t.Errorf("message %s delivered %d times", payload, n)
+		}
+	}
+	if per[0] == 0 || per[1] == 0 {
+		t.Errorf("messages were not shared: %v", per)
+	}
+}
+
+func TestServer_UnackedMessageRedeliveredAfterAckTimeout(t *testing.T) {
+	ex := newTestExchange(t, ExchangeOpts{AckTimeout: 100 * time.Millisecond})
+	addr := newTestServer(t, ex)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	start := FromEarliest()
+	err = enc.Encode(&frame{Op: opSubscribe, ID: 1, Sub: "s", Topic: "jobs", Channel: "workers", Start: &start})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp frame
+	if err := dec.Decode(&resp); err != nil || resp.Error != "" {
+		t.Fatalf("subscribe: %v %s", err, resp.Error)
+	}
+
+	p, _ := ex.NewPublisher()
+	id, err := p.Publish("jobs", []byte("job-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Never acking the first delivery must not stall the subscription.
+	for attempt := 1; attempt <= 2; attempt++ {
+		var msg frame
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("delivery %d: %v", attempt, err)
+		}
+		if msg.Op != opMessage || msg.MsgID != id {
+			t.Fatalf("delivery %d: got %+v, want message %s", attempt, msg, id)
+		}
+	}
+
+	// A late reply for an earlier delivery must not hide the real one.
+	enc.Encode(&frame{Op: opAck, Sub: "s", MsgID: "stale"})
+	enc.Encode(&frame{Op: opAck, Sub: "s", MsgID: id})
+
+	tp, err := ex.GetTopic("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m, err := tp.Metrics()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.TotalInFlight == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ack was dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
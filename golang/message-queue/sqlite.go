@@ -0,0 +1,310 @@
+package messagequeue
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+type sqliteStore struct {
+	db   *sql.DB
+	path string
+}
+
+// OpenSQLiteStore keeps a topic in a SQLite database at dir/<topic>.db.
+func OpenSQLiteStore(dir string, topic string) (Store, error) {
+	path := filepath.Join(dir, topic+".db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a second pooled connection writing while
+	// another holds a transaction fails with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS messages (
+		id text not null,
+		timestamp int not null,
+		msg blob not null,
+		primary key (id)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS channels (
+		id text not null,
+		last_read text not null,
+		primary key (id)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS inflight (
+		channel text not null,
+		id text not null,
+		attempts int not null,
+		deadline int not null,
+		primary key (channel, id)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db, path: path}, nil
+}
+
+func (s *sqliteStore) Append(msgs []Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT INTO messages (id, timestamp, msg)
+		VALUES (?, ?, ?)`,
+	)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, m := range msgs {
+		millis, _, _ := decodeID(m.ID)
+
+		_, err = stmt.Exec(m.ID, millis, m.Payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) After(id string) (*Message, error) {
+	m := &Message{}
+
+	err := s.db.QueryRow(
+		`SELECT id, msg
+		FROM messages
+		WHERE id > ? ORDER BY id ASC LIMIT 1`,
+		id,
+	).
+		Scan(&m.ID, &m.Payload)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *sqliteStore) Get(id string) (*Message, error) {
+	m := &Message{}
+
+	err := s.db.QueryRow(`SELECT id, msg FROM messages WHERE id = ?`, id).
+		Scan(&m.ID, &m.Payload)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *sqliteStore) Last() (string, error) {
+	last := ""
+
+	err := s.db.QueryRow(`SELECT id FROM messages ORDER BY id DESC LIMIT 1`).
+		Scan(&last)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return last, err
+}
+
+func (s *sqliteStore) CountAfter(id string) (int64, error) {
+	var count int64
+
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE id > ?`, id).
+		Scan(&count)
+
+	return count, err
+}
+
+func (s *sqliteStore) Prune(through string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM messages
+		WHERE id <= ?
+		AND id NOT IN (SELECT id FROM inflight)`,
+		through,
+	)
+	return err
+}
+
+func (s *sqliteStore) Retain(before string, maxBytes int64) error {
+	if before != "" {
+		_, err := s.db.Exec(`DELETE FROM messages WHERE id < ?`, before)
+		if err != nil {
+			return err
+		}
+	}
+
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	// The newest message that would push the total over maxBytes, counting
+	// from the newest, is the last one to go.
+	cutoff := ""
+
+	err := s.db.QueryRow(
+		`SELECT id FROM (
+			SELECT id, SUM(LENGTH(msg)) OVER (ORDER BY id DESC) AS total
+			FROM messages
+		)
+		WHERE total > ?
+		ORDER BY id DESC LIMIT 1`,
+		maxBytes,
+	).
+		Scan(&cutoff)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`DELETE FROM messages WHERE id <= ?`, cutoff)
+	return err
+}
+
+func (s *sqliteStore) Channels() (map[string]*ChannelState, error) {
+	channels := map[string]*ChannelState{}
+
+	rows, err := s.db.Query(`SELECT id, last_read FROM channels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		id := ""
+		st := &ChannelState{InFlight: map[string]InFlight{}}
+
+		err = rows.Scan(&id, &st.LastRead)
+		if err != nil {
+			return nil, err
+		}
+
+		channels[id] = st
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`SELECT channel, id, attempts, deadline FROM inflight`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		channel := ""
+		f := InFlight{}
+
+		err = rows.Scan(&channel, &f.ID, &f.Attempts, &f.Deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		st, ok := channels[channel]
+		if !ok {
+			continue
+		}
+
+		st.InFlight[f.ID] = f
+	}
+
+	return channels, rows.Err()
+}
+
+func (s *sqliteStore) SetCursor(channel string, id string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO CHANNELS (id, last_read) values (?, ?)
+		ON CONFLICT(id)
+		DO UPDATE SET last_read = ?;`,
+		channel, id, id,
+	)
+	return err
+}
+
+func (s *sqliteStore) PutInFlight(channel string, f InFlight) error {
+	_, err := s.db.Exec(`
+		INSERT INTO inflight (channel, id, attempts, deadline) values (?, ?, ?, ?)
+		ON CONFLICT(channel, id)
+		DO UPDATE SET attempts = ?, deadline = ?;`,
+		channel, f.ID, f.Attempts, f.Deadline, f.Attempts, f.Deadline,
+	)
+	return err
+}
+
+func (s *sqliteStore) DeleteInFlight(channel string, id string) error {
+	_, err := s.db.Exec(`DELETE FROM inflight WHERE channel = ? AND id = ?`, channel, id)
+	return err
+}
+
+func (s *sqliteStore) Clear() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM messages`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM channels`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM inflight`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) Drop() error {
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package messagequeue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Message is a stored message.
+type Message struct {
+	ID      string
+	Payload []byte
+}
+
+// InFlight is a message delivered on a channel but not yet acked.
+type InFlight struct {
+	ID       string
+	Attempts int
+	Deadline int64 // unix millis
+}
+
+// ChannelState is the delivery state of one channel.
+type ChannelState struct {
+	LastRead string
+	InFlight map[string]InFlight
+}
+
+// Store persists a topic's messages and the delivery state of its
+// channels. The topic serializes calls, so a Store need not be safe for
+// concurrent use.
+type Store interface {
+	// Append stores msgs, whose IDs are increasing and greater than any
+	// already stored, in a single write.
+	Append(msgs []Message) error
+	// After returns the first message with an ID greater than id, or nil.
+	After(id string) (*Message, error)
+	// Get returns the message with id, or nil if it is gone.
+	Get(id string) (*Message, error)
+	// Last returns the newest message's ID, or "" if there are none.
+	Last() (string, error)
+	// CountAfter returns how many stored messages have an ID greater than
+	// id; CountAfter("") counts them all.
+	CountAfter(id string) (int64, error)
+	// Prune deletes messages with IDs up to and including through, except
+	// those in flight on some channel. It may keep more than that.
+	Prune(through string) error
+	// Retain deletes messages with IDs below before, then the oldest ones
+	// until the payloads left total at most maxBytes, whether or not
+	// channels have read them. An empty before or zero maxBytes is no
+	// bound. It may keep more than that.
+	Retain(before string, maxBytes int64) error
+
+	// Channels returns every channel's state.
+	Channels() (map[string]*ChannelState, error)
+	SetCursor(channel string, id string) error
+	PutInFlight(channel string, f InFlight) error
+	DeleteInFlight(channel string, id string) error
+
+	// Clear deletes all messages and channels.
+	Clear() error
+	Close() error
+	// Drop deletes the store's data. It is called after Close.
+	Drop() error
+}
+
+// OpenStore opens the store for a topic, keeping its data under dir.
+type OpenStore func(dir string, topic string) (Store, error)
+
+func EncodeTimestamp(millis int64) string {
+	return fmt.Sprintf("%024d", millis)
+}
+
+// maxSeq bounds the sequence part of an ID; a millisecond with more
+// messages borrows from the next one.
+const maxSeq = 999999
+
+// EncodeID formats a message ID from a millisecond timestamp and a
+// sequence number within it. IDs sort in the order they were assigned,
+// after any ID made by EncodeTimestamp for the same millisecond.
+func EncodeID(millis int64, seq int64) string {
+	return fmt.Sprintf("%024d-%06d", millis, seq)
+}
+
+// idBefore returns a cursor just below id, so reading after it starts at
+// id. An ID not made by EncodeID or EncodeTimestamp is returned as is.
+func idBefore(id string) string {
+	millis, seq, ok := decodeID(id)
+	if !ok {
+		return id
+	}
+
+	if seq > 0 {
+		return EncodeID(millis, seq-1)
+	}
+
+	return EncodeID(millis-1, maxSeq)
+}
+
+// decodeID splits an ID made by EncodeID or EncodeTimestamp.
+func decodeID(id string) (millis int64, seq int64, ok bool) {
+	ts, s, hasSeq := strings.Cut(id, "-")
+
+	millis, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if hasSeq {
+		seq, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+
+	return millis, seq, true
+}
+
+// idGen hands out increasing IDs even when the clock stalls or steps back.
+// The topic's lock guards it.
+type idGen struct {
+	millis int64
+	seq    int64
+}
+
+// seed makes later IDs sort after last.
+func (g *idGen) seed(last string) {
+	millis, seq, ok := decodeID(last)
+	if !ok {
+		return
+	}
+
+	g.millis = millis
+	g.seq = seq
+}
+
+func (g *idGen) next(now int64) string {
+	if now > g.millis {
+		g.millis = now
+		g.seq = 0
+	} else if g.seq < maxSeq {
+		g.seq++
+	} else {
+		g.millis++
+		g.seq = 0
+	}
+
+	return EncodeID(g.millis, g.seq)
+}
@@ -2,27 +2,66 @@ package messagequeue
 
 import (
 	"context"
-	"database/sql"
 	"errors"
-	"fmt"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	_ "modernc.org/sqlite"
 )
 
 var ErrStopped = errors.New("stopped")
 
-func EncodeTimestamp(millis int64) string {
-	return fmt.Sprintf("%024d", millis)
+// Retention bounds how much of a topic is kept, whether or not its channels
+// have read it. With neither MaxAge nor MaxBytes set, messages are deleted
+// once every channel has read them.
+type Retention struct {
+	// MaxAge deletes messages older than this.
+	MaxAge time.Duration
+	// MaxBytes deletes the oldest messages while payloads total more.
+	MaxBytes int64
+	// Interval is how often retention runs. Defaults to 5 minutes.
+	Interval time.Duration
+}
+
+func (r Retention) bounded() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0
+}
+
+// Position is where a new or rewound channel starts reading. The zero
+// Position is the latest: only messages published from then on.
+type Position struct {
+	// After starts at the first message with a greater ID.
+	After string `json:"after,omitempty"`
+	// Earliest starts at the oldest retained message.
+	Earliest bool `json:"earliest,omitempty"`
+}
+
+// FromLatest starts after the newest message.
+func FromLatest() Position {
+	return Position{}
+}
+
+// FromEarliest starts at the oldest retained message.
+func FromEarliest() Position {
+	return Position{Earliest: true}
+}
+
+// FromID starts at the message with id, or the first one after it.
+func FromID(id string) Position {
+	return Position{After: idBefore(id)}
+}
+
+// FromTime starts at the first message published at or after ts.
+func FromTime(ts time.Time) Position {
+	return Position{After: idBefore(EncodeID(ts.UnixMilli(), 0))}
 }
 
 type topic struct {
-	db        *sql.DB
+	store     Store
+	retention Retention
 	name      string
 	last      string
+	ids       idGen
+	channels  map[string]*ChannelState
 	consumers []chan struct{}
 	running   atomic.Bool
 	rw        sync.RWMutex
@@ -32,65 +71,35 @@ type topic struct {
 	once   sync.Once
 }
 
-func newTopic(name string) (*topic, error) {
-	if strings.HasSuffix(name, "#temp") {
-		t := &topic{
-			name:      name,
-			consumers: []chan struct{}{},
-		}
-
-		go t.gc()
+func newTopic(name string, store Store, retention Retention) (*topic, error) {
+	if retention.Interval <= 0 {
+		retention.Interval = 5 * time.Minute
 	}
 
-	db, err := sql.Open("sqlite", "./_msq_/"+name+".db")
+	last, err := store.Last()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS messages (
-		id text not null,
-		timestamp int not null,
-		msg blob not null,
-		primary key (id)
-	)`)
+	channels, err := store.Channels()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS channels (
-		id text not null,
-		last_read text not null,
-		primary key (id)
-	)`)
-	if err != nil {
-		return nil, err
-	}
-
-	last := ""
-	err = db.QueryRow(
-		`SELECT last_read
-		FROM channels
-		ORDER BY last_read DESC
-		LIMIT 1`,
-	).
-		Scan(&last)
-	if err != sql.ErrNoRows && err != nil {
-		return nil, err
-	}
-
 	ctx, cancel := context.WithCancel(context.Background())
 
 	t := &topic{
+		store:     store,
+		retention: retention,
 		name:      name,
-		db:        db,
+		channels:  channels,
 		consumers: []chan struct{}{},
 		last:      last,
 		ctx:       ctx,
 		cancel:    cancel,
 		once:      sync.Once{},
 	}
+	t.ids.seed(last)
 
 	go t.gc()
 
@@ -104,26 +113,52 @@ func (t *topic) Name() string {
 type Metrics struct {
 	TotalMessages int64
 	TotalChannels int64
+	TotalInFlight int64
+	Channels      map[string]ChannelMetrics
+}
+
+type ChannelMetrics struct {
+	LastRead string
+	// Lag is how many messages the channel has yet to receive.
+	Lag      int64
+	InFlight int64
 }
 
 func (t *topic) Metrics() (*Metrics, error) {
-	var totalMessages, totalChannels int64
+	t.rw.RLock()
+	defer t.rw.RUnlock()
 
-	err := t.db.QueryRow(`SELECT COUNT(*) FROM messages`).
-		Scan(&totalMessages)
-	if err != nil {
-		return nil, err
+	if !t.running.Load() {
+		return nil, ErrStopped
 	}
 
-	err = t.db.QueryRow(`SELECT COUNT(*) FROM channels`).
-		Scan(&totalChannels)
+	totalMessages, err := t.store.CountAfter("")
 	if err != nil {
 		return nil, err
 	}
 
+	var totalInFlight int64
+	channels := map[string]ChannelMetrics{}
+
+	for name, st := range t.channels {
+		lag, err := t.store.CountAfter(st.LastRead)
+		if err != nil {
+			return nil, err
+		}
+
+		channels[name] = ChannelMetrics{
+			LastRead: st.LastRead,
+			Lag:      lag,
+			InFlight: int64(len(st.InFlight)),
+		}
+		totalInFlight += int64(len(st.InFlight))
+	}
+
 	return &Metrics{
 		TotalMessages: totalMessages,
-		TotalChannels: totalChannels,
+		TotalChannels: int64(len(t.channels)),
+		TotalInFlight: totalInFlight,
+		Channels:      channels,
 	}, nil
 }
 
@@ -141,24 +176,16 @@ out:
 		case <-t.ctx.Done():
 			break out
 		case <-instant:
-			last := ""
-			instant = time.After(5 * time.Minute)
-
-			err := t.db.QueryRow(
-				`SELECT last_read
-				FROM channels
-				ORDER BY last_read
-				DESC LIMIT 1`,
-			).Scan(&last)
-			if err == sql.ErrNoRows {
-				continue
-			}
+			instant = time.After(t.retention.Interval)
 
-			if err != nil {
-				panic(err)
+			t.rw.Lock()
+			if t.ctx.Err() != nil {
+				// Stopped while waiting; the store is closed.
+				t.rw.Unlock()
+				break out
 			}
-
-			_, err = t.db.Exec(`DELETE FROM messages WHERE id <= ?`, last)
+			err := t.compact()
+			t.rw.Unlock()
 			if err != nil {
 				panic(err)
 			}
@@ -166,6 +193,40 @@ out:
 	}
 }
 
+// compact applies the topic's retention, or prunes read messages if it
+// has none. t.rw must be held.
+func (t *topic) compact() error {
+	if !t.retention.bounded() {
+		return t.prune()
+	}
+
+	before := ""
+	if t.retention.MaxAge > 0 {
+		before = EncodeID(time.Now().Add(-t.retention.MaxAge).UnixMilli(), 0)
+	}
+
+	return t.store.Retain(before, t.retention.MaxBytes)
+}
+
+// prune deletes messages every channel has moved past, keeping those still
+// in flight on some channel. t.rw must be held.
+func (t *topic) prune() error {
+	if len(t.channels) == 0 {
+		return nil
+	}
+
+	through := ""
+	first := true
+	for _, st := range t.channels {
+		if first || st.LastRead < through {
+			through = st.LastRead
+			first = false
+		}
+	}
+
+	return t.store.Prune(through)
+}
+
 func newClosedChan[T any]() <-chan T {
 	ch := make(chan T)
 	close(ch)
@@ -173,282 +234,371 @@ func newClosedChan[T any]() <-chan T {
 }
 
 func (t *topic) Send(msg []byte) (string, error) {
-	if !t.running.Load() {
-		return "", ErrStopped
+	ids, err := t.SendBatch([][]byte{msg})
+	if err != nil {
+		return "", err
+	}
+
+	return ids[0], nil
+}
+
+// SendBatch stores msgs in one write and returns their IDs in order.
+func (t *topic) SendBatch(msgs [][]byte) ([]string, error) {
+	if len(msgs) == 0 {
+		return nil, nil
 	}
 
 	t.rw.Lock()
 	defer t.rw.Unlock()
 
+	if !t.running.Load() {
+		return nil, ErrStopped
+	}
+
 	now := time.Now().UnixMilli()
-	id := EncodeTimestamp(now)
+	batch := make([]Message, len(msgs))
+	ids := make([]string, len(msgs))
 
-	_, err := t.db.Exec(
-		`INSERT INTO messages (id, timestamp, msg)
-		VALUES (?, ?, ?)`,
-		id, now, msg,
-	)
+	for i, msg := range msgs {
+		ids[i] = t.ids.next(now)
+		batch[i] = Message{ID: ids[i], Payload: msg}
+	}
+
+	err := t.store.Append(batch)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
+	t.notify()
+	t.last = ids[len(ids)-1]
+
+	return ids, nil
+}
+
+// notify wakes every consumer waiting for a message. t.rw must be held.
+func (t *topic) notify() {
 	for _, v := range t.consumers {
 		close(v)
 	}
 	t.consumers = nil
-	t.last = id
-
-	time.Sleep(time.Millisecond * 5)
+}
 
-	return id, nil
+// Delivery is a message handed to one consumer of a channel. It stays in
+// flight until it is acked or nacked, and is delivered again if neither
+// happens before its ack deadline.
+type Delivery struct {
+	ID      string
+	Payload []byte
+	Attempt int
 }
 
-func (t *topic) readNext(channel string) (string, []byte, error) {
-	last := ""
+// claim hands the channel's next message to the caller, waiting for one if
+// none is available. Messages whose ack deadline passed come first, then
+// messages after the channel's last_read. Consumers sharing a channel each
+// claim different messages.
+func (t *topic) claim(ctx context.Context, channel string, ackTimeout time.Duration) (*Delivery, error) {
+	for {
+		t.rw.Lock()
+		if !t.running.Load() {
+			t.rw.Unlock()
+			return nil, ErrStopped
+		}
 
-	err := t.db.QueryRow(
-		`SELECT LAST_READ FROM channels WHERE id = ?`,
-		channel,
-	).Scan(&last)
-	if err == sql.ErrNoRows {
-		err = nil
-		if last == "" && t.last != "" {
-			t.rw.Lock()
-			t.markRead(channel, t.last)
-			last = t.last
+		d, wait, err := t.tryClaim(channel, ackTimeout)
+		if err != nil || d != nil {
 			t.rw.Unlock()
+			return d, err
 		}
-	} else if err != nil {
-		return "", nil, err
-	}
 
-	for {
-		if !t.running.Load() {
-			return "", nil, ErrStopped
+		ch := make(chan struct{})
+		t.consumers = append(t.consumers, ch)
+		t.rw.Unlock()
+
+		// Wake when the earliest in-flight message expires, if any.
+		var expired <-chan time.Time
+		if wait > 0 {
+			expired = time.After(wait)
 		}
 
-		id := ""
-		msg := []byte{}
+		select {
+		case <-ch:
+		case <-expired:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
 
-		err := t.db.QueryRow(
-			`SELECT id, msg
-			FROM messages
-			WHERE id > ? ORDER BY id ASC LIMIT 1`,
-			last,
-		).
-			Scan(&id, &msg)
+// tryClaim claims a message without waiting. If there is none it returns
+// how long until the channel's next in-flight message expires, or 0 if
+// nothing is in flight. t.rw must be held.
+func (t *topic) tryClaim(channel string, ackTimeout time.Duration) (*Delivery, time.Duration, error) {
+	now := time.Now().UnixMilli()
+	deadline := now + ackTimeout.Milliseconds()
 
-		// log.Println("get next", channel, id, last)
+	st, err := t.channel(channel)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		if err == sql.ErrNoRows {
-			err = nil
-			t.rw.Lock()
-			ch := make(chan struct{})
-			t.consumers = append(t.consumers, ch)
-			t.rw.Unlock()
-			<-ch
-			continue
+	var expired *InFlight
+	var next int64
+
+	for _, f := range st.InFlight {
+		if f.Deadline <= now {
+			if expired == nil || f.ID < expired.ID {
+				f := f
+				expired = &f
+			}
+		} else if next == 0 || f.Deadline < next {
+			next = f.Deadline
 		}
+	}
 
+	if expired != nil {
+		m, err := t.store.Get(expired.ID)
 		if err != nil {
-			return "", nil, err
+			return nil, 0, err
 		}
 
-		if id != "" {
-			return id, msg, nil
+		if m == nil {
+			// Gone from the store, so there is nothing to redeliver.
+			err = t.store.DeleteInFlight(channel, expired.ID)
+			if err != nil {
+				return nil, 0, err
+			}
+			delete(st.InFlight, expired.ID)
+
+			return t.tryClaim(channel, ackTimeout)
 		}
+
+		f := *expired
+		f.Attempts++
+		f.Deadline = deadline
+
+		err = t.store.PutInFlight(channel, f)
+		if err != nil {
+			return nil, 0, err
+		}
+		st.InFlight[f.ID] = f
+
+		return &Delivery{ID: m.ID, Payload: m.Payload, Attempt: f.Attempts}, 0, nil
 	}
-}
 
-func (t *topic) markRead(channel string, id string) error {
-	tx, err := t.db.Begin()
+	m, err := t.store.After(st.LastRead)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`
-		INSERT INTO CHANNELS (id, last_read) values (?, ?)
-		ON CONFLICT(id)
-		DO UPDATE SET last_read = ?;`,
-		channel, id, id,
-	)
-	return tx.Commit()
+	if m != nil {
+		f := InFlight{ID: m.ID, Attempts: 1, Deadline: deadline}
+
+		// Record the delivery before moving the cursor past it, so a crash
+		// in between delivers the message again rather than losing it.
+		err = t.store.PutInFlight(channel, f)
+		if err != nil {
+			return nil, 0, err
+		}
+		st.InFlight[f.ID] = f
+
+		err = t.store.SetCursor(channel, m.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		st.LastRead = m.ID
+
+		return &Delivery{ID: m.ID, Payload: m.Payload, Attempt: f.Attempts}, 0, nil
+	}
+
+	if next == 0 {
+		return nil, 0, nil
+	}
+
+	return nil, time.Duration(next-now+1) * time.Millisecond, nil
 }
 
-func (t *topic) Clear() error {
-	tx, err := t.db.Begin()
-	if err != nil {
-		return err
+// channel returns the channel's state. A channel seen for the first time
+// starts after the newest message. t.rw must be held.
+func (t *topic) channel(name string) (*ChannelState, error) {
+	st, ok := t.channels[name]
+	if ok {
+		return st, nil
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`DELETE FROM messages`)
+	return t.createChannel(name, Position{})
+}
+
+// createChannel starts a channel at pos. t.rw must be held.
+func (t *topic) createChannel(name string, pos Position) (*ChannelState, error) {
+	cursor := t.cursor(pos)
+
+	err := t.store.SetCursor(name, cursor)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = tx.Exec(`DELETE FROM channels`)
-	if err != nil {
-		return err
+	st := &ChannelState{LastRead: cursor, InFlight: map[string]InFlight{}}
+	t.channels[name] = st
+
+	return st, nil
+}
+
+func (t *topic) cursor(pos Position) string {
+	switch {
+	case pos.After != "":
+		return pos.After
+	case pos.Earliest:
+		return ""
 	}
 
-	return tx.Commit()
+	return t.last
 }
 
-func (t *topic) Stop() {
+// open creates the channel at pos unless it already exists, in which case
+// pos is ignored.
+func (t *topic) open(channel string, pos Position) error {
 	t.rw.Lock()
+	defer t.rw.Unlock()
 
-	t.running.Store(false)
-	t.once.Do(func() {
-		t.cancel()
-	})
+	if !t.running.Load() {
+		return ErrStopped
+	}
 
-	for _, v := range t.consumers {
-		close(v)
+	if _, ok := t.channels[channel]; ok {
+		return nil
 	}
-	t.consumers = nil
 
-	last := ""
+	_, err := t.createChannel(channel, pos)
+	return err
+}
 
-	err := t.db.QueryRow(
-		`SELECT last_read
-		FROM channels
-		ORDER BY last_read DESC 
-		LIMIT 1`,
-	).Scan(&last)
-	if err != nil {
+// seek moves the channel to pos, creating it if needed. Messages in
+// flight on the channel are forgotten; their acks become no-ops.
+func (t *topic) seek(channel string, pos Position) error {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+
+	if !t.running.Load() {
+		return ErrStopped
 	}
 
-	_, err = t.db.Exec(
-		`DELETE FROM messages
-		WHERE id <= ?`,
-		last,
-	)
-	if err != nil {
-		panic(err)
+	st, ok := t.channels[channel]
+	if !ok {
+		_, err := t.createChannel(channel, pos)
+		t.notify()
+		return err
 	}
 
-	t.db.Close()
-	t.rw.Unlock()
-}
+	for id := range st.InFlight {
+		err := t.store.DeleteInFlight(channel, id)
+		if err != nil {
+			return err
+		}
+		delete(st.InFlight, id)
+	}
 
-type memStore struct {
-	messages map[string][]byte
-	order    []string
-	lastRead map[string]string
+	cursor := t.cursor(pos)
 
-	name      string
-	last      string
-	consumers []chan struct{}
-	running   atomic.Bool
-	rw        sync.RWMutex
+	err := t.store.SetCursor(channel, cursor)
+	if err != nil {
+		return err
+	}
+	st.LastRead = cursor
 
-	ctx    context.Context
-	cancel context.CancelFunc
-	once   sync.Once
+	t.notify()
+
+	return nil
 }
 
-func newMemStore(name string) *memStore {
-	ctx, cancel := context.WithCancel(context.Background())
+// ack removes a delivered message from the channel's in-flight set.
+func (t *topic) ack(channel string, id string) error {
+	t.rw.Lock()
+	defer t.rw.Unlock()
 
-	return &memStore{
-		messages:  map[string][]byte{},
-		order:     []string{},
-		lastRead:  map[string]string{},
-		name:      name,
-		last:      "",
-		consumers: []chan struct{}{},
-		running:   atomic.Bool{},
-		ctx:       ctx,
-		cancel:    cancel,
+	if !t.running.Load() {
+		return ErrStopped
 	}
-}
 
-func (m *memStore) run() {
-	m.running.Store(true)
-}
+	st, ok := t.channels[channel]
+	if !ok {
+		return nil
+	}
 
-func (m *memStore) Send(msg []byte) (string, error) {
-	if !m.running.Load() {
-		return "", ErrStopped
+	if _, ok := st.InFlight[id]; !ok {
+		return nil
 	}
 
-	m.rw.Lock()
-	defer m.rw.Unlock()
+	err := t.store.DeleteInFlight(channel, id)
+	if err != nil {
+		return err
+	}
+	delete(st.InFlight, id)
 
-	now := time.Now().UnixMilli()
-	id := EncodeTimestamp(now)
+	return nil
+}
 
-	m.messages[id] = msg
-	m.order = append(m.order, id)
-	m.last = id
+// nack makes a delivered message available again after delay.
+func (t *topic) nack(channel string, id string, delay time.Duration) error {
+	t.rw.Lock()
+	defer t.rw.Unlock()
 
-	for _, v := range m.consumers {
-		close(v)
+	if !t.running.Load() {
+		return ErrStopped
 	}
-	m.consumers = nil
 
-	time.Sleep(time.Millisecond * 5)
+	st, ok := t.channels[channel]
+	if !ok {
+		return nil
+	}
 
-	return id, nil
-}
+	f, ok := st.InFlight[id]
+	if !ok {
+		return nil
+	}
 
-func (m *memStore) readNext(channel string) (string, []byte, error) {
-	last := ""
+	f.Deadline = time.Now().Add(delay).UnixMilli()
 
-	if m.lastRead[channel] == "" && m.last != "" {
-		m.rw.Lock()
-		m.markRead(channel, m.last)
-		last = m.last
-		m.rw.Unlock()
-	} else {
-		last = m.lastRead[channel]
+	err := t.store.PutInFlight(channel, f)
+	if err != nil {
+		return err
 	}
+	st.InFlight[id] = f
 
-	for {
-		if !m.running.Load() {
-			return "", nil, ErrStopped
-		}
-
-		id := ""
-		msg := []byte{}
+	// Waiting consumers may be sleeping until a later deadline.
+	t.notify()
 
-		for _, v := range m.order {
-			if v > last {
-				id = v
-				msg = m.messages[v]
-				break
-			}
-		}
+	return nil
+}
 
-		if id != "" {
-			return id, msg, nil
-		}
+func (t *topic) Clear() error {
+	t.rw.Lock()
+	defer t.rw.Unlock()
 
-		m.rw.Lock()
-		ch := make(chan struct{})
-		m.consumers = append(m.consumers, ch)
-		m.rw.Unlock()
-		<-ch
+	err := t.store.Clear()
+	if err != nil {
+		return err
 	}
-}
 
-func (m *memStore) markRead(channel string, id string) error {
-	m.rw.Lock()
-	defer m.rw.Unlock()
+	t.channels = map[string]*ChannelState{}
 
-	m.lastRead[channel] = id
 	return nil
 }
 
-func (m *memStore) Clear() error {
-	m.rw.Lock()
-	defer m.rw.Unlock()
+func (t *topic) Stop() {
+	t.rw.Lock()
 
-	m.messages = map[string][]byte{}
-	m.order = []string{}
-	m.lastRead = map[string]string{}
+	t.running.Store(false)
+	t.once.Do(func() {
+		t.cancel()
+	})
 
-	return nil
+	t.notify()
+
+	err := t.compact()
+	if err != nil {
+		panic(err)
+	}
+
+	t.store.Close()
+	t.rw.Unlock()
 }
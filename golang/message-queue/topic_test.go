@@ -0,0 +1,168 @@
+package messagequeue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestTopic(t *testing.T, open OpenStore) *topic {
+	t.Helper()
+
+	store, err := open(t.TempDir(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp, err := newTopic("events", store, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.run()
+	t.Cleanup(tp.Stop)
+
+	return tp
+}
+
+// claimWithin claims the channel's next message, or returns nil if none
+// arrives within d.
+func claimWithin(t *testing.T, tp *topic, channel string, d time.Duration) *Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	del, err := tp.claim(ctx, channel, time.Minute)
+	if err == context.DeadlineExceeded {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return del
+}
+
+func TestTopic_PruneKeepsInFlight(t *testing.T) {
+	stores := map[string]OpenStore{
+		"memory":  OpenMemoryStore,
+		"sqlite":  OpenSQLiteStore,
+		"segment": OpenSegmentStore,
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			tp := newTestTopic(t, open)
+
+			err := tp.open("c", FromEarliest())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids, err := tp.SendBatch([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := claimWithin(t, tp, "c", time.Second)
+			second := claimWithin(t, tp, "c", time.Second)
+			if first == nil || second == nil {
+				t.Fatal("expected two deliveries")
+			}
+
+			err = tp.ack("c", second.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The channel has read past both; only the unacked one must stay.
+			tp.rw.Lock()
+			err = tp.compact()
+			tp.rw.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := tp.store.Get(ids[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m == nil {
+				t.Fatal("in-flight message was pruned")
+			}
+
+			err = tp.nack("c", first.ID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			again := claimWithin(t, tp, "c", time.Second)
+			if again == nil || again.ID != ids[0] || string(again.Payload) != "a" {
+				t.Fatalf("redelivered %+v, want %s", again, ids[0])
+			}
+			if again.Attempt != 2 {
+				t.Fatalf("attempt = %d, want 2", again.Attempt)
+			}
+		})
+	}
+}
+
+func TestTopic_SeekPositions(t *testing.T) {
+	tp := newTestTopic(t, OpenMemoryStore)
+
+	first, err := tp.Send([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave a gap so FromTime can tell the first message from the rest.
+	time.Sleep(5 * time.Millisecond)
+
+	rest, err := tp.SendBatch([][]byte{[]byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := append([]string{first}, rest...)
+
+	millis, _, _ := decodeID(ids[1])
+
+	cases := []struct {
+		name string
+		pos  Position
+		want string
+	}{
+		{"earliest", FromEarliest(), ids[0]},
+		{"id", FromID(ids[1]), ids[1]},
+		{"last id", FromID(ids[2]), ids[2]},
+		{"time", FromTime(time.UnixMilli(millis)), ids[1]},
+		{"latest", FromLatest(), ""},
+	}
+
+	for _, c := range cases {
+		err := tp.seek("c", c.pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		if d := claimWithin(t, tp, "c", 50*time.Millisecond); d != nil {
+			got = d.ID
+		}
+
+		if got != c.want {
+			t.Errorf("%s: claimed %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// Seeking forgets what was in flight, so latest now waits for a new
+	// message rather than redelivering an old one.
+	id, err := tp.Send([]byte("d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := claimWithin(t, tp, "c", time.Second)
+	if d == nil || d.ID != id {
+		t.Fatalf("claimed %+v after latest, want %s", d, id)
+	}
+}
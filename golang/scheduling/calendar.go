@@ -0,0 +1,293 @@
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Weekdays is a set of days of the week. The zero value is every day.
+type Weekdays uint8
+
+const (
+	Sunday Weekdays = 1 << iota
+	Monday
+	Tuesday
+	Wednesday
+	Thursday
+	Friday
+	Saturday
+
+	Workdays = Monday | Tuesday | Wednesday | Thursday | Friday
+	Weekend  = Saturday | Sunday
+)
+
+func (d Weekdays) Has(day time.Weekday) bool {
+	return d == 0 || d&(1<<day) != 0
+}
+
+// Window is open from Start to End, both "HH:MM" wall clock times, on each
+// of Days. An End at or before Start falls on the next day.
+type Window struct {
+	Days  Weekdays
+	Start string
+	End   string
+
+	start int // minutes after midnight
+	end   int
+}
+
+func (w *Window) parse() error {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return fmt.Errorf("failed to parse start time - %w", err)
+	}
+
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return fmt.Errorf("failed to parse end time - %w", err)
+	}
+
+	w.start = start.Hour()*60 + start.Minute()
+	w.end = end.Hour()*60 + end.Minute()
+
+	return nil
+}
+
+type cronWindow struct {
+	cron     *Cron
+	duration time.Duration
+}
+
+// Span is one opening of a Calendar.
+type Span struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Calendar is a set of recurring windows in a time zone. Wall clock times
+// are kept across DST changes: a window opening at 09:00 opens at 09:00
+// local time on both sides of a change. A time skipped when clocks go
+// forward moves to the moment they jump, and a time repeated when they go
+// back means its first occurrence. Overlapping and touching windows merge into one Span.
+//
+// A Calendar must not be changed once given to a Scheduler.
+type Calendar struct {
+	loc        *time.Location
+	windows    []Window
+	crons      []cronWindow
+	exceptions map[string][]Window
+
+	// maxDays is how many days before a date a window can open and still
+	// be open on it.
+	maxDays int
+}
+
+func NewCalendar(tz string) (*Calendar, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Calendar{
+		loc:        loc,
+		exceptions: map[string][]Window{},
+		maxDays:    1,
+	}, nil
+}
+
+func (c *Calendar) Location() *time.Location {
+	return c.loc
+}
+
+// AddWindow opens the calendar from start to end on days.
+func (c *Calendar) AddWindow(days Weekdays, start, end string) error {
+	w := Window{Days: days, Start: start, End: end}
+
+	err := w.parse()
+	if err != nil {
+		return err
+	}
+
+	c.windows = append(c.windows, w)
+
+	return nil
+}
+
+// AddCron opens the calendar for duration each time expr fires.
+func (c *Calendar) AddCron(expr string, duration time.Duration) error {
+	cron, err := ParseCron(expr)
+	if err != nil {
+		return err
+	}
+
+	if duration <= 0 {
+		return fmt.Errorf("cron %q: duration must be positive", expr)
+	}
+
+	c.crons = append(c.crons, cronWindow{cron: cron, duration: duration})
+	c.maxDays = max(c.maxDays, int(duration/(24*time.Hour))+1)
+
+	return nil
+}
+
+// AddException replaces the windows and cron windows opening on date,
+// "2006-01-02", with windows, whose Days are ignored. Without windows the
+// calendar stays closed that day, as on a holiday.
+func (c *Calendar) AddException(date string, windows ...Window) error {
+	_, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return fmt.Errorf("failed to parse exception date - %w", err)
+	}
+
+	parsed := make([]Window, len(windows))
+	for i, w := range windows {
+		err = w.parse()
+		if err != nil {
+			return err
+		}
+
+		parsed[i] = w
+	}
+
+	c.exceptions[date] = parsed
+
+	return nil
+}
+
+// spansOn returns the spans opening on a date, unmerged.
+func (c *Calendar) spansOn(year int, month time.Month, day int) []Span {
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	year, month, day = date.Date()
+
+	spans := []Span{}
+
+	windows, exception := c.exceptions[date.Format(time.DateOnly)]
+	if !exception {
+		windows = c.windows
+	}
+
+	for _, w := range windows {
+		if !exception && !w.Days.Has(date.Weekday()) {
+			continue
+		}
+
+		end := w.end
+		if end <= w.start {
+			end += 24 * 60
+		}
+
+		spans = append(spans, Span{
+			Start: wallTime(year, month, day, w.start, c.loc),
+			End:   wallTime(year, month, day, end, c.loc),
+		})
+	}
+
+	if exception {
+		return spans
+	}
+
+	for _, cw := range c.crons {
+		for _, at := range cw.cron.on(year, month, day, c.loc) {
+			spans = append(spans, Span{Start: at, End: at.Add(cw.duration)})
+		}
+	}
+
+	return spans
+}
+
+// Next returns the span open at now, or else the next one to open. It
+// gives up after eight years, which only calendars that never open reach.
+func (c *Calendar) Next(now time.Time) (Span, bool) {
+	y, m, d := now.In(c.loc).Date()
+
+	candidates := []Span{}
+	day := -c.maxDays
+
+	for ; day < 8*366; day++ {
+		for _, s := range c.spansOn(y, m, d+day) {
+			if s.End.After(now) {
+				candidates = append(candidates, s)
+			}
+		}
+
+		if len(candidates) > 0 {
+			break
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Span{}, false
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].Start.Before(candidates[b].Start)
+	})
+
+	span := candidates[0]
+	span.End = merge(span.End, candidates[1:])
+
+	// Spans opening on later days may open before this one closes.
+	for day++; !wallTime(y, m, d+day, 0, c.loc).After(span.End); day++ {
+		span.End = merge(span.End, c.spansOn(y, m, d+day))
+	}
+
+	return span, true
+}
+
+// merge extends end over every span that opens by the time it closes.
+func merge(end time.Time, spans []Span) time.Time {
+	sort.Slice(spans, func(a, b int) bool {
+		return spans[a].Start.Before(spans[b].Start)
+	})
+
+	for _, s := range spans {
+		if !s.Start.After(end) && s.End.After(end) {
+			end = s.End
+		}
+	}
+
+	return end
+}
+
+// wallTime returns the time minute minutes after midnight on a date in loc,
+// as described on Calendar.
+func wallTime(year int, month time.Month, day int, minute int, loc *time.Location) time.Time {
+	naive := time.Date(year, month, day, 0, minute, 0, 0, time.UTC)
+
+	// Offsets on either side of any DST change near the date.
+	_, before := naive.Add(-26 * time.Hour).In(loc).Zone()
+	_, after := naive.Add(26 * time.Hour).In(loc).Zone()
+
+	first := naive.Add(-time.Duration(before) * time.Second)
+	second := naive.Add(-time.Duration(after) * time.Second)
+
+	valid := func(t time.Time) bool {
+		l := t.In(loc)
+		return l.Year() == naive.Year() && l.YearDay() == naive.YearDay() &&
+			l.Hour() == naive.Hour() && l.Minute() == naive.Minute()
+	}
+
+	switch {
+	case valid(first) && valid(second):
+		if second.Before(first) {
+			return second.In(loc)
+		}
+		return first.In(loc)
+	case valid(first):
+		return first.In(loc)
+	case valid(second):
+		return second.In(loc)
+	}
+
+	// Skipped by a change: the later candidate is past it, and its zone
+	// starts where the skipped time ends.
+	later := first
+	if second.After(first) {
+		later = second
+	}
+
+	start, _ := later.In(loc).ZoneBounds()
+
+	return start
+}
@@ -0,0 +1,265 @@
+package scheduling_test
+
+import (
+	"scheduling"
+	"testing"
+	"time"
+)
+
+func TestCalendarNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	at := func(loc *time.Location, s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04 MST", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	ny := func(s string) time.Time { return at(newYork, s) }
+	utc := func(s string) time.Time { return at(time.UTC, s+" UTC") }
+
+	type window struct {
+		days       scheduling.Weekdays
+		start, end string
+	}
+	type cron struct {
+		expr     string
+		duration time.Duration
+	}
+	type exception struct {
+		date    string
+		windows []scheduling.Window
+	}
+
+	cases := []struct {
+		name       string
+		tz         string
+		windows    []window
+		crons      []cron
+		exceptions []exception
+		now        time.Time
+		start, end time.Time
+	}{
+		{
+			name:    "keeps wall clock across spring forward",
+			tz:      "America/New_York",
+			windows: []window{{0, "09:00", "17:00"}},
+			now:     ny("2026-03-07 20:00 EST"),
+			start:   utc("2026-03-08 13:00"),
+			end:     utc("2026-03-08 21:00"),
+		},
+		{
+			name:    "keeps wall clock across fall back",
+			tz:      "America/New_York",
+			windows: []window{{0, "09:00", "17:00"}},
+			now:     ny("2026-10-31 20:00 EDT"),
+			start:   utc("2026-11-01 14:00"),
+			end:     utc("2026-11-01 22:00"),
+		},
+		{
+			name:    "skipped start moves to the jump",
+			tz:      "America/New_York",
+			windows: []window{{0, "02:30", "03:30"}},
+			now:     ny("2026-03-08 00:00 EST"),
+			start:   utc("2026-03-08 07:00"),
+			end:     utc("2026-03-08 07:30"),
+		},
+		{
+			name:    "repeated start is the first one",
+			tz:      "America/New_York",
+			windows: []window{{0, "01:30", "02:00"}},
+			now:     ny("2026-11-01 00:00 EDT"),
+			start:   utc("2026-11-01 05:30"),
+			end:     utc("2026-11-01 07:00"),
+		},
+		{
+			name:    "overnight window across spring forward",
+			tz:      "America/New_York",
+			windows: []window{{0, "22:00", "06:00"}},
+			now:     ny("2026-03-07 12:00 EST"),
+			start:   utc("2026-03-08 03:00"),
+			end:     utc("2026-03-08 10:00"),
+		},
+		{
+			name:    "open window is returned",
+			tz:      "America/New_York",
+			windows: []window{{0, "22:00", "06:00"}},
+			now:     ny("2026-03-08 04:00 EDT"),
+			start:   utc("2026-03-08 03:00"),
+			end:     utc("2026-03-08 10:00"),
+		},
+		{
+			name:    "weekday mask skips the weekend",
+			tz:      "America/New_York",
+			windows: []window{{scheduling.Workdays, "09:00", "17:00"}},
+			now:     ny("2026-03-07 10:00 EST"),
+			start:   utc("2026-03-09 13:00"),
+			end:     utc("2026-03-09 21:00"),
+		},
+		{
+			name:    "second window of the day",
+			tz:      "America/New_York",
+			windows: []window{{0, "09:00", "12:00"}, {0, "13:00", "17:00"}},
+			now:     ny("2026-06-01 12:30 EDT"),
+			start:   utc("2026-06-01 17:00"),
+			end:     utc("2026-06-01 21:00"),
+		},
+		{
+			name:    "touching windows merge",
+			tz:      "America/New_York",
+			windows: []window{{0, "12:00", "17:00"}, {0, "09:00", "12:00"}},
+			now:     ny("2026-06-01 08:00 EDT"),
+			start:   utc("2026-06-01 13:00"),
+			end:     utc("2026-06-01 21:00"),
+		},
+		{
+			name:       "holiday closes the day",
+			tz:         "America/New_York",
+			windows:    []window{{0, "09:00", "17:00"}},
+			exceptions: []exception{{date: "2026-12-25"}},
+			now:        ny("2026-12-24 18:00 EST"),
+			start:      utc("2026-12-26 14:00"),
+			end:        utc("2026-12-26 22:00"),
+		},
+		{
+			name:    "exception replaces the windows",
+			tz:      "America/New_York",
+			windows: []window{{0, "09:00", "17:00"}},
+			exceptions: []exception{{
+				date:    "2026-12-24",
+				windows: []scheduling.Window{{Start: "09:00", End: "12:00"}},
+			}},
+			now:   ny("2026-12-24 08:00 EST"),
+			start: utc("2026-12-24 14:00"),
+			end:   utc("2026-12-24 17:00"),
+		},
+		{
+			name:  "cron in the skipped hour",
+			tz:    "America/New_York",
+			crons: []cron{{"30 2 * * *", time.Hour}},
+			now:   ny("2026-03-08 00:00 EST"),
+			start: utc("2026-03-08 07:00"),
+			end:   utc("2026-03-08 08:00"),
+		},
+		{
+			name:  "cron in the repeated hour fires once",
+			tz:    "America/New_York",
+			crons: []cron{{"30 1 * * *", 10 * time.Minute}},
+			now:   utc("2026-11-01 06:35"),
+			start: utc("2026-11-02 06:30"),
+			end:   utc("2026-11-02 06:40"),
+		},
+		{
+			name:  "back to back cron windows merge",
+			tz:    "America/New_York",
+			crons: []cron{{"*/15 9-10 * * mon-fri", 15 * time.Minute}},
+			now:   ny("2026-06-06 00:00 EDT"),
+			start: utc("2026-06-08 13:00"),
+			end:   utc("2026-06-08 15:00"),
+		},
+		{
+			name:    "skipped hour in London",
+			tz:      "Europe/London",
+			windows: []window{{0, "01:30", "02:30"}},
+			now:     at(london, "2026-03-29 00:00 GMT"),
+			start:   utc("2026-03-29 01:00"),
+			end:     utc("2026-03-29 01:30"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cal, err := scheduling.NewCalendar(tc.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range tc.windows {
+				if err := cal.AddWindow(w.days, w.start, w.end); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, c := range tc.crons {
+				if err := cal.AddCron(c.expr, c.duration); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, e := range tc.exceptions {
+				if err := cal.AddException(e.date, e.windows...); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			span, ok := cal.Next(tc.now)
+			if !ok {
+				t.Fatal("calendar never opens")
+			}
+
+			if !span.Start.Equal(tc.start) || !span.End.Equal(tc.end) {
+				t.Errorf("got %s - %s, want %s - %s",
+					span.Start.UTC(), span.End.UTC(), tc.start, tc.end)
+			}
+		})
+	}
+}
+
+func TestCalendarNeverOpens(t *testing.T) {
+	cal, err := scheduling.NewCalendar("UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cal.AddCron("0 0 30 feb *", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cal.Next(time.Now()); ok {
+		t.Error("30 February opened")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	cases := []struct {
+		expr string
+		from string
+		want string
+	}{
+		{"0 9 * * *", "2026-02-01T09:00:00Z", "2026-02-02T09:00:00Z"},
+		{"*/20 * * * *", "2026-02-01T09:41:00Z", "2026-02-01T10:00:00Z"},
+		{"0 0 1 */3 *", "2026-02-01T00:00:00Z", "2026-04-01T00:00:00Z"},
+		{"0 0 * * 7", "2026-02-02T00:00:00Z", "2026-02-08T00:00:00Z"},
+		// Either day field matches when both are restricted.
+		{"0 0 13 * fri", "2026-02-01T00:00:00Z", "2026-02-06T00:00:00Z"},
+		{"0 0 29 2 *", "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+	}
+
+	for _, tc := range cases {
+		c, err := scheduling.ParseCron(tc.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		from, _ := time.Parse(time.RFC3339, tc.from)
+		want, _ := time.Parse(time.RFC3339, tc.want)
+
+		got, ok := c.Next(from)
+		if !ok || !got.Equal(want) {
+			t.Errorf("%q after %s: got %s, want %s", tc.expr, tc.from, got, tc.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := scheduling.ParseCron(expr); err == nil {
+			t.Errorf("%q parsed", expr)
+		}
+	}
+}
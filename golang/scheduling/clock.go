@@ -0,0 +1,24 @@
+package scheduling
+
+import "time"
+
+// Clock is the time source of a JobScheduler, so tests can move time
+// forward instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	// After behaves like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// SystemClock is the real clock.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,218 @@
+package scheduling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute, hour, day of month,
+// month and day of week. Fields take *, numbers, ranges (a-b), steps (*/n,
+// a-b/n) and comma separated lists of these; months and days of the week
+// also take three letter names. As in Vixie cron, when both day fields are
+// restricted a day matching either one matches.
+type Cron struct {
+	minutes uint64
+	hours   uint64
+	doms    uint64
+	months  uint64
+	dows    uint64
+
+	anyDom bool
+	anyDow bool
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields, got %d", expr, len(fields))
+	}
+
+	c := &Cron{}
+
+	var err error
+
+	c.minutes, err = parseCronField(fields[0], 0, 59, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cron %q: minute - %w", expr, err)
+	}
+
+	c.hours, err = parseCronField(fields[1], 0, 23, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cron %q: hour - %w", expr, err)
+	}
+
+	c.doms, err = parseCronField(fields[2], 1, 31, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cron %q: day of month - %w", expr, err)
+	}
+
+	c.months, err = parseCronField(fields[3], 1, 12, monthNames)
+	if err != nil {
+		return nil, fmt.Errorf("cron %q: month - %w", expr, err)
+	}
+
+	c.dows, err = parseCronField(fields[4], 0, 7, dayNames)
+	if err != nil {
+		return nil, fmt.Errorf("cron %q: day of week - %w", expr, err)
+	}
+
+	// 7 is another name for Sunday.
+	if c.dows&(1<<7) != 0 {
+		c.dows |= 1
+	}
+
+	c.anyDom = fields[2] == "*"
+	c.anyDow = fields[4] == "*"
+
+	return c, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+
+		lo, hi := min, max
+
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+
+			var err error
+
+			lo, err = parseCronValue(a, names)
+			if err != nil {
+				return 0, err
+			}
+
+			hi = lo
+			if isRange {
+				hi, err = parseCronValue(b, names)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "a/n" means from a to the end in steps of n.
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		n := 1
+		if hasStep {
+			var err error
+
+			n, err = strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+		}
+
+		for v := lo; v <= hi; v += n {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	return v, nil
+}
+
+func (c *Cron) matchesDay(year int, month time.Month, day int) bool {
+	if c.months&(1<<month) == 0 {
+		return false
+	}
+
+	dom := c.doms&(1<<day) != 0
+	weekday := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday()
+	dow := c.dows&(1<<weekday) != 0
+
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+
+	return dom || dow
+}
+
+// on returns the times c fires on a date in loc, in order, with DST changes
+// handled as described on Calendar.
+func (c *Cron) on(year int, month time.Month, day int, loc *time.Location) []time.Time {
+	year, month, day = time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Date()
+
+	if !c.matchesDay(year, month, day) {
+		return nil
+	}
+
+	times := []time.Time{}
+
+	for h := 0; h < 24; h++ {
+		if c.hours&(1<<h) == 0 {
+			continue
+		}
+
+		for m := 0; m < 60; m++ {
+			if c.minutes&(1<<m) == 0 {
+				continue
+			}
+
+			t := wallTime(year, month, day, h*60+m, loc)
+
+			// Several times in a gap all land on its end; fire once.
+			if len(times) > 0 && !t.After(times[len(times)-1]) {
+				continue
+			}
+
+			times = append(times, t)
+		}
+	}
+
+	return times
+}
+
+// Next returns the first time after t that c fires, evaluated in t's
+// location. It gives up after eight years, which only expressions that
+// never fire, such as 30 February, reach.
+func (c *Cron) Next(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	y, m, d := t.Date()
+
+	for i := 0; i < 8*366; i++ {
+		for _, at := range c.on(y, m, d+i, loc) {
+			if at.After(t) {
+				return at, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,433 @@
+package scheduling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MisfirePolicy decides what a job does about runs it missed, because the
+// process was down or the job was still busy with an earlier run.
+type MisfirePolicy int
+
+const (
+	// MisfireFireOnce runs the job once for all the runs it missed.
+	MisfireFireOnce MisfirePolicy = iota
+	// MisfireFireAll runs the job for each run it missed, oldest first, up
+	// to the last thousand of them.
+	MisfireFireAll
+	// MisfireSkip drops missed runs and waits for the next one.
+	MisfireSkip
+)
+
+// maxCatchUp bounds the missed runs MisfireFireAll makes up for.
+const maxCatchUp = 1000
+
+type Job struct {
+	ID string
+	// Cron, evaluated in TZ (default UTC), or Every makes the job recur.
+	// With neither it runs once, at NextRun, and is then removed.
+	Cron  string
+	TZ    string
+	Every time.Duration
+	// NextRun is when the job runs next. Left zero, it is the first run
+	// after the job is added, or the time it is added for one-off jobs.
+	NextRun time.Time
+	Misfire MisfirePolicy
+	Meta    map[string]string
+}
+
+// following returns a func giving the job's run after a given one.
+func (j *Job) following() (func(t time.Time) (time.Time, bool), error) {
+	if j.Cron != "" && j.Every != 0 {
+		return nil, fmt.Errorf("job %s: both cron and every set", j.ID)
+	}
+
+	if j.Cron != "" {
+		cron, err := ParseCron(j.Cron)
+		if err != nil {
+			return nil, err
+		}
+
+		loc, err := time.LoadLocation(j.TZ)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(t time.Time) (time.Time, bool) {
+			return cron.Next(t.In(loc))
+		}, nil
+	}
+
+	if j.Every < 0 {
+		return nil, fmt.Errorf("job %s: negative every", j.ID)
+	}
+
+	if j.Every > 0 {
+		return func(t time.Time) (time.Time, bool) {
+			return t.Add(j.Every), true
+		}, nil
+	}
+
+	return func(t time.Time) (time.Time, bool) {
+		return time.Time{}, false
+	}, nil
+}
+
+// Run is an entry in a job's run history.
+type Run struct {
+	JobID     string
+	Scheduled time.Time
+	Started   time.Time
+	Finished  time.Time
+	Error     string
+}
+
+// JobHandler runs a job for the run scheduled at scheduled.
+type JobHandler func(ctx context.Context, job Job, scheduled time.Time) error
+
+// JobScheduler runs the jobs in a JobStore when they are due. Runs are
+// recorded only once they finish, so a run cut short by a crash happens
+// again after a restart.
+type JobScheduler struct {
+	// MisfireThreshold is how late a run may start before it counts as
+	// missed. Default 1 minute.
+	MisfireThreshold time.Duration
+
+	store   *JobStore
+	clock   Clock
+	handler JobHandler
+
+	mu      sync.Mutex
+	running map[string]bool
+	wake    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Creates a new JobScheduler. A nil clock means SystemClock.
+func NewJobScheduler(
+	store *JobStore,
+	clock Clock,
+	handler JobHandler,
+) *JobScheduler {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return &JobScheduler{
+		MisfireThreshold: time.Minute,
+		store:            store,
+		clock:            clock,
+		handler:          handler,
+		running:          map[string]bool{},
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+func (s *JobScheduler) Add(job Job) error {
+	following, err := job.following()
+	if err != nil {
+		return err
+	}
+
+	if job.NextRun.IsZero() {
+		now := s.clock.Now()
+
+		next, ok := following(now)
+		if !ok {
+			if job.Cron != "" {
+				return fmt.Errorf("job %s: cron %q never fires", job.ID, job.Cron)
+			}
+
+			next = now
+		}
+
+		job.NextRun = next
+	}
+
+	err = s.store.insert(&job)
+	if err != nil {
+		return err
+	}
+
+	s.notify()
+
+	return nil
+}
+
+// Remove deletes a job. A run in progress finishes, but no more follow.
+func (s *JobScheduler) Remove(id string) error {
+	err := s.store.delete(id)
+	if err != nil {
+		return err
+	}
+
+	s.notify()
+
+	return nil
+}
+
+func (s *JobScheduler) Jobs() ([]Job, error) {
+	jobs, err := s.store.jobs()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Job, len(jobs))
+	for i, j := range jobs {
+		list[i] = *j
+	}
+
+	return list, nil
+}
+
+func (s *JobScheduler) History(jobID string, limit int) ([]Run, error) {
+	return s.store.History(jobID, limit)
+}
+
+func (s *JobScheduler) Start() {
+	s.mu.Lock()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx)
+	}()
+}
+
+// Stop cancels the context of runs in progress and waits for them.
+func (s *JobScheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	s.wg.Wait()
+}
+
+func (s *JobScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// should not be called directly
+func (s *JobScheduler) run(ctx context.Context) {
+	log.Println("job scheduler started")
+
+	for {
+		wait := s.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("job scheduler exited - reason: context")
+			return
+		case <-s.wake:
+		case <-wait:
+		}
+	}
+}
+
+// dispatch starts every due job that is not already running and returns a
+// channel that fires when the next one is due.
+func (s *JobScheduler) dispatch(ctx context.Context) <-chan time.Time {
+	jobs, err := s.store.jobs()
+	if err != nil {
+		log.Println("job scheduler - failed to load jobs", err)
+		return s.clock.After(time.Second)
+	}
+
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range jobs {
+		if s.running[j.ID] {
+			continue
+		}
+
+		if j.NextRun.After(now) {
+			return s.clock.After(j.NextRun.Sub(now))
+		}
+
+		s.running[j.ID] = true
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+			s.runJob(ctx, j, now)
+
+			s.mu.Lock()
+			delete(s.running, j.ID)
+			s.mu.Unlock()
+
+			s.notify()
+		}()
+	}
+
+	return nil
+}
+
+// runJob runs j for the runs due by now, as its MisfirePolicy says, and
+// moves it on to its next run.
+func (s *JobScheduler) runJob(ctx context.Context, j *Job, now time.Time) {
+	following, err := j.following()
+	if err != nil {
+		log.Println("job scheduler - invalid job", j.ID, err)
+		return
+	}
+
+	// Jump over runs that are past catching up on anyway. Only
+	// MisfireFireAll makes up for more than the last missed run.
+	t := j.NextRun
+	cutoff := now.Add(-s.MisfireThreshold)
+
+	switch {
+	case j.Misfire != MisfireFireAll && t.Before(cutoff) && j.Every > 0:
+		t = t.Add(cutoff.Sub(t) / j.Every * j.Every)
+	case j.Misfire != MisfireFireAll && t.Before(cutoff):
+		t = lastRunBy(following, t, cutoff, s.MisfireThreshold)
+	case j.Every > 0 && now.Sub(t)/j.Every > maxCatchUp:
+		t = t.Add((now.Sub(t)/j.Every - maxCatchUp) * j.Every)
+	}
+
+	due := []time.Time{}
+	ok := true
+
+	for ok && !t.After(now) {
+		due = append(due, t)
+		if len(due) > maxCatchUp {
+			due = due[1:]
+		}
+
+		t, ok = following(t)
+	}
+
+	next, hasNext := t, ok
+	runs := s.pick(j, due, now)
+
+	if len(runs) == 0 {
+		err = s.store.advance(j.ID, next, hasNext)
+		if err != nil {
+			log.Println("job scheduler - failed to advance", j.ID, err)
+		}
+		return
+	}
+
+	for i, scheduled := range runs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		r := Run{JobID: j.ID, Scheduled: scheduled, Started: s.clock.Now()}
+
+		err = s.call(ctx, j, scheduled)
+		if err != nil {
+			r.Error = err.Error()
+		}
+
+		r.Finished = s.clock.Now()
+
+		err = s.store.record(r)
+		if err != nil {
+			log.Println("job scheduler - failed to record run", j.ID, err)
+		}
+
+		// Between the runs of a catch-up, the job resumes after the last
+		// one finished.
+		after, afterOK := next, hasNext
+		if i < len(runs)-1 {
+			after, afterOK = following(scheduled)
+		}
+
+		err = s.store.advance(j.ID, after, afterOK)
+		if err != nil {
+			log.Println("job scheduler - failed to advance", j.ID, err)
+			return
+		}
+	}
+}
+
+// lastRunBy returns the last run at or before cutoff, starting from the run
+// at from. Rather than walking every run since from, it looks back from
+// cutoff over windows doubling from window until one holds a run.
+func lastRunBy(
+	following func(t time.Time) (time.Time, bool),
+	from time.Time,
+	cutoff time.Time,
+	window time.Duration,
+) time.Time {
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	for w := window; w < cutoff.Sub(from); w *= 2 {
+		t, ok := following(cutoff.Add(-w))
+		if ok && !t.After(cutoff) {
+			from = t
+			break
+		}
+	}
+
+	for {
+		t, ok := following(from)
+		if !ok || t.After(cutoff) {
+			return from
+		}
+		from = t
+	}
+}
+
+// pick returns which of the due runs to make, oldest first.
+func (s *JobScheduler) pick(j *Job, due []time.Time, now time.Time) []time.Time {
+	if len(due) == 0 {
+		return nil
+	}
+
+	last := due[len(due)-1]
+	onTime := now.Sub(last) <= s.MisfireThreshold
+
+	missed := len(due)
+	if onTime {
+		missed--
+	}
+
+	if missed > 0 {
+		log.Println("job scheduler - job", j.ID, "missed", missed, "runs")
+	}
+
+	switch j.Misfire {
+	case MisfireFireAll:
+		return due
+	case MisfireSkip:
+		if !onTime {
+			return nil
+		}
+	}
+
+	return []time.Time{last}
+}
+
+func (s *JobScheduler) call(ctx context.Context, j *Job, scheduled time.Time) (err error) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = errors.New(fmt.Sprint("panic: ", r))
+		}
+	}()
+
+	return s.handler(ctx, *j, scheduled)
+}
@@ -0,0 +1,284 @@
+package scheduling_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"scheduling"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			kept = append(kept, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = kept
+}
+
+func openStore(t *testing.T, path string) *scheduling.JobStore {
+	store, err := scheduling.OpenJobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// waitFor polls cond, which the scheduler satisfies in the background.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func nextRun(t *testing.T, sch *scheduling.JobScheduler, id string) time.Time {
+	jobs, err := sch.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range jobs {
+		if j.ID == id {
+			return j.NextRun
+		}
+	}
+
+	return time.Time{}
+}
+
+func TestMisfireAfterRestart(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		policy scheduling.MisfirePolicy
+		want   []time.Time
+	}{
+		{scheduling.MisfireFireAll, []time.Time{t0, t0.Add(time.Hour), t0.Add(2 * time.Hour), t0.Add(3 * time.Hour)}},
+		{scheduling.MisfireFireOnce, []time.Time{t0.Add(3 * time.Hour)}},
+		{scheduling.MisfireSkip, nil},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "jobs.db")
+		clock := &fakeClock{now: t0}
+
+		// Schedule the job, then "restart" three and a half hours later.
+		first := scheduling.NewJobScheduler(openStore(t, path), clock, nil)
+		err := first.Add(scheduling.Job{ID: "hourly", Every: time.Hour, NextRun: t0, Misfire: tc.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(3*time.Hour + 30*time.Minute)
+
+		var mu sync.Mutex
+		got := []time.Time{}
+
+		sch := scheduling.NewJobScheduler(openStore(t, path), clock,
+			func(ctx context.Context, job scheduling.Job, scheduled time.Time) error {
+				mu.Lock()
+				got = append(got, scheduled)
+				mu.Unlock()
+				return nil
+			},
+		)
+		sch.Start()
+
+		waitFor(t, func() bool {
+			return nextRun(t, sch, "hourly").Equal(t0.Add(4 * time.Hour))
+		})
+		sch.Stop()
+
+		mu.Lock()
+		if len(got) != len(tc.want) {
+			t.Fatalf("policy %v: ran %v, want %v", tc.policy, got, tc.want)
+		}
+		for i := range got {
+			if !got[i].Equal(tc.want[i]) {
+				t.Errorf("policy %v: ran %v, want %v", tc.policy, got, tc.want)
+			}
+		}
+		mu.Unlock()
+
+		history, err := sch.History("hourly", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != len(tc.want) {
+			t.Errorf("policy %v: %d runs in history, want %d", tc.policy, len(history), len(tc.want))
+		}
+	}
+}
+
+func TestMisfireCronAfterLongDowntime(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Three years of five-minute runs, the last one two and a half minutes
+	// late.
+	last := t0.AddDate(3, 0, 0)
+
+	cases := []struct {
+		policy scheduling.MisfirePolicy
+		want   []time.Time
+	}{
+		{scheduling.MisfireFireOnce, []time.Time{last}},
+		{scheduling.MisfireSkip, nil},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "jobs.db")
+		clock := &fakeClock{now: t0}
+
+		first := scheduling.NewJobScheduler(openStore(t, path), clock, nil)
+		err := first.Add(scheduling.Job{ID: "five", Cron: "*/5 * * * *", NextRun: t0, Misfire: tc.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(last.Sub(t0) + 150*time.Second)
+
+		var mu sync.Mutex
+		got := []time.Time{}
+
+		sch := scheduling.NewJobScheduler(openStore(t, path), clock,
+			func(ctx context.Context, job scheduling.Job, scheduled time.Time) error {
+				mu.Lock()
+				got = append(got, scheduled)
+				mu.Unlock()
+				return nil
+			},
+		)
+		sch.Start()
+
+		waitFor(t, func() bool {
+			return nextRun(t, sch, "five").Equal(last.Add(5 * time.Minute))
+		})
+		sch.Stop()
+
+		mu.Lock()
+		if len(got) != len(tc.want) || (len(got) == 1 && !got[0].Equal(tc.want[0])) {
+			t.Errorf("policy %v: ran %v, want %v", tc.policy, got, tc.want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestJobSchedulerClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC)}
+	ran := make(chan time.Time, 4)
+
+	sch := scheduling.NewJobScheduler(openStore(t, filepath.Join(t.TempDir(), "jobs.db")), clock,
+		func(ctx context.Context, job scheduling.Job, scheduled time.Time) error {
+			ran <- scheduled
+			if job.Meta["fail"] == "yes" {
+				return errors.New("failed")
+			}
+			return nil
+		},
+	)
+	sch.Start()
+	defer sch.Stop()
+
+	err := sch.Add(scheduling.Job{ID: "top-of-hour", Cron: "0 * * * *"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sch.Add(scheduling.Job{ID: "top-of-hour", Every: time.Minute}); err != scheduling.ErrJobExists {
+		t.Errorf("duplicate add: %v", err)
+	}
+
+	err = sch.Add(scheduling.Job{
+		ID:      "once",
+		NextRun: clock.Now().Add(10 * time.Minute),
+		Meta:    map[string]string{"fail": "yes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case at := <-ran:
+		t.Fatalf("ran %s before its time", at)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(10 * time.Minute)
+	if at := <-ran; !at.Equal(clock.Now()) {
+		t.Errorf("one-off job ran for %s", at)
+	}
+
+	// One-off jobs are removed once run, keeping their history.
+	waitFor(t, func() bool { return nextRun(t, sch, "once").IsZero() })
+
+	history, err := sch.History("once", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Error != "failed" {
+		t.Errorf("history %+v", history)
+	}
+
+	for _, want := range []string{"13:00", "14:00"} {
+		clock.Advance(time.Hour)
+		if at := <-ran; at.Format("15:04") != want {
+			t.Errorf("ran for %s, want %s", at.Format("15:04"), want)
+		}
+	}
+
+	waitFor(t, func() bool {
+		history, _ := sch.History("top-of-hour", 10)
+		return len(history) == 2
+	})
+}
@@ -0,0 +1,215 @@
+package scheduling
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrJobExists   = errors.New("job already exists")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// JobStore keeps jobs and their run history in a SQLite database, so
+// pending runs survive restarts.
+type JobStore struct {
+	db *sql.DB
+}
+
+func OpenJobStore(path string) (*JobStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a second pooled connection writing while
+	// another holds a transaction fails with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table if not exists jobs (
+		id text not null,
+		cron text not null,
+		tz text not null,
+		every int not null,
+		next_run int not null,
+		misfire int not null,
+		meta text not null,
+		primary key (id)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		create table if not exists runs (
+		id integer primary key autoincrement,
+		job_id text not null,
+		scheduled int not null,
+		started int not null,
+		finished int not null,
+		error text not null
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	_, err = db.Exec(`create index if not exists runs_job on runs (job_id, id)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &JobStore{db: db}, nil
+}
+
+func (s *JobStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *JobStore) insert(j *Job) error {
+	meta, err := json.Marshal(j.Meta)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec(
+		`insert into jobs (id, cron, tz, every, next_run, misfire, meta)
+		values (?, ?, ?, ?, ?, ?, ?)
+		on conflict (id) do nothing`,
+		j.ID, j.Cron, j.TZ, int64(j.Every), j.NextRun.UnixNano(), int(j.Misfire), string(meta),
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrJobExists
+	}
+
+	return nil
+}
+
+func (s *JobStore) delete(id string) error {
+	res, err := s.db.Exec(`delete from jobs where id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// advance moves a job to its next run, or deletes it when it has none. A
+// job deleted meanwhile stays deleted.
+func (s *JobStore) advance(id string, next time.Time, ok bool) error {
+	if !ok {
+		_, err := s.db.Exec(`delete from jobs where id = ?`, id)
+		return err
+	}
+
+	_, err := s.db.Exec(`update jobs set next_run = ? where id = ?`, next.UnixNano(), id)
+	return err
+}
+
+// jobs returns every job, soonest first.
+func (s *JobStore) jobs() ([]*Job, error) {
+	rows, err := s.db.Query(
+		`select id, cron, tz, every, next_run, misfire, meta
+		from jobs order by next_run asc, id asc`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+
+	for rows.Next() {
+		j := &Job{}
+
+		var every, nextRun int64
+		var misfire int
+		var meta string
+
+		err = rows.Scan(&j.ID, &j.Cron, &j.TZ, &every, &nextRun, &misfire, &meta)
+		if err != nil {
+			return nil, err
+		}
+
+		j.Every = time.Duration(every)
+		j.NextRun = time.Unix(0, nextRun)
+		j.Misfire = MisfirePolicy(misfire)
+
+		err = json.Unmarshal([]byte(meta), &j.Meta)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (s *JobStore) record(r Run) error {
+	_, err := s.db.Exec(
+		`insert into runs (job_id, scheduled, started, finished, error)
+		values (?, ?, ?, ?, ?)`,
+		r.JobID, r.Scheduled.UnixNano(), r.Started.UnixNano(), r.Finished.UnixNano(), r.Error,
+	)
+	return err
+}
+
+// History returns up to limit of a job's runs, latest first.
+func (s *JobStore) History(jobID string, limit int) ([]Run, error) {
+	rows, err := s.db.Query(
+		`select job_id, scheduled, started, finished, error
+		from runs where job_id = ?
+		order by id desc limit ?`,
+		jobID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+
+	for rows.Next() {
+		r := Run{}
+
+		var scheduled, started, finished int64
+
+		err = rows.Scan(&r.JobID, &scheduled, &started, &finished, &r.Error)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Scheduled = time.Unix(0, scheduled)
+		r.Started = time.Unix(0, started)
+		r.Finished = time.Unix(0, finished)
+
+		runs = append(runs, r)
+	}
+
+	return runs, rows.Err()
+}
@@ -2,7 +2,6 @@ package scheduling
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
@@ -20,10 +19,8 @@ type Scheduler struct {
 
 	started atomic.Bool
 
-	loc   *time.Location
-	start time.Time
-	end   time.Time
-	mu    sync.Mutex
+	cal *Calendar
+	mu  sync.Mutex
 }
 
 // Creates a new TimeScheduler instance.
@@ -39,45 +36,43 @@ func NewTimeScheduler(
 	return sch
 }
 
-// Set does the following:
+// Set schedules one window a day from start to end, "HH:MM" in tz. It is
+// short for SetCalendar with a Calendar holding that window.
+func (w *Scheduler) Set(tz, start, end string) error {
+	log.Println("start new scheduler", tz, start, end)
+
+	cal, err := NewCalendar(tz)
+	if err != nil {
+		return err
+	}
+
+	err = cal.AddWindow(0, start, end)
+	if err != nil {
+		return err
+	}
+
+	w.SetCalendar(cal)
+
+	return nil
+}
+
+// SetCalendar does the following:
 //
 // - Stops the current scheduling routine if any.
 //
-// - Sets the new time range.
+// - Sets the new calendar.
 //
 // - Starts up a new scheduling routine.
 //
 // Can be called more than once.
-func (w *Scheduler) Set(tz, start, end string) error {
-	log.Println("start new scheduler", tz, start, end)
-
+func (w *Scheduler) SetCalendar(cal *Calendar) {
 	w.mu.Lock()
 
 	if w.started.Load() {
 		w.Stop()
 	}
 
-	startTime, err := time.Parse("15:04", start)
-	if err != nil {
-		w.mu.Unlock()
-		return fmt.Errorf("failed to parse start time - %w", err)
-	}
-
-	endTime, err := time.Parse("15:04", end)
-	if err != nil {
-		w.mu.Unlock()
-		return fmt.Errorf("failed to parse end time - %w", err)
-	}
-
-	loc, err := time.LoadLocation(tz)
-	if err != nil {
-		w.mu.Unlock()
-		return err
-	}
-
-	w.start = startTime
-	w.end = endTime
-	w.loc = loc
+	w.cal = cal
 
 	ctx, cancel := context.WithCancel(context.Background())
 	w.ctx = ctx
@@ -87,8 +82,19 @@ func (w *Scheduler) Set(tz, start, end string) error {
 	w.mu.Unlock()
 
 	go w.run()
+}
 
-	return nil
+// Next returns the window open at now, or else the next one to open.
+func (w *Scheduler) Next(now time.Time) (Span, bool) {
+	w.mu.Lock()
+	cal := w.cal
+	w.mu.Unlock()
+
+	if cal == nil {
+		return Span{}, false
+	}
+
+	return cal.Next(now)
 }
 
 func (w *Scheduler) Stop() {
@@ -106,45 +112,51 @@ func (w *Scheduler) run() {
 	call(w.reset)
 
 	w.mu.Lock()
-	now := time.Now()
-	actualStart, actualEnd := calculateTimeRangeV2(w.loc, now, w.start, w.end)
+	ctx := w.ctx
+	cal := w.cal
 	w.mu.Unlock()
-	log.Println("started scheduling", actualStart, actualEnd, w.loc.String())
+
+	now := time.Now()
 
 out:
 	for {
-		startDiff := time.Until(actualStart)
-		endDiff := time.Until(actualEnd)
-		log.Println("scheduling time until", startDiff, endDiff)
+		span, ok := cal.Next(now)
+		if !ok {
+			log.Println("scheduler exited - reason: calendar never opens")
+			break
+		}
 
-		select {
-		case <-w.ctx.Done():
+		log.Println("scheduling", span.Start, span.End, cal.Location().String())
+
+		if !sleepUntil(ctx, span.Start) {
+			log.Println("scheduler exited - reason: context")
+			break out
+		}
+		call(w.onStart)
+
+		if !sleepUntil(ctx, span.End) {
 			log.Println("scheduler exited - reason: context")
 			break out
-		case <-time.After(startDiff):
-			call(w.onStart)
-			actualStart = actualStart.AddDate(0, 0, 1)
-			// actualStart = actualStart.Add(time.Hour * 24)
-		case <-time.After(endDiff):
-			call(w.onEnd)
-			// actualEnd = actualEnd.Add(time.Hour * 24)
-			actualEnd = actualEnd.AddDate(0, 0, 1)
 		}
+		call(w.onEnd)
+
+		now = span.End
 	}
 
 	log.Println("scheduler exited")
 }
 
-func makeTime(
-	oldTime time.Time,
-	newTime time.Time,
-	loc *time.Location,
-) time.Time {
-	return time.Date(
-		oldTime.Year(), oldTime.Month(), oldTime.Day(),
-		newTime.Hour(), newTime.Minute(),
-		0, 0, loc,
-	)
+// sleepUntil waits for t, returning false if ctx ends first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
 
 func call(fn func()) {
@@ -152,67 +164,3 @@ func call(fn func()) {
 		fn()
 	}
 }
-
-// func calculateTimeRange(
-// 	loc *time.Location,
-// 	now, startTime, endTime time.Time,
-// ) (time.Time, time.Time) {
-// 	var diff time.Duration
-//
-// 	if endTime.Before(startTime) {
-// 		diff = 24*time.Hour - startTime.Sub(endTime)
-// 	} else {
-// 		diff = endTime.Sub(startTime)
-// 	}
-//
-// 	// nowAdj := now.Add(time.Hour * -24)
-// 	nowAdj := now.AddDate(0, 0, 1)
-//
-// 	actualStart := makeTime(nowAdj, startTime, loc)
-// 	actualEnd := actualStart.Add(diff)
-//
-// 	if actualEnd.Before(now) {
-// 		actualStart = makeTime(now, startTime, loc)
-// 		actualEnd = actualStart.Add(diff)
-// 	}
-//
-// 	if actualStart.Before(now) && actualEnd.Before(now) {
-// 		// actualStart = actualStart.Add(24 * time.Hour)
-// 		actualStart = actualStart.AddDate(0, 0, 1)
-// 	}
-//
-// 	if actualEnd.Before(now) {
-// 		// actualEnd = actualEnd.Add(24 * time.Hour)
-// 		actualEnd = actualEnd.AddDate(0, 0, 1)
-// 	}
-//
-// 	return actualStart, actualEnd
-// }
-
-func calculateTimeRangeV2(
-	loc *time.Location,
-	now time.Time,
-	startTime, endTime time.Time,
-) (time.Time, time.Time) {
-	start := time.Date(now.Year(), now.Month(), now.Day(),
-		startTime.Hour(), startTime.Minute(), 0, 0, loc)
-
-	end := time.Date(now.Year(), now.Month(), now.Day(),
-		endTime.Hour(), endTime.Minute(), 0, 0, loc)
-
-	if end.Before(start) || end.Equal(start) {
-		end = end.AddDate(0, 0, 1)
-	}
-
-	if now.After(end) {
-		start = start.AddDate(0, 0, 1)
-		end = end.AddDate(0, 0, 1)
-	}
-
-	if now.Before(start) && now.Before(end) && end.Sub(start) > 12*time.Hour {
-		start = start.AddDate(0, 0, -1)
-		end = end.AddDate(0, 0, -1)
-	}
-
-	return start, end
-}
@@ -0,0 +1,120 @@
+package supervisor
+
+import (
+	"fmt"
+	"time"
+)
+
+type EventKind int
+
+const (
+	// EventStarted is sent when a child first runs.
+	EventStarted EventKind = iota
+	// EventCrashed is sent when a worker panics or a nested supervisor
+	// gives up.
+	EventCrashed
+	// EventRestarted is sent when a child runs again after a restart.
+	EventRestarted
+	// EventStopped is sent when a child is removed from its supervisor,
+	// whether by Stop, Cancel, replacement or its RestartPolicy.
+	EventStopped
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStarted:
+		return "started"
+	case EventCrashed:
+		return "crashed"
+	case EventRestarted:
+		return "restarted"
+	case EventStopped:
+		return "stopped"
+	}
+
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event reports a change in a child's lifecycle. Events from nested
+// supervisors reach their parents' subscribers too, with the nested
+// supervisor's key and a slash before Key.
+type Event struct {
+	Kind EventKind
+	Key  string
+	Time time.Time
+	// Restarts counts the restarts so far.
+	Restarts int
+	// Panic and Stack are set for crashed workers, Err for crashed
+	// supervisors.
+	Panic any
+	Stack []byte
+	Err   error
+}
+
+// panicError carries a worker's panic to the supervisor.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprint(e.value)
+}
+
+// Subscribe returns a channel of lifecycle events. Events are dropped
+// rather than stall the supervisor when the channel's buffer is full. The
+// channel is closed by unsubscribe or when the supervisor stops for good.
+func (g *SupervisorGroup) Subscribe(buffer int) (events <-chan Event, unsubscribe func()) {
+	ch := make(chan Event, buffer)
+
+	g.subMu.Lock()
+	defer g.subMu.Unlock()
+
+	if g.subs == nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	g.subs[ch] = struct{}{}
+
+	return ch, func() {
+		g.subMu.Lock()
+		defer g.subMu.Unlock()
+
+		if _, ok := g.subs[ch]; ok {
+			delete(g.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (g *SupervisorGroup) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	g.subMu.Lock()
+	for ch := range g.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	g.subMu.Unlock()
+
+	if g.parent != nil {
+		ev.Key = g.name + "/" + ev.Key
+		g.parent.emit(ev)
+	}
+}
+
+// closeSubscribers ends every subscription once the supervisor is done.
+func (g *SupervisorGroup) closeSubscribers() {
+	g.subMu.Lock()
+	defer g.subMu.Unlock()
+
+	for ch := range g.subs {
+		close(ch)
+	}
+	g.subs = nil
+}
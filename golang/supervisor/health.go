@@ -0,0 +1,138 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHung is the crash reason of a worker that missed its heartbeat.
+var ErrHung = errors.New("supervisor: worker missed its heartbeat")
+
+type heartbeatKey struct{}
+
+// Heartbeat tells the supervisor that the worker given ctx is alive.
+// Workers with a HeartbeatTimeout must call it more often than that; for
+// others it does nothing.
+func Heartbeat(ctx context.Context) {
+	beat, ok := ctx.Value(heartbeatKey{}).(*atomic.Int64)
+	if ok {
+		beat.Store(time.Now().UnixNano())
+	}
+}
+
+// watch ends a run as hung when it misses its heartbeat. The worker's
+// context is cancelled and a new run started without waiting for the old
+// one, which Go has no way to kill, to return.
+func watch(
+	ctx context.Context,
+	key string,
+	timeout time.Duration,
+	beat *atomic.Int64,
+	exit func(err error, report bool),
+) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, beat.Load())) > timeout {
+				log.Println("supervisor: worker hung", key)
+				exit(ErrHung, true)
+				return
+			}
+		}
+	}
+}
+
+type State string
+
+const (
+	StateRunning State = "running"
+	// StateRestarting is a child waiting out its backoff.
+	StateRestarting State = "restarting"
+	// StateStopped is a child being stopped, or not yet started by a
+	// nested supervisor.
+	StateStopped State = "stopped"
+)
+
+type WorkerStatus struct {
+	Key           string         `json:"key"`
+	State         State          `json:"state"`
+	Restarts      int            `json:"restarts"`
+	LastError     string         `json:"last_error,omitempty"`
+	Uptime        time.Duration  `json:"uptime"`
+	LastHeartbeat *time.Time     `json:"last_heartbeat,omitempty"`
+	DependsOn     []string       `json:"depends_on,omitempty"`
+	Children      []WorkerStatus `json:"children,omitempty"`
+}
+
+// Snapshot returns the status of every child in start order, with nested
+// supervisors' children under them.
+func (g *SupervisorGroup) Snapshot() []WorkerStatus {
+	now := time.Now()
+
+	g.mu.Lock()
+
+	statuses := make([]WorkerStatus, len(g.children))
+	nested := map[int]*SupervisorGroup{}
+
+	for i, c := range g.children {
+		st := WorkerStatus{
+			Key:       c.key,
+			State:     StateStopped,
+			Restarts:  c.restarts,
+			DependsOn: c.dependsOn,
+		}
+
+		if c.lastErr != nil {
+			st.LastError = c.lastErr.Error()
+		}
+
+		if c.active {
+			if now.Before(c.started) {
+				st.State = StateRestarting
+			} else {
+				st.State = StateRunning
+				st.Uptime = now.Sub(c.started)
+			}
+		}
+
+		if c.active && c.beat != nil {
+			t := time.Unix(0, c.beat.Load())
+			st.LastHeartbeat = &t
+		}
+
+		if c.sup != nil {
+			nested[i] = c.sup
+		}
+
+		statuses[i] = st
+	}
+
+	g.mu.Unlock()
+
+	for i, sub := range nested {
+		statuses[i].Children = sub.Snapshot()
+	}
+
+	return statuses
+}
+
+// ServeHTTP writes the Snapshot as JSON, so a supervisor can be mounted on
+// a mux for monitoring.
+func (g *SupervisorGroup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(g.Snapshot())
+	if err != nil {
+		log.Println("supervisor: snapshot", err)
+	}
+}
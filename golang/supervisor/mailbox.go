@@ -0,0 +1,204 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrWorkerNotFound = errors.New("supervisor: worker not found")
+	ErrMailboxFull    = errors.New("supervisor: mailbox full")
+	ErrMessageType    = errors.New("supervisor: worker does not accept this message type")
+	ErrNoReply        = errors.New("supervisor: worker does not take calls")
+	ErrCallTimeout    = errors.New("supervisor: call timed out")
+)
+
+// Message is an entry in a typed worker's mailbox. Messages sent with Call
+// expect the worker to Reply; for the rest Reply does nothing.
+type Message[T any] struct {
+	Value T
+	reply chan any
+}
+
+// Reply answers the Call that sent m. Only the first reply is delivered.
+func (m Message[T]) Reply(v any) {
+	if m.reply == nil {
+		return
+	}
+
+	select {
+	case m.reply <- v:
+	default:
+	}
+}
+
+// Mailbox holds a typed worker's messages in the order they were sent. It
+// outlives restarts: messages not yet received when a worker crashes are
+// there for the next run.
+type Mailbox[T any] <-chan Message[T]
+
+type TypedWorkerConfig[T any] struct {
+	Key    string
+	Worker func(ctx context.Context, mailbox Mailbox[T])
+	// MailboxSize bounds the mailbox; sends to a full one fail with
+	// ErrMailboxFull or wait, depending on how they were made. Default 16.
+	MailboxSize   int
+	LogStackTrace bool
+	RestartPolicy RestartPolicy
+	RestartCount  int
+	// See WorkerConfig.
+	DependsOn        []string
+	HeartbeatTimeout time.Duration
+	StopTimeout      time.Duration
+}
+
+// AddWorker adds a worker that receives messages of type T. Methods can't
+// take type parameters, hence a function.
+func AddWorker[T any](g *SupervisorGroup, config TypedWorkerConfig[T]) error {
+	size := config.MailboxSize
+	if size <= 0 {
+		size = 16
+	}
+
+	mailbox := make(chan Message[T], size)
+
+	c := &child{
+		key:         config.Key,
+		policy:      config.RestartPolicy,
+		limit:       config.RestartCount,
+		done:        make(chan struct{}),
+		dependsOn:   config.DependsOn,
+		heartbeat:   config.HeartbeatTimeout,
+		stopTimeout: config.StopTimeout,
+	}
+
+	c.deliver = func(ctx context.Context, msg any, reply chan any) error {
+		v, ok := msg.(T)
+		if !ok {
+			return ErrMessageType
+		}
+
+		return enqueue(ctx, mailbox, Message[T]{Value: v, reply: reply}, c.done)
+	}
+
+	return g.addWorker(c, config.LogStackTrace, func(ctx context.Context) {
+		config.Worker(ctx, mailbox)
+	})
+}
+
+// enqueue puts msg in mailbox, giving up at once if it is full and ctx is
+// nil, or else when ctx is done.
+func enqueue[M any](ctx context.Context, mailbox chan M, msg M, done chan struct{}) error {
+	if ctx == nil {
+		select {
+		case mailbox <- msg:
+			return nil
+		case <-done:
+			return ErrWorkerNotFound
+		default:
+			return ErrMailboxFull
+		}
+	}
+
+	select {
+	case mailbox <- msg:
+		return nil
+	case <-done:
+		return ErrWorkerNotFound
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *SupervisorGroup) lookup(key string) (*child, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c := g.find(key)
+	if c == nil || c.deliver == nil {
+		return nil, ErrWorkerNotFound
+	}
+
+	return c, nil
+}
+
+// Send puts message in the worker's mailbox without waiting, failing with
+// ErrMailboxFull if there is no room.
+func (g *SupervisorGroup) Send(key string, message any) error {
+	c, err := g.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	return c.deliver(nil, message, nil)
+}
+
+// SendContext puts message in the worker's mailbox, waiting for room until
+// ctx is done.
+func (g *SupervisorGroup) SendContext(ctx context.Context, key string, message any) error {
+	c, err := g.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	return c.deliver(ctx, message, nil)
+}
+
+// Call sends message to a typed worker and waits for its Reply. The timeout
+// covers both waiting for room in the mailbox and waiting for the reply.
+func (g *SupervisorGroup) Call(key string, message any, timeout time.Duration) (any, error) {
+	c, err := g.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	reply := make(chan any, 1)
+
+	err = c.deliver(ctx, message, reply)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrCallTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case v := <-reply:
+		return v, nil
+	case <-c.done:
+		return nil, ErrWorkerNotFound
+	case <-ctx.Done():
+		return nil, ErrCallTimeout
+	}
+}
+
+// workers whose mailbox is full or which take another message type will
+// be skipped
+func (g *SupervisorGroup) Broadcast(message any) {
+	g.BroadcastWithPrefix("", message)
+}
+
+// workers whose mailbox is full or which take another message type will
+// be skipped
+func (g *SupervisorGroup) BroadcastWithPrefix(prefix string, message any) {
+	g.mu.Lock()
+	children := append([]*child(nil), g.children...)
+	g.mu.Unlock()
+
+	for _, c := range children {
+		if c.deliver == nil || !strings.HasPrefix(c.key, prefix) {
+			continue
+		}
+
+		err := c.deliver(nil, message, nil)
+		if err != nil && err != ErrMessageType {
+			log.Println("supervisor: broadcast", c.key, err)
+		}
+	}
+}
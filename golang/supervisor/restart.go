@@ -7,3 +7,17 @@ const (
 	RestartLimited
 	RestartNever
 )
+
+// Strategy decides which children a supervisor restarts when one of them
+// exits and its RestartPolicy asks for a restart.
+type Strategy int
+
+const (
+	// OneForOne restarts only the child that exited.
+	OneForOne Strategy = iota
+	// OneForAll stops every other child and restarts them all.
+	OneForAll
+	// RestForOne stops the children started after the one that exited and
+	// restarts it and them.
+	RestForOne
+)
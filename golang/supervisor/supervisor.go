@@ -6,222 +6,811 @@ import (
 	"fmt"
 	"log"
 	"runtime/debug"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// SupervisorConfig controls how a supervisor restarts its children.
+type SupervisorConfig struct {
+	Strategy Strategy
+	// MaxRestarts is how many restarts are allowed within Window. One more
+	// and the supervisor gives up: it stops its children and fails, which
+	// its parent handles like a crashed child. Zero means no limit.
+	MaxRestarts int
+	// Window defaults to 5 seconds.
+	Window time.Duration
+	// BackoffMin is the delay before a child's first restart, doubling on
+	// each further one up to BackoffMax. A child that ran for BackoffMax
+	// before exiting starts over at BackoffMin. Default 100ms and 10s.
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+	// DependsOn lists siblings, in the parent supervisor, that this one
+	// starts after and stops before.
+	DependsOn []string
+}
+
 type SupervisorGroup struct {
-	workers      map[string]context.CancelFunc
-	channels     map[string]chan any
+	config       SupervisorConfig
+	name         string
+	parent       *SupervisorGroup
 	errorHandler func(err error)
-	wg           sync.WaitGroup
-	mu           sync.Mutex
-	running      atomic.Bool
+
+	mu       sync.Mutex
+	children []*child
+	ctx      context.Context
+	cancel   context.CancelFunc
+	events   chan childExit
+	restarts []time.Time
+	err      error
+	// stopping is set while children are stopped in order, so their exits
+	// are not taken for crashes.
+	stopping bool
+
+	subMu sync.Mutex
+	subs  map[chan Event]struct{}
+
+	wg      sync.WaitGroup
+	running atomic.Bool
+}
+
+type child struct {
+	key    string
+	run    func(ctx context.Context) error
+	policy RestartPolicy
+	limit  int
+	sup    *SupervisorGroup
+	// deliver puts a message in the child's mailbox; nil for supervisors.
+	deliver func(ctx context.Context, msg any, reply chan any) error
+	// done is closed once the child is removed.
+	done chan struct{}
+
+	dependsOn   []string
+	heartbeat   time.Duration
+	stopTimeout time.Duration
+
+	gen      int
+	active   bool
+	cancel   context.CancelFunc
+	exited   chan struct{}
+	exit     func(err error, report bool)
+	beat     *atomic.Int64
+	started  time.Time
+	restarts int
+	backoff  time.Duration
+	lastErr  error
+}
+
+type childExit struct {
+	child *child
+	gen   int
+	err   error
 }
 
 func NewSupervisorGroup() *SupervisorGroup {
-	sup := &SupervisorGroup{
-		workers:  map[string]context.CancelFunc{},
-		channels: map[string]chan any{},
-	}
+	return NewSupervisor(SupervisorConfig{})
+}
 
+// NewSupervisor starts a root supervisor. Nested ones are made with
+// AddSupervisor.
+func NewSupervisor(config SupervisorConfig) *SupervisorGroup {
+	sup := newGroup(config)
 	sup.running.Store(true)
+	sup.start(context.Background())
 
 	return sup
 }
 
+func newGroup(config SupervisorConfig) *SupervisorGroup {
+	if config.Window <= 0 {
+		config.Window = 5 * time.Second
+	}
+	if config.BackoffMin <= 0 {
+		config.BackoffMin = 100 * time.Millisecond
+	}
+	if config.BackoffMax < config.BackoffMin {
+		config.BackoffMax = 10 * time.Second
+	}
+
+	return &SupervisorGroup{
+		config: config,
+		events: make(chan childExit),
+		subs:   map[chan Event]struct{}{},
+	}
+}
+
+// SetErrorHandler sets a function called when the supervisor gives up
+// after too many restarts.
+func (g *SupervisorGroup) SetErrorHandler(handler func(err error)) {
+	g.mu.Lock()
+	g.errorHandler = handler
+	g.mu.Unlock()
+}
+
+// Err returns why the supervisor last gave up, if it did.
+func (g *SupervisorGroup) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.err
+}
+
 type WorkerConfig struct {
 	Key           string
 	Worker        func(ctx context.Context, ch chan any)
 	LogStackTrace bool
 	RestartPolicy RestartPolicy
 	RestartCount  int
+	// DependsOn lists workers, added before this one, that it starts after
+	// and stops before.
+	DependsOn []string
+	// HeartbeatTimeout, if set, has the worker restarted as hung when it
+	// goes that long without calling Heartbeat.
+	HeartbeatTimeout time.Duration
+	// StopTimeout is how long Stop, Cancel and restarts wait for the worker
+	// to return once cancelled before abandoning it. Default 5 seconds.
+	StopTimeout time.Duration
 }
 
+// AddWorkerConfig adds a worker whose mailbox is a plain channel holding
+// one message. It takes messages of any type but not calls.
 func (g *SupervisorGroup) AddWorkerConfig(
 	config WorkerConfig,
+) error {
+	ch := make(chan any, 1)
+
+	c := &child{
+		key:         config.Key,
+		policy:      config.RestartPolicy,
+		limit:       config.RestartCount,
+		done:        make(chan struct{}),
+		dependsOn:   config.DependsOn,
+		heartbeat:   config.HeartbeatTimeout,
+		stopTimeout: config.StopTimeout,
+	}
+
+	c.deliver = func(ctx context.Context, msg any, reply chan any) error {
+		if reply != nil {
+			return ErrNoReply
+		}
+
+		return enqueue(ctx, ch, msg, c.done)
+	}
+
+	return g.addWorker(c, config.LogStackTrace, func(ctx context.Context) {
+		config.Worker(ctx, ch)
+	})
+}
+
+// addWorker adds c, running work and turning its panics into errors.
+func (g *SupervisorGroup) addWorker(
+	c *child,
+	logStackTrace bool,
+	work func(ctx context.Context),
 ) error {
 	if g.running.Load() == false {
-		log.Println("supervisor: not running, skip adding worker", config.Key)
+		log.Println("supervisor: not running, skip adding worker", c.key)
 		return errors.New("supervisor group not running")
 	}
 
+	if c.stopTimeout <= 0 {
+		c.stopTimeout = 5 * time.Second
+	}
+
+	log.Printf("supervisor: add worker %s - %v:%v \n", c.key, c.policy, c.limit)
+
+	c.run = func(ctx context.Context) (err error) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				stackTrace := debug.Stack()
+				if logStackTrace {
+					log.Printf("%s stack trace:\n%s\n", c.key, stackTrace)
+				}
+
+				err = &panicError{value: r, stack: stackTrace}
+			}
+		}()
+
+		work(ctx)
+
+		return nil
+	}
+
+	return g.add(c)
+}
+
+// AddSupervisor adds a nested supervisor under key and returns it so
+// workers can be added to it. When it gives up, this supervisor restarts
+// it, and with it all of its children, according to its own strategy.
+func (g *SupervisorGroup) AddSupervisor(
+	key string,
+	config SupervisorConfig,
+) (*SupervisorGroup, error) {
+	if g.running.Load() == false {
+		log.Println("supervisor: not running, skip adding supervisor", key)
+		return nil, errors.New("supervisor group not running")
+	}
+
+	sub := newGroup(config)
+	sub.name = key
+	sub.parent = g
+	sub.running.Store(true)
+
+	log.Printf("supervisor: add supervisor %s - %v:%v \n", key, config.Strategy, config.MaxRestarts)
+
+	err := g.add(&child{
+		key: key,
+		run: func(ctx context.Context) error {
+			return sub.runNested(ctx)
+		},
+		policy:    RestartAlways,
+		sup:       sub,
+		done:      make(chan struct{}),
+		dependsOn: config.DependsOn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// add registers c, replacing any child with the same key, and starts it if
+// the supervisor is running.
+func (g *SupervisorGroup) add(c *child) error {
 	g.mu.Lock()
-	defer g.mu.Unlock()
 
-	cancel_, ok := g.workers[config.Key]
-	if ok {
-		cancel_()
+	for _, dep := range c.dependsOn {
+		if dep == c.key || g.find(dep) == nil {
+			g.mu.Unlock()
+			return fmt.Errorf("supervisor: %s depends on unknown worker %s", c.key, dep)
+		}
 	}
 
-	ch_, ok := g.channels[config.Key]
-	if ok {
-		close(ch_)
+	children := []*child{}
+	for _, o := range g.children {
+		if o.key != c.key {
+			children = append(children, o)
+		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	ch := make(chan any, 1)
+	children, err := startOrder(append(children, c))
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+
+	old := g.find(c.key)
+	if old != nil {
+		h := g.detach(old)
+		g.mu.Unlock()
+
+		h.wait()
+		g.finish(old)
+
+		g.mu.Lock()
+	}
 
-	g.workers[config.Key] = cancel
-	g.channels[config.Key] = ch
+	g.children = children
 	g.wg.Add(1)
 
-	log.Printf("supervisor: add worker %s - %v:%v \n", config.Key, config.RestartPolicy, config.RestartCount)
+	if g.ctx != nil && g.ctx.Err() == nil && !g.stopping {
+		g.launch(c, 0)
+	}
+	g.mu.Unlock()
+
+	return nil
+}
+
+// startOrder sorts children so each comes after its dependencies, keeping
+// the order they were added in otherwise.
+func startOrder(children []*child) ([]*child, error) {
+	present := map[string]bool{}
+	for _, c := range children {
+		present[c.key] = true
+	}
+
+	placed := map[string]bool{}
+	sorted := make([]*child, 0, len(children))
 
-	go func(key string) {
-		defer g.done(key)
+	for len(sorted) < len(children) {
+		progress := false
 
-		restartCount := 0
+		for _, c := range children {
+			if placed[c.key] {
+				continue
+			}
 
-		for {
-			err := func() (err error) {
-				defer func() {
-					r := recover()
-					if r != nil {
-						log.Println("supervisor: worker quit", r)
+			ready := true
+			for _, dep := range c.dependsOn {
+				// Dependencies cancelled since are no longer waited for.
+				if present[dep] && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				placed[c.key] = true
+				sorted = append(sorted, c)
+				progress = true
+			}
+		}
 
-						if config.LogStackTrace {
-							stackTrace := debug.Stack()
-							log.Printf("%s stack trace:\n%s\n", key, stackTrace)
-						}
+		if !progress {
+			return nil, errors.New("supervisor: dependency cycle")
+		}
+	}
 
-						err = fmt.Errorf("%v", r)
-						return
-					}
+	return sorted, nil
+}
 
-					log.Println("worker stopped", key)
-				}()
+func (g *SupervisorGroup) find(key string) *child {
+	for _, c := range g.children {
+		if c.key == key {
+			return c
+		}
+	}
 
-				config.Worker(ctx, ch)
+	return nil
+}
 
+func (g *SupervisorGroup) has(c *child) bool {
+	for _, o := range g.children {
+		if o == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// start launches every child and the loop that handles their exits.
+func (g *SupervisorGroup) start(parent context.Context) {
+	g.mu.Lock()
+	g.ctx, g.cancel = context.WithCancel(parent)
+	g.restarts = nil
+	g.err = nil
+	g.stopping = false
+
+	ctx := g.ctx
+	for _, c := range g.children {
+		g.launch(c, 0)
+	}
+	g.mu.Unlock()
+
+	go g.loop(ctx)
+}
+
+func (g *SupervisorGroup) loop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-g.events:
+			g.handleExit(ev)
+		}
+	}
+}
+
+// launch runs c after delay. g.mu must be held.
+func (g *SupervisorGroup) launch(c *child, delay time.Duration) {
+	groupCtx := g.ctx
+	ctx, cancel := context.WithCancel(groupCtx)
+	exited := make(chan struct{})
+
+	c.gen++
+	gen := c.gen
+	restarts := c.restarts
+
+	// exit ends this run once, whether the worker returned, was hung or
+	// was abandoned, reporting the first two to the loop.
+	var once sync.Once
+	exit := func(err error, report bool) {
+		once.Do(func() {
+			cancel()
+			close(exited)
+
+			if !report {
 				return
-			}()
-			if err != nil {
-				log.Println("supervisor: worker panicked", err)
 			}
 
-			if ctx.Err() != nil {
-				log.Println("supervisor: worker cancelled")
-				break
+			select {
+			case g.events <- childExit{child: c, gen: gen, err: err}:
+			case <-groupCtx.Done():
 			}
+		})
+	}
 
-			shouldRestart := false
+	var beat *atomic.Int64
+	if c.heartbeat > 0 {
+		beat = &atomic.Int64{}
+		beat.Store(time.Now().Add(delay).UnixNano())
+		ctx = context.WithValue(ctx, heartbeatKey{}, beat)
+	}
 
-			switch config.RestartPolicy {
-			case RestartAlways:
-				shouldRestart = true
-			case RestartLimited:
-				if restartCount <= config.RestartCount {
-					shouldRestart = true
-					restartCount++
-				}
-			case RestartNever:
-				shouldRestart = false
+	c.active = true
+	c.cancel = cancel
+	c.exited = exited
+	c.exit = exit
+	c.beat = beat
+	c.started = time.Now().Add(delay)
+
+	go func() {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				exit(nil, false)
+				return
 			}
+		}
 
-			log.Println(key, shouldRestart, ctx.Err())
-			shouldRestart = shouldRestart && g.running.Load() && ctx.Err() == nil
+		kind := EventRestarted
+		if gen == 1 {
+			kind = EventStarted
+		}
+		g.emit(Event{Kind: kind, Key: c.key, Restarts: restarts})
 
-			if !shouldRestart {
-				break
-			}
+		if beat != nil {
+			beat.Store(time.Now().UnixNano())
+			go watch(ctx, c.key, c.heartbeat, beat, exit)
 		}
-	}(config.Key)
 
-	return nil
+		err := c.run(ctx)
+		stopped := ctx.Err() != nil
+
+		if stopped {
+			log.Println("supervisor: worker cancelled", c.key)
+		}
+
+		exit(err, !stopped)
+	}()
 }
 
-func (g *SupervisorGroup) Cancel(key string) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+// halt is a run to be stopped.
+type halt struct {
+	key     string
+	cancel  context.CancelFunc
+	exited  chan struct{}
+	exit    func(err error, report bool)
+	timeout time.Duration
+}
+
+// halt marks c as stopping and returns its run, or a zero halt if it was
+// not running. g.mu must be held.
+func (c *child) halt() halt {
+	if !c.active {
+		return halt{}
+	}
 
-	cancel := g.workers[key]
-	cancel()
+	c.active = false
+
+	return halt{
+		key:     c.key,
+		cancel:  c.cancel,
+		exited:  c.exited,
+		exit:    c.exit,
+		timeout: c.stopTimeout,
+	}
 }
 
-func (g *SupervisorGroup) Stop() {
-	log.Println("supervisor: cancel all")
-	g.running.Store(false)
+// wait cancels the run and waits for it to return, abandoning it after its
+// stop timeout. A zero timeout waits as long as it takes.
+func (h halt) wait() {
+	if h.cancel == nil {
+		return
+	}
+
+	h.cancel()
 
-	for key, cancel := range g.workers {
-		log.Println("cancelling worker", key)
-		cancel()
+	if h.timeout <= 0 {
+		<-h.exited
+		return
 	}
-	g.wg.Wait()
+
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-h.exited:
+	case <-timer.C:
+		log.Printf("supervisor: %s did not stop within %s, abandoning it\n", h.key, h.timeout)
+		h.exit(nil, false)
+	}
+}
+
+// stopAll marks every running child as stopping and returns them in the
+// order to stop them: dependents before what they depend on. g.mu must be
+// held.
+func (g *SupervisorGroup) stopAll() []halt {
+	halts := []halt{}
+
+	for i := len(g.children) - 1; i >= 0; i-- {
+		h := g.children[i].halt()
+		if h.cancel != nil {
+			halts = append(halts, h)
+		}
+	}
+
+	return halts
 }
 
-// workers whose channel is full will be skipped
-func (g *SupervisorGroup) Broadcast(message any) {
-	log.Println("supervisor: broadcast", message)
+func stopInOrder(halts []halt) {
+	for _, h := range halts {
+		h.wait()
+	}
+}
 
+func (g *SupervisorGroup) handleExit(ev childExit) {
 	g.mu.Lock()
-	defer g.mu.Unlock()
 
-	for k, ch := range g.channels {
-		go func() {
-			select {
-			case ch <- message:
-			case <-time.After(time.Minute):
-				log.Println("supervisor: broadcast timeout", k)
+	c := ev.child
+	if c.gen != ev.gen || !c.active || !g.has(c) || g.stopping || g.ctx.Err() != nil {
+		g.mu.Unlock()
+		return
+	}
+
+	c.active = false
+
+	if ev.err != nil {
+		log.Println("supervisor: worker panicked", c.key, ev.err)
+		c.lastErr = ev.err
+
+		crash := Event{Kind: EventCrashed, Key: c.key, Restarts: c.restarts, Err: ev.err}
+		if p, ok := ev.err.(*panicError); ok {
+			crash.Panic = p.value
+			crash.Stack = p.stack
+			crash.Err = nil
+		}
+		g.emit(crash)
+	} else {
+		log.Println("worker stopped", c.key)
+	}
+
+	shouldRestart := false
+
+	switch c.policy {
+	case RestartAlways:
+		// A nested supervisor stopped by its own Stop stays stopped.
+		shouldRestart = c.sup == nil || c.sup.running.Load()
+	case RestartLimited:
+		shouldRestart = c.restarts < c.limit
+	case RestartNever:
+		shouldRestart = false
+	}
+
+	if !shouldRestart {
+		g.detach(c)
+		g.mu.Unlock()
+		g.finish(c)
+		return
+	}
+
+	now := time.Now()
+
+	if g.config.MaxRestarts > 0 {
+		recent := g.restarts[:0]
+		for _, t := range g.restarts {
+			if now.Sub(t) < g.config.Window {
+				recent = append(recent, t)
 			}
-		}()
+		}
+		g.restarts = append(recent, now)
+
+		if len(g.restarts) > g.config.MaxRestarts {
+			g.mu.Unlock()
+			g.fail(fmt.Errorf(
+				"supervisor: %s exceeded %d restarts in %s",
+				c.key, g.config.MaxRestarts, g.config.Window,
+			))
+			return
+		}
 	}
-}
 
-// workers whose channel is full will be skipped
-func (g *SupervisorGroup) BroadcastWithPrefix(prefix string, message any) {
-	log.Println("supervisor: broadcast with prefix", prefix)
+	if c.backoff == 0 || c.started.Add(g.config.BackoffMax).Before(now) {
+		c.backoff = g.config.BackoffMin
+	} else {
+		c.backoff = min(c.backoff*2, g.config.BackoffMax)
+	}
+	c.restarts++
+
+	restart := g.restartSet(c)
+
+	// Stop the others last-started first, then restart in start order.
+	halts := []halt{}
+	for i := len(restart) - 1; i >= 0; i-- {
+		if restart[i] != c {
+			halts = append(halts, restart[i].halt())
+		}
+	}
+	g.mu.Unlock()
+
+	stopInOrder(halts)
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	for k, ch := range g.channels {
-		if !strings.HasPrefix(k, prefix) {
+	if g.ctx.Err() != nil {
+		return
+	}
+
+	log.Printf("supervisor: restarting %s in %s\n", c.key, c.backoff)
+
+	for _, s := range restart {
+		if s.active || !g.has(s) {
 			continue
 		}
 
-		go func() {
-			select {
-			case ch <- message:
-			case <-time.After(time.Minute):
-				log.Println("supervisor: broadcast_prefix timeout", k)
+		// Like Erlang's temporary children, workers that never restart
+		// are not brought back by their siblings' restarts either.
+		if s != c && s.policy == RestartNever {
+			g.detach(s)
+			go g.finish(s)
+			continue
+		}
+
+		g.launch(s, c.backoff)
+	}
+}
+
+// restartSet returns the children the strategy restarts when c exits, in
+// start order. g.mu must be held.
+func (g *SupervisorGroup) restartSet(c *child) []*child {
+	switch g.config.Strategy {
+	case OneForAll:
+		return append([]*child(nil), g.children...)
+	case RestForOne:
+		for i, o := range g.children {
+			if o == c {
+				return append([]*child(nil), g.children[i:]...)
 			}
-		}()
+		}
 	}
+
+	return []*child{c}
 }
 
-func (g *SupervisorGroup) Send(key string, message any) {
+// fail gives up after too many restarts: it stops every child and either
+// reports to the parent, which sees this supervisor exit with err, or, at
+// the root, removes the children so Wait returns.
+func (g *SupervisorGroup) fail(err error) {
+	log.Println(err)
+
 	g.mu.Lock()
-	defer g.mu.Unlock()
+	g.err = err
+	g.stopping = true
+	halts := g.stopAll()
+	handler := g.errorHandler
+	g.mu.Unlock()
 
-	ch, ok := g.channels[key]
-	if ok {
-		go func() {
-			select {
-			case ch <- message:
-			case <-time.After(time.Minute):
-				log.Println("supervisor: send timeout", key)
-			}
-		}()
+	stopInOrder(halts)
+
+	if handler != nil {
+		handler(err)
+	}
+
+	// Only now let runNested return, so the parent never restarts this
+	// supervisor while its old children are still running.
+	g.mu.Lock()
+	g.cancel()
+	g.mu.Unlock()
+
+	if g.parent == nil {
+		g.running.Store(false)
+		g.terminate()
+		g.closeSubscribers()
 	}
 }
 
-func (g *SupervisorGroup) done(key string) {
+// runNested runs a nested supervisor until its parent stops it or it gives
+// up, returning the reason in the latter case.
+func (g *SupervisorGroup) runNested(ctx context.Context) error {
+	// The parent's cancellation must not reach the children all at once,
+	// or they could not be stopped in order.
+	g.start(context.WithoutCancel(ctx))
+
 	g.mu.Lock()
-	defer g.mu.Unlock()
+	done := g.ctx.Done()
+	g.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		g.mu.Lock()
+		g.stopping = true
+		halts := g.stopAll()
+		g.mu.Unlock()
+
+		stopInOrder(halts)
+
+		g.mu.Lock()
+		g.cancel()
+		g.mu.Unlock()
+	}
+
+	return g.Err()
+}
 
-	ch, ok := g.channels[key]
-	if ok {
-		close(ch)
+// detach removes c from the supervisor and returns its run for the caller
+// to stop. g.mu must be held.
+func (g *SupervisorGroup) detach(c *child) halt {
+	for i, o := range g.children {
+		if o == c {
+			g.children = append(g.children[:i:i], g.children[i+1:]...)
+			break
+		}
 	}
 
-	delete(g.channels, key)
-	delete(g.workers, key)
+	return c.halt()
+}
+
+// finish releases a detached child once it has exited.
+func (g *SupervisorGroup) finish(c *child) {
+	if c.sup != nil {
+		c.sup.running.Store(false)
+		c.sup.terminate()
+		c.sup.closeSubscribers()
+	}
+
+	close(c.done)
 	g.wg.Done()
 
-	log.Println("supervisor: worker done", key)
+	g.emit(Event{Kind: EventStopped, Key: c.key, Restarts: c.restarts})
+
+	log.Println("supervisor: worker done", c.key)
+}
+
+// terminate removes every child of a supervisor that has stopped.
+func (g *SupervisorGroup) terminate() {
+	g.mu.Lock()
+	children := g.children
+	g.children = nil
+	g.mu.Unlock()
+
+	for _, c := range children {
+		g.finish(c)
+	}
+}
+
+func (g *SupervisorGroup) Cancel(key string) {
+	g.mu.Lock()
+	c := g.find(key)
+	if c == nil {
+		g.mu.Unlock()
+		return
+	}
+
+	h := g.detach(c)
+	g.mu.Unlock()
+
+	h.wait()
+	g.finish(c)
+}
+
+// Stop stops every child, dependents before what they depend on, waiting
+// for each up to its StopTimeout.
+func (g *SupervisorGroup) Stop() {
+	log.Println("supervisor: cancel all")
+	g.running.Store(false)
+
+	g.mu.Lock()
+	g.stopping = true
+	halts := g.stopAll()
+	g.mu.Unlock()
+
+	stopInOrder(halts)
+
+	g.mu.Lock()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	g.mu.Unlock()
+
+	g.terminate()
+	g.closeSubscribers()
+	g.wg.Wait()
 }
 
 func (g *SupervisorGroup) Wait() {
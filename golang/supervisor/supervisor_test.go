@@ -2,7 +2,11 @@ package supervisor_test
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -56,3 +60,426 @@ func TestSupervisor(t *testing.T) {
 	sup.Stop()
 	sup.Wait()
 }
+
+func crashOnce(starts *atomic.Int32) func(ctx context.Context, ch chan any) {
+	return func(ctx context.Context, ch chan any) {
+		if starts.Add(1) == 1 {
+			panic("crash")
+		}
+
+		<-ctx.Done()
+	}
+}
+
+func idle(starts *atomic.Int32) func(ctx context.Context, ch chan any) {
+	return func(ctx context.Context, ch chan any) {
+		starts.Add(1)
+		<-ctx.Done()
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	cases := []struct {
+		strategy supervisor.Strategy
+		want     [3]int32
+	}{
+		{supervisor.OneForOne, [3]int32{1, 2, 1}},
+		{supervisor.OneForAll, [3]int32{2, 2, 2}},
+		{supervisor.RestForOne, [3]int32{1, 2, 2}},
+	}
+
+	for _, tc := range cases {
+		sup := supervisor.NewSupervisor(supervisor.SupervisorConfig{
+			Strategy:   tc.strategy,
+			BackoffMin: time.Millisecond,
+		})
+
+		var starts [3]atomic.Int32
+
+		sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "a", Worker: idle(&starts[0])})
+		sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "b", Worker: crashOnce(&starts[1])})
+		sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "c", Worker: idle(&starts[2])})
+
+		time.Sleep(100 * time.Millisecond)
+		sup.Stop()
+
+		got := [3]int32{starts[0].Load(), starts[1].Load(), starts[2].Load()}
+		if got != tc.want {
+			t.Errorf("strategy %v: starts %v, want %v", tc.strategy, got, tc.want)
+		}
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	sup := supervisor.NewSupervisor(supervisor.SupervisorConfig{
+		BackoffMin: time.Millisecond,
+	})
+
+	sub, err := sup.AddSupervisor("sub", supervisor.SupervisorConfig{
+		MaxRestarts: 2,
+		Window:      time.Second,
+		BackoffMin:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var crashes, siblings atomic.Int32
+
+	sub.AddWorkerConfig(supervisor.WorkerConfig{
+		Key: "crasher",
+		Worker: func(ctx context.Context, ch chan any) {
+			// Crash three times in a row to exceed the limit, then settle.
+			if crashes.Add(1) <= 3 {
+				panic("crash")
+			}
+
+			<-ctx.Done()
+		},
+	})
+	sub.AddWorkerConfig(supervisor.WorkerConfig{Key: "sibling", Worker: idle(&siblings)})
+
+	time.Sleep(200 * time.Millisecond)
+
+	// The sub supervisor gives up on the third crash and its parent
+	// restarts it, starting the sibling a second time.
+	if n := siblings.Load(); n != 2 {
+		t.Errorf("sibling started %d times, want 2", n)
+	}
+	if n := crashes.Load(); n != 4 {
+		t.Errorf("crasher started %d times, want 4", n)
+	}
+
+	sup.Stop()
+	sup.Wait()
+}
+
+func TestRootEscalation(t *testing.T) {
+	sup := supervisor.NewSupervisor(supervisor.SupervisorConfig{
+		MaxRestarts: 1,
+		BackoffMin:  time.Millisecond,
+	})
+
+	failed := make(chan error, 1)
+	sup.SetErrorHandler(func(err error) {
+		failed <- err
+	})
+
+	sup.AddWorkerConfig(supervisor.WorkerConfig{
+		Key: "crasher",
+		Worker: func(ctx context.Context, ch chan any) {
+			panic("crash")
+		},
+	})
+
+	select {
+	case err := <-failed:
+		if err != sup.Err() {
+			t.Errorf("handler got %v, Err returned %v", err, sup.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not give up")
+	}
+
+	sup.Wait()
+
+	err := sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "late", Worker: idle(new(atomic.Int32))})
+	if err == nil {
+		t.Error("added a worker to a failed supervisor")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	sup := supervisor.NewSupervisor(supervisor.SupervisorConfig{
+		BackoffMin: 20 * time.Millisecond,
+		BackoffMax: time.Second,
+	})
+
+	starts := make(chan time.Time, 4)
+	var n atomic.Int32
+
+	sup.AddWorkerConfig(supervisor.WorkerConfig{
+		Key: "crasher",
+		Worker: func(ctx context.Context, ch chan any) {
+			starts <- time.Now()
+			if n.Add(1) < 4 {
+				panic("crash")
+			}
+
+			<-ctx.Done()
+		},
+	})
+
+	prev := <-starts
+	for i, want := range []time.Duration{20, 40, 80} {
+		next := <-starts
+		if gap := next.Sub(prev); gap < want*time.Millisecond {
+			t.Errorf("restart %d after %s, want at least %dms", i+1, gap, want)
+		}
+		prev = next
+	}
+
+	sup.Stop()
+}
+
+func TestTypedWorker(t *testing.T) {
+	sup := supervisor.NewSupervisorGroup()
+	defer sup.Stop()
+
+	release := make(chan struct{})
+	received := make(chan int, 8)
+
+	err := supervisor.AddWorker(sup, supervisor.TypedWorkerConfig[int]{
+		Key:         "adder",
+		MailboxSize: 4,
+		Worker: func(ctx context.Context, mailbox supervisor.Mailbox[int]) {
+			<-release
+
+			total := 0
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case m := <-mailbox:
+					total += m.Value
+					received <- m.Value
+					m.Reply(total)
+				}
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		if err := sup.Send("adder", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sup.Send("adder", 5); err != supervisor.ErrMailboxFull {
+		t.Errorf("send to full mailbox: %v", err)
+	}
+	if err := sup.Send("adder", "five"); err != supervisor.ErrMessageType {
+		t.Errorf("send of wrong type: %v", err)
+	}
+	if err := sup.Send("missing", 5); err != supervisor.ErrWorkerNotFound {
+		t.Errorf("send to missing worker: %v", err)
+	}
+	if _, err := sup.Call("adder", 5, 10*time.Millisecond); err != supervisor.ErrCallTimeout {
+		t.Errorf("call to busy worker: %v", err)
+	}
+
+	close(release)
+
+	for i := 1; i <= 4; i++ {
+		if v := <-received; v != i {
+			t.Fatalf("received %d, want %d", v, i)
+		}
+	}
+
+	total, err := sup.Call("adder", 5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 15 {
+		t.Errorf("total %v, want 15", total)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	sup := supervisor.NewSupervisor(supervisor.SupervisorConfig{
+		BackoffMin: time.Millisecond,
+	})
+
+	events, unsubscribe := sup.Subscribe(16)
+	defer unsubscribe()
+
+	sub, err := sup.AddSupervisor("sub", supervisor.SupervisorConfig{
+		BackoffMin: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var starts atomic.Int32
+
+	sub.AddWorkerConfig(supervisor.WorkerConfig{
+		Key:           "worker",
+		Worker:        crashOnce(&starts),
+		RestartPolicy: supervisor.RestartAlways,
+	})
+
+	want := []struct {
+		kind supervisor.EventKind
+		key  string
+	}{
+		{supervisor.EventStarted, "sub"},
+		{supervisor.EventStarted, "sub/worker"},
+		{supervisor.EventCrashed, "sub/worker"},
+		{supervisor.EventRestarted, "sub/worker"},
+	}
+
+	for _, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Kind != w.kind || ev.Key != w.key {
+				t.Fatalf("got %v %s, want %v %s", ev.Kind, ev.Key, w.kind, w.key)
+			}
+			if ev.Kind == supervisor.EventCrashed && (ev.Panic != "crash" || len(ev.Stack) == 0) {
+				t.Errorf("crash event without panic details: %+v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %v event for %s", w.kind, w.key)
+		}
+	}
+
+	sup.Stop()
+
+	stopped := []string{}
+	for ev := range events {
+		if ev.Kind == supervisor.EventStopped {
+			stopped = append(stopped, ev.Key)
+		}
+	}
+
+	if len(stopped) != 2 || stopped[0] != "sub/worker" || stopped[1] != "sub" {
+		t.Errorf("stopped %v, want [sub/worker sub]", stopped)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	sup := supervisor.NewSupervisor(supervisor.SupervisorConfig{
+		BackoffMin: time.Millisecond,
+	})
+	defer sup.Stop()
+
+	var starts atomic.Int32
+
+	sup.AddWorkerConfig(supervisor.WorkerConfig{
+		Key: "hangs",
+		Worker: func(ctx context.Context, ch chan any) {
+			// Beat a few times, then stop beating without returning.
+			if starts.Add(1) == 1 {
+				for i := 0; i < 3; i++ {
+					supervisor.Heartbeat(ctx)
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(10 * time.Millisecond):
+					if starts.Load() > 1 {
+						supervisor.Heartbeat(ctx)
+					}
+				}
+			}
+		},
+		HeartbeatTimeout: 40 * time.Millisecond,
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	if n := starts.Load(); n != 2 {
+		t.Errorf("started %d times, want 2", n)
+	}
+
+	st := sup.Snapshot()
+	if len(st) != 1 || st[0].LastError != supervisor.ErrHung.Error() || st[0].LastHeartbeat == nil {
+		t.Errorf("snapshot %+v", st)
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	sup := supervisor.NewSupervisorGroup()
+
+	stopped := make(chan string, 4)
+	worker := func(key string) func(ctx context.Context, ch chan any) {
+		return func(ctx context.Context, ch chan any) {
+			<-ctx.Done()
+			stopped <- key
+		}
+	}
+
+	sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "db", Worker: worker("db")})
+	sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "cache", Worker: worker("cache"), DependsOn: []string{"db"}})
+	sup.AddWorkerConfig(supervisor.WorkerConfig{
+		Key: "stuck",
+		Worker: func(ctx context.Context, ch chan any) {
+			select {}
+		},
+		StopTimeout: 20 * time.Millisecond,
+	})
+	sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "api", Worker: worker("api"), DependsOn: []string{"cache"}})
+
+	err := sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "x", Worker: worker("x"), DependsOn: []string{"missing"}})
+	if err == nil {
+		t.Error("added a worker with an unknown dependency")
+	}
+
+	err = sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "db", Worker: worker("db"), DependsOn: []string{"api"}})
+	if err == nil {
+		t.Error("added a dependency cycle")
+	}
+
+	// Replacing db keeps it ahead of the workers depending on it.
+	sup.AddWorkerConfig(supervisor.WorkerConfig{Key: "db", Worker: worker("db")})
+	if key := <-stopped; key != "db" {
+		t.Fatalf("replacement stopped %s", key)
+	}
+
+	keys := []string{}
+	for _, st := range sup.Snapshot() {
+		keys = append(keys, st.Key)
+	}
+	if strings.Join(keys, ",") != "stuck,db,cache,api" {
+		t.Errorf("start order %v", keys)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sup.Stop()
+		sup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop blocked on a stuck worker")
+	}
+
+	order := []string{<-stopped, <-stopped, <-stopped}
+	if strings.Join(order, ",") != "api,cache,db" {
+		t.Errorf("stop order %v", order)
+	}
+}
+
+func TestSnapshotHTTP(t *testing.T) {
+	sup := supervisor.NewSupervisorGroup()
+	defer sup.Stop()
+
+	sub, _ := sup.AddSupervisor("sub", supervisor.SupervisorConfig{})
+	sub.AddWorkerConfig(supervisor.WorkerConfig{Key: "worker", Worker: idle(new(atomic.Int32))})
+
+	time.Sleep(20 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	sup.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	var st []supervisor.WorkerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st) != 1 || st[0].Key != "sub" || len(st[0].Children) != 1 {
+		t.Fatalf("snapshot %+v", st)
+	}
+	if w := st[0].Children[0]; w.Key != "worker" || w.State != supervisor.StateRunning || w.Uptime <= 0 {
+		t.Errorf("worker status %+v", w)
+	}
+}
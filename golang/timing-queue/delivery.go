@@ -0,0 +1,116 @@
+package timingqueue
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens to a due task when the delivery
+// buffer is full because consumers are lagging.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room. Tasks keep coming due meanwhile and are
+	// delivered late.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the task that found the buffer full.
+	OverflowDropNewest
+	// OverflowDropOldest drops the longest waiting task in the buffer to
+	// make room.
+	OverflowDropOldest
+)
+
+type Options struct {
+	// Timers holds the pending tasks. Default NewHeapTimers().
+	Timers Timers
+	// Buffer is how many due tasks wait for a consumer. Default 100.
+	Buffer   int
+	Overflow OverflowPolicy
+	// Handler, when set, is called with each due task on a pool of Workers
+	// goroutines (default 1) instead of sending it on Signal.
+	Handler func(task *Task)
+	Workers int
+}
+
+// deliver hands a due task to consumers as the overflow policy says. It
+// returns false if the task was dropped.
+func (tw *TimingQueue) deliver(task *Task) bool {
+	for {
+		select {
+		case tw.signal <- task:
+			return true
+		default:
+		}
+
+		switch tw.overflow {
+		case OverflowDropNewest:
+			atomic.AddUint64(&tw.dropped, 1)
+			return false
+
+		case OverflowDropOldest:
+			select {
+			case <-tw.signal:
+				atomic.AddUint64(&tw.dropped, 1)
+			default:
+			}
+
+		default:
+			select {
+			case tw.signal <- task:
+				return true
+			case <-tw.stopChan:
+				return tw.deliverStopping(task)
+			}
+		}
+	}
+}
+
+// deliverStopping hands over a task that came due before Stop. Workers take
+// tasks until Stop closes Signal, so with a Handler it waits for room; a
+// Signal consumer may have stopped reading, so otherwise the task is
+// dropped if the buffer is full.
+func (tw *TimingQueue) deliverStopping(task *Task) bool {
+	if tw.handler != nil {
+		tw.signal <- task
+		return true
+	}
+
+	select {
+	case tw.signal <- task:
+		return true
+	default:
+		atomic.AddUint64(&tw.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns how many due tasks were dropped because the delivery
+// buffer was full.
+func (tw *TimingQueue) Dropped() uint64 {
+	return atomic.LoadUint64(&tw.dropped)
+}
+
+func (tw *TimingQueue) startWorkers() {
+	for i := 0; i < tw.workers; i++ {
+		tw.wg.Add(1)
+
+		go func() {
+			defer tw.wg.Done()
+
+			for task := range tw.signal {
+				tw.handle(task)
+			}
+		}()
+	}
+}
+
+func (tw *TimingQueue) handle(task *Task) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			log.Println("timing queue - handler panic", task.ID, r)
+		}
+	}()
+
+	tw.handler(task)
+}
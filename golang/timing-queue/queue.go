@@ -1,34 +1,79 @@
 package timingqueue
 
 import (
-	"container/heap"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"sync"
 	"time"
 )
 
+var ErrTaskNotFound = errors.New("task not found")
+
 type Task struct {
 	ID       string
-	Meta map[string]string
+	Meta     map[string]string
 	Deadline time.Time
+	// Interval makes the task recur; each run after the first is due
+	// Interval after the last was scheduled, delayed by up to Jitter.
+	Interval time.Duration
+	Jitter   time.Duration
+
+	index int       // position in a PriorityQueue
+	base  time.Time // deadline before jitter
 }
 
 type TimingQueue struct {
-	queue    PriorityQueue
+	timers   Timers
 	mu       sync.RWMutex
-	addChan  chan *Task
+	wake     chan struct{}
 	stopChan chan struct{}
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
 	signal   chan *Task
 	taskMap  map[string]*Task
+
+	overflow OverflowPolicy
+	dropped  uint64
+	handler  func(task *Task)
+	workers  int
+	wg       sync.WaitGroup
 }
 
 func NewTimingQueue() *TimingQueue {
+	return NewTimingQueueWithOptions(Options{})
+}
+
+// NewTimingQueueWith returns a TimingQueue keeping its tasks in timers, such
+// as NewTimingWheel for large numbers of them.
+func NewTimingQueueWith(timers Timers) *TimingQueue {
+	return NewTimingQueueWithOptions(Options{Timers: timers})
+}
+
+func NewTimingQueueWithOptions(opts Options) *TimingQueue {
+	if opts.Timers == nil {
+		opts.Timers = NewHeapTimers()
+	}
+
+	if opts.Buffer <= 0 {
+		opts.Buffer = 100
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
 	return &TimingQueue{
-		queue:    make(PriorityQueue, 0),
-		addChan:  make(chan *Task, 100),
+		timers:   opts.Timers,
+		wake:     make(chan struct{}, 1),
 		stopChan: make(chan struct{}),
-		signal:   make(chan *Task, 100),
+		done:     make(chan struct{}),
+		signal:   make(chan *Task, opts.Buffer),
 		taskMap:  make(map[string]*Task),
+		overflow: opts.Overflow,
+		handler:  opts.Handler,
+		workers:  opts.Workers,
 	}
 }
 
@@ -37,22 +82,75 @@ func (tw *TimingQueue) AddTask(
 	deadline time.Time,
 	meta map[string]string,
 ) error {
-	tw.mu.Lock()
-	defer tw.mu.Unlock()
+	return tw.add(&Task{
+		ID:       id,
+		Deadline: deadline,
+		Meta:     meta,
+	})
+}
+
+// AddRecurring adds a task that is due first at first and then every
+// interval, each run delayed by a random amount up to jitter. Runs missed
+// while the queue was behind are skipped.
+func (tw *TimingQueue) AddRecurring(
+	id string,
+	first time.Time,
+	interval time.Duration,
+	jitter time.Duration,
+	meta map[string]string,
+) error {
+	if interval <= 0 {
+		return fmt.Errorf("task %s: interval must be positive", id)
+	}
 
-	if _, exists := tw.taskMap[id]; exists {
-		return fmt.Errorf("task with ID %s already exists", id)
+	if jitter < 0 {
+		return fmt.Errorf("task %s: negative jitter", id)
 	}
 
 	task := &Task{
 		ID:       id,
-		Deadline: deadline,
-		Meta: meta,
+		Meta:     meta,
+		Interval: interval,
+		Jitter:   jitter,
+		base:     first,
 	}
+	task.Deadline = task.jittered()
+
+	return tw.add(task)
+}
+
+func (tw *TimingQueue) add(task *Task) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if _, exists := tw.taskMap[task.ID]; exists {
+		return fmt.Errorf("task with ID %s already exists", task.ID)
+	}
+
+	tw.taskMap[task.ID] = task
+	tw.timers.Add(task)
+	tw.notify()
+
+	return nil
+}
+
+// Reschedule moves a pending task to a new deadline. A recurring task
+// carries on at its interval from there.
+func (tw *TimingQueue) Reschedule(id string, deadline time.Time) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	task, exists := tw.taskMap[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, id)
+	}
+
+	tw.timers.Remove(id)
+	task.Deadline = deadline
+	task.base = deadline
+	tw.timers.Add(task)
+	tw.notify()
 
-	tw.taskMap[id] = task
-	tw.addChan <- task
-	
 	return nil
 }
 
@@ -67,24 +165,39 @@ func (tw *TimingQueue) RemoveTask(id string) bool {
 
 	delete(tw.taskMap, id)
 
-	for i, t := range tw.queue {
-		if t.ID == id {
-			heap.Remove(&tw.queue, i)
-			return true
-		}
-	}
+	return tw.timers.Remove(id)
+}
 
-	return false
+// notify wakes the loop to look at the next deadline again. Callers hold mu.
+func (tw *TimingQueue) notify() {
+	select {
+	case tw.wake <- struct{}{}:
+	default:
+	}
 }
 
-func (tw *TimingQueue) Signal() <-chan *Task{
+// Signal returns the channel due tasks are sent on. It is closed by Stop,
+// and not used when the queue has a Handler.
+func (tw *TimingQueue) Signal() <-chan *Task {
 	return tw.signal
 }
 
 func (tw *TimingQueue) Start() {
-	heap.Init(&tw.queue)
+	tw.mu.Lock()
+	if tw.started {
+		tw.mu.Unlock()
+		return
+	}
+	tw.started = true
+	tw.mu.Unlock()
+
+	if tw.handler != nil {
+		tw.startWorkers()
+	}
 
 	go func() {
+		defer close(tw.done)
+
 		var timer *time.Timer
 		timer = time.NewTimer(0)
 		if !timer.Stop() {
@@ -93,75 +206,109 @@ func (tw *TimingQueue) Start() {
 		defer timer.Stop()
 
 		for {
-			tw.mu.Lock()
-			if tw.queue.Len() > 0 {
-				nextTask := tw.queue[0]
-				waitDuration := time.Until(nextTask.Deadline)
-
-				if waitDuration <= 0 {
-					tw.signalTask(nextTask)
-					heap.Pop(&tw.queue)
-					delete(tw.taskMap, nextTask.ID)
-					tw.mu.Unlock()
-					continue
-				}
+			due := tw.expire(time.Now())
 
-				timer.Reset(waitDuration)
-				tw.mu.Unlock()
+			for _, task := range due {
+				tw.deliver(task)
+			}
 
-				select {
-				case <-timer.C:
-					tw.mu.Lock()
-					if tw.queue.Len() > 0 {
-						task := heap.Pop(&tw.queue).(*Task)
-						if _, exists := tw.taskMap[task.ID]; exists {
-							tw.signalTask(task)
-							delete(tw.taskMap, task.ID)
-						}
-					}
-					tw.mu.Unlock()
-
-				case newTask := <-tw.addChan:
-					tw.mu.Lock()
-					heap.Push(&tw.queue, newTask)
-					tw.mu.Unlock()
-
-				case <-tw.stopChan:
-					return
+			tw.mu.RLock()
+			next, ok := tw.timers.Next()
+			tw.mu.RUnlock()
+
+			var wait <-chan time.Time
+			if ok {
+				timer.Reset(time.Until(next))
+				wait = timer.C
+			}
+
+			select {
+			case <-wait:
+			case <-tw.wake:
+			case <-tw.stopChan:
+				for _, task := range tw.expire(time.Now()) {
+					tw.deliver(task)
 				}
-			} else {
-				tw.mu.Unlock()
-				select {
-				case newTask := <-tw.addChan:
-					tw.mu.Lock()
-					heap.Push(&tw.queue, newTask)
-					tw.mu.Unlock()
+				return
+			}
 
-				case <-tw.stopChan:
-					return
+			if ok && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
 			}
 		}
 	}()
 }
 
-func (tw *TimingQueue) signalTask(task *Task) {
-	task2 := &Task{}
-	*task2 = *task
-	tw.signal <- task2
+// expire takes the tasks due by now off the timers, putting recurring ones
+// back for their next run, and returns copies of them for delivery.
+func (tw *TimingQueue) expire(now time.Time) []*Task {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	expired := tw.timers.Expire(now)
+	due := make([]*Task, len(expired))
+
+	for i, task := range expired {
+		task2 := &Task{}
+		*task2 = *task
+		due[i] = task2
+
+		if task.Interval <= 0 {
+			delete(tw.taskMap, task.ID)
+			continue
+		}
+
+		task.base = task.base.Add(task.Interval)
+		if !task.base.After(now) {
+			missed := now.Sub(task.base)/task.Interval + 1
+			task.base = task.base.Add(missed * task.Interval)
+		}
+
+		task.Deadline = task.jittered()
+		tw.timers.Add(task)
+	}
+
+	return due
+}
+
+func (t *Task) jittered() time.Time {
+	if t.Jitter <= 0 {
+		return t.base
+	}
+
+	return t.base.Add(rand.N(t.Jitter))
 }
 
+// Stop stops the queue, closes Signal and waits for handlers in progress.
+// Tasks already due are still handled; without a Handler they stay on
+// Signal if the buffer has room and are counted by Dropped otherwise.
+// Calling Stop again waits for the first call to finish.
 func (tw *TimingQueue) Stop() {
-	close(tw.stopChan)
-	close(tw.signal)
-}
+	tw.stopOnce.Do(func() {
+		close(tw.stopChan)
+
+		tw.mu.RLock()
+		started := tw.started
+		tw.mu.RUnlock()
 
+		if started {
+			<-tw.done
+		}
+
+		close(tw.signal)
+		tw.wg.Wait()
+	})
+}
 func (tw *TimingQueue) GetNextTaskTime() *time.Time {
 	tw.mu.RLock()
 	defer tw.mu.RUnlock()
-	
-	if tw.queue.Len() > 0 {
-		return &tw.queue[0].Deadline
+
+	next, ok := tw.timers.Next()
+	if ok {
+		return &next
 	}
 	return nil
 }
@@ -169,7 +316,7 @@ func (tw *TimingQueue) GetNextTaskTime() *time.Time {
 func (tw *TimingQueue) TaskCount() int {
 	tw.mu.RLock()
 	defer tw.mu.RUnlock()
-	return tw.queue.Len()
+	return tw.timers.Len()
 }
 
 func (tw *TimingQueue) HasTask(id string) bool {
@@ -189,10 +336,14 @@ func (pq PriorityQueue) Less(i, j int) bool {
 
 func (pq PriorityQueue) Swap(i, j int) {
 	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
 }
 
 func (pq *PriorityQueue) Push(x any) {
-	*pq = append(*pq, x.(*Task))
+	task := x.(*Task)
+	task.index = len(*pq)
+	*pq = append(*pq, task)
 }
 
 func (pq *PriorityQueue) Pop() any {
@@ -202,4 +353,3 @@ func (pq *PriorityQueue) Pop() any {
 	*pq = old[0 : n-1]
 	return item
 }
-
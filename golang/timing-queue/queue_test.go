@@ -0,0 +1,225 @@
+package timingqueue_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	timingqueue "timing-queue"
+)
+
+// waitFor polls cond, which the queue satisfies in the background.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	cases := []struct {
+		name  string
+		first time.Duration
+		moved time.Duration
+	}{
+		{"earlier", time.Hour, 20 * time.Millisecond},
+		{"later", 20 * time.Millisecond, 80 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tq := timingqueue.NewTimingQueue()
+			tq.Start()
+			defer tq.Stop()
+
+			now := time.Now()
+			err := tq.AddTask("a", now.Add(c.first), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := now.Add(c.moved)
+			err = tq.Reschedule("a", deadline)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case task := <-tq.Signal():
+				if at := time.Now(); at.Before(deadline) {
+					t.Errorf("delivered %v early", deadline.Sub(at))
+				}
+				if !task.Deadline.Equal(deadline) {
+					t.Errorf("deadline %v, want %v", task.Deadline, deadline)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("task not delivered")
+			}
+
+			if tq.HasTask("a") {
+				t.Error("one-off task still pending")
+			}
+		})
+	}
+
+	tq := timingqueue.NewTimingQueue()
+	err := tq.Reschedule("missing", time.Now())
+	if err == nil {
+		t.Error("rescheduled a missing task")
+	}
+}
+
+func TestRecurringSkipsMissedRuns(t *testing.T) {
+	tq := timingqueue.NewTimingQueue()
+	tq.Start()
+	defer tq.Stop()
+
+	const interval = 50 * time.Millisecond
+
+	// Ten runs are already overdue; only the first is made up for.
+	first := time.Now().Add(-10 * interval)
+	err := tq.AddRecurring("tick", first, interval, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := []time.Time{}
+	for len(runs) < 2 {
+		select {
+		case task := <-tq.Signal():
+			runs = append(runs, task.Deadline)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d runs", len(runs))
+		}
+	}
+
+	if !runs[0].Equal(first) {
+		t.Errorf("first run %v, want %v", runs[0], first)
+	}
+
+	gap := runs[1].Sub(runs[0])
+	if gap%interval != 0 || gap < 10*interval {
+		t.Errorf("second run %v after the first, want a multiple of %v past the missed runs", gap, interval)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  timingqueue.OverflowPolicy
+		dropped uint64
+		kept    []string
+	}{
+		{"drop newest", timingqueue.OverflowDropNewest, 3, []string{"0", "1"}},
+		{"drop oldest", timingqueue.OverflowDropOldest, 3, []string{"3", "4"}},
+		{"block", timingqueue.OverflowBlock, 0, []string{"0", "1", "2", "3", "4"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tq := timingqueue.NewTimingQueueWithOptions(timingqueue.Options{
+				Buffer:   2,
+				Overflow: c.policy,
+			})
+
+			// All due at once, in order, with nobody reading Signal.
+			now := time.Now()
+			for i := 0; i < 5; i++ {
+				err := tq.AddTask(fmt.Sprint(i), now.Add(time.Duration(i-5)*time.Millisecond), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			tq.Start()
+
+			waitFor(t, func() bool { return tq.TaskCount() == 0 })
+			waitFor(t, func() bool { return tq.Dropped() == c.dropped })
+
+			// Blocked deliveries go through as the buffer is read.
+			got := []string{}
+			for len(got) < len(c.kept) {
+				select {
+				case task := <-tq.Signal():
+					got = append(got, task.ID)
+				case <-time.After(time.Second):
+					t.Fatalf("received %v, want %v", got, c.kept)
+				}
+			}
+			tq.Stop()
+
+			if fmt.Sprint(got) != fmt.Sprint(c.kept) {
+				t.Errorf("received %v, want %v", got, c.kept)
+			}
+			if n := tq.Dropped(); n != c.dropped {
+				t.Errorf("dropped %d, want %d", n, c.dropped)
+			}
+		})
+	}
+}
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	handled := make(chan string, 2)
+
+	tq := timingqueue.NewTimingQueueWithOptions(timingqueue.Options{
+		Handler: func(task *timingqueue.Task) {
+			if task.ID == "bad" {
+				panic("boom")
+			}
+			handled <- task.ID
+		},
+	})
+	tq.Start()
+	defer tq.Stop()
+
+	now := time.Now()
+	tq.AddTask("bad", now, nil)
+	tq.AddTask("good", now.Add(time.Millisecond), nil)
+
+	select {
+	case id := <-handled:
+		if id != "good" {
+			t.Errorf("handled %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("worker died with the panicking handler")
+	}
+}
+
+func TestStopHandlesDueTasks(t *testing.T) {
+	var handled atomic.Int32
+	var once sync.Once
+	started := make(chan struct{})
+
+	tq := timingqueue.NewTimingQueueWithOptions(timingqueue.Options{
+		Buffer: 1,
+		Handler: func(task *timingqueue.Task) {
+			once.Do(func() { close(started) })
+			time.Sleep(10 * time.Millisecond)
+			handled.Add(1)
+		},
+	})
+
+	// More due tasks than the buffer holds, so the loop is blocked on
+	// delivery when Stop is called.
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		tq.AddTask(fmt.Sprint(i), now, nil)
+	}
+	tq.Start()
+	tq.Start()
+
+	<-started
+	tq.Stop()
+	tq.Stop()
+
+	if n := handled.Load(); n != 5 {
+		t.Errorf("handled %d of 5 due tasks", n)
+	}
+}
@@ -0,0 +1,75 @@
+package timingqueue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Timers holds the pending tasks of a TimingQueue. It is not safe for
+// concurrent use; the queue guards it with its mutex.
+type Timers interface {
+	Add(task *Task)
+	Remove(id string) bool
+	// Next returns when Expire next has work to do: the earliest deadline
+	// for the heap, or the next tick a task is in or cascades from for the
+	// wheel.
+	Next() (time.Time, bool)
+	// Expire removes and returns the tasks due by now, earliest first.
+	Expire(now time.Time) []*Task
+	Len() int
+}
+
+// heapTimers keeps tasks in a binary heap ordered by deadline, with an index
+// so removal is O(log n).
+type heapTimers struct {
+	queue PriorityQueue
+	tasks map[string]*Task
+}
+
+func NewHeapTimers() Timers {
+	return &heapTimers{
+		queue: make(PriorityQueue, 0),
+		tasks: map[string]*Task{},
+	}
+}
+
+func (h *heapTimers) Add(task *Task) {
+	h.tasks[task.ID] = task
+	heap.Push(&h.queue, task)
+}
+
+func (h *heapTimers) Remove(id string) bool {
+	task, ok := h.tasks[id]
+	if !ok {
+		return false
+	}
+
+	delete(h.tasks, id)
+	heap.Remove(&h.queue, task.index)
+
+	return true
+}
+
+func (h *heapTimers) Next() (time.Time, bool) {
+	if h.queue.Len() == 0 {
+		return time.Time{}, false
+	}
+
+	return h.queue[0].Deadline, true
+}
+
+func (h *heapTimers) Expire(now time.Time) []*Task {
+	var ready []*Task
+
+	for h.queue.Len() > 0 && !h.queue[0].Deadline.After(now) {
+		task := heap.Pop(&h.queue).(*Task)
+		delete(h.tasks, task.ID)
+		ready = append(ready, task)
+	}
+
+	return ready
+}
+
+func (h *heapTimers) Len() int {
+	return h.queue.Len()
+}
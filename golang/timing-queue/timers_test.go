@@ -0,0 +1,223 @@
+package timingqueue_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	timingqueue "timing-queue"
+)
+
+func ids(tasks []*timingqueue.Task) []string {
+	list := make([]string, len(tasks))
+	for i, t := range tasks {
+		list[i] = t.ID
+	}
+	return list
+}
+
+// TestTimersAgree drives the heap and the wheel through the same adds,
+// removes and expiries and checks they hand out the same tasks.
+func TestTimersAgree(t *testing.T) {
+	const tick = time.Millisecond
+
+	heap := timingqueue.NewHeapTimers()
+	wheel := timingqueue.NewTimingWheel(tick)
+
+	rng := rand.New(rand.NewSource(1))
+	now := time.Now().Truncate(tick).Add(tick)
+	live := []string{}
+
+	for i := 0; i < 20000; i++ {
+		switch op := rng.Intn(10); {
+		case op < 6:
+			// Spread deadlines over every level of the wheel, on tick
+			// boundaries so the wheel has no rounding to do.
+			span := time.Duration(1) << (rng.Intn(40)) * tick
+			deadline := now.Add(time.Duration(rng.Int63n(int64(span)/int64(tick)+1)) * tick)
+			id := fmt.Sprint(i)
+
+			heap.Add(&timingqueue.Task{ID: id, Deadline: deadline})
+			wheel.Add(&timingqueue.Task{ID: id, Deadline: deadline})
+			live = append(live, id)
+
+		case op < 8 && len(live) > 0:
+			j := rng.Intn(len(live))
+			id := live[j]
+
+			h, w := heap.Remove(id), wheel.Remove(id)
+			if h != w {
+				t.Fatalf("remove %s: heap %v, wheel %v", id, h, w)
+			}
+
+		default:
+			now = now.Add(time.Duration(rng.Int63n(int64(time.Hour))))
+			now = now.Truncate(tick)
+
+			h, w := heap.Expire(now), wheel.Expire(now)
+			if len(h) != len(w) {
+				t.Fatalf("expire at %v: heap %d tasks, wheel %d", now, len(h), len(w))
+			}
+
+			for j := range h {
+				if !h[j].Deadline.Equal(w[j].Deadline) {
+					t.Fatalf("expire at %v: heap %v, wheel %v", now, ids(h), ids(w))
+				}
+			}
+		}
+
+		if heap.Len() != wheel.Len() {
+			t.Fatalf("len: heap %d, wheel %d", heap.Len(), wheel.Len())
+		}
+	}
+}
+
+func TestWheelNext(t *testing.T) {
+	const tick = time.Millisecond
+
+	wheel := timingqueue.NewTimingWheel(tick)
+	now := time.Now()
+
+	_, ok := wheel.Next()
+	if ok {
+		t.Fatal("next on an empty wheel")
+	}
+
+	deadlines := []time.Duration{
+		5 * time.Millisecond,
+		time.Second,
+		time.Minute,
+		3 * time.Hour,
+		40 * 24 * time.Hour,
+	}
+
+	for i, d := range deadlines {
+		wheel.Add(&timingqueue.Task{ID: fmt.Sprint(i), Deadline: now.Add(d)})
+	}
+
+	// Following Next should reach each task within a tick of its deadline
+	// and never expire one early.
+	for i, d := range deadlines {
+		for {
+			next, ok := wheel.Next()
+			if !ok {
+				t.Fatalf("task %d: wheel empty", i)
+			}
+
+			tasks := wheel.Expire(next)
+			if len(tasks) == 0 {
+				continue
+			}
+
+			if len(tasks) != 1 || tasks[0].ID != fmt.Sprint(i) {
+				t.Fatalf("task %d: expired %v", i, ids(tasks))
+			}
+
+			late := next.Sub(now.Add(d))
+			if late < 0 || late > tick {
+				t.Fatalf("task %d: expired %v after its deadline", i, late)
+			}
+
+			break
+		}
+	}
+}
+
+var backends = []struct {
+	name string
+	new  func() timingqueue.Timers
+}{
+	{"Heap", timingqueue.NewHeapTimers},
+	{"Wheel", func() timingqueue.Timers {
+		return timingqueue.NewTimingWheel(time.Millisecond)
+	}},
+}
+
+func tasks(n int) []*timingqueue.Task {
+	rng := rand.New(rand.NewSource(1))
+	now := time.Now()
+
+	list := make([]*timingqueue.Task, n)
+	for i := range list {
+		list[i] = &timingqueue.Task{
+			ID:       fmt.Sprint(i),
+			Deadline: now.Add(time.Duration(rng.Int63n(int64(time.Hour)))),
+		}
+	}
+
+	return list
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for _, n := range []int{1e3, 1e6} {
+		list := tasks(n)
+
+		for _, backend := range backends {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, n), func(b *testing.B) {
+				var timers timingqueue.Timers
+
+				for i := 0; i < b.N; i++ {
+					// Fill up to n tasks, then start over.
+					if i%n == 0 {
+						b.StopTimer()
+						timers = backend.new()
+						b.StartTimer()
+					}
+
+					timers.Add(list[i%n])
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkAddRemove adds and cancels a task against n others already
+// pending.
+func BenchmarkAddRemove(b *testing.B) {
+	for _, n := range []int{1e3, 1e6} {
+		list := tasks(n + 1)
+
+		for _, backend := range backends {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, n), func(b *testing.B) {
+				timers := backend.new()
+				for _, task := range list[:n] {
+					timers.Add(task)
+				}
+
+				task := list[n]
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					timers.Add(task)
+					timers.Remove(task.ID)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkExpire expires n tasks spread over an hour, a minute at a time.
+func BenchmarkExpire(b *testing.B) {
+	for _, n := range []int{1e3, 1e6} {
+		list := tasks(n)
+
+		for _, backend := range backends {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					timers := backend.new()
+					for _, task := range list {
+						timers.Add(task)
+					}
+					now := time.Now()
+					b.StartTimer()
+
+					for m := 1; m <= 61; m++ {
+						timers.Expire(now.Add(time.Duration(m) * time.Minute))
+					}
+				}
+			})
+		}
+	}
+}
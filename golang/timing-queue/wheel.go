@@ -0,0 +1,243 @@
+package timingqueue
+
+import (
+	"container/list"
+	"slices"
+	"time"
+)
+
+const (
+	wheelBits   = 8
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 5
+)
+
+// timingWheel is a hierarchical hashed timing wheel. Time is counted in
+// ticks; level 0 has a slot per tick and each level above a slot per full
+// turn of the one below, so five levels of 256 slots span 2^40 ticks. A
+// task sits in the lowest level whose current turn holds its deadline and
+// moves down a level each time the slot it is in comes up. Add and Remove
+// are O(1); tasks expire up to one tick after their deadline.
+type timingWheel struct {
+	tick int64 // nanoseconds
+	cur  int64 // last tick expired
+
+	levels [wheelLevels][wheelSlots]*list.List
+	counts [wheelLevels]int
+	// overflow holds tasks beyond the top level's current turn.
+	overflow *list.List
+	ready    *list.List
+
+	tasks map[string]*wheelEntry
+}
+
+type wheelEntry struct {
+	task *Task
+	tick int64
+	list *list.List
+	elem *list.Element
+	// level is the level the entry is in, or -1 for ready and overflow.
+	level int
+}
+
+// NewTimingWheel returns Timers backed by a hierarchical timing wheel with
+// the given tick, the precision deadlines are kept to.
+func NewTimingWheel(tick time.Duration) Timers {
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+
+	return &timingWheel{
+		tick:     int64(tick),
+		cur:      time.Now().UnixNano() / int64(tick),
+		overflow: list.New(),
+		ready:    list.New(),
+		tasks:    map[string]*wheelEntry{},
+	}
+}
+
+func (w *timingWheel) Add(task *Task) {
+	// Round up so no task fires before its deadline.
+	nanos := task.Deadline.UnixNano()
+	tick := nanos / w.tick
+	if nanos%w.tick > 0 {
+		tick++
+	}
+
+	e := &wheelEntry{task: task, tick: tick}
+	w.tasks[task.ID] = e
+	w.place(e)
+}
+
+// place puts e in the ready list, a slot or the overflow list, according to
+// its tick.
+func (w *timingWheel) place(e *wheelEntry) {
+	e.level = -1
+
+	if e.tick <= w.cur {
+		e.list = w.ready
+		e.elem = w.ready.PushBack(e)
+		return
+	}
+
+	for level := 0; level < wheelLevels; level++ {
+		shift := wheelBits * (level + 1)
+		if e.tick>>shift != w.cur>>shift {
+			continue
+		}
+
+		slot := (e.tick >> (wheelBits * level)) & wheelMask
+		l := w.levels[level][slot]
+		if l == nil {
+			l = list.New()
+			w.levels[level][slot] = l
+		}
+
+		e.level = level
+		e.list = l
+		e.elem = l.PushBack(e)
+		w.counts[level]++
+		return
+	}
+
+	e.list = w.overflow
+	e.elem = w.overflow.PushBack(e)
+}
+
+func (w *timingWheel) unlink(e *wheelEntry) {
+	e.list.Remove(e.elem)
+	if e.level >= 0 {
+		w.counts[e.level]--
+	}
+}
+
+func (w *timingWheel) Remove(id string) bool {
+	e, ok := w.tasks[id]
+	if !ok {
+		return false
+	}
+
+	delete(w.tasks, id)
+	w.unlink(e)
+
+	return true
+}
+
+// cascade moves the entries of a slot down to where they now belong.
+func (w *timingWheel) cascade(l *list.List) {
+	if l == nil {
+		return
+	}
+
+	for l.Len() > 0 {
+		e := l.Remove(l.Front()).(*wheelEntry)
+		if e.level >= 0 {
+			w.counts[e.level]--
+		}
+		w.place(e)
+	}
+}
+
+// step expires the next tick, first cascading every level whose turn it
+// starts.
+func (w *timingWheel) step() {
+	w.cur++
+
+	if w.cur&(1<<(wheelBits*wheelLevels)-1) == 0 {
+		w.cascade(w.overflow)
+	}
+
+	for level := wheelLevels - 1; level > 0; level-- {
+		if w.cur&(1<<(wheelBits*level)-1) == 0 {
+			slot := (w.cur >> (wheelBits * level)) & wheelMask
+			w.cascade(w.levels[level][slot])
+		}
+	}
+
+	w.cascade(w.levels[0][w.cur&wheelMask])
+}
+
+// advance expires every tick up to target, jumping over stretches where
+// nothing is in the lower levels.
+func (w *timingWheel) advance(target int64) {
+	for w.cur < target {
+		empty := 0
+		for empty < wheelLevels && w.counts[empty] == 0 {
+			empty++
+		}
+
+		if empty == wheelLevels && w.overflow.Len() == 0 {
+			w.cur = target
+			return
+		}
+
+		// Until the next turn of the lowest non-empty level, no slot
+		// below it has anything to expire.
+		if empty > 0 {
+			last := w.cur | (1<<(wheelBits*empty) - 1)
+			if last > w.cur {
+				w.cur = min(last, target)
+				continue
+			}
+		}
+
+		w.step()
+	}
+}
+
+func (w *timingWheel) Next() (time.Time, bool) {
+	if w.ready.Len() > 0 {
+		return time.Unix(0, w.cur*w.tick), true
+	}
+
+	for level := 0; level < wheelLevels; level++ {
+		if w.counts[level] == 0 {
+			continue
+		}
+
+		shift := wheelBits * level
+		digit := (w.cur >> shift) & wheelMask
+
+		for slot := digit + 1; slot < wheelSlots; slot++ {
+			l := w.levels[level][slot]
+			if l != nil && l.Len() > 0 {
+				tick := (w.cur>>(shift+wheelBits)<<wheelBits | slot) << shift
+				return time.Unix(0, tick*w.tick), true
+			}
+		}
+	}
+
+	if w.overflow.Len() > 0 {
+		shift := wheelBits * wheelLevels
+		tick := (w.cur>>shift + 1) << shift
+		return time.Unix(0, tick*w.tick), true
+	}
+
+	return time.Time{}, false
+}
+
+func (w *timingWheel) Expire(now time.Time) []*Task {
+	w.advance(now.UnixNano() / w.tick)
+
+	if w.ready.Len() == 0 {
+		return nil
+	}
+
+	ready := make([]*Task, 0, w.ready.Len())
+	for w.ready.Len() > 0 {
+		e := w.ready.Remove(w.ready.Front()).(*wheelEntry)
+		delete(w.tasks, e.task.ID)
+		ready = append(ready, e.task)
+	}
+
+	slices.SortStableFunc(ready, func(a, b *Task) int {
+		return a.Deadline.Compare(b.Deadline)
+	})
+
+	return ready
+}
+
+func (w *timingWheel) Len() int {
+	return len(w.tasks)
+}